@@ -2,7 +2,12 @@ package admin
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
 
@@ -27,6 +32,8 @@ func (c *AddressBooksController) BeforeActivation(b mvc.BeforeActivation) {
 	b.Handle("POST", "/address-books/{id:int}/import-devices", "HandleImportDevices")
 	b.Handle("POST", "/address-books/{id:int}/peers", "HandleAddPeer")
 	b.Handle("DELETE", "/address-books/{id:int}/peers/{peerId:int}", "HandleDeletePeer")
+	b.Handle("GET", "/address-books/{id:int}/export", "HandleExport")
+	b.Handle("POST", "/address-books/{id:int}/import", "HandleImport")
 }
 
 // HandleList - List all address books with pagination
@@ -184,9 +191,9 @@ func (c *AddressBooksController) HandleCreate() mvc.Result {
 			}
 			inserted, err := c.Db.Insert(&tag)
 			if err != nil {
-				c.Ctx.Application().Logger().Errorf("Failed to insert tag %s: %v", tagName, err)
+				c.Log().Error("Failed to insert tag", "tag", tagName, "error", err)
 			} else {
-				c.Ctx.Application().Logger().Infof("Created tag: %s (id=%d) for address book %d", tagName, tag.Id, ab.Id)
+				c.Log().Debug("Created tag", "tag", tagName, "tag_id", tag.Id, "address_book_id", ab.Id)
 				_ = inserted
 			}
 		}
@@ -339,7 +346,7 @@ func (c *AddressBooksController) HandleGetTags() mvc.Result {
 		tags = append(tags, tag.Name)
 	}
 
-	c.Ctx.Application().Logger().Infof("HandleGetTags: Found %d tags for address book %d", len(tags), id)
+	c.Log().Debug("Address book tags", "count", len(tags), "address_book_id", id)
 
 	return c.Success(tags, "ok")
 }
@@ -397,7 +404,7 @@ func (c *AddressBooksController) HandleImportDevices() mvc.Result {
 
 		_, err = c.Db.Insert(&peer)
 		if err != nil {
-			c.Ctx.Application().Logger().Errorf("Failed to import device %s: %v", device.RustdeskId, err)
+			c.Log().Error("Failed to import device", "rustdesk_id", device.RustdeskId, "error", err)
 			skippedCount++
 			continue
 		}
@@ -470,7 +477,7 @@ func (c *AddressBooksController) HandleAddPeer() mvc.Result {
 		AbId:       ab.Id,
 		RustdeskId: form.RustdeskId,
 		Alias:      form.Alias,
-		Password:   form.Password,
+		Password:   model.EncryptedString(form.Password),
 		Hostname:   form.Hostname,
 		Username:   form.Username,
 		Platform:   form.Platform,
@@ -567,3 +574,104 @@ func (c *AddressBooksController) HandleDeletePeer() mvc.Result {
 		"id": peerId,
 	}, "Peer deleted successfully")
 }
+
+// HandleExport - Export an address book in the RustDesk client format ({"data":"<json>"} like GetAb)
+// Query: passwords=true keeps the peer passwords, only a super admin may ask for them
+func (c *AddressBooksController) HandleExport() mvc.Result {
+	// Require SUPPORT_N2 or higher to export address books
+	if err := c.RequirePermission(model.ROLE_SUPPORT_N2, "export address book"); err != nil {
+		return err
+	}
+	withPasswords := c.Ctx.URLParamBoolDefault("passwords", false)
+	if withPasswords {
+		if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "export peer passwords"); err != nil {
+			return err
+		}
+	}
+
+	id, err := c.Ctx.Params().GetInt("id")
+	if err != nil {
+		return c.Error(nil, "Invalid ID")
+	}
+
+	var ab model.AddressBook
+	has, err := c.Db.ID(id).Get(&ab)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if !has {
+		return c.Error(nil, "Address book not found")
+	}
+
+	abData, err := service.NewAddressBookService().Export(&ab, withPasswords)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	dataJson, err := json.Marshal(abData)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	filename := fmt.Sprintf("address-book-%d.json", ab.Id)
+	c.Ctx.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	return mvc.Response{
+		Object: iris.Map{
+			"data": string(dataJson),
+		},
+	}
+}
+
+// maxAbImportSize limits the body of an address book import, an export of thousands of peers is far below it
+const maxAbImportSize = 10 << 20
+
+// HandleImport - Merge an exported (or legacy AbData) address book into this one
+// Query: strategy=skip|overwrite|rename decides what happens with peers that already exist
+func (c *AddressBooksController) HandleImport() mvc.Result {
+	// Require SUPPORT_N2 or higher to import address books
+	if err := c.RequirePermission(model.ROLE_SUPPORT_N2, "import address book"); err != nil {
+		return err
+	}
+
+	id, err := c.Ctx.Params().GetInt("id")
+	if err != nil {
+		return c.Error(nil, "Invalid ID")
+	}
+
+	strategy := c.Ctx.URLParamDefault("strategy", service.AB_IMPORT_SKIP)
+	if !service.IsValidAbImportStrategy(strategy) {
+		return c.Error(nil, "Invalid strategy, use skip, overwrite or rename")
+	}
+
+	var ab model.AddressBook
+	has, err := c.Db.ID(id).Get(&ab)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if !has {
+		return c.Error(nil, "Address book not found")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Ctx.ResponseWriter(), c.Ctx.Request().Body, maxAbImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.Error(nil, "The import is larger than 10 MB")
+	}
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	abService := service.NewAddressBookService()
+	abData, tagColors, err := abService.ParseImportData(body)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	result, err := abService.Import(&ab, abData, tagColors, strategy)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	return c.Success(result, "ok")
+}
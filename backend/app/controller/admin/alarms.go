@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+	"xorm.io/xorm"
+)
+
+type AlarmsController struct {
+	basicController
+}
+
+func (c *AlarmsController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/audit/alarm-list", "HandleList")
+	b.Handle("POST", "/audit/alarm/ack", "HandleAck")
+}
+
+func (c *AlarmsController) HandleList() mvc.Result {
+	currentPage := c.Ctx.URLParamIntDefault("current", 1)
+	pageSize := c.Ctx.URLParamIntDefault("size", 10)
+	_type := c.Ctx.URLParamDefault("type", "")
+	severity := c.Ctx.URLParamDefault("severity", "")
+	status := c.Ctx.URLParamDefault("status", "")
+	rustdesk_id := c.Ctx.URLParamDefault("rustdesk_id", "")
+	ip := c.Ctx.URLParamDefault("ip", "")
+	created_at_0 := c.Ctx.URLParamDefault("created_at[0]", "")
+	created_at_1 := c.Ctx.URLParamDefault("created_at[1]", "")
+
+	query := func() *xorm.Session {
+		q := c.Db.Table(&model.Alarm{})
+		if _type != "" {
+			q.Where("type = ?", _type)
+		}
+		if severity != "" {
+			q.Where("severity >= ?", severity)
+		}
+		if status != "" {
+			q.Where("status = ?", status)
+		}
+		if rustdesk_id != "" {
+			q.Where("rustdesk_id = ?", rustdesk_id)
+		}
+		if ip != "" {
+			q.Where("ip = ?", ip)
+		}
+		if created_at_0 != "" && created_at_1 != "" {
+			q.Where("created_at BETWEEN ? AND ?", created_at_0, created_at_1)
+		}
+		q.Desc("id")
+		return q
+	}
+
+	pagination := db.NewPagination(currentPage, pageSize)
+	alarmList := make([]model.Alarm, 0)
+	err := pagination.Paginate(query, &model.Alarm{}, &alarmList)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	list := make([]iris.Map, 0)
+	for _, a := range alarmList {
+		ackAt := ""
+		if !a.AckAt.IsZero() {
+			ackAt = a.AckAt.Format(config.TimeFormat)
+		}
+		list = append(list, iris.Map{
+			"id":            a.Id,
+			"rustdesk_id":   a.RustdeskId,
+			"uuid":          a.Uuid,
+			"type":          a.Type,
+			"type_name":     service.AlarmTypeName(a.Type),
+			"severity":      a.Severity,
+			"severity_name": service.AlarmSeverityName(a.Severity),
+			"ip":            a.IP,
+			"peer_id":       a.PeerId,
+			"peer_name":     a.PeerName,
+			"user_id":       a.UserId,
+			"notified":      a.Notified,
+			"status":        a.Status,
+			"ack_user_id":   a.AckUserId,
+			"ack_note":      a.AckNote,
+			"ack_at":        ackAt,
+			"created_at":    a.CreatedAt.Format(config.TimeFormat),
+		})
+	}
+	return c.Success(iris.Map{
+		"total":   pagination.TotalCount,
+		"records": list,
+		"current": currentPage,
+		"size":    pageSize,
+	}, "ok")
+}
+
+// HandleAck acknowledges {"ids":[1,2],"note":"..."}
+func (c *AlarmsController) HandleAck() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "acknowledge alarms"); err != nil {
+		return err
+	}
+	var form struct {
+		Ids  []int  `json:"ids"`
+		Note string `json:"note"`
+	}
+	err := c.Ctx.ReadJSON(&form)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if len(form.Ids) == 0 {
+		return c.Error(nil, "ParamsError")
+	}
+	count, err := service.NewAlarmService().Acknowledge(form.Ids, c.GetUser().Id, form.Note)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(iris.Map{
+		"acknowledged": count,
+	}, "ok")
+}
@@ -2,8 +2,11 @@ package admin
 
 import (
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/export"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -16,13 +19,294 @@ type AuditController struct {
 
 func (c *AuditController) BeforeActivation(b mvc.BeforeActivation) {
 	b.Handle("GET", "/audit/list", "HandleList")
+	b.Handle("GET", "/audit/{id:int}/detail", "HandleDetail")
 	b.Handle("GET", "/audit/file-transfer-list", "HandleFileTransferList")
+	b.Handle("GET", "/audit/file-transfer/{id:int}/files", "HandleFileTransferFiles")
 	b.Handle("GET", "/audit/stats", "HandleStats")
+	b.Handle("GET", "/audit/export", "HandleExport")
+	b.Handle("GET", "/audit/file-transfer-export", "HandleFileTransferExport")
+	b.Handle("GET", "/audit/stats/export", "HandleStatsExport")
+	b.Handle("GET", "/audit/report/pdf", "HandleReportPdf")
 }
 
 func (c *AuditController) HandleList() mvc.Result {
 	currentPage := c.Ctx.URLParamIntDefault("current", 1)
 	pageSize := c.Ctx.URLParamIntDefault("size", 10)
+	query := c.auditQuery()
+
+	pagination := db.NewPagination(currentPage, pageSize)
+	auditList := make([]model.Audit, 0)
+	err := pagination.Paginate(query, &model.Audit{}, &auditList)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	userIds := make([]int, 0)
+	for _, a := range auditList {
+		if a.UserId > 0 {
+			userIds = append(userIds, a.UserId)
+		}
+	}
+	usernames := c.usernames(userIds)
+
+	list := make([]iris.Map, 0)
+	for _, a := range auditList {
+		list = append(list, auditMap(&a, usernames[a.UserId]))
+	}
+	return c.Success(iris.Map{
+		"total":   pagination.TotalCount,
+		"records": list,
+		"current": currentPage,
+		"size":    pageSize,
+	}, "ok")
+}
+
+func (c *AuditController) HandleFileTransferList() mvc.Result {
+	currentPage := c.Ctx.URLParamIntDefault("current", 1)
+	pageSize := c.Ctx.URLParamIntDefault("size", 10)
+	query := c.fileTransferQuery()
+
+	pagination := db.NewPagination(currentPage, pageSize)
+	fileTransferList := make([]model.FileTransfer, 0)
+	err := pagination.Paginate(query, &model.FileTransfer{}, &fileTransferList)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	userIds := make([]int, 0)
+	for _, a := range fileTransferList {
+		if a.UserId > 0 {
+			userIds = append(userIds, a.UserId)
+		}
+	}
+	usernames := c.usernames(userIds)
+
+	list := make([]iris.Map, 0)
+	for _, a := range fileTransferList {
+		list = append(list, fileTransferMap(&a, usernames[a.UserId]))
+	}
+	return c.Success(iris.Map{
+		"total":   pagination.TotalCount,
+		"records": list,
+		"current": currentPage,
+		"size":    pageSize,
+	}, "ok")
+}
+
+// HandleDetail returns one session with the file transfers and alarms of its device while it was open
+func (c *AuditController) HandleDetail() mvc.Result {
+	id := c.Ctx.Params().GetIntDefault("id", 0)
+	var audit model.Audit
+	has, err := c.Db.ID(id).Get(&audit)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if !has {
+		return c.Error(nil, "RecordNotFound")
+	}
+
+	start := audit.CreatedAt.Format(config.TimeFormat)
+	end := time.Now().Format(config.TimeFormat)
+	if !audit.ClosedAt.IsZero() {
+		end = audit.ClosedAt.Format(config.TimeFormat)
+	}
+
+	// transfers reported before the session got linked have no audit_id
+	transfers := make([]model.FileTransfer, 0)
+	err = c.Db.Where("audit_id = ? OR (audit_id = 0 AND rustdesk_id = ? AND created_at BETWEEN ? AND ?)", audit.Id, audit.RustdeskId, start, end).
+		Asc("id").Find(&transfers)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	alarms := make([]model.Alarm, 0)
+	err = c.Db.Where("rustdesk_id = ? AND created_at BETWEEN ? AND ?", audit.RustdeskId, start, end).Asc("id").Find(&alarms)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	userIds := []int{audit.UserId}
+	for _, t := range transfers {
+		userIds = append(userIds, t.UserId)
+	}
+	usernames := c.usernames(userIds)
+
+	transferList := make([]iris.Map, 0)
+	for _, t := range transfers {
+		transferList = append(transferList, fileTransferMap(&t, usernames[t.UserId]))
+	}
+	alarmList := make([]iris.Map, 0)
+	for _, a := range alarms {
+		alarmList = append(alarmList, iris.Map{
+			"id":            a.Id,
+			"type":          a.Type,
+			"type_name":     service.AlarmTypeName(a.Type),
+			"severity":      a.Severity,
+			"severity_name": service.AlarmSeverityName(a.Severity),
+			"ip":            a.IP,
+			"peer_id":       a.PeerId,
+			"peer_name":     a.PeerName,
+			"status":        a.Status,
+			"created_at":    a.CreatedAt.Format(config.TimeFormat),
+		})
+	}
+
+	return c.Success(iris.Map{
+		"session":        auditMap(&audit, usernames[audit.UserId]),
+		"file_transfers": transferList,
+		"alarms":         alarmList,
+	}, "ok")
+}
+
+// HandleFileTransferFiles returns the files listed in one transfer
+func (c *AuditController) HandleFileTransferFiles() mvc.Result {
+	id := c.Ctx.Params().GetIntDefault("id", 0)
+	files := make([]model.FileTransferItem, 0)
+	err := c.Db.Where("file_transfer_id = ?", id).Asc("id").Find(&files)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
+	list := make([]iris.Map, 0)
+	for _, f := range files {
+		list = append(list, iris.Map{
+			"id":   f.Id,
+			"name": f.Name,
+			"size": f.Size,
+		})
+	}
+	return c.Success(list, "ok")
+}
+
+// HandleExport streams the sessions matching the list filters, ?format=csv|xlsx
+func (c *AuditController) HandleExport() mvc.Result {
+	query := c.auditQuery()
+	header := []string{"ID", "RustDesk ID", "Conn ID", "Session ID", "Type", "User", "IP", "Peer ID", "Peer name", "Started", "Closed", "Duration (s)", "Close reason", "Note"}
+	return c.Export("sessions", header, func(w export.Writer) error {
+		username := c.usernameLookup()
+		rows, err := query().Rows(&model.Audit{})
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a model.Audit
+			if err = rows.Scan(&a); err != nil {
+				return err
+			}
+			err = w.WriteRow(a.Id, a.RustdeskId, a.ConnId, a.SessionId, a.Type, username(a.UserId), a.IP, a.PeerId, a.PeerName,
+				a.CreatedAt, a.ClosedAt, a.Duration, a.CloseReason, a.Note)
+			if err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// HandleFileTransferExport streams the file transfers matching the list filters, ?format=csv|xlsx
+func (c *AuditController) HandleFileTransferExport() mvc.Result {
+	query := c.fileTransferQuery()
+	header := []string{"ID", "RustDesk ID", "Peer ID", "Direction", "User", "Session", "Path", "Is file", "Files", "Size (bytes)", "Remote IP", "Remote name", "Time"}
+	return c.Export("file-transfers", header, func(w export.Writer) error {
+		username := c.usernameLookup()
+		rows, err := query().Rows(&model.FileTransfer{})
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t model.FileTransfer
+			if err = rows.Scan(&t); err != nil {
+				return err
+			}
+			direction := "remote to local"
+			if t.Type == model.FILE_TRANSFER_LOCAL_TO_REMOTE {
+				direction = "local to remote"
+			}
+			err = w.WriteRow(t.Id, t.RustdeskId, t.PeerId, direction, username(t.UserId), t.AuditId, t.Path, t.IsFile, t.FileCount,
+				t.TotalSize, t.RemoteIp, t.RemoteName, t.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// HandleStatsExport exports the sessions per user and per device started in created_at[0] - created_at[1],
+// the current month by default
+func (c *AuditController) HandleStatsExport() mvc.Result {
+	from, to, err := c.reportRange()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	report, err := service.NewReportService().AuditReport(from, to, 0)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	header := []string{"Scope", "ID", "Name", "Sessions", "Total duration (s)", "Average duration (s)"}
+	return c.Export("session-report", header, func(w export.Writer) error {
+		scopes := []string{"user", "device"}
+		for i, list := range [][]service.ReportRow{report.ByUser, report.ByDevice} {
+			scope := scopes[i]
+			for _, r := range list {
+				if err := w.WriteRow(scope, r.Key, r.Name, r.Sessions, r.TotalDuration, r.AvgDuration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// HandleReportPdf renders the monthly report of ?month=2006-01, the previous month by default
+func (c *AuditController) HandleReportPdf() mvc.Result {
+	from, to := service.PreviousMonth(time.Now())
+	if month := c.Ctx.URLParamDefault("month", ""); month != "" {
+		start, err := time.ParseInLocation("2006-01", month, from.Location())
+		if err != nil {
+			return c.Error(nil, "Invalid month, use YYYY-MM")
+		}
+		from, to = start, start.AddDate(0, 1, 0)
+	}
+	report, err := service.NewReportService().AuditReport(from, to, 20)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	data, err := report.RenderPDF()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Ctx.Header("Content-Disposition", "attachment; filename=\"audit-report-"+from.Format("2006-01")+".pdf\"")
+	return mvc.Response{
+		ContentType: "application/pdf",
+		Content:     data,
+	}
+}
+
+func (c *AuditController) reportRange() (time.Time, time.Time, error) {
+	from, to := service.PreviousMonth(time.Now())
+	from, to = to, to.AddDate(0, 1, 0)
+	created_at_0 := c.Ctx.URLParamDefault("created_at[0]", "")
+	created_at_1 := c.Ctx.URLParamDefault("created_at[1]", "")
+	if created_at_0 == "" || created_at_1 == "" {
+		return from, to, nil
+	}
+	start, err := time.ParseInLocation(config.TimeFormat, created_at_0, from.Location())
+	if err != nil {
+		return from, to, err
+	}
+	end, err := time.ParseInLocation(config.TimeFormat, created_at_1, from.Location())
+	if err != nil {
+		return from, to, err
+	}
+	// the report range is half-open, created_at[1] is included like in the list filters
+	return start, end.Add(time.Second), nil
+}
+
+// auditQuery builds the session query from the filters of the list, the exports take the same filters
+func (c *AuditController) auditQuery() func() *xorm.Session {
 	conn_id := c.Ctx.URLParamDefault("conn_id", "")
 	_type := c.Ctx.URLParamDefault("type", "")
 	rustdesk_id := c.Ctx.URLParamDefault("rustdesk_id", "")
@@ -34,7 +318,7 @@ func (c *AuditController) HandleList() mvc.Result {
 	closed_at_0 := c.Ctx.URLParamDefault("closed_at[0]", "")
 	closed_at_1 := c.Ctx.URLParamDefault("closed_at[1]", "")
 
-	query := func() *xorm.Session {
+	return func() *xorm.Session {
 		q := c.Db.Table(&model.Audit{})
 		if conn_id != "" {
 			q.Where("audit.conn_id = ?", conn_id)
@@ -63,104 +347,135 @@ func (c *AuditController) HandleList() mvc.Result {
 		q.Desc("id")
 		return q
 	}
-
-	pagination := db.NewPagination(currentPage, pageSize)
-	auditList := make([]model.Audit, 0)
-	err := pagination.Paginate(query, &model.Audit{}, &auditList)
-	if err != nil {
-		return c.Error(nil, err.Error())
-	}
-
-	list := make([]iris.Map, 0)
-	for _, a := range auditList {
-		// Get username from user_id
-		var user model.User
-		username := "-"
-		if a.UserId > 0 {
-			has, err := c.Db.ID(a.UserId).Get(&user)
-			if err == nil && has {
-				username = user.Username
-			}
-		}
-		
-		list = append(list, iris.Map{
-			"id":          a.Id,
-			"user_id":     a.UserId,
-			"username":    username,
-			"conn_id":     a.ConnId,
-			"rustdesk_id": a.RustdeskId,
-			"ip":          a.IP,
-			"session_id":  a.SessionId,
-			"uuid":        a.Uuid,
-			"type":        a.Type,
-			"created_at":  a.CreatedAt.Format(config.TimeFormat),
-			"closed_at":   a.ClosedAt.Format(config.TimeFormat),
-		})
-	}
-	return c.Success(iris.Map{
-		"total":   pagination.TotalCount,
-		"records": list,
-		"current": currentPage,
-		"size":    pageSize,
-	}, "ok")
 }
 
-func (c *AuditController) HandleFileTransferList() mvc.Result {
-	currentPage := c.Ctx.URLParamIntDefault("current", 1)
-	pageSize := c.Ctx.URLParamIntDefault("size", 10)
-	_type := c.Ctx.URLParamDefault("type", "")
+func (c *AuditController) fileTransferQuery() func() *xorm.Session {
+	_type := c.Ctx.URLParamDefault("type", "") // direction, 0=remote to local 1=local to remote
 	rustdesk_id := c.Ctx.URLParamDefault("rustdesk_id", "")
 	peer_id := c.Ctx.URLParamDefault("peer_id", "")
 	uuid := c.Ctx.URLParamDefault("uuid", "")
+	user_id := c.Ctx.URLParamDefault("user_id", "")
+	username := c.Ctx.URLParamDefault("username", "")
+	audit_id := c.Ctx.URLParamDefault("audit_id", "")
+	filename := c.Ctx.URLParamDefault("filename", "")
+	min_size := c.Ctx.URLParamInt64Default("min_size", 0)
 	created_at_0 := c.Ctx.URLParamDefault("created_at[0]", "")
 	created_at_1 := c.Ctx.URLParamDefault("created_at[1]", "")
 
-	query := func() *xorm.Session {
+	return func() *xorm.Session {
 		q := c.Db.Table(&model.FileTransfer{})
 		if _type != "" {
-			q.Where("type = ?", _type)
+			q.Where("file_transfer.type = ?", _type)
 		}
 		if rustdesk_id != "" {
-			q.Where("rustdesk_id = ?", rustdesk_id)
+			q.Where("file_transfer.rustdesk_id = ?", rustdesk_id)
 		}
 		if peer_id != "" {
-			q.Where("peer_id = ?", peer_id)
+			q.Where("file_transfer.peer_id = ?", peer_id)
 		}
 		if uuid != "" {
-			q.Where("audit.uuid = ?", uuid)
+			q.Where("file_transfer.uuid = ?", uuid)
+		}
+		if user_id != "" {
+			q.Where("file_transfer.user_id = ?", user_id)
+		}
+		if username != "" {
+			q.Where("file_transfer.user_id IN (SELECT id FROM "+c.Db.Quote("user")+" WHERE username = ?)", username)
+		}
+		if audit_id != "" {
+			q.Where("file_transfer.audit_id = ?", audit_id)
+		}
+		if filename != "" {
+			like := "%" + filename + "%"
+			q.Where("file_transfer.path LIKE ? OR file_transfer.id IN (SELECT file_transfer_id FROM file_transfer_item WHERE name LIKE ?)", like, like)
+		}
+		if min_size > 0 {
+			q.Where("file_transfer.total_size >= ?", min_size)
 		}
 		if created_at_0 != "" && created_at_1 != "" {
-			q.Where("audit.created_at BETWEEN ? AND ?", created_at_0, created_at_1)
+			q.Where("file_transfer.created_at BETWEEN ? AND ?", created_at_0, created_at_1)
 		}
-		q.Desc("id")
+		q.Desc("file_transfer.id")
 		return q
 	}
+}
 
-	pagination := db.NewPagination(currentPage, pageSize)
-	fileTransferList := make([]model.FileTransfer, 0)
-	err := pagination.Paginate(query, &model.FileTransfer{}, &fileTransferList)
-	if err != nil {
-		return c.Error(nil, err.Error())
+// usernames maps user ids to usernames
+func (c *AuditController) usernames(userIds []int) map[int]string {
+	usernames := make(map[int]string)
+	if len(userIds) > 0 {
+		users := make([]model.User, 0)
+		_ = c.Db.Cols("id", "username").In("id", userIds).Find(&users)
+		for _, u := range users {
+			usernames[u.Id] = u.Username
+		}
 	}
+	return usernames
+}
 
-	list := make([]iris.Map, 0)
-	for _, a := range fileTransferList {
-		list = append(list, iris.Map{
-			"id":          a.Id,
-			"rustdesk_id": a.RustdeskId,
-			"peer_id":     a.PeerId,
-			"path":        a.Path,
-			"uuid":        a.Uuid,
-			"type":        a.Type,
-			"created_at":  a.CreatedAt.Format(config.TimeFormat),
-		})
+// usernameLookup returns the username of a user id for the streamed exports, each user is loaded once
+func (c *AuditController) usernameLookup() func(userId int) string {
+	usernames := make(map[int]string)
+	return func(userId int) string {
+		if userId == 0 {
+			return ""
+		}
+		if username, ok := usernames[userId]; ok {
+			return username
+		}
+		var user model.User
+		_, _ = c.Db.ID(userId).Cols("id", "username").Get(&user)
+		usernames[userId] = user.Username
+		return user.Username
+	}
+}
+
+func auditMap(a *model.Audit, username string) iris.Map {
+	if username == "" {
+		username = "-"
+	}
+	closedAt := ""
+	if !a.ClosedAt.IsZero() {
+		closedAt = a.ClosedAt.Format(config.TimeFormat)
+	}
+	return iris.Map{
+		"id":           a.Id,
+		"user_id":      a.UserId,
+		"username":     username,
+		"conn_id":      a.ConnId,
+		"rustdesk_id":  a.RustdeskId,
+		"ip":           a.IP,
+		"session_id":   a.SessionId,
+		"uuid":         a.Uuid,
+		"type":         a.Type,
+		"peer_id":      a.PeerId,
+		"peer_name":    a.PeerName,
+		"note":         a.Note,
+		"duration":     a.Duration,
+		"close_reason": a.CloseReason,
+		"created_at":   a.CreatedAt.Format(config.TimeFormat),
+		"closed_at":    closedAt,
+	}
+}
+
+func fileTransferMap(a *model.FileTransfer, username string) iris.Map {
+	return iris.Map{
+		"id":          a.Id,
+		"rustdesk_id": a.RustdeskId,
+		"peer_id":     a.PeerId,
+		"path":        a.Path,
+		"is_file":     a.IsFile,
+		"uuid":        a.Uuid,
+		"type":        a.Type,
+		"user_id":     a.UserId,
+		"username":    username,
+		"audit_id":    a.AuditId,
+		"remote_ip":   a.RemoteIp,
+		"remote_name": a.RemoteName,
+		"file_count":  a.FileCount,
+		"total_size":  a.TotalSize,
+		"created_at":  a.CreatedAt.Format(config.TimeFormat),
 	}
-	return c.Success(iris.Map{
-		"total":   pagination.TotalCount,
-		"records": list,
-		"current": currentPage,
-		"size":    pageSize,
-	}, "ok")
 }
 
 // HandleStats returns audit statistics
@@ -180,7 +495,7 @@ func (c *AuditController) HandleStats() mvc.Result {
 		LIMIT 10
 	`).Find(&topDevices)
 	if err != nil {
-		c.Ctx.Application().Logger().Errorf("Error getting top devices: %v", err)
+		c.Log().Error("Error getting top devices", "error", err)
 	}
 
 	// Format top devices for frontend
@@ -198,12 +513,12 @@ func (c *AuditController) HandleStats() mvc.Result {
 	}
 	var avgDuration AvgDuration
 	_, err = c.Db.SQL(`
-		SELECT AVG(CAST((julianday(closed_at) - julianday(created_at)) * 86400 AS INTEGER)) as avg_seconds
-		FROM audit 
-		WHERE closed_at IS NOT NULL AND closed_at > created_at
+		SELECT AVG(duration) as avg_seconds
+		FROM audit
+		WHERE close_reason IS NOT NULL AND close_reason != ''
 	`).Get(&avgDuration)
 	if err != nil {
-		c.Ctx.Application().Logger().Errorf("Error getting avg duration: %v", err)
+		c.Log().Error("Error getting avg duration", "error", err)
 		avgDuration.AvgSeconds = 0
 	}
 
@@ -221,7 +536,7 @@ func (c *AuditController) HandleStats() mvc.Result {
 		ORDER BY date ASC
 	`).Find(&dailyStats)
 	if err != nil {
-		c.Ctx.Application().Logger().Errorf("Error getting daily stats: %v", err)
+		c.Log().Error("Error getting daily stats", "error", err)
 	}
 
 	// Format daily stats for chart
@@ -253,7 +568,7 @@ func (c *AuditController) HandleStats() mvc.Result {
 		LIMIT 10
 	`).Find(&topUsers)
 	if err != nil {
-		c.Ctx.Application().Logger().Errorf("Error getting top users: %v", err)
+		c.Log().Error("Error getting top users", "error", err)
 	}
 
 	// Format top users for frontend
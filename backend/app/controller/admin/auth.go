@@ -3,8 +3,10 @@ package admin
 import (
 	"rustdesk-api-server-pro/app/form/admin"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/helper/captcha"
+	"rustdesk-api-server-pro/helper/syslog"
 	"rustdesk-api-server-pro/util"
 	"strconv"
 	"time"
@@ -26,6 +28,7 @@ func (c *AuthController) PostAuthLogin() mvc.Result {
 	}
 
 	if !captcha.VerifyCode(loginForm.CaptchaId, loginForm.Code) {
+		c.loginEvent(loginForm.Username, "wrong captcha")
 		return c.Error(nil, "CaptchaError")
 	}
 
@@ -36,10 +39,12 @@ func (c *AuthController) PostAuthLogin() mvc.Result {
 	}
 
 	if !get {
+		c.loginEvent(loginForm.Username, "user not found")
 		return c.Error(nil, "UserNotExists")
 	}
 
 	if !util.PasswordVerify(loginForm.Password, user.Password) {
+		c.loginEvent(user.Username, "wrong password")
 		return c.Error(nil, "UsernameOrPasswordError")
 	}
 
@@ -50,7 +55,7 @@ func (c *AuthController) PostAuthLogin() mvc.Result {
 
 	signStr := strconv.Itoa(user.Id) + user.Username + time.Now().String()
 	token := util.HmacSha256(signStr, c.Cfg.SignKey)
-	expired := 2 * time.Hour // 2 hours
+	expired := time.Duration(service.NewSettingsService().GetInt(service.SETTING_ADMIN_SESSION_HOURS)) * time.Hour
 
 	authToken := &model.AuthToken{
 		UserId:  user.Id,
@@ -65,11 +70,26 @@ func (c *AuthController) PostAuthLogin() mvc.Result {
 		return c.Error(nil, err.Error())
 	}
 
+	c.loginEvent(user.Username, "")
 	return c.Success(iris.Map{
 		"token": token,
 	}, "ok")
 }
 
+// loginEvent emits admin.login.success, or admin.login.failure when there is a reason
+func (c *AuthController) loginEvent(username, reason string) {
+	fields := map[string]string{
+		"username": username,
+		"ip":       c.Ctx.RemoteAddr(),
+	}
+	if reason == "" {
+		service.EmitEvent(service.EVENT_ADMIN_LOGIN_SUCCESS, syslog.SEVERITY_NOTICE, "", fields)
+		return
+	}
+	fields["reason"] = reason
+	service.EmitEvent(service.EVENT_ADMIN_LOGIN_FAILURE, syslog.SEVERITY_WARNING, "", fields)
+}
+
 func (c *AuthController) GetAuthCaptcha() mvc.Result {
 	id, img := captcha.CreateCaptcha()
 	return c.Success(iris.Map{
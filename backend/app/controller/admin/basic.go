@@ -1,8 +1,11 @@
 package admin
 
 import (
+	"log/slog"
+	"rustdesk-api-server-pro/app/middleware"
 	"rustdesk-api-server-pro/app/model"
 	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/logger"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -14,6 +17,13 @@ type basicController struct {
 	Db  *xorm.Engine
 }
 
+var controllerLog = logger.Module("admin")
+
+// Log returns the logger of the request, the records carry its request id
+func (c *basicController) Log() *slog.Logger {
+	return controllerLog.With(logger.REQUEST_ID_KEY, middleware.RequestId(c.Ctx))
+}
+
 func (c *basicController) GetUser() *model.User {
 	return c.Ctx.Values().Get(config.AdminUserKey).(*model.User)
 }
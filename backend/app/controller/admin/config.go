@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+type ConfigController struct {
+	basicController
+}
+
+func (c *ConfigController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/config/status", "HandleStatus")
+	b.Handle("POST", "/config/reload", "HandleReload")
+}
+
+// HandleStatus reports the last reload and the settings that still need a restart
+func (c *ConfigController) HandleStatus() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view config status"); err != nil {
+		return err
+	}
+	return c.Success(iris.Map{
+		"file":            config.GetConfigFile(),
+		"lastReload":      config.LastReload(),
+		"restartRequired": config.RestartRequired(),
+	}, "ok")
+}
+
+func (c *ConfigController) HandleReload() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "reload config"); err != nil {
+		return err
+	}
+	result, err := config.Reload()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("Config reloaded", "username", c.GetUser().Username, "changed", result.Changed)
+	return c.Success(result, "ok")
+}
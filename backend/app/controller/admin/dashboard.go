@@ -35,11 +35,17 @@ func (c *DashboardController) GetDashboardStat() mvc.Result {
 		return c.Error(nil, err.Error())
 	}
 
+	openAlarmCount, err := c.Db.Where("status = ?", model.ALARM_STATUS_OPEN).Count(&model.Alarm{})
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+
 	return c.Success(iris.Map{
-		"userCount":   userCount,
-		"deviceCount": deviceCount,
-		"onlineCount": onlineCount,
-		"visitsCount": visitsCount,
+		"userCount":      userCount,
+		"deviceCount":    deviceCount,
+		"onlineCount":    onlineCount,
+		"visitsCount":    visitsCount,
+		"openAlarmCount": openAlarmCount,
 	}, "ok")
 }
 
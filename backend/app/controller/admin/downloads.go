@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"rustdesk-api-server-pro/app/middleware"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+// downloadTarget is what a signed link points to below /api/download/: a platform, file/<catalog id> or a file
+// of installersDir
+var downloadTarget = regexp.MustCompile(`^(file/[0-9]+|[^/\\]+)$`)
+
+type DownloadsController struct {
+	basicController
+}
+
+func (c *DownloadsController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("POST", "/downloads/link", "HandleLink")
+	b.Handle("GET", "/downloads/stats", "HandleStats")
+	b.Handle("GET", "/downloads/events", "HandleEvents")
+}
+
+// HandleLink signs a download link that works for hours (httpConfig.downloadLinkHours by default) and mails it when
+// email is set. target is windows, macos, linux, android, file/<catalog id> or the name of a file in installersDir.
+func (c *DownloadsController) HandleLink() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT_N2, "create download links"); err != nil {
+		return err
+	}
+	type linkForm struct {
+		Target     string `json:"target"`
+		Arch       string `json:"arch"`
+		Channel    string `json:"channel"`
+		Configured bool   `json:"configured"`
+		Hours      int    `json:"hours"`
+		Email      string `json:"email"`
+	}
+	var form linkForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if !downloadTarget.MatchString(form.Target) || form.Target == "list" {
+		return c.Error(nil, "target must be a platform, file/<id> or an installer file name")
+	}
+	if id, ok := strings.CutPrefix(form.Target, "file/"); ok {
+		n, _ := strconv.Atoi(id)
+		if _, err := service.NewInstallerService().Get(n); err != nil {
+			return c.Error(nil, err.Error())
+		}
+	}
+	if form.Hours <= 0 {
+		form.Hours = config.GetServerConfig().HttpConfig.DownloadLinkHours
+	}
+	if form.Hours > 24*90 {
+		return c.Error(nil, "a link works for 90 days at most")
+	}
+
+	query := url.Values{}
+	if form.Arch != "" {
+		query.Set("arch", form.Arch)
+	}
+	if form.Channel != "" {
+		query.Set("channel", form.Channel)
+	}
+	if form.Configured {
+		query.Set("configured", "true")
+	}
+	expires := time.Now().Add(time.Duration(form.Hours) * time.Hour)
+	link := service.SignDownloadUrl(service.ApiServerUrl(middleware.RequestUrl(c.Ctx)), service.DOWNLOAD_PATH+form.Target, query, expires)
+
+	if form.Email != "" {
+		if _, err := mail.ParseAddress(form.Email); err != nil {
+			return c.Error(nil, "invalid email")
+		}
+		if err := service.NewDownloadService().MailLink(0, form.Email, link, form.Target, expires); err != nil {
+			c.Log().Error("Mail download link error", "username", c.GetUser().Username, "email", form.Email, "error", err)
+			return c.Error(nil, err.Error())
+		}
+	}
+	c.Log().Info("Download link created", "username", c.GetUser().Username, "target", form.Target, "email", form.Email, "expires", expires)
+	return c.Success(iris.Map{
+		"url":       link,
+		"expiresAt": expires.Format(config.TimeFormat),
+		"mailed":    form.Email != "",
+	}, "ok")
+}
+
+// HandleStats counts the downloads of the last ?days= (30 by default) by platform and by version
+func (c *DownloadsController) HandleStats() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "view download statistics"); err != nil {
+		return err
+	}
+	days := c.Ctx.URLParamIntDefault("days", 30)
+	if days < 1 {
+		return c.Error(nil, "days must be 1 or more")
+	}
+	stats, err := service.NewDownloadService().Stats(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(stats, "ok")
+}
+
+func (c *DownloadsController) HandleEvents() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "view downloads"); err != nil {
+		return err
+	}
+	currentPage := c.Ctx.URLParamIntDefault("current", 1)
+	pageSize := c.Ctx.URLParamIntDefault("size", 10)
+	pagination, events, err := service.NewDownloadService().Events(currentPage, pageSize, c.Ctx.URLParamDefault("platform", ""))
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(iris.Map{
+		"total":   pagination.TotalCount,
+		"records": events,
+		"current": currentPage,
+		"size":    pageSize,
+	}, "ok")
+}
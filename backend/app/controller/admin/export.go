@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/middleware"
+	"rustdesk-api-server-pro/helper/export"
+	"rustdesk-api-server-pro/helper/logger"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+// exportResult streams a table as csv or xlsx, rows are written while they are read from the database
+type exportResult struct {
+	format string
+	name   string
+	header []string
+	rows   func(w export.Writer) error
+}
+
+func (r *exportResult) Dispatch(ctx iris.Context) {
+	filename := r.name + "-" + time.Now().Format("20060102-150405") + "." + r.format
+	ctx.ContentType(export.ContentType(r.format))
+	ctx.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	w, err := export.NewWriter(r.format, ctx.ResponseWriter(), r.name, r.header)
+	if err == nil {
+		err = r.rows(w)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		// the status is already sent, the client gets a truncated file
+		controllerLog.Error("Export failed", logger.REQUEST_ID_KEY, middleware.RequestId(ctx), "file", filename, "error", err)
+	}
+}
+
+// Export validates the format query parameter and streams the rows
+func (c *basicController) Export(name string, header []string, rows func(w export.Writer) error) mvc.Result {
+	format := c.Ctx.URLParamDefault("format", export.FORMAT_CSV)
+	if !export.IsValidFormat(format) {
+		return c.Error(nil, "Invalid format, use csv or xlsx")
+	}
+	return &exportResult{format: format, name: name, header: header, rows: rows}
+}
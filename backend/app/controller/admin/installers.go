@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strconv"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+// maxInstallerSize limits an upload to the installer catalog
+const maxInstallerSize = 1 << 30
+
+type InstallersController struct {
+	basicController
+}
+
+func (c *InstallersController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/installers/list", "HandleList")
+	b.Handle("POST", "/installers/upload", "HandleUpload")
+	b.Handle("POST", "/installers/edit", "HandleEdit")
+	b.Handle("POST", "/installers/delete", "HandleDelete")
+}
+
+// HandleList lists the catalog, newest version first, filtered by ?platform=, ?arch= and ?channel=
+func (c *InstallersController) HandleList() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "view installers"); err != nil {
+		return err
+	}
+	installers, err := service.NewInstallerService().List(
+		c.Ctx.URLParamDefault("platform", ""),
+		service.NormalizeArch(c.Ctx.URLParamDefault("arch", "")),
+		c.Ctx.URLParamDefault("channel", ""),
+	)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	list := make([]iris.Map, 0, len(installers))
+	for i := range installers {
+		list = append(list, installerMap(&installers[i]))
+	}
+	return c.Success(list, "ok")
+}
+
+// HandleUpload adds a build from a multipart form: file, platform, arch, version, channel (stable or beta),
+// releaseNotes and optionally the sha256 the file must have
+func (c *InstallersController) HandleUpload() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "upload installers"); err != nil {
+		return err
+	}
+	c.Ctx.SetMaxRequestBodySize(maxInstallerSize)
+	file, info, err := c.Ctx.FormFile("file")
+	if err != nil {
+		return c.Error(nil, "Failed to read file")
+	}
+	defer file.Close()
+
+	installer, err := service.NewInstallerService().Add(file, info.Filename, service.InstallerUpload{
+		Platform:     c.Ctx.FormValue("platform"),
+		Arch:         c.Ctx.FormValue("arch"),
+		Version:      c.Ctx.FormValue("version"),
+		Channel:      c.Ctx.FormValue("channel"),
+		ReleaseNotes: c.Ctx.FormValue("releaseNotes"),
+		Sha256:       c.Ctx.FormValue("sha256"),
+	}, c.GetUser().Id)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("Installer uploaded", "username", c.GetUser().Username, "path", installer.Path, "sha256", installer.Sha256)
+	return c.Success(installerMap(installer), "ok")
+}
+
+// HandleEdit changes the channel and the release notes of a build
+func (c *InstallersController) HandleEdit() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "edit installers"); err != nil {
+		return err
+	}
+	type editForm struct {
+		Id           int    `json:"id"`
+		Channel      string `json:"channel"`
+		ReleaseNotes string `json:"releaseNotes"`
+	}
+	var form editForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	installer, err := service.NewInstallerService().Update(form.Id, form.Channel, form.ReleaseNotes)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(installerMap(installer), "ok")
+}
+
+func (c *InstallersController) HandleDelete() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "delete installers"); err != nil {
+		return err
+	}
+	type deleteParams struct {
+		Ids []int `json:"ids"`
+	}
+	var params deleteParams
+	if err := c.Ctx.ReadJSON(&params); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	count, err := service.NewInstallerService().Delete(params.Ids)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("Installers deleted", "username", c.GetUser().Username, "ids", params.Ids)
+	return c.Success(iris.Map{"deleted": count}, "ok")
+}
+
+func installerMap(i *model.Installer) iris.Map {
+	return iris.Map{
+		"id":           i.Id,
+		"platform":     i.Platform,
+		"arch":         i.Arch,
+		"version":      i.Version,
+		"channel":      i.Channel,
+		"filename":     i.Filename,
+		"sha256":       i.Sha256,
+		"size":         i.Size,
+		"releaseNotes": i.ReleaseNotes,
+		"url":          "/api/download/file/" + strconv.Itoa(i.Id),
+		"createdBy":    i.CreatedBy,
+		"createdAt":    i.CreatedAt.Format(config.TimeFormat),
+	}
+}
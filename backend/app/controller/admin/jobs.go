@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+type JobsController struct {
+	basicController
+}
+
+func (c *JobsController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/jobs/list", "HandleList")
+	b.Handle("GET", "/jobs/runs", "HandleRuns")
+	b.Handle("POST", "/jobs/{name:string}/run", "HandleRun")
+}
+
+// HandleList lists the background jobs with their last and next run and the instance holding the job lease
+func (c *JobsController) HandleList() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "view jobs"); err != nil {
+		return err
+	}
+	status, err := service.NewJobService().List()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(status, "ok")
+}
+
+func (c *JobsController) HandleRuns() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT, "view job runs"); err != nil {
+		return err
+	}
+	currentPage := c.Ctx.URLParamIntDefault("current", 1)
+	pageSize := c.Ctx.URLParamIntDefault("size", 10)
+	job := c.Ctx.URLParamDefault("job", "")
+
+	pagination, runs, err := service.NewJobService().Runs(job, currentPage, pageSize)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(iris.Map{
+		"total":   pagination.TotalCount,
+		"records": runs,
+		"current": currentPage,
+		"size":    pageSize,
+	}, "ok")
+}
+
+// HandleRun starts a job on this instance now, whichever instance holds the job lease
+func (c *JobsController) HandleRun(name string) mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "run jobs"); err != nil {
+		return err
+	}
+	run, err := service.NewJobService().Start(name, model.JOB_TRIGGER_MANUAL)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("Job started", "username", c.GetUser().Username, "job", name, "run", run.Id)
+	return c.Success(run, "ok")
+}
@@ -2,8 +2,10 @@ package admin
 
 import (
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -17,6 +19,7 @@ type MaiLogsController struct {
 func (c *MaiLogsController) BeforeActivation(b mvc.BeforeActivation) {
 	b.Handle("GET", "/mail/logs/list", "HandleList")
 	b.Handle("GET", "/mail/logs/info", "HandleInfo")
+	b.Handle("POST", "/mail/logs/resend", "HandleResend")
 }
 
 func (c *MaiLogsController) HandleList() mvc.Result {
@@ -31,7 +34,8 @@ func (c *MaiLogsController) HandleList() mvc.Result {
 
 	query := func() *xorm.Session {
 		q := c.Db.Table(&model.MailLogs{})
-		q.Join("INNER", &model.User{}, "mail_logs.user_id = user.id")
+		// the mails to the extra addresses of the alarms and reports have no user
+		q.Join("LEFT", &model.User{}, "mail_logs.user_id = user.id")
 		if username != "" {
 			q.Where("user.username = ?", username)
 		}
@@ -66,16 +70,11 @@ func (c *MaiLogsController) HandleList() mvc.Result {
 
 	list := make([]iris.Map, 0)
 	for _, a := range mailLogList {
-		list = append(list, iris.Map{
-			"id":         a.MailLogs.Id,
-			"username":   a.User.Username,
-			"from":       a.MailLogs.From,
-			"to":         a.MailLogs.To,
-			"subject":    a.MailLogs.Subject,
-			"uuid":       a.Uuid,
-			"status":     a.MailLogs.Status,
-			"created_at": a.MailLogs.CreatedAt.Format(config.TimeFormat),
-		})
+		item := mailDelivery(&a.MailLogs)
+		item["username"] = a.User.Username
+		item["subject"] = a.MailLogs.Subject
+		item["uuid"] = a.Uuid
+		list = append(list, item)
 	}
 	return c.Success(iris.Map{
 		"total":   pagination.TotalCount,
@@ -98,7 +97,54 @@ func (c *MaiLogsController) HandleInfo() mvc.Result {
 		return c.Error(nil, err.Error())
 	}
 
-	return c.Success(iris.Map{
-		"content": log.Contents,
-	}, "ok")
+	info := mailDelivery(&log)
+	info["content"] = log.Contents
+	info["logs"] = log.Logs
+	return c.Success(info, "ok")
+}
+
+// HandleResend queues sent, failed or dead mails again
+func (c *MaiLogsController) HandleResend() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPPORT_N2, "resend mails"); err != nil {
+		return err
+	}
+	type resendParams struct {
+		Ids []int `json:"ids"`
+	}
+	var params resendParams
+	if err := c.Ctx.ReadJSON(&params); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	count, err := service.NewMailQueueService().Resend(params.Ids)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	service.NewMailQueueService().Kick()
+	c.Log().Info("Mails resent", "username", c.GetUser().Username, "ids", params.Ids, "queued", count)
+	return c.Success(iris.Map{"queued": count}, "ok")
+}
+
+// mailDelivery is the delivery state of a mail, the times are empty until they happen
+func mailDelivery(log *model.MailLogs) iris.Map {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(config.TimeFormat)
+	}
+	nextAttemptAt := ""
+	if log.Status == model.MAIL_SEND_QUEUED || log.Status == model.MAIL_SEND_ERR {
+		nextAttemptAt = formatTime(log.NextAttemptAt)
+	}
+	return iris.Map{
+		"id":              log.Id,
+		"from":            log.From,
+		"to":              log.To,
+		"status":          log.Status,
+		"attempts":        log.Attempts,
+		"next_attempt_at": nextAttemptAt,
+		"sent_at":         formatTime(log.SentAt),
+		"expires_at":      formatTime(log.ExpiresAt),
+		"created_at":      log.CreatedAt.Format(config.TimeFormat),
+	}
 }
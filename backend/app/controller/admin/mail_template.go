@@ -1,12 +1,16 @@
 package admin
 
 import (
+	"errors"
+
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
 	"rustdesk-api-server-pro/app/form/admin"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/util"
 	"xorm.io/xorm"
 )
 
@@ -18,6 +22,9 @@ func (c *MailTemplateController) BeforeActivation(b mvc.BeforeActivation) {
 	b.Handle("GET", "/mail/templates/list", "HandleList")
 	b.Handle("POST", "/mail/templates/add", "HandleAdd")
 	b.Handle("POST", "/mail/templates/edit", "HandleEdit")
+	b.Handle("GET", "/mail/templates/vars", "HandleVars")
+	b.Handle("POST", "/mail/templates/preview", "HandlePreview")
+	b.Handle("POST", "/mail/templates/test", "HandleTest")
 }
 
 func (c *MailTemplateController) HandleList() mvc.Result {
@@ -59,12 +66,14 @@ func (c *MailTemplateController) HandleList() mvc.Result {
 	list := make([]iris.Map, 0)
 	for _, u := range templateList {
 		list = append(list, iris.Map{
-			"id":         u.Id,
-			"name":       u.Name,
-			"type":       u.Type,
-			"subject":    u.Subject,
-			"contents":   u.Contents,
-			"created_at": u.CreatedAt.Format(config.TimeFormat),
+			"id":            u.Id,
+			"name":          u.Name,
+			"type":          u.Type,
+			"language":      u.Language,
+			"subject":       u.Subject,
+			"contents":      u.Contents,
+			"text_contents": u.TextContents,
+			"created_at":    u.CreatedAt.Format(config.TimeFormat),
 		})
 	}
 	return c.Success(iris.Map{
@@ -92,11 +101,9 @@ func (c *MailTemplateController) HandleAdd() mvc.Result {
 		return c.Error(nil, "MailTemplateContentsEmpty")
 	}
 
-	template := &model.MailTemplate{
-		Name:     form.Name,
-		Type:     form.Type,
-		Subject:  form.Subject,
-		Contents: form.Contents,
+	template := mailTemplateFromForm(&form)
+	if err = service.ValidateMailTemplate(template); err != nil {
+		return c.Error(nil, err.Error())
 	}
 
 	_, err = c.Db.Insert(template)
@@ -117,17 +124,98 @@ func (c *MailTemplateController) HandleEdit() mvc.Result {
 	if form.Id <= 0 {
 		return c.Error(nil, "DataError")
 	}
-	template := &model.MailTemplate{
-		Name:     form.Name,
-		Type:     form.Type,
-		Subject:  form.Subject,
-		Contents: form.Contents,
+	template := mailTemplateFromForm(&form)
+	if err = service.ValidateMailTemplate(template); err != nil {
+		return c.Error(nil, err.Error())
 	}
 
-	_, err = c.Db.Where("id = ?", form.Id).Update(template)
+	_, err = c.Db.Where("id = ?", form.Id).MustCols("language", "text_contents").Update(template)
 	if err != nil {
 		return c.Error(nil, err.Error())
 	}
 
 	return c.Success(nil, "MailTemplateUpdateSuccess")
 }
+
+// HandleVars lists the variables the templates of each type can use
+func (c *MailTemplateController) HandleVars() mvc.Result {
+	return c.Success(service.MailTemplateVars, "ok")
+}
+
+type mailTemplatePreviewForm struct {
+	admin.MailTemplateForm
+	Vars map[string]string `json:"vars"` // replace the sample values
+}
+
+// HandlePreview renders a template, the saved one when only its id is given, with the sample values of its type
+func (c *MailTemplateController) HandlePreview() mvc.Result {
+	_, content, err := c.render()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(content, "ok")
+}
+
+// HandleTest renders a template like the preview and mails it to the current user
+func (c *MailTemplateController) HandleTest() mvc.Result {
+	user := c.GetUser()
+	if user.Email == "" {
+		return c.Error(nil, "your account has no email address")
+	}
+	template, content, err := c.render()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	content.Subject = "[Test] " + content.Subject
+	uuid := util.GetUUID()
+	if err = service.NewMailService().SendContent(user.Id, template.Id, user.Email, uuid, content); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	service.NewMailQueueService().Kick()
+	return c.Success(iris.Map{"uuid": uuid, "to": user.Email}, "ok")
+}
+
+// render renders the template of the request body, the saved template when it only has an id
+func (c *MailTemplateController) render() (*model.MailTemplate, *service.MailContent, error) {
+	var form mailTemplatePreviewForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return nil, nil, err
+	}
+	template := mailTemplateFromForm(&form.MailTemplateForm)
+	if form.Id > 0 && form.Contents == "" {
+		has, err := c.Db.ID(form.Id).Get(template)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !has {
+			return nil, nil, errors.New("mail template not found")
+		}
+	}
+	if err := service.ValidateMailTemplate(template); err != nil {
+		return nil, nil, err
+	}
+	vars := service.MailTemplateSamples(template.Type)
+	for k, v := range form.Vars {
+		vars[k] = v
+	}
+	content, err := service.RenderMailTemplate(template, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	if content.Text == "" {
+		content.Text = service.HtmlToText(content.Html)
+	}
+	return template, content, nil
+}
+
+func mailTemplateFromForm(form *admin.MailTemplateForm) *model.MailTemplate {
+	return &model.MailTemplate{
+		Id:           form.Id,
+		Name:         form.Name,
+		Type:         form.Type,
+		Language:     service.NormalizeLanguage(form.Language),
+		Subject:      form.Subject,
+		Contents:     form.Contents,
+		TextContents: form.TextContents,
+	}
+}
@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+
+	"github.com/kataras/iris/v12/mvc"
+)
+
+type RetentionController struct {
+	basicController
+}
+
+func (c *RetentionController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/retention/report", "HandleReport")
+}
+
+// HandleReport is a dry run of the retention job, it lists the rows each policy would delete now
+func (c *RetentionController) HandleReport() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view the retention report"); err != nil {
+		return err
+	}
+	results, err := service.NewRetentionService().Prune(true)
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(results, "ok")
+}
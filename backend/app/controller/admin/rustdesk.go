@@ -0,0 +1,280 @@
+package admin
+
+import (
+	"fmt"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+type RustdeskController struct {
+	basicController
+}
+
+func (c *RustdeskController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/rustdesk/status", "HandleStatus")
+	b.Handle("POST", "/rustdesk/start", "HandleStart")
+	b.Handle("POST", "/rustdesk/stop", "HandleStop")
+	b.Handle("POST", "/rustdesk/restart", "HandleRestart")
+	b.Handle("GET", "/rustdesk/keys", "HandleKeys")
+	b.Handle("GET", "/rustdesk/keys/history", "HandleKeyHistory")
+	b.Handle("GET", "/rustdesk/keys/devices", "HandleKeyDevices")
+	b.Handle("POST", "/rustdesk/keys/generate", "HandleKeyGenerate")
+	b.Handle("POST", "/rustdesk/keys/import", "HandleKeyImport")
+	b.Handle("POST", "/rustdesk/keys/cancel", "HandleKeyCancel")
+	b.Handle("GET", "/rustdesk/releases", "HandleReleases")
+	b.Handle("POST", "/rustdesk/upgrade", "HandleUpgrade")
+}
+
+// HandleStatus reports hbbs and hbbr, with the pid, uptime and restarts when this server supervises them
+func (c *RustdeskController) HandleStatus() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view the rustdesk-server status"); err != nil {
+		return err
+	}
+	return c.Success(service.NewRustdeskService().Status(), "ok")
+}
+
+func (c *RustdeskController) HandleStart() mvc.Result {
+	return c.control("start", service.NewRustdeskService().Start)
+}
+
+func (c *RustdeskController) HandleStop() mvc.Result {
+	return c.control("stop", service.NewRustdeskService().Stop)
+}
+
+func (c *RustdeskController) HandleRestart() mvc.Result {
+	return c.control("restart", service.NewRustdeskService().Restart)
+}
+
+func (c *RustdeskController) control(action string, fn func() error) mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, action+" the rustdesk-server"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("rustdesk-server "+action, "username", c.GetUser().Username)
+	return c.Success(service.NewRustdeskService().Status(), "ok")
+}
+
+// HandleKeys returns the public key of hbbs and the key waiting for its grace period, ?private=true adds the private key
+func (c *RustdeskController) HandleKeys() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view the rustdesk-server keys"); err != nil {
+		return err
+	}
+	private := c.Ctx.URLParamBoolDefault("private", false)
+	if private {
+		c.Log().Warn("rustdesk-server private key viewed", "username", c.GetUser().Username)
+	}
+	keys := iris.Map{}
+	for k, v := range service.NewRustdeskService().Keys(private) {
+		keys[k] = v
+	}
+	pending, err := service.NewServerKeyService().Pending()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if pending != nil {
+		keys["pending"] = iris.Map{
+			"publicKey":  pending.PublicKey,
+			"source":     pending.Source,
+			"activateAt": pending.ActivateAt.Format(config.TimeFormat),
+		}
+	}
+	return c.Success(keys, "ok")
+}
+
+// HandleKeyHistory lists every key hbbs used or will use, without the private keys
+func (c *RustdeskController) HandleKeyHistory() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view the rustdesk-server keys"); err != nil {
+		return err
+	}
+	keys, err := service.NewServerKeyService().History()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	list := make([]iris.Map, 0, len(keys))
+	for _, k := range keys {
+		item := iris.Map{
+			"id":          k.Id,
+			"publicKey":   k.PublicKey,
+			"status":      k.Status,
+			"source":      k.Source,
+			"createdBy":   k.CreatedBy,
+			"createdAt":   k.CreatedAt.Format(config.TimeFormat),
+			"activateAt":  "",
+			"activatedAt": "",
+			"retiredAt":   "",
+		}
+		if !k.ActivateAt.IsZero() {
+			item["activateAt"] = k.ActivateAt.Format(config.TimeFormat)
+		}
+		if !k.ActivatedAt.IsZero() {
+			item["activatedAt"] = k.ActivatedAt.Format(config.TimeFormat)
+		}
+		if !k.RetiredAt.IsZero() {
+			item["retiredAt"] = k.RetiredAt.Format(config.TimeFormat)
+		}
+		list = append(list, item)
+	}
+	return c.Success(list, "ok")
+}
+
+// HandleKeyDevices lists the devices that last reported a key other than the active one, with the number of devices
+// that never reported a key
+func (c *RustdeskController) HandleKeyDevices() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view the rustdesk-server keys"); err != nil {
+		return err
+	}
+	keys := service.NewServerKeyService()
+	devices, err := keys.OutdatedDevices()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	unreported, err := keys.UnreportedDevices()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(iris.Map{
+		"devices":           keyDevices(devices),
+		"unreportedDevices": unreported,
+		"note":              fmt.Sprintf(service.SERVER_KEY_DEVICES_NOTE, unreported),
+	}, "ok")
+}
+
+// HandleKeyGenerate generates a new keypair, put in place after graceHours, right away when it is 0
+func (c *RustdeskController) HandleKeyGenerate() mvc.Result {
+	type generateForm struct {
+		GraceHours int `json:"graceHours"`
+	}
+	var form generateForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.rotate("", form.GraceHours)
+}
+
+// HandleKeyImport puts an existing private key in place, the base64 secret key of hbbs or its 32 byte seed
+func (c *RustdeskController) HandleKeyImport() mvc.Result {
+	type importForm struct {
+		PrivateKey string `json:"privateKey"`
+		GraceHours int    `json:"graceHours"`
+	}
+	var form importForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if form.PrivateKey == "" {
+		return c.Error(nil, "privateKey is required")
+	}
+	return c.rotate(form.PrivateKey, form.GraceHours)
+}
+
+func (c *RustdeskController) rotate(privateKey string, graceHours int) mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "rotate the rustdesk-server key"); err != nil {
+		return err
+	}
+	if graceHours < 0 {
+		return c.Error(nil, "graceHours must not be negative")
+	}
+	keys := service.NewServerKeyService()
+	active, err := keys.Active()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	key, err := keys.Rotate(privateKey, time.Duration(graceHours)*time.Hour, c.GetUser().Id)
+	if err != nil {
+		c.Log().Error("rustdesk-server key rotation failed", "username", c.GetUser().Username, "error", err)
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Warn("rustdesk-server key rotation", "username", c.GetUser().Username, "public_key", key.PublicKey, "source", key.Source, "grace_hours", graceHours)
+
+	affected := make([]model.Device, 0)
+	if active != nil {
+		if affected, err = keys.DevicesWithKey(active.PublicKey); err != nil {
+			return c.Error(nil, err.Error())
+		}
+	}
+	unreported, err := keys.UnreportedDevices()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(iris.Map{
+		"publicKey":         key.PublicKey,
+		"status":            key.Status,
+		"activateAt":        key.ActivateAt.Format(config.TimeFormat),
+		"warning":           service.SERVER_KEY_ROTATION_WARNING,
+		"devices":           keyDevices(affected),
+		"unreportedDevices": unreported,
+		"note":              fmt.Sprintf(service.SERVER_KEY_DEVICES_NOTE, unreported),
+	}, "ok")
+}
+
+// HandleKeyCancel drops the key waiting for its grace period, the active key stays in place
+func (c *RustdeskController) HandleKeyCancel() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "rotate the rustdesk-server key"); err != nil {
+		return err
+	}
+	cancelled, err := service.NewServerKeyService().CancelPending()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if !cancelled {
+		return c.Error(nil, "no key rotation is pending")
+	}
+	c.Log().Info("rustdesk-server key rotation cancelled", "username", c.GetUser().Username)
+	return c.Success(nil, "ok")
+}
+
+func keyDevices(devices []model.Device) []iris.Map {
+	list := make([]iris.Map, 0, len(devices))
+	for _, d := range devices {
+		list = append(list, iris.Map{
+			"rustdeskId":  d.RustdeskId,
+			"hostname":    d.Hostname,
+			"serverKey":   d.ServerKey,
+			"serverKeyAt": d.ServerKeyAt.Format(config.TimeFormat),
+		})
+	}
+	return list
+}
+
+func (c *RustdeskController) HandleReleases() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "list the rustdesk-server releases"); err != nil {
+		return err
+	}
+	releases, err := service.NewRustdeskService().Releases()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(releases, "ok")
+}
+
+// HandleUpgrade installs a release, sha256 is only needed when github publishes no digest for the zip
+func (c *RustdeskController) HandleUpgrade() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "upgrade the rustdesk-server"); err != nil {
+		return err
+	}
+	type upgradeForm struct {
+		Version string `json:"version"`
+		Sha256  string `json:"sha256"`
+	}
+	var form upgradeForm
+	if err := c.Ctx.ReadJSON(&form); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	if form.Version == "" {
+		return c.Error(nil, "version is required")
+	}
+	result, err := service.NewRustdeskService().Upgrade(form.Version, form.Sha256)
+	if err != nil {
+		c.Log().Error("rustdesk-server upgrade failed", "username", c.GetUser().Username, "version", form.Version, "error", err)
+		return c.Error(nil, err.Error())
+	}
+	c.Log().Info("rustdesk-server upgraded", "username", c.GetUser().Username, "from", result.From, "to", result.To)
+	return c.Success(result, "ok")
+}
@@ -1,10 +1,14 @@
 package admin
 
 import (
+	"fmt"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/syslog"
 	"rustdesk-api-server-pro/util"
+	"strings"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -86,5 +90,14 @@ func (c *SessionsController) HandleKill() mvc.Result {
 	if err != nil {
 		return c.Error(nil, err.Error())
 	}
+	killed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		killed = append(killed, fmt.Sprint(id))
+	}
+	service.EmitEvent(service.EVENT_ADMIN_SESSION_KILL, syslog.SEVERITY_NOTICE, "", map[string]string{
+		"username": c.GetUser().Username,
+		"ip":       c.Ctx.RemoteAddr(),
+		"ids":      strings.Join(killed, ","),
+	})
 	return c.Success(nil, "SessionKillSuccess")
 }
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"strconv"
+
+	"github.com/kataras/iris/v12/mvc"
+	"github.com/tidwall/gjson"
+)
+
+type SettingsController struct {
+	basicController
+}
+
+func (c *SettingsController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/settings", "HandleList")
+	b.Handle("PUT", "/settings", "HandleSave")
+	b.Handle("DELETE", "/settings/{key:string}", "HandleReset")
+}
+
+// HandleList returns every setting with its effective value and where the value comes from (db yaml default)
+func (c *SettingsController) HandleList() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "view settings"); err != nil {
+		return err
+	}
+	return c.Success(service.NewSettingsService().List(), "ok")
+}
+
+// HandleSave stores {"key": value, ...}, values may be json strings, numbers or booleans
+func (c *SettingsController) HandleSave() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "change settings"); err != nil {
+		return err
+	}
+	body, err := c.Ctx.GetBody()
+	if err != nil {
+		return c.Error(nil, err.Error())
+	}
+	result := gjson.ParseBytes(body)
+	if !result.IsObject() {
+		return c.Error(nil, "ParamsError")
+	}
+
+	values := make(map[string]string)
+	result.ForEach(func(key, value gjson.Result) bool {
+		switch value.Type {
+		case gjson.True, gjson.False:
+			values[key.String()] = strconv.FormatBool(value.Bool())
+		default:
+			values[key.String()] = value.String()
+		}
+		return true
+	})
+
+	s := service.NewSettingsService()
+	if err = s.Save(values); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(s.List(), "ok")
+}
+
+// HandleReset removes the saved value, server.yaml or the default applies again
+func (c *SettingsController) HandleReset() mvc.Result {
+	if err := c.RequirePermission(model.ROLE_SUPER_ADMIN, "change settings"); err != nil {
+		return err
+	}
+	key := c.Ctx.Params().GetString("key")
+	s := service.NewSettingsService()
+	if err := s.Reset(key); err != nil {
+		return c.Error(nil, err.Error())
+	}
+	return c.Success(s.Get(key), "ok")
+}
@@ -3,6 +3,7 @@ package admin
 import (
 	"rustdesk-api-server-pro/app/form/admin"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
 	"rustdesk-api-server-pro/util"
@@ -10,6 +11,7 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
 	"github.com/pquerna/otp/totp"
+	"github.com/tidwall/gjson"
 	"xorm.io/xorm"
 )
 
@@ -75,10 +77,11 @@ func (c *UsersController) HandleList() mvc.Result {
 			"username":         u.Username,
 			"name":             u.Name,
 			"email":            u.Email,
+			"language":         u.Language,
 			"licensed_devices": u.LicensedDevices,
 			"note":             u.Note,
 			"login_verify":     u.LoginVerify,
-			"tfa_secret":       u.TwoFactorAuthSecret,
+			"tfa_secret":       u.TwoFactorAuthSecret.String(),
 			"status":           u.Status,
 			"is_admin":         u.IsAdmin,
 			"role":             u.Role,
@@ -116,6 +119,10 @@ func (c *UsersController) HandleAdd() mvc.Result {
 		form.Name = form.Username
 	}
 
+	body, _ := c.Ctx.GetBody()
+	if !gjson.GetBytes(body, "licensed_devices").Exists() {
+		form.LicensedDevices = service.NewSettingsService().GetInt(service.SETTING_DEFAULT_LICENSED_DEVICES)
+	}
 	if form.LicensedDevices < 0 {
 		form.LicensedDevices = 0
 	}
@@ -134,6 +141,7 @@ func (c *UsersController) HandleAdd() mvc.Result {
 		Password:        p,
 		Name:            form.Name,
 		Email:           form.Email,
+		Language:        form.Language,
 		Note:            form.Note,
 		LicensedDevices: form.LicensedDevices,
 		LoginVerify:     form.LoginVerify,
@@ -147,7 +155,7 @@ func (c *UsersController) HandleAdd() mvc.Result {
 		if !totp.Validate(form.TwoFactorAuthCode, form.TwoFactorAuthSecret) {
 			return c.Error(nil, "TFA_Validate_Err")
 		}
-		user.TwoFactorAuthSecret = form.TwoFactorAuthSecret
+		user.TwoFactorAuthSecret = model.EncryptedString(form.TwoFactorAuthSecret)
 	}
 
 	_, err = c.Db.Insert(user)
@@ -192,6 +200,7 @@ func (c *UsersController) HandleEdit() mvc.Result {
 	newUser := &model.User{
 		Name:            form.Name,
 		Email:           form.Email,
+		Language:        form.Language,
 		Note:            form.Note,
 		LicensedDevices: form.LicensedDevices,
 		LoginVerify:     form.LoginVerify,
@@ -211,14 +220,19 @@ func (c *UsersController) HandleEdit() mvc.Result {
 	}
 
 	// 要绑定2fa
-	if form.LoginVerify == model.LOGIN_TFA_CHECK && form.TwoFactorAuthSecret != user.TwoFactorAuthSecret {
+	if form.LoginVerify == model.LOGIN_TFA_CHECK && form.TwoFactorAuthSecret != user.TwoFactorAuthSecret.String() {
 		if !totp.Validate(form.TwoFactorAuthCode, form.TwoFactorAuthSecret) {
 			return c.Error(nil, "TFA_Validate_Err")
 		}
-		newUser.TwoFactorAuthSecret = form.TwoFactorAuthSecret
+		newUser.TwoFactorAuthSecret = model.EncryptedString(form.TwoFactorAuthSecret)
 	}
 
-	_, err = c.Db.Where("id = ?", form.Id).MustCols("licensed_devices", "status", "is_admin").Update(newUser)
+	update := c.Db.Where("id = ?", form.Id).MustCols("licensed_devices", "status", "is_admin", "language")
+	if newUser.TwoFactorAuthSecret == "" {
+		// keep the current secret, an empty EncryptedString would be written as ''
+		update.Omit("tfa_secret")
+	}
+	_, err = update.Update(newUser)
 	if err != nil {
 		return c.Error(nil, err.Error())
 	}
@@ -70,7 +70,7 @@ func (c *AddressBookPeerController) PostAbPeers() mvc.Result {
 		data = append(data, iris.Map{
 			"id":               peer.RustdeskId,
 			"hash":             peer.Hash,
-			"password":         peer.Password,
+			"password":         peer.Password.String(),
 			"username":         peer.Username,
 			"hostname":         peer.Hostname,
 			"platform":         peer.Platform,
@@ -247,7 +247,7 @@ func (c *AddressBookPeerController) HandleAbPeerUpdate() mvc.Result {
 
 	passwordResult := gjson.GetBytes(body, "password")
 	if passwordResult.Exists() {
-		peer.Password = passwordResult.String()
+		peer.Password = model.EncryptedString(passwordResult.String())
 	}
 
 	_, err = c.Db.Where("id = ?", peer.Id).Cols("tags", "alias", "hash", "password").Update(&peer)
@@ -84,8 +84,7 @@ func (c *AddressBookController) GetAb() mvc.Result {
 	}
 
 	// Log for debugging
-	c.Ctx.Application().Logger().Infof("User %d (%s) accessing shared address books: %d tags, %d peers",
-		user.Id, user.Username, len(tags), len(peers))
+	c.Log().Debug("Shared address books", "user_id", user.Id, "username", user.Username, "tags", len(tags), "peers", len(peers))
 
 	tagColorsJson, err := json.Marshal(tagColors)
 	if err != nil {
@@ -498,7 +497,7 @@ func (c *AddressBookController) PostAbTags() mvc.Result {
 	}
 
 	// Log for debugging
-	c.Ctx.Application().Logger().Infof("PostAbTags: Found %d tags for user %d", len(tags), user.Id)
+	c.Log().Debug("Address book tags", "count", len(tags), "user_id", user.Id)
 
 	// Return array directly without wrapping in "data" key
 	// RustDesk 1.4.x expects: [{"name":"tag1","color":4278190335}, ...]
@@ -1,13 +1,13 @@
 package api
 
 import (
+	"errors"
 	"io"
-	"rustdesk-api-server-pro/app/model"
-	"time"
+	"rustdesk-api-server-pro/app/service"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
 	"github.com/kataras/iris/v12/mvc"
-	"github.com/tidwall/gjson"
 )
 
 type AuditController struct {
@@ -37,66 +37,13 @@ func (c *AuditController) PostAuditConn() mvc.Result {
 		}
 	}
 
-	rustdeskId := gjson.GetBytes(body, "id").String()
-	sessionId := gjson.GetBytes(body, "session_id").String()
-
-	if gjson.GetBytes(body, "note").Exists() { // 只更新备注
-		c.Db.Where("session_id = ?", sessionId).Update(&model.Audit{
-			Note: gjson.GetBytes(body, "note").String(),
-		})
-		return mvc.Response{}
-	}
-
-	connId := int(gjson.GetBytes(body, "conn_id").Int())
-	uuid := gjson.GetBytes(body, "uuid").String()
-
-	actionResult := gjson.GetBytes(body, "action")
-	if actionResult.Exists() {
-		action := actionResult.String()
-		if action == "new" {
-			ip := gjson.GetBytes(body, "ip").String()
-			
-			// Try to get authenticated user (may be nil if no auth token)
-			var userId int = 0
-			user := c.GetUser()
-			if user != nil {
-				userId = user.Id
-			} else {
-				// If no auth token, try to find user by rustdesk_id from peer table
-				var peer model.Peer
-				has, _ := c.Db.Where("rustdesk_id = ?", rustdeskId).Get(&peer)
-				if has && peer.UserId > 0 {
-					userId = peer.UserId
-				}
-			}
-			
-			c.Db.Insert(&model.Audit{
-				UserId:     userId,
-				ConnId:     connId,
-				RustdeskId: rustdeskId,
-				IP:         ip,
-				SessionId:  sessionId,
-				Uuid:       uuid,
-			})
-		}
-		if action == "close" {
-			c.Db.Where("conn_id = ?", connId).Update(&model.Audit{
-				ClosedAt: time.Now(),
-			})
+	err = service.NewAuditService().RecordConn(body, jwt.FromHeader(c.Ctx))
+	if err != nil {
+		return mvc.Response{
+			Object: iris.Map{
+				"error": err.Error(),
+			},
 		}
-		return mvc.Response{}
-	}
-
-	peerResult := gjson.GetBytes(body, "peer")
-	if peerResult.Exists() {
-		t := gjson.GetBytes(body, "type").Int()
-		_type := int(t)
-		peer := peerResult.String()
-		c.Db.Where("conn_id = ?", connId).Update(&model.Audit{
-			SessionId: sessionId,
-			Type:      _type,
-			Peer:      peer,
-		})
 	}
 
 	return mvc.Response{}
@@ -119,24 +66,48 @@ func (c *AuditController) PostAuditFile() mvc.Result {
 		}
 	}
 
-	c.Db.Insert(&model.FileTransfer{
-		RustdeskId: gjson.GetBytes(body, "id").String(),
-		Info:       gjson.GetBytes(body, "info").String(),
-		IsFile:     gjson.GetBytes(body, "is_file").Bool(),
-		Path:       gjson.GetBytes(body, "path").String(),
-		PeerId:     gjson.GetBytes(body, "peer_id").String(),
-		Type:       int(gjson.GetBytes(body, "type").Int()),
-		Uuid:       gjson.GetBytes(body, "type").String(),
-	})
+	_, err = service.NewAuditService().RecordFileTransfer(body, jwt.FromHeader(c.Ctx))
+	if err != nil {
+		return mvc.Response{
+			Object: iris.Map{
+				"error": err.Error(),
+			},
+		}
+	}
 
 	return mvc.Response{}
 }
 
 func (c *AuditController) PostAuditAlarm() mvc.Result {
+	// {"id":"1235182932","info":"{\"id\":\"182921366\",\"ip\":\"::ffff:192.168.100.170\",\"name\":\"mrkin\"}","typ":2,"uuid":"NzIwQTBFMUYtRjg1OS01NjU0LUJCREUtMkNCMEU5MzQ5QzhF"}
+	// 重要字段解析
+	// typ：0=IP白名单，1=超过30次密码错误，2=1分钟内6次密码错误，3=同一IPv6前缀密码错误过多
+
+	body, err := io.ReadAll(c.Ctx.Request().Body)
+	if err != nil {
+		return mvc.Response{
+			Object: iris.Map{
+				"error": err.Error(),
+			},
+		}
+	}
 
-	return mvc.Response{
-		Object: iris.Map{
-			"error": "11",
-		},
+	_, err = service.NewAlarmService().Record(body, c.Ctx.RemoteAddr())
+	if errors.Is(err, service.ErrAlarmRateLimited) {
+		return mvc.Response{
+			Code: iris.StatusTooManyRequests,
+			Object: iris.Map{
+				"error": err.Error(),
+			},
+		}
+	}
+	if err != nil {
+		return mvc.Response{
+			Object: iris.Map{
+				"error": err.Error(),
+			},
+		}
 	}
+
+	return mvc.Response{}
 }
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"log/slog"
+	"rustdesk-api-server-pro/app/middleware"
 	"rustdesk-api-server-pro/app/model"
 	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/logger"
 
 	"github.com/kataras/iris/v12"
 	"xorm.io/xorm"
@@ -13,6 +16,13 @@ type basicController struct {
 	Db  *xorm.Engine
 }
 
+var controllerLog = logger.Module("api")
+
+// Log returns the logger of the request, the records carry its request id
+func (c *basicController) Log() *slog.Logger {
+	return controllerLog.With(logger.REQUEST_ID_KEY, middleware.RequestId(c.Ctx))
+}
+
 func (c *basicController) GetUser() *model.User {
 	user := c.Ctx.Values().Get(config.CurrentUserKey)
 	if user != nil {
@@ -28,3 +38,8 @@ func (c *basicController) GetToken() string {
 func (c *basicController) GetAuthToken() *model.AuthToken {
 	return c.Ctx.Values().Get(config.CurrentAuthToken).(*model.AuthToken)
 }
+
+// RequestUrl returns the url of this server as the client reached it
+func (c *basicController) RequestUrl() string {
+	return middleware.RequestUrl(c.Ctx)
+}
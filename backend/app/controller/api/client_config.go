@@ -0,0 +1,100 @@
+package api
+
+import (
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/rustdesk"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+type ClientConfigController struct {
+	basicController
+}
+
+func (c *ClientConfigController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/client-config", "HandleClientConfig")
+	b.Handle("GET", "/client-config/qr", "HandleQRCode")
+}
+
+// HandleClientConfig GET /api/client-config returns the ID server, relay, api and key a client needs, as the config
+// code it imports and as the name of a configured windows installer. nextKey is the key a pending rotation puts in
+// place at nextKeyAt. With ?id=<rustdesk id>&uuid=<uuid> the device is recorded as using the current key.
+func (c *ClientConfigController) HandleClientConfig() mvc.Result {
+	cfg, err := service.NewClientConfigService().Build(c.RequestUrl())
+	if err != nil {
+		c.Log().Error("Build client config error", "error", err)
+		return mvc.Response{Code: iris.StatusInternalServerError, Object: iris.Map{"error": "the client config can not be built"}}
+	}
+	data := clientConfigMap(cfg)
+	if id := c.Ctx.URLParam("id"); id != "" && cfg.Key != "" {
+		if err = service.NewServerKeyService().ReportKey(id, c.Ctx.URLParam("uuid"), cfg.Key); err != nil {
+			c.Log().Warn("Record device key error", "rustdesk_id", id, "error", err)
+		}
+	}
+	if pending, err := service.NewServerKeyService().Pending(); err == nil && pending != nil {
+		data["nextKey"] = pending.PublicKey
+		data["nextKeyAt"] = pending.ActivateAt.Format(config.TimeFormat)
+	}
+	return mvc.Response{Object: data}
+}
+
+// HandleQRCode GET /api/client-config/qr?size=256 is the png QR code of the config code for the mobile clients
+func (c *ClientConfigController) HandleQRCode() mvc.Result {
+	size := c.Ctx.URLParamIntDefault("size", 256)
+	if size < 64 || size > 1024 {
+		return mvc.Response{Code: iris.StatusBadRequest, Object: iris.Map{"error": "size must be between 64 and 1024"}}
+	}
+	cfg, err := service.NewClientConfigService().Build(c.RequestUrl())
+	if err != nil {
+		c.Log().Error("Build client config error", "error", err)
+		return mvc.Response{Code: iris.StatusInternalServerError, Object: iris.Map{"error": "the client config can not be built"}}
+	}
+	if cfg.Host == "" {
+		return mvc.Response{Code: iris.StatusNotFound, Object: iris.Map{"error": "the client.idServer setting is not set"}}
+	}
+	png, err := cfg.QRCode(size)
+	if err != nil {
+		c.Log().Error("Render client config QR code error", "error", err)
+		return mvc.Response{Code: iris.StatusInternalServerError, Object: iris.Map{"error": "the QR code can not be rendered"}}
+	}
+	return mvc.Response{ContentType: "image/png", Content: png}
+}
+
+// clientConfigMap is the client config as the api returns it, code and installerName are empty until the ID
+// server is set
+func clientConfigMap(cfg *rustdesk.ClientConfig) iris.Map {
+	data := iris.Map{
+		"host":          cfg.Host,
+		"relay":         cfg.Relay,
+		"api":           cfg.Api,
+		"key":           cfg.Key,
+		"code":          "",
+		"installerName": "",
+		"warnings":      []string{},
+	}
+	if cfg.Host == "" {
+		data["warnings"] = []string{"The ID server is not set (client.idServer setting), clients can not be configured yet."}
+		return data
+	}
+	data["code"] = cfg.Code()
+	name, skipped := cfg.InstallerName()
+	data["installerName"] = name
+	warnings := []string{}
+	for _, field := range skipped {
+		if field == "key" {
+			warnings = append(warnings, "The key has characters a windows file name can not hold, the installer name leaves it out. "+
+				"Import the config code, or generate a new key until it has none.")
+			continue
+		}
+		if field == "api" {
+			warnings = append(warnings, "The installer name can not hold the api url, clients installed with it use http://<ID server>:21114. "+
+				"Import the config code when the api is served elsewhere.")
+			continue
+		}
+		warnings = append(warnings, "The installer name leaves out "+field+", a windows file name can not hold it. Import the config code to set it.")
+	}
+	data["warnings"] = warnings
+	return data
+}
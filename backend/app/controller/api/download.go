@@ -3,7 +3,11 @@ package api
 import (
 	"os"
 	"path/filepath"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
+	"strconv"
+	"strings"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -19,6 +23,8 @@ func (c *DownloadController) BeforeActivation(b mvc.BeforeActivation) {
 	b.Handle("GET", "/download/windows", "HandleWindows")
 	b.Handle("GET", "/download/macos", "HandleMacos")
 	b.Handle("GET", "/download/linux", "HandleLinux")
+	b.Handle("GET", "/download/android", "HandleAndroid")
+	b.Handle("GET", "/download/file/{id:int}", "HandleCatalogFile")
 	b.Handle("GET", "/download/{filename}", "HandleFile")
 }
 
@@ -37,13 +43,24 @@ func (c *DownloadController) HandleLinux() {
 	c.serveInstaller("linux", "rustdesk-linux.deb")
 }
 
+// HandleAndroid GET /api/download/android
+func (c *DownloadController) HandleAndroid() {
+	c.serveInstaller("android", "rustdesk-android.apk")
+}
+
 // HandleFile GET /api/download/{filename}
 func (c *DownloadController) HandleFile(filename string) {
 	c.serveInstaller("", filename)
 }
 
-// serveInstaller serves the installer file
+// serveInstaller serves the newest build of platform from the installer catalog, for ?arch= (guessed from the
+// user agent when missing) and ?channel= (stable by default, beta includes stable builds). Without a catalog
+// build the first file of installersDir with an extension of the platform is served.
 func (c *DownloadController) serveInstaller(platform, defaultFilename string) {
+	signed, ok := c.authorize()
+	if !ok {
+		return
+	}
 	cfg := config.GetServerConfig()
 	installersPath := cfg.HttpConfig.InstallersDir
 	if installersPath == "" {
@@ -53,6 +70,20 @@ func (c *DownloadController) serveInstaller(platform, defaultFilename string) {
 	var filePath string
 
 	if platform != "" {
+		arch := service.NormalizeArch(c.Ctx.URLParam("arch"))
+		if arch == "" {
+			arch = archFromUserAgent(platform, c.Ctx.GetHeader("User-Agent"))
+		}
+		installers := service.NewInstallerService()
+		installer, err := installers.Latest(platform, arch, c.Ctx.URLParamDefault("channel", model.INSTALLER_CHANNEL_STABLE))
+		if err != nil {
+			c.Log().Error("Read installer catalog error", "error", err)
+		}
+		if installer != nil {
+			c.serveFile(installers.FilePath(installer), installer.Sha256, catalogEvent(installer, signed))
+			return
+		}
+
 		// Try to find any file matching the platform
 		files, err := os.ReadDir(installersPath)
 		if err != nil {
@@ -62,24 +93,9 @@ func (c *DownloadController) serveInstaller(platform, defaultFilename string) {
 		}
 
 		for _, file := range files {
-			name := file.Name()
-			switch platform {
-			case "windows":
-				if filepath.Ext(name) == ".exe" || filepath.Ext(name) == ".msi" {
-					filePath = filepath.Join(installersPath, name)
-					break
-				}
-			case "macos":
-				if filepath.Ext(name) == ".dmg" || filepath.Ext(name) == ".pkg" {
-					filePath = filepath.Join(installersPath, name)
-					break
-				}
-			case "linux":
-				ext := filepath.Ext(name)
-				if ext == ".deb" || ext == ".rpm" || ext == ".AppImage" {
-					filePath = filepath.Join(installersPath, name)
-					break
-				}
+			if !file.IsDir() && installerPlatform(file.Name()) == platform {
+				filePath = filepath.Join(installersPath, file.Name())
+				break
 			}
 		}
 	} else {
@@ -91,22 +107,113 @@ func (c *DownloadController) serveInstaller(platform, defaultFilename string) {
 		c.Ctx.JSON(iris.Map{"error": "Installer not found for " + platform})
 		return
 	}
+	name := filepath.Base(filePath)
+	c.serveFile(filePath, "", &model.DownloadEvent{File: name, Platform: installerPlatform(name), Signed: signed})
+}
+
+// HandleCatalogFile GET /api/download/file/{id} serves a build of the installer catalog
+func (c *DownloadController) HandleCatalogFile(id int) {
+	signed, ok := c.authorize()
+	if !ok {
+		return
+	}
+	installers := service.NewInstallerService()
+	installer, err := installers.Get(id)
+	if err != nil {
+		c.Ctx.StatusCode(iris.StatusNotFound)
+		c.Ctx.JSON(iris.Map{"error": "Installer not found"})
+		return
+	}
+	c.serveFile(installers.FilePath(installer), installer.Sha256, catalogEvent(installer, signed))
+}
+
+// authorize checks the signature of a signed link, and that there is one when httpConfig.requireSignedDownloads
+// is set. The request is answered with 403 when ok is false. The link is signed on the decoded path.
+func (c *DownloadController) authorize() (signed bool, ok bool) {
+	signed, err := service.VerifyDownload(c.Ctx.Request().URL.Path, c.Ctx.Request().URL.Query())
+	if err != nil {
+		c.Ctx.StatusCode(iris.StatusForbidden)
+		c.Ctx.JSON(iris.Map{"error": err.Error()})
+		return false, false
+	}
+	return signed, true
+}
+
+func catalogEvent(installer *model.Installer, signed bool) *model.DownloadEvent {
+	return &model.DownloadEvent{
+		File:        installer.Filename,
+		InstallerId: installer.Id,
+		Platform:    installer.Platform,
+		Arch:        installer.Arch,
+		Version:     installer.Version,
+		Signed:      signed,
+	}
+}
 
+// serveFile sends an installer as an attachment named event.File, with its checksum in X-Checksum-Sha256 when
+// known, and records the download
+func (c *DownloadController) serveFile(filePath, sha256 string, event *model.DownloadEvent) {
+	filename := event.File
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		c.Ctx.StatusCode(iris.StatusNotFound)
-		c.Ctx.JSON(iris.Map{"error": "Installer file not found: " + filepath.Base(filePath)})
+		c.Ctx.JSON(iris.Map{"error": "Installer file not found: " + filename})
 		return
 	}
+	// a resumed download is counted once, by the request for its first bytes
+	if r := c.Ctx.GetHeader("Range"); r == "" || strings.HasPrefix(r, "bytes=0-") {
+		event.Ip, event.UserAgent = c.Ctx.RemoteAddr(), c.Ctx.GetHeader("User-Agent")
+		service.NewDownloadService().Record(event)
+	}
 
+	// ?configured=true names the windows installer after the client config, the client applies it when installed
+	if c.Ctx.URLParamBoolDefault("configured", false) && strings.EqualFold(filepath.Ext(filename), ".exe") {
+		if cfg, err := service.NewClientConfigService().Build(c.RequestUrl()); err == nil && cfg.Host != "" {
+			filename, _ = cfg.InstallerName()
+		}
+	}
 	// Set Content-Disposition header to force download with correct filename
-	filename := filepath.Base(filePath)
 	c.Ctx.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	if sha256 != "" {
+		c.Ctx.Header("X-Checksum-Sha256", sha256)
+	}
 
 	// Serve the file
 	c.Ctx.ServeFile(filePath)
 }
 
+// installerPlatform tells the platform of an installer by its extension, empty when it is not an installer
+func installerPlatform(name string) string {
+	switch filepath.Ext(name) {
+	case ".exe", ".msi":
+		return "windows"
+	case ".dmg", ".pkg":
+		return "macos"
+	case ".deb", ".rpm", ".AppImage":
+		return "linux"
+	case ".apk":
+		return "android"
+	}
+	return ""
+}
+
+// archFromUserAgent guesses the arch of the browser, macos browsers report Intel on Apple silicon too so any
+// arch is taken there
+func archFromUserAgent(platform, userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case platform == "macos":
+		return ""
+	case strings.Contains(ua, "arm64") || strings.Contains(ua, "aarch64"):
+		return model.INSTALLER_ARCH_ARM64
+	case strings.Contains(ua, "x86_64") || strings.Contains(ua, "x64") || strings.Contains(ua, "win64") || strings.Contains(ua, "amd64"):
+		return model.INSTALLER_ARCH_X86_64
+	case platform == "windows" || platform == "linux":
+		return model.INSTALLER_ARCH_X86_64
+	}
+	return ""
+}
+
 // HandleList GET /api/download/list - lista os instaladores disponíveis
 func (c *DownloadController) HandleList() {
 	cfg := config.GetServerConfig()
@@ -116,9 +223,9 @@ func (c *DownloadController) HandleList() {
 	}
 
 	// Debug log
-	c.Ctx.Application().Logger().Infof("ExternalLinks config: %+v", cfg.HttpConfig.ExternalLinks)
+	c.Log().Debug("External links", "links", cfg.HttpConfig.ExternalLinks)
 	if cfg.HttpConfig.ExternalLinks != nil && cfg.HttpConfig.ExternalLinks.Windows != nil {
-		c.Ctx.Application().Logger().Infof("Windows URL: %s", cfg.HttpConfig.ExternalLinks.Windows.URL)
+		c.Log().Debug("External windows link", "url", cfg.HttpConfig.ExternalLinks.Windows.URL)
 	}
 
 	type InstallerInfo struct {
@@ -127,6 +234,14 @@ func (c *DownloadController) HandleList() {
 		Size     int64  `json:"size"`
 		URL      string `json:"url"`
 		External bool   `json:"external"`
+		// ConfiguredURL downloads a local windows installer named after the client config
+		ConfiguredURL string `json:"configuredUrl,omitempty"`
+		// catalog builds
+		Arch         string `json:"arch,omitempty"`
+		Version      string `json:"version,omitempty"`
+		Channel      string `json:"channel,omitempty"`
+		Sha256       string `json:"sha256,omitempty"`
+		ReleaseNotes string `json:"releaseNotes,omitempty"`
 	}
 
 	var installers []InstallerInfo
@@ -174,6 +289,29 @@ func (c *DownloadController) HandleList() {
 		}
 	}
 
+	// the installer catalog, newest version first
+	catalog, err := service.NewInstallerService().List("", "", "")
+	if err != nil {
+		c.Log().Error("Read installer catalog error", "error", err)
+	}
+	for _, i := range catalog {
+		installer := InstallerInfo{
+			Name:         i.Filename,
+			Platform:     i.Platform,
+			Size:         i.Size,
+			URL:          "/api/download/file/" + strconv.Itoa(i.Id),
+			Arch:         i.Arch,
+			Version:      i.Version,
+			Channel:      i.Channel,
+			Sha256:       i.Sha256,
+			ReleaseNotes: i.ReleaseNotes,
+		}
+		if strings.EqualFold(filepath.Ext(i.Filename), ".exe") {
+			installer.ConfiguredURL = installer.URL + "?configured=true"
+		}
+		installers = append(installers, installer)
+	}
+
 	// Depois, adiciona arquivos locais (se existirem)
 	files, err := os.ReadDir(installersPath)
 	if err == nil {
@@ -184,16 +322,8 @@ func (c *DownloadController) HandleList() {
 
 			name := file.Name()
 			ext := filepath.Ext(name)
-
-			var platform string
-			switch ext {
-			case ".exe", ".msi":
-				platform = "windows"
-			case ".dmg", ".pkg":
-				platform = "macos"
-			case ".deb", ".rpm", ".AppImage":
-				platform = "linux"
-			default:
+			platform := installerPlatform(name)
+			if platform == "" {
 				continue
 			}
 
@@ -215,17 +345,30 @@ func (c *DownloadController) HandleList() {
 				size = info.Size()
 			}
 
-			installers = append(installers, InstallerInfo{
+			installer := InstallerInfo{
 				Name:     name,
 				Platform: platform,
 				Size:     size,
 				URL:      "/api/download/" + name,
 				External: false,
-			})
+			}
+			if ext == ".exe" {
+				installer.ConfiguredURL = installer.URL + "?configured=true"
+			}
+			installers = append(installers, installer)
 		}
 	}
 
-	c.Ctx.JSON(iris.Map{
-		"installers": installers,
-	})
+	data := iris.Map{
+		"installers":   installers,
+		"instructions": service.ClientInstructions,
+		// the urls only work with a signed link then
+		"signedOnly": cfg.HttpConfig.RequireSignedDownloads,
+	}
+	if clientConfig, err := service.NewClientConfigService().Build(c.RequestUrl()); err == nil {
+		data["config"] = clientConfigMap(clientConfig)
+	} else {
+		c.Log().Error("Build client config error", "error", err)
+	}
+	c.Ctx.JSON(data)
 }
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/version"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+// HealthController serves the probes of docker and kubernetes, they need no authentication
+type HealthController struct {
+	basicController
+}
+
+func (c *HealthController) BeforeActivation(b mvc.BeforeActivation) {
+	b.Handle("GET", "/healthz", "HandleHealthz")
+	b.Handle("GET", "/readyz", "HandleReadyz")
+	b.Handle("GET", "/version", "HandleVersion")
+}
+
+// HandleHealthz answers as long as the process serves http
+func (c *HealthController) HandleHealthz() mvc.Result {
+	return mvc.Response{
+		Object: iris.Map{
+			"status": service.HEALTH_OK,
+		},
+	}
+}
+
+// HandleReadyz checks the database, migrations and scheduler, ?smtp=true also connects to the smtp server
+func (c *HealthController) HandleReadyz() mvc.Result {
+	ready, checks := service.NewHealthService().Ready(c.Ctx.Request().Context(), c.Ctx.URLParamBoolDefault("smtp", false))
+	status, code := service.HEALTH_OK, iris.StatusOK
+	if !ready {
+		status, code = service.HEALTH_FAIL, iris.StatusServiceUnavailable
+	}
+	return mvc.Response{
+		Code: code,
+		Object: iris.Map{
+			"status": status,
+			"checks": checks,
+		},
+	}
+}
+
+func (c *HealthController) HandleVersion() mvc.Result {
+	info := version.Get()
+	result := iris.Map{
+		"version":    info.Version,
+		"commit":     info.Commit,
+		"build_time": info.BuildTime,
+		"go_version": info.GoVersion,
+		"db_driver":  config.GetServerConfig().Db.Driver,
+	}
+	if status := service.RustdeskStatus(); status != nil {
+		result["rustdesk"] = status
+	}
+	return mvc.Response{
+		Object: result,
+	}
+}
@@ -29,6 +29,7 @@ func (c *LoginController) PostLogin() mvc.Result {
 		}
 	}
 
+	loginForm.RemoteIp = c.Ctx.RemoteAddr()
 	userService := service.NewUserService()
 
 	// {"type":"email_code","verificationCode":"666666","secret":""} // email
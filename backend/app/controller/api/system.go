@@ -3,6 +3,7 @@ package api
 import (
 	"rustdesk-api-server-pro/app/form/api"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"time"
 
 	"github.com/kataras/iris/v12"
@@ -66,6 +67,15 @@ func (c *SystemController) PostHeartbeat() mvc.Result {
 		}
 	}
 
+	// sessions the client no longer reports were closed without a close report
+	if err = service.NewAuditService().ReconcileConns(form.RustdeskId, form.Conns); err != nil {
+		return mvc.Response{
+			Object: iris.Map{
+				"error": err.Error(),
+			},
+		}
+	}
+
 	return mvc.Response{
 		Object: iris.Map{
 			"modified_at": time.Now().Unix(),
@@ -113,6 +123,12 @@ func (c *SystemController) PostSysinfo() mvc.Result {
 
 	c.Db.Where("id = ?", device.Id).Update(&device)
 
+	if form.Key != "" {
+		if err = service.NewServerKeyService().ReportKey(form.RustdeskId, form.Uuid, form.Key); err != nil {
+			c.Log().Error("Failed to record the device key", "error", err)
+		}
+	}
+
 	// Update platform in peers table when OS info is received
 	if form.Os != "" {
 		_, err = c.Db.Where("rustdesk_id = ?", form.RustdeskId).Cols("platform", "hostname", "username").Update(&model.Peer{
@@ -122,7 +138,7 @@ func (c *SystemController) PostSysinfo() mvc.Result {
 		})
 		if err != nil {
 			// Log error but don't fail the request
-			c.Ctx.Application().Logger().Errorf("Failed to update peer platform: %v", err)
+			c.Log().Error("Failed to update peer platform", "error", err)
 		}
 	}
 
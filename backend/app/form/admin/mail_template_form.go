@@ -1,9 +1,11 @@
 package admin
 
 type MailTemplateForm struct {
-	Id       int    `json:"id"`
-	Name     string `json:"name"`
-	Type     int    `json:"type"`
-	Subject  string `json:"subject"`
-	Contents string `json:"contents"`
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	Type         int    `json:"type"`
+	Language     string `json:"language"`
+	Subject      string `json:"subject"`
+	Contents     string `json:"contents"`
+	TextContents string `json:"text_contents"`
 }
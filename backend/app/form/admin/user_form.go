@@ -6,6 +6,7 @@ type UserForm struct {
 	Password            string `json:"password"`
 	Name                string `json:"name"`
 	Email               string `json:"email"`
+	Language            string `json:"language"`     // mail language, e.g. en or zh-CN
 	LoginVerify         string `json:"login_verify"` // email_check tfa_check access_token
 	TwoFactorAuthSecret string `json:"tfa_secret"`   // 2fa key
 	TwoFactorAuthCode   string `json:"tfa_code"`     // 2fa code
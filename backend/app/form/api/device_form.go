@@ -9,4 +9,5 @@ type DeviceForm struct {
 	Username   string `json:"username"`
 	Uuid       string `json:"uuid"`
 	Version    string `json:"version"`
+	Key        string `json:"key"` // public key of the server, sent by deployment agents, not by the rustdesk client
 }
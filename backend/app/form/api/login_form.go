@@ -11,6 +11,7 @@ type LoginForm struct {
 	TfaCode          string     `json:"tfaCode"`
 	Secret           string     `json:"secret"`
 	DeviceInfo       DeviceInfo `json:"deviceInfo"`
+	RemoteIp         string     `json:"-"`
 }
 
 type DeviceInfo struct {
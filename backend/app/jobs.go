@@ -1,49 +1,205 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
-	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/logger"
+	"strings"
 	"time"
 
 	"github.com/go-co-op/gocron/v2"
 	"github.com/golang-module/carbon/v2"
+	"xorm.io/xorm"
 )
 
-func StartJobs(cfg *config.ServerConfig) {
+var jobsLog = logger.Module("jobs")
 
-	dbEngine, err := db.NewEngine(cfg.Db)
+// StartJobs starts the scheduler, running jobs get stopTimeout to finish on Shutdown.
+// The jobs only run while leader holds the job lease, failed, slow and hourly runs are recorded in job_run.
+func StartJobs(cfg *config.ServerConfig, dbEngine *xorm.Engine, stopTimeout time.Duration, leader gocron.Elector) (gocron.Scheduler, error) {
+	s, err := gocron.NewScheduler(gocron.WithStopTimeout(stopTimeout), gocron.WithDistributedElector(leader))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	s, err := gocron.NewScheduler()
-	if err != nil {
-		panic(err)
-	}
-	
 	// Job: Check device online status
-	s.NewJob(gocron.DurationJob(time.Duration(cfg.JobsConfig.DeviceCheckJob.Duration)*time.Second), gocron.NewTask(func() {
+	service.RegisterJob(service.JOB_DEVICE_CHECK, "Set the devices that stopped reporting offline", func() (string, error) {
 		expired := carbon.Now(cfg.Db.TimeZone).SubSeconds(30).ToDateTimeString()
-		dbEngine.Where("is_online = 1 and updated_at <= ?", expired).Cols("is_online").Update(&model.Device{
+		count, err := dbEngine.Where("is_online = 1 and updated_at <= ?", expired).Cols("is_online").Update(&model.Device{
 			IsOnline: false,
 		})
-	}))
-
-	// Job: Close orphaned audit sessions (sessions without closed_at that are older than 2 hours)
-	// This handles disconnections due to network issues, Alt+F4, crashes, etc.
-	s.NewJob(gocron.DurationJob(5*time.Minute), gocron.NewTask(func() {
-		twoHoursAgo := carbon.Now(cfg.Db.TimeZone).SubHours(2).ToDateTimeString()
-		now := time.Now()
-		
-		// Find sessions that are open (closed_at is zero) and created more than 2 hours ago
-		dbEngine.Where("(closed_at IS NULL OR closed_at = '0001-01-01 00:00:00') AND created_at <= ?", twoHoursAgo).
-			Cols("closed_at", "note").
-			Update(&model.Audit{
-				ClosedAt: now,
-				Note:     "Auto-closed: session timeout",
-			})
-	}))
+		return fmt.Sprintf("%d devices offline", count), err
+	})
+	deviceCheckTask := scheduledTask(service.JOB_DEVICE_CHECK)
+	deviceCheckJob, err := s.NewJob(deviceCheckDuration(cfg), deviceCheckTask, jobOptions(service.JOB_DEVICE_CHECK)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_DEVICE_CHECK, func() (time.Time, error) { return deviceCheckJob.NextRun() })
+
+	// Reschedule the device check when its duration changes
+	config.OnReload(func(old, cfg *config.ServerConfig) {
+		if !config.Changed(old, cfg, "jobsConfig.deviceCheckJob") {
+			return
+		}
+		job, err := s.Update(deviceCheckJob.ID(), deviceCheckDuration(cfg), deviceCheckTask, jobOptions(service.JOB_DEVICE_CHECK)...)
+		if err != nil {
+			jobsLog.Error("Device check job reschedule error", "error", err)
+			return
+		}
+		deviceCheckJob = job
+	})
+
+	// Job: Close the audit sessions of devices that went offline without reporting the close
+	// (network loss, crashes, killed clients). The session ends when the device was last seen.
+	service.RegisterJob(service.JOB_CLOSE_OFFLINE_AUDITS, "Close the audit sessions of offline devices", func() (string, error) {
+		timeout := service.NewSettingsService().GetInt(service.SETTING_AUDIT_ORPHAN_TIMEOUT_MINS)
+		count, err := service.NewAuditService().CloseOffline(time.Duration(timeout) * time.Minute)
+		return fmt.Sprintf("%d sessions closed", count), err
+	})
+	closeOfflineJob, err := s.NewJob(gocron.DurationJob(time.Minute), scheduledTask(service.JOB_CLOSE_OFFLINE_AUDITS), jobOptions(service.JOB_CLOSE_OFFLINE_AUDITS)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_CLOSE_OFFLINE_AUDITS, closeOfflineJob.NextRun)
+
+	// Job: Prune the tables that have a retention policy
+	service.RegisterJob(service.JOB_RETENTION, "Prune the tables that have a retention policy", func() (string, error) {
+		results, err := service.NewRetentionService().Prune(false)
+		if err != nil {
+			return "", err
+		}
+		var pruned int64
+		var failed []string
+		for _, r := range results {
+			if r.Error != "" {
+				jobsLog.Error("Retention failed", "table", r.Table, "rows", r.Rows, "error", r.Error)
+				failed = append(failed, r.Table+": "+r.Error)
+			} else if r.Rows > 0 {
+				jobsLog.Info("Retention pruned", "table", r.Table, "rows", r.Rows, "days", r.Days)
+			}
+			pruned += r.Rows
+		}
+		if len(failed) > 0 {
+			return fmt.Sprintf("%d rows pruned", pruned), errors.New(strings.Join(failed, "; "))
+		}
+		return fmt.Sprintf("%d rows pruned", pruned), nil
+	})
+	retentionTask := scheduledTask(service.JOB_RETENTION)
+	retentionJob, err := s.NewJob(retentionDuration(cfg), retentionTask, jobOptions(service.JOB_RETENTION)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_RETENTION, func() (time.Time, error) { return retentionJob.NextRun() })
+
+	config.OnReload(func(old, cfg *config.ServerConfig) {
+		if !config.Changed(old, cfg, "jobsConfig.retentionJob") {
+			return
+		}
+		job, err := s.Update(retentionJob.ID(), retentionDuration(cfg), retentionTask, jobOptions(service.JOB_RETENTION)...)
+		if err != nil {
+			jobsLog.Error("Retention job reschedule error", "error", err)
+			return
+		}
+		retentionJob = job
+	})
+
+	// Job: Put the pending server key in place at the end of its grace period
+	service.RegisterJob(service.JOB_SERVER_KEY_ROTATION, "Activate the pending server key when its grace period ends", func() (string, error) {
+		key, err := service.NewServerKeyService().ActivateDue()
+		if err != nil || key == nil {
+			return "", err
+		}
+		return "activated " + key.PublicKey, nil
+	})
+	keyRotationJob, err := s.NewJob(gocron.DurationJob(time.Minute), scheduledTask(service.JOB_SERVER_KEY_ROTATION), jobOptions(service.JOB_SERVER_KEY_ROTATION)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_SERVER_KEY_ROTATION, keyRotationJob.NextRun)
+
+	// Job: Deliver the queued mails, the failed ones are retried with a growing backoff
+	service.RegisterJob(service.JOB_MAIL_QUEUE, "Deliver the queued mails", func() (string, error) {
+		result, err := service.NewMailQueueService().Drain()
+		return result.String(), err
+	})
+	mailQueueTask := scheduledTask(service.JOB_MAIL_QUEUE)
+	mailQueueJob, err := s.NewJob(mailQueueDuration(cfg), mailQueueTask, jobOptions(service.JOB_MAIL_QUEUE)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_MAIL_QUEUE, func() (time.Time, error) { return mailQueueJob.NextRun() })
+
+	config.OnReload(func(old, cfg *config.ServerConfig) {
+		if !config.Changed(old, cfg, "jobsConfig.mailQueueJob.duration") {
+			return
+		}
+		job, err := s.Update(mailQueueJob.ID(), mailQueueDuration(cfg), mailQueueTask, jobOptions(service.JOB_MAIL_QUEUE)...)
+		if err != nil {
+			jobsLog.Error("Mail queue job reschedule error", "error", err)
+			return
+		}
+		mailQueueJob = job
+	})
+
+	// Job: Mail the audit report of the previous month
+	service.RegisterJob(service.JOB_MONTHLY_REPORT, "Mail the audit report of the previous month", func() (string, error) {
+		if !service.NewSettingsService().GetBool(service.SETTING_REPORT_MONTHLY_ENABLED) {
+			return "the monthly report is disabled", nil
+		}
+		if err := service.NewReportService().SendMonthlyReport(time.Now()); err != nil {
+			return "", err
+		}
+		return "report sent", nil
+	})
+	reportJob, err := s.NewJob(gocron.MonthlyJob(1, gocron.NewDaysOfTheMonth(1), gocron.NewAtTimes(gocron.NewAtTime(6, 0, 0))),
+		scheduledTask(service.JOB_MONTHLY_REPORT), jobOptions(service.JOB_MONTHLY_REPORT)...)
+	if err != nil {
+		return nil, err
+	}
+	service.SetJobNextRun(service.JOB_MONTHLY_REPORT, reportJob.NextRun)
 
 	s.Start()
+
+	// the device check runs every few seconds, a stopped or stalled scheduler leaves its next run behind
+	service.SetSchedulerProbe(func() error {
+		next, err := deviceCheckJob.NextRun()
+		if err != nil {
+			return err
+		}
+		if !next.IsZero() && time.Since(next) > time.Minute {
+			return fmt.Errorf("the device check job is overdue since %s", next.Format(config.TimeFormat))
+		}
+		return nil
+	})
+	return s, nil
+}
+
+// scheduledTask runs a registered job through the job service, which records the run
+func scheduledTask(name string) gocron.Task {
+	return gocron.NewTask(func() {
+		if _, err := service.NewJobService().Run(name, model.JOB_TRIGGER_SCHEDULE); err != nil {
+			// a manual run of the job is not done yet
+			jobsLog.Warn("Scheduled job skipped", "job", name, "error", err)
+		}
+	})
+}
+
+func jobOptions(name string) []gocron.JobOption {
+	return []gocron.JobOption{gocron.WithName(name), gocron.WithSingletonMode(gocron.LimitModeReschedule)}
+}
+
+func deviceCheckDuration(cfg *config.ServerConfig) gocron.JobDefinition {
+	return gocron.DurationJob(time.Duration(cfg.JobsConfig.DeviceCheckJob.Duration) * time.Second)
+}
+
+func retentionDuration(cfg *config.ServerConfig) gocron.JobDefinition {
+	return gocron.DurationJob(time.Duration(cfg.JobsConfig.RetentionJob.Duration) * time.Second)
+}
+
+func mailQueueDuration(cfg *config.ServerConfig) gocron.JobDefinition {
+	return gocron.DurationJob(time.Duration(cfg.JobsConfig.MailQueueJob.Duration) * time.Second)
 }
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/kataras/iris/v12"
+	"xorm.io/xorm"
+)
+
+// Lifecycle owns the db engine, the job scheduler and the http server of the api server.
+// Run blocks until SIGINT or SIGTERM, Shutdown then stops them in order: the http server (requests in
+// flight finish), hbbs and hbbr, the jobs and their lease, the open audit sessions, the syslog queue and last the database.
+// shutdown.timeout bounds the whole sequence, not each step.
+type Lifecycle struct {
+	cfg       *config.ServerConfig
+	engine    *xorm.Engine
+	app       *iris.Application
+	scheduler gocron.Scheduler
+	leader    *service.JobLeader
+	rustdesk  *rustdesk.Supervisor
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+
+	// SuperviseRustdesk runs hbbs and hbbr even when rustdesk.supervise is off
+	SuperviseRustdesk bool
+}
+
+func NewLifecycle(cfg *config.ServerConfig) (*Lifecycle, error) {
+	if err := configureLogger(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Rustdesk != nil {
+		service.ConfigureRustdesk(cfg.Rustdesk)
+	}
+
+	engine, err := db.NewEngine(cfg.Db)
+	if err != nil {
+		log.Error("Db engine create error", "error", err)
+		return nil, err
+	}
+
+	app, err := newApp(cfg, engine)
+	if err != nil {
+		_ = engine.Close()
+		return nil, err
+	}
+
+	return &Lifecycle{
+		cfg:    cfg,
+		engine: engine,
+		app:    app,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Run starts the jobs and serves http until the server is shut down
+func (l *Lifecycle) Run() error {
+	service.NewEventService()
+
+	if cfg := l.cfg.Rustdesk; cfg != nil && (cfg.Supervise || l.SuperviseRustdesk) {
+		l.startRustdesk(cfg)
+	}
+
+	l.leader = service.NewJobLeader(service.InstanceId(), time.Duration(l.cfg.JobsConfig.LeaseSeconds)*time.Second)
+	l.leader.Start()
+
+	scheduler, err := StartJobs(l.cfg, l.engine, shutdownTimeout(), l.leader)
+	if err != nil {
+		l.Shutdown()
+		return err
+	}
+	l.scheduler = scheduler
+
+	startReload(l.app, l.cfg, l.stop)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Info("Shutting down", "signal", sig.String(), "timeout", shutdownTimeout().String())
+			l.Shutdown()
+		case <-l.stop:
+		}
+	}()
+
+	err = l.app.Listen(l.cfg.HttpConfig.Port,
+		iris.WithoutBodyConsumptionOnUnmarshal,
+		iris.WithoutInterruptHandler,
+		iris.WithoutServerError(iris.ErrServerClosed),
+	)
+	if err != nil {
+		// e.g. the port is in use
+		l.Shutdown()
+		return err
+	}
+
+	<-l.done
+	return nil
+}
+
+// Shutdown stops everything Run started, it can be called more than once and returns when all is stopped
+func (l *Lifecycle) Shutdown() {
+	l.stopOnce.Do(func() {
+		defer close(l.done)
+		close(l.stop)
+
+		// one deadline for every step, the later steps get what the earlier ones left
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+		defer cancel()
+		remaining := func() time.Duration {
+			deadline, _ := ctx.Deadline()
+			return max(time.Until(deadline), 0)
+		}
+		if err := l.app.Shutdown(ctx); err != nil {
+			log.Error("HTTP server shutdown error", "error", err)
+		}
+
+		if l.rustdesk != nil {
+			l.rustdesk.Stop(remaining())
+		}
+		if l.scheduler != nil {
+			stopped := make(chan error, 1)
+			go func() { stopped <- l.scheduler.Shutdown() }()
+			select {
+			case err := <-stopped:
+				if err != nil {
+					log.Error("Scheduler shutdown error", "error", err)
+				}
+			case <-ctx.Done():
+				log.Warn("Scheduled jobs did not finish in time")
+			}
+		}
+		if !service.WaitStarted(remaining()) {
+			log.Warn("Manual job runs did not finish in time")
+		}
+		// the sessions are shared by every replica, only the lease holder closes them
+		leading := l.leader != nil && l.leader.IsLeader(ctx) == nil
+		if l.leader != nil {
+			l.leader.Stop()
+		}
+
+		if cfg := config.GetServerConfig().Shutdown; cfg != nil && cfg.CloseAuditSessions {
+			if !leading {
+				log.Info("Open audit sessions kept, this instance does not hold the job lease")
+			} else if count, err := service.NewAuditService().CloseAll(model.AUDIT_CLOSE_REASON_SHUTDOWN); err != nil {
+				log.Error("Close audit sessions error", "error", err)
+			} else if count > 0 {
+				log.Info("Closed open audit sessions", "count", count)
+			}
+		}
+
+		service.StopEventService()
+
+		if err := l.engine.Close(); err != nil {
+			log.Error("Db engine close error", "error", err)
+		}
+		log.Info("Server stopped")
+	})
+}
+
+// startRustdesk supervises hbbs and hbbr, a missing binary is retried like a crash so
+// `rustdesk install` can run while the server is up
+func (l *Lifecycle) startRustdesk(cfg *config.Rustdesk) {
+	processes := service.RustdeskProcesses(cfg)
+	for _, p := range processes {
+		if _, err := os.Stat(p.Bin); err != nil {
+			log.Warn("rustdesk-server binary not found, run rustdesk install", "process", p.Name, "bin", p.Bin)
+		}
+	}
+	l.rustdesk = rustdesk.NewSupervisor(processes...)
+	l.rustdesk.Start()
+	service.SetRustdeskSupervisor(l.rustdesk)
+}
+
+func shutdownTimeout() time.Duration {
+	cfg := config.GetServerConfig().Shutdown
+	if cfg == nil || cfg.Timeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(cfg.Timeout) * time.Second
+}
@@ -1,68 +1,66 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
 	"rustdesk-api-server-pro/app/middleware"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
-	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/logger"
+	"syscall"
+	"time"
 
+	"github.com/kataras/golog"
 	"github.com/kataras/iris/v12"
 	"xorm.io/xorm"
 )
 
-func newApp(cfg *config.ServerConfig) (*iris.Application, error) {
+var log = logger.Module("app")
+
+func newApp(cfg *config.ServerConfig, dbEngine *xorm.Engine) (*iris.Application, error) {
+	routeIrisLogger(golog.Default)
 	app := iris.Default()
+	app.Logger().SetLevel(irisLevel())
 
-	dbEngine, err := db.NewEngine(cfg.Db)
+	// Auto-sync database tables on startup
+	log.Info("Syncing database tables")
+	err := dbEngine.Sync2(model.Tables()...)
 	if err != nil {
-		app.Logger().Fatal("Db Engine create error:", err)
+		log.Error("Database sync error", "error", err)
 		return nil, err
 	}
+	log.Info("Database tables synced")
 
-	// Auto-sync database tables on startup
-	app.Logger().Info("Syncing database tables...")
-	err = dbEngine.Sync2(
-		new(model.User),
-		new(model.Device),
-		new(model.Peer),
-		new(model.AddressBook),
-		new(model.AddressBookTag),
-		new(model.Tags),
-		new(model.AuthToken),
-		new(model.Audit),
-		new(model.FileTransfer),
-		new(model.MailLogs),
-		new(model.MailTemplate),
-		new(model.SystemSettings),
-		new(model.VerifyCode),
-		// DocHelp tables
-		new(model.KnowledgeBaseCategory),
-		new(model.KnowledgeBaseArticle),
-		new(model.Ticket),
-		new(model.TicketComment),
-	)
-	if err != nil {
-		app.Logger().Fatal("Database sync error:", err)
+	// Auto-fix user roles after sync (migration for existing users)
+	log.Info("Checking user roles")
+	fixUserRoles(dbEngine)
+
+	// A master key generated for a missing key file is only kept when nothing was encrypted with the lost one
+	if err = service.NewSecretService().SaveGeneratedKey(); err != nil {
+		log.Error("Master key error", "error", err)
 		return nil, err
 	}
-	app.Logger().Info("Database tables synced successfully!")
 
-	// Auto-fix user roles after sync (migration for existing users)
-	app.Logger().Info("Checking user roles...")
-	fixUserRoles(dbEngine, app)
+	// Encrypt secrets that were stored in plaintext by older versions
+	encryptPlaintextSecrets()
 
-	app.RegisterDependency(dbEngine, cfg)
+	// Mails that failed before the mail queue are not retried
+	fixLegacyMailLogs()
 
-	app.OnErrorCode(iris.StatusNotFound, func(context iris.Context) {
-		requestInfo := fmt.Sprintf("(404)▶ %s:%s", context.Method(), context.Request().RequestURI)
-		body, _ := context.GetBody()
-		context.Application().Logger().Info(requestInfo)
-		for header, value := range context.Request().Header {
-			fmt.Println(header+":", value)
-		}
-		fmt.Println(string(body))
-	})
+	// Record the hbbs keypair when it changed while the api server was down
+	if _, err = service.NewServerKeyService().SyncFiles(); err != nil {
+		log.Error("Server key sync error", "error", err)
+	}
+
+	if err = service.NewSettingsService().ValidateYaml(cfg); err != nil {
+		log.Error("Config error", "error", err)
+		return nil, err
+	}
+
+	app.RegisterDependency(dbEngine, cfg)
 
 	app.Use(iris.Compression)
 
@@ -83,9 +81,8 @@ func newApp(cfg *config.ServerConfig) (*iris.Application, error) {
 		ctx.Next()
 	})
 
-	if cfg.HttpConfig.PrintRequestLog {
-		app.Use(middleware.RequestLogger())
-	}
+	// printRequestLog is checked per request, so it can be reloaded
+	app.Use(middleware.RequestLogger())
 
 	SetRoute(app)
 
@@ -98,20 +95,20 @@ func newApp(cfg *config.ServerConfig) (*iris.Application, error) {
 }
 
 // fixUserRoles - Auto-migrate existing users to role-based system
-func fixUserRoles(dbEngine *xorm.Engine, app *iris.Application) {
+func fixUserRoles(dbEngine *xorm.Engine) {
 	var users []model.User
 	err := dbEngine.Where("role = 0 OR role IS NULL").Find(&users)
 	if err != nil {
-		app.Logger().Warn("Failed to check user roles:", err)
+		log.Warn("Failed to check user roles", "error", err)
 		return
 	}
 
 	if len(users) == 0 {
-		app.Logger().Info("All users have valid roles ✓")
+		log.Info("All users have valid roles")
 		return
 	}
 
-	app.Logger().Infof("Found %d users without role, fixing...", len(users))
+	log.Info("Fixing users without role", "count", len(users))
 
 	for _, user := range users {
 		// If user has is_admin=true, set as Super Admin (4)
@@ -123,33 +120,140 @@ func fixUserRoles(dbEngine *xorm.Engine, app *iris.Application) {
 
 		_, err := dbEngine.ID(user.Id).Cols("role").Update(&model.User{Role: newRole})
 		if err != nil {
-			app.Logger().Warnf("Failed to update role for user %s: %v", user.Username, err)
+			log.Warn("Failed to update user role", "username", user.Username, "error", err)
 		} else {
 			roleName := "USER"
 			if newRole == model.ROLE_SUPER_ADMIN {
 				roleName = "SUPER_ADMIN"
 			}
-			app.Logger().Infof("✓ User '%s' (ID: %d) → role: %s", user.Username, user.Id, roleName)
+			log.Info("User role set", "username", user.Username, "user_id", user.Id, "role", roleName)
 		}
 	}
 
-	app.Logger().Info("User roles migration completed!")
+	log.Info("User roles migration completed")
 }
 
-func StartServer() (bool, error) {
-	cfg := config.GetServerConfig()
-
-	StartJobs(cfg)
+// encryptPlaintextSecrets - Migrate plaintext peer passwords and 2FA secrets to encrypted values
+func encryptPlaintextSecrets() {
+	count, err := service.NewSecretService().EncryptPlaintext()
+	if err != nil {
+		log.Warn("Failed to encrypt plaintext secrets", "error", err)
+		return
+	}
+	if count > 0 {
+		log.Info("Encrypted plaintext secrets", "count", count)
+	}
+}
 
-	app, err := newApp(cfg)
+// fixLegacyMailLogs - Move the failed mails of older versions, which have no next attempt, to dead
+func fixLegacyMailLogs() {
+	count, err := service.NewMailQueueService().MarkLegacyFailed()
 	if err != nil {
-		return false, err
+		log.Warn("Failed to migrate failed mail logs", "error", err)
+		return
+	}
+	if count > 0 {
+		log.Info("Marked failed mails of older versions as dead", "count", count)
+	}
+}
+
+// configureLogger applies the log section, debugMode lowers an empty level to debug
+func configureLogger(cfg *config.ServerConfig) error {
+	opts := logger.Options{Level: "info"}
+	if cfg.Log != nil {
+		opts = logger.Options{
+			Level:      cfg.Log.Level,
+			Format:     cfg.Log.Format,
+			Modules:    cfg.Log.Modules,
+			File:       cfg.Log.File,
+			MaxSizeMB:  cfg.Log.MaxSizeMB,
+			MaxBackups: cfg.Log.MaxBackups,
+		}
+	}
+	if opts.Level == "" && cfg.DebugMode {
+		opts.Level = "debug"
 	}
+	return logger.Configure(opts)
+}
+
+// routeIrisLogger sends the messages of iris itself to the "iris" module logger
+// it is registered on the default golog logger, so the app logger created by iris inherits it
+func routeIrisLogger(gl *golog.Logger) {
+	irisLog := logger.Module("iris")
+	gl.Handle(func(l *golog.Log) bool {
+		level := slog.LevelInfo
+		switch l.Level {
+		case golog.DebugLevel:
+			level = slog.LevelDebug
+		case golog.WarnLevel:
+			level = slog.LevelWarn
+		case golog.ErrorLevel, golog.FatalLevel:
+			level = slog.LevelError
+		}
+		irisLog.Log(context.Background(), level, l.Message)
+		return true
+	})
+}
 
-	err = app.Listen(cfg.HttpConfig.Port, iris.WithoutBodyConsumptionOnUnmarshal)
+// irisLevel keeps the route listing and other debug output of iris off unless the iris module logs debug
+func irisLevel() string {
+	if logger.Module("iris").Enabled(context.Background(), slog.LevelDebug) {
+		return "debug"
+	}
+	return "info"
+}
+
+// startReload reloads the config on SIGHUP and, when enabled, when the config file changes
+func startReload(app *iris.Application, cfg *config.ServerConfig, stop <-chan struct{}) {
+	config.OnReload(func(old, cfg *config.ServerConfig) {
+		if err := configureLogger(cfg); err != nil {
+			log.Warn("Invalid log config is ignored", "error", err)
+		}
+		app.Logger().SetLevel(irisLevel())
+		if err := service.NewSettingsService().ValidateYaml(cfg); err != nil {
+			log.Warn("Invalid settings are ignored", "error", err)
+		}
+	})
+
+	logResult := func(result *config.ReloadResult, err error) {
+		if err != nil {
+			log.Error("Config reload rejected, keeping the current config", "error", err)
+			return
+		}
+		log.Info("Config reloaded", "changed", result.Changed)
+		if len(result.RestartRequired) > 0 {
+			log.Warn("Restart required to apply", "settings", result.RestartRequired)
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				logResult(config.Reload())
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	if cfg.Reload != nil && cfg.Reload.WatchFile {
+		go config.WatchConfigFile(time.Duration(cfg.Reload.WatchInterval)*time.Second, stop, logResult)
+	}
+}
+
+// StartServer runs the api server until it is stopped, superviseRustdesk also runs hbbs and hbbr
+func StartServer(superviseRustdesk bool) (bool, error) {
+	lifecycle, err := NewLifecycle(config.GetServerConfig())
 	if err != nil {
 		return false, err
 	}
-
+	lifecycle.SuperviseRustdesk = superviseRustdesk
+	if err = lifecycle.Run(); err != nil {
+		return false, err
+	}
 	return true, nil
 }
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/helper"
 	"time"
@@ -59,7 +60,8 @@ func AdminAuth(app *iris.Application) iris.Handler {
 
 		s := carbon.Now().DiffInSeconds(carbon.Parse(authToken.Expired.Format(config.TimeFormat)))
 		if s <= 60*5 {
-			authToken.Expired = carbon.Parse(authToken.Expired.Format(config.TimeFormat)).AddHours(2).ToStdTime()
+			hours := service.NewSettingsService().GetInt(service.SETTING_ADMIN_SESSION_HOURS)
+			authToken.Expired = carbon.Parse(authToken.Expired.Format(config.TimeFormat)).AddHours(hours).ToStdTime()
 			db.Where("id = ?", authToken.Id).Cols("expired").Update(&authToken)
 		}
 
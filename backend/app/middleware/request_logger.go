@@ -1,24 +1,106 @@
 package middleware
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
 	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/logger"
+	"rustdesk-api-server-pro/util"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/requestid"
 )
 
+// a client supplied X-Request-Id is kept when it is a plain token, anything else gets a new id
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestLogBodyLimit is the most of a body that is read for the log, a longer body is not logged: a cut json
+// body can not be parsed to redact its secrets
+const requestLogBodyLimit = 4096
+
+// loggedBodyTypes are the content types whose body is logged, uploads and binary bodies never are
+var loggedBodyTypes = map[string]bool{
+	"":                                  true,
+	"application/json":                  true,
+	"application/x-www-form-urlencoded": true,
+	"text/plain":                        true,
+}
+
+var quietPaths = map[string]bool{
+	"/api/heartbeat": true,
+	"/healthz":       true,
+	"/readyz":        true,
+}
+
+// RequestLogger puts the request id into the request context and logs every request when it is done.
+// With httpConfig.printRequestLog the headers and body are logged too (debug level, secrets redacted), the body
+// only when debug logging is on, it is a text type and at most requestLogBodyLimit bytes.
 func RequestLogger() iris.Handler {
-	return func(context iris.Context) {
-		if config.GetServerConfig().DebugMode && context.Request().RequestURI != "/api/heartbeat" {
-			requestInfo := fmt.Sprintf("▶ %s:%s", context.Method(), context.Request().RequestURI)
-			body, _ := context.GetBody()
-			context.Application().Logger().Info(requestInfo)
-			for header, value := range context.Request().Header {
-				fmt.Println(header+":", value)
-			}
-			fmt.Println(string(body))
+	log := logger.Module("http")
+	return func(ctx iris.Context) {
+		start := time.Now()
+		id := RequestId(ctx)
+		ctx.ResetRequest(ctx.Request().WithContext(logger.WithRequestId(ctx.Request().Context(), id)))
+		rctx := ctx.Request().Context()
+
+		// the heartbeat and the probes run every few seconds, they are only logged at debug level
+		level := slog.LevelInfo
+		if quietPaths[ctx.Path()] {
+			level = slog.LevelDebug
+		}
+
+		if config.GetServerConfig().HttpConfig.PrintRequestLog && level == slog.LevelInfo && log.Enabled(rctx, slog.LevelDebug) {
+			log.DebugContext(rctx, "request", "method", ctx.Method(), "path", ctx.Path(),
+				"headers", logger.RedactHeaders(ctx.Request().Header), "body", logBody(ctx.Request()))
+		}
+
+		ctx.Next()
+
+		status := ctx.GetStatusCode()
+		if status >= 500 {
+			level = slog.LevelError
 		}
+		log.Log(rctx, level, "request done", "method", ctx.Method(), "path", ctx.Path(), "status", status,
+			"duration_ms", time.Since(start).Milliseconds(), "ip", ctx.RemoteAddr())
+	}
+}
+
+// logBody reads the start of the body for the log and puts it back in front of the rest, the handler reads it all
+func logBody(r *http.Request) string {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !loggedBodyTypes[contentType] {
+		return "(" + contentType + " body not logged)"
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	head, err := io.ReadAll(io.LimitReader(r.Body, requestLogBodyLimit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	if err != nil {
+		return "(body not readable)"
+	}
+	if len(head) > requestLogBodyLimit {
+		return fmt.Sprintf("(more than %d bytes, not logged)", requestLogBodyLimit)
+	}
+	return logger.RedactBody(head, 0)
+}
 
-		context.Next()
+// RequestId returns the id of the request, the X-Request-Id response header
+func RequestId(ctx iris.Context) string {
+	id := requestid.Get(ctx)
+	if !validRequestId.MatchString(id) {
+		id = util.GetUUID()
+		ctx.SetID(id)
+		ctx.Header("X-Request-Id", id)
 	}
+	return id
 }
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net"
+	"rustdesk-api-server-pro/config"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// RequestUrl returns the url of this server as the client reached it. X-Forwarded-Proto and X-Forwarded-Host are
+// only used when the request comes from one of httpConfig.trustedProxies, anyone else could make the server hand out
+// the url of another host.
+func RequestUrl(ctx iris.Context) string {
+	scheme := strings.TrimSuffix(ctx.Scheme(), "://")
+	host := ctx.Host()
+	// the socket address, ctx.RemoteAddr may already come from a header
+	remoteIp, _, err := net.SplitHostPort(ctx.Request().RemoteAddr)
+	if err == nil && config.GetServerConfig().HttpConfig.IsTrustedProxy(remoteIp) {
+		// a chain of proxies lists the value the client used first
+		if proto, _, _ := strings.Cut(ctx.GetHeader("X-Forwarded-Proto"), ","); strings.TrimSpace(proto) != "" {
+			scheme = strings.TrimSpace(proto)
+		}
+		if forwardedHost, _, _ := strings.Cut(ctx.GetHeader("X-Forwarded-Host"), ","); strings.TrimSpace(forwardedHost) != "" {
+			host = strings.TrimSpace(forwardedHost)
+		}
+	}
+	return scheme + "://" + host
+}
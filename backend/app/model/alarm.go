@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// Alarm types reported by RustDesk clients (AlarmAuditType)
+const (
+	ALARM_TYPE_IP_WHITELIST                = 0 // connection from an ip outside the whitelist
+	ALARM_TYPE_EXCEED_THIRTY_ATTEMPTS      = 1 // more than 30 wrong passwords
+	ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE  = 2 // 6 wrong passwords within one minute
+	ALARM_TYPE_EXCEED_IPV6_PREFIX_ATTEMPTS = 3 // too many wrong passwords from one ipv6 prefix
+)
+
+const (
+	ALARM_SEVERITY_LOW    = 1
+	ALARM_SEVERITY_MEDIUM = 2
+	ALARM_SEVERITY_HIGH   = 3
+)
+
+const (
+	ALARM_STATUS_OPEN         = 0
+	ALARM_STATUS_ACKNOWLEDGED = 1
+)
+
+type Alarm struct {
+	Id         int       `xorm:"'id' int notnull pk autoincr"`
+	RustdeskId string    `xorm:"'rustdesk_id' varchar(100) index"` // device that raised the alarm
+	Uuid       string    `xorm:"'uuid' varchar(255)"`
+	Type       int       `xorm:"'type' tinyint"`
+	Severity   int       `xorm:"'severity' tinyint"`
+	IP         string    `xorm:"'ip' varchar(50) index"` // ip of the remote side
+	PeerId     string    `xorm:"'peer_id' varchar(100)"` // rustdesk id the remote side claimed
+	PeerName   string    `xorm:"'peer_name' varchar(255)"`
+	Info       string    `xorm:"'info' text"`
+	UserId     int       `xorm:"'user_id' int"`          // owner of the device
+	Notified   bool      `xorm:"'notified' tinyint"`     // a notification was sent
+	Status     int       `xorm:"'status' tinyint index"` // 0=open 1=acknowledged
+	AckUserId  int       `xorm:"'ack_user_id' int"`
+	AckNote    string    `xorm:"'ack_note' varchar(255)"`
+	AckAt      time.Time `xorm:"'ack_at' datetime"`
+	CreatedAt  time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt  time.Time `xorm:"'updated_at' datetime updated"`
+}
+
+func (m *Alarm) TableName() string {
+	return "alarm"
+}
@@ -2,20 +2,32 @@ package model
 
 import "time"
 
+const (
+	AUDIT_CLOSE_REASON_CLOSED     = "closed"     // the client reported the close
+	AUDIT_CLOSE_REASON_LOST       = "lost"       // the heartbeat no longer lists the connection
+	AUDIT_CLOSE_REASON_OFFLINE    = "offline"    // the device went offline
+	AUDIT_CLOSE_REASON_SUPERSEDED = "superseded" // the conn id was reused by a new connection
+	AUDIT_CLOSE_REASON_SHUTDOWN   = "shutdown"   // the api server stopped
+)
+
 type Audit struct {
-	Id         int       `xorm:"'id' int notnull pk autoincr"`
-	UserId     int       `xorm:"'user_id' int"`                // Qual usuário conectou
-	ConnId     int       `xorm:"'conn_id' int"`
-	RustdeskId string    `xorm:"'rustdesk_id' varchar(100)"` // Máquina que foi acessada
-	IP         string    `xorm:"'ip' varchar(15)"`
-	SessionId  string    `xorm:"'session_id' varchar(50)"`
-	Peer       string    `xorm:"'peer' text"`
-	Uuid       string    `xorm:"'uuid' varchar(255)"`
-	Note       string    `xorm:"'note' varchar(255)"`
-	Type       int       `xorm:"'type' tinyint"`
-	ClosedAt   time.Time `xorm:"'closed_at' datetime"`
-	CreatedAt  time.Time `xorm:"'created_at' datetime created"`
-	UpdatedAt  time.Time `xorm:"'updated_at' datetime updated"`
+	Id          int       `xorm:"'id' int notnull pk autoincr"`
+	UserId      int       `xorm:"'user_id' int"` // Qual usuário conectou
+	ConnId      int       `xorm:"'conn_id' int index(idx_audit_conn)"`
+	RustdeskId  string    `xorm:"'rustdesk_id' varchar(100) index(idx_audit_conn)"` // Máquina que foi acessada
+	IP          string    `xorm:"'ip' varchar(50)"`
+	SessionId   string    `xorm:"'session_id' varchar(50)"`
+	Peer        string    `xorm:"'peer' text"`
+	PeerId      string    `xorm:"'peer_id' varchar(100)"`   // rustdesk id of the controlling side
+	PeerName    string    `xorm:"'peer_name' varchar(255)"` // name of the controlling side
+	Uuid        string    `xorm:"'uuid' varchar(255)"`
+	Note        string    `xorm:"'note' varchar(255)"`
+	Type        int       `xorm:"'type' tinyint"`
+	ClosedAt    time.Time `xorm:"'closed_at' datetime"`
+	Duration    int       `xorm:"'duration' int"` // seconds, set when the session is closed
+	CloseReason string    `xorm:"'close_reason' varchar(50)"`
+	CreatedAt   time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt   time.Time `xorm:"'updated_at' datetime updated"`
 }
 
 func (m *Audit) TableName() string {
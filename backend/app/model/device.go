@@ -16,8 +16,11 @@ type Device struct {
 	LastSeenAt time.Time `xorm:"'last_seen_at' datetime"`
 	IpAddress  string    `xorm:"'ip_address' varchar(45)"`
 	Conns      int       `xorm:"'conns' int"`
-	CreatedAt  time.Time `xorm:"'created_at' datetime created"`
-	UpdatedAt  time.Time `xorm:"'updated_at' datetime updated"`
+	// ServerKey is the public key of hbbs the client last reported or fetched from /api/client-config
+	ServerKey   string    `xorm:"'server_key' varchar(64)"`
+	ServerKeyAt time.Time `xorm:"'server_key_at' datetime"`
+	CreatedAt   time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt   time.Time `xorm:"'updated_at' datetime updated"`
 }
 
 func (m *Device) TableName() string {
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// DownloadEvent is an installer served by /api/download
+type DownloadEvent struct {
+	Id          int       `xorm:"'id' int notnull pk autoincr" json:"id"`
+	File        string    `xorm:"'file' varchar(255)" json:"file"`
+	InstallerId int       `xorm:"'installer_id' int" json:"installer_id"` // 0 for a file outside the catalog
+	Platform    string    `xorm:"'platform' varchar(20) index" json:"platform"`
+	Arch        string    `xorm:"'arch' varchar(20)" json:"arch"`
+	Version     string    `xorm:"'version' varchar(32)" json:"version"`
+	Ip          string    `xorm:"'ip' varchar(64)" json:"ip"`
+	UserAgent   string    `xorm:"'user_agent' varchar(512)" json:"user_agent"`
+	Signed      bool      `xorm:"'signed' tinyint" json:"signed"` // downloaded with a signed link
+	CreatedAt   time.Time `xorm:"'created_at' datetime created index" json:"created_at"`
+}
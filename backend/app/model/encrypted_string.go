@@ -0,0 +1,29 @@
+package model
+
+import "rustdesk-api-server-pro/helper/secret"
+
+// EncryptedString is stored encrypted with the master key and decrypted when it is read back.
+// Old plaintext values are still readable, they get encrypted the next time they are saved.
+// Note that xorm writes the column on Update even when the value is empty, use Cols/Omit.
+type EncryptedString string
+
+func (s *EncryptedString) FromDB(data []byte) error {
+	value, err := secret.Decrypt(string(data))
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(value)
+	return nil
+}
+
+func (s *EncryptedString) ToDB() ([]byte, error) {
+	value, err := secret.Encrypt(string(*s))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (s EncryptedString) String() string {
+	return string(s)
+}
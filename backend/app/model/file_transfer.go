@@ -2,15 +2,26 @@ package model
 
 import "time"
 
+const (
+	FILE_TRANSFER_REMOTE_TO_LOCAL = 0 // download from the controlled device
+	FILE_TRANSFER_LOCAL_TO_REMOTE = 1 // upload to the controlled device
+)
+
 type FileTransfer struct {
 	Id         int       `xorm:"'id' int notnull pk autoincr"`
 	RustdeskId string    `xorm:"'rustdesk_id' varchar(100)"`
-	Info       string    `xorm:"'info' text"`
+	Info       string    `xorm:"'info' text"` // raw info json sent by the client
 	IsFile     bool      `xorm:"'is_file' tinyint"`
 	Path       string    `xorm:"'path' text"`
 	PeerId     string    `xorm:"'peer_id' varchar(100)"`
-	Type       int       `xorm:"'type' tinyint"`
+	Type       int       `xorm:"'type' tinyint"` // 0=remote to local 1=local to remote
 	Uuid       string    `xorm:"'uuid' varchar(255)"`
+	UserId     int       `xorm:"'user_id' int index"`
+	AuditId    int       `xorm:"'audit_id' int index"` // the connection session the transfer belongs to
+	RemoteIp   string    `xorm:"'remote_ip' varchar(50)"`
+	RemoteName string    `xorm:"'remote_name' varchar(255)"`
+	FileCount  int       `xorm:"'file_count' int"`    // files of the whole transfer, the client only lists some of them
+	TotalSize  int64     `xorm:"'total_size' bigint"` // size of the listed files
 	CreatedAt  time.Time `xorm:"'created_at' datetime created"`
 	UpdatedAt  time.Time `xorm:"'updated_at' datetime updated"`
 }
@@ -18,3 +29,15 @@ type FileTransfer struct {
 func (m *FileTransfer) TableName() string {
 	return "file_transfer"
 }
+
+type FileTransferItem struct {
+	Id             int       `xorm:"'id' int notnull pk autoincr"`
+	FileTransferId int       `xorm:"'file_transfer_id' int index"`
+	Name           string    `xorm:"'name' varchar(1024)"` // full path of the file
+	Size           int64     `xorm:"'size' bigint"`
+	CreatedAt      time.Time `xorm:"'created_at' datetime created"`
+}
+
+func (m *FileTransferItem) TableName() string {
+	return "file_transfer_item"
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+const (
+	INSTALLER_CHANNEL_STABLE = "stable"
+	INSTALLER_CHANNEL_BETA   = "beta"
+)
+
+const (
+	INSTALLER_ARCH_X86_64    = "x86_64"
+	INSTALLER_ARCH_X86       = "x86"
+	INSTALLER_ARCH_ARM64     = "arm64"
+	INSTALLER_ARCH_ARMV7     = "armv7"
+	INSTALLER_ARCH_UNIVERSAL = "universal" // one build for every arch, e.g. a macos universal dmg
+)
+
+// Installer is a RustDesk client build of the installer catalog, the file is in installersDir
+type Installer struct {
+	Id           int       `xorm:"'id' int notnull pk autoincr"`
+	Platform     string    `xorm:"'platform' varchar(20) index"` // windows macos linux android ios
+	Arch         string    `xorm:"'arch' varchar(20)"`
+	Version      string    `xorm:"'version' varchar(32)"`
+	Channel      string    `xorm:"'channel' varchar(20)"`
+	Filename     string    `xorm:"'filename' varchar(255)"` // the name it is downloaded with
+	Path         string    `xorm:"'path' varchar(512)"`     // relative to installersDir
+	Sha256       string    `xorm:"'sha256' varchar(64)"`
+	Size         int64     `xorm:"'size' bigint"`
+	ReleaseNotes string    `xorm:"'release_notes' text"`
+	CreatedBy    int       `xorm:"'created_by' int"`
+	CreatedAt    time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt    time.Time `xorm:"'updated_at' datetime updated"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+const (
+	JOB_TRIGGER_SCHEDULE = "schedule"
+	JOB_TRIGGER_MANUAL   = "manual"
+)
+
+const (
+	JOB_STATUS_RUNNING = "running"
+	JOB_STATUS_SUCCESS = "success"
+	JOB_STATUS_FAILED  = "failed"
+)
+
+// JobLease is held by the replica that runs the scheduled jobs. ExpiresAt is in unix seconds so the
+// replicas compare it in sql whatever the time zone of the database.
+type JobLease struct {
+	Name      string `xorm:"'name' varchar(64) pk"`
+	Holder    string `xorm:"'holder' varchar(255)"`
+	ExpiresAt int64  `xorm:"'expires_at' bigint"`
+}
+
+// JobRun is one run of a background job
+type JobRun struct {
+	Id         int       `xorm:"'id' int notnull pk autoincr" json:"id"`
+	Job        string    `xorm:"'job' varchar(64) index" json:"job"`
+	Instance   string    `xorm:"'instance' varchar(255)" json:"instance"`
+	Trigger    string    `xorm:"'triggered_by' varchar(20)" json:"trigger"` // schedule manual
+	Status     string    `xorm:"'status' varchar(20)" json:"status"`
+	Result     string    `xorm:"'result' text" json:"result"`
+	Error      string    `xorm:"'error' text" json:"error"`
+	StartedAt  time.Time `xorm:"'started_at' datetime index" json:"started_at"`
+	FinishedAt time.Time `xorm:"'finished_at' datetime" json:"finished_at"`
+	Duration   int64     `xorm:"'duration' bigint" json:"duration"` // milliseconds
+}
@@ -3,25 +3,46 @@ package model
 import "time"
 
 const (
-	MAIL_SEND_OK  = 1
-	MAIL_SEND_ERR = 2
+	MAIL_SEND_OK      = 1
+	MAIL_SEND_ERR     = 2 // the last attempt failed, the queue retries at NextAttemptAt
+	MAIL_SEND_QUEUED  = 3
+	MAIL_SEND_SENDING = 4
+	MAIL_SEND_DEAD    = 5 // given up after the last attempt or its expiry, a resend queues it again
 )
 
 type MailLogs struct {
-	Id        int       `xorm:"'id' int notnull pk autoincr"`
-	UserId    int       `xorm:"'user_id' int"`
-	TplId     int       `xorm:"'tpl_id' int"`
-	Uuid      string    `xorm:"'uuid' varchar(255)"`
-	From      string    `xorm:"'from' varchar(255)"`
-	To        string    `xorm:"'to' varchar(255)"`
-	Subject   string    `xorm:"'subject' varchar(255)"`
-	Contents  string    `xorm:"'contents' mediumtext"`
-	Status    int       `xorm:"'status' tinyint"` // 1=ok,2=err
-	Logs      string    `xorm:"'logs' text"`
-	CreatedAt time.Time `xorm:"'created_at' datetime created"`
-	UpdatedAt time.Time `xorm:"'updated_at' datetime updated"`
+	Id            int       `xorm:"'id' int notnull pk autoincr"`
+	UserId        int       `xorm:"'user_id' int"`
+	TplId         int       `xorm:"'tpl_id' int"`
+	Uuid          string    `xorm:"'uuid' varchar(255)"`
+	From          string    `xorm:"'from' varchar(255)"`
+	To            string    `xorm:"'to' varchar(255)"`
+	Subject       string    `xorm:"'subject' varchar(255)"`
+	Contents      string    `xorm:"'contents' mediumtext"`
+	TextContents  string    `xorm:"'text_contents' mediumtext"`
+	Status        int       `xorm:"'status' tinyint index"` // 1=ok,2=err,3=queued,4=sending,5=dead
+	Attempts      int       `xorm:"'attempts' int notnull default 0"`
+	NextAttemptAt time.Time `xorm:"'next_attempt_at' datetime index"`
+	SentAt        time.Time `xorm:"'sent_at' datetime"`
+	ExpiresAt     time.Time `xorm:"'expires_at' datetime"` // a mail not sent by then is dead, zero never expires
+	Logs          string    `xorm:"'logs' text"`
+	CreatedAt     time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt     time.Time `xorm:"'updated_at' datetime updated"`
 }
 
 func (m *MailLogs) TableName() string {
 	return "mail_logs"
 }
+
+// MailAttachment is a file attached to a queued mail
+type MailAttachment struct {
+	Id        int    `xorm:"'id' int notnull pk autoincr"`
+	MailLogId int    `xorm:"'mail_log_id' int notnull index"`
+	Name      string `xorm:"'name' varchar(255)"`
+	MimeType  string `xorm:"'mime_type' varchar(128)"`
+	Data      []byte `xorm:"'data' mediumblob"`
+}
+
+func (m *MailAttachment) TableName() string {
+	return "mail_attachment"
+}
@@ -6,16 +6,22 @@ const (
 	MAIL_TPL_TYPE_LOGIN_VERIFY    = 1
 	MAIL_TPL_TYPE_REGISTER_VERIFY = 2
 	MAIL_TPL_TYPE_OTHER           = 3
+	MAIL_TPL_TYPE_ALARM           = 4
+	MAIL_TPL_TYPE_DOWNLOAD_LINK   = 5
 )
 
+// MailTemplate is a html/template, the {$var} placeholders of the older templates still work. Language is empty for
+// the default variant of a type, TextContents is the plain text part, generated from Contents when empty.
 type MailTemplate struct {
-	Id        int       `xorm:"'id' int notnull pk autoincr"`
-	Name      string    `xorm:"'name' varchar(255)"`
-	Type      int       `xorm:"'type' tinyint"` // login_code
-	Subject   string    `xorm:"'subject' varchar(255)"`
-	Contents  string    `xorm:"'contents' mediumtext"`
-	CreatedAt time.Time `xorm:"'created_at' datetime created"`
-	UpdatedAt time.Time `xorm:"'updated_at' datetime updated"`
+	Id           int       `xorm:"'id' int notnull pk autoincr"`
+	Name         string    `xorm:"'name' varchar(255)"`
+	Type         int       `xorm:"'type' tinyint"` // login_code
+	Language     string    `xorm:"'language' varchar(16) notnull default ''"`
+	Subject      string    `xorm:"'subject' varchar(255)"`
+	Contents     string    `xorm:"'contents' mediumtext"`
+	TextContents string    `xorm:"'text_contents' mediumtext"`
+	CreatedAt    time.Time `xorm:"'created_at' datetime created"`
+	UpdatedAt    time.Time `xorm:"'updated_at' datetime updated"`
 }
 
 func (m *MailTemplate) TableName() string {
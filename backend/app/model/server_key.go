@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+const (
+	SERVER_KEY_PENDING = "pending" // published as the next key until ActivateAt
+	SERVER_KEY_ACTIVE  = "active"
+	SERVER_KEY_RETIRED = "retired"
+)
+
+const (
+	SERVER_KEY_SOURCE_HBBS      = "hbbs" // generated by hbbs on its first start
+	SERVER_KEY_SOURCE_GENERATED = "generated"
+	SERVER_KEY_SOURCE_IMPORTED  = "imported"
+)
+
+// ServerKey is an ed25519 keypair of hbbs, the active one is the one in id_ed25519
+type ServerKey struct {
+	Id          int             `xorm:"'id' int notnull pk autoincr"`
+	PublicKey   string          `xorm:"'public_key' varchar(64) index"`
+	PrivateKey  EncryptedString `xorm:"'private_key' text"`
+	Status      string          `xorm:"'status' varchar(20) index"`
+	Source      string          `xorm:"'source' varchar(20)"`
+	ActivateAt  time.Time       `xorm:"'activate_at' datetime"` // end of the grace period of a pending key
+	ActivatedAt time.Time       `xorm:"'activated_at' datetime"`
+	RetiredAt   time.Time       `xorm:"'retired_at' datetime"`
+	CreatedBy   int             `xorm:"'created_by' int"`
+	CreatedAt   time.Time       `xorm:"'created_at' datetime created"`
+}
@@ -5,7 +5,7 @@ import "time"
 type SystemSettings struct {
 	Id        int       `xorm:"'id' int notnull pk autoincr"`
 	Name      string    `xorm:"'name' varchar(255)"`
-	Key       string    `xorm:"'key' varchar(255)"`
+	Key       string    `xorm:"'key' varchar(255) unique"`
 	Value     string    `xorm:"'value' varchar(255)"`
 	CreatedAt time.Time `xorm:"'created_at' datetime created"`
 	UpdatedAt time.Time `xorm:"'updated_at' datetime updated"`
@@ -0,0 +1,33 @@
+package model
+
+// Tables returns the models synced when the server starts
+func Tables() []interface{} {
+	return []interface{}{
+		new(User),
+		new(Device),
+		new(Peer),
+		new(AddressBook),
+		new(AddressBookTag),
+		new(Tags),
+		new(AuthToken),
+		new(Audit),
+		new(FileTransfer),
+		new(FileTransferItem),
+		new(Alarm),
+		new(MailLogs),
+		new(MailTemplate),
+		new(SystemSettings),
+		new(VerifyCode),
+		new(JobLease),
+		new(JobRun),
+		new(ServerKey),
+		new(Installer),
+		new(DownloadEvent),
+		new(MailAttachment),
+		// DocHelp tables
+		new(KnowledgeBaseCategory),
+		new(KnowledgeBaseArticle),
+		new(Ticket),
+		new(TicketComment),
+	}
+}
@@ -20,8 +20,9 @@ type User struct {
 	Password            string    `xorm:"'password' varchar(255)"`
 	Name                string    `xorm:"'name' varchar(100)"`
 	Email               string    `xorm:"'email' varchar(255)"`
+	Language            string    `xorm:"'language' varchar(16)"` // mail language, e.g. en or zh-CN
 	LoginVerify         string    `xorm:"'login_verify' varchar(20)"` // email_check tfa_check access_token
-	TwoFactorAuthSecret string    `xorm:"'tfa_secret' varchar(255)"`  // 2fa key
+	TwoFactorAuthSecret EncryptedString `xorm:"'tfa_secret' text"` // 2fa key, encrypted at rest
 	Note                string    `xorm:"'note' varchar(255)"`
 	LicensedDevices     int       `xorm:"'licensed_devices' int"`
 	Status              int       `xorm:"'status' tinyint"` // 0=disabled,1=normal,-1=unverified
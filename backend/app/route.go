@@ -10,12 +10,16 @@ import (
 )
 
 func SetRoute(app *iris.Application) {
+	// /healthz, /readyz and /version
+	mvc.New(app.Party("/")).Handle(new(api.HealthController))
+
 	apiParty := app.Party("/api")
 	apiMvc := mvc.New(apiParty)
 	apiMvc.Handle(new(api.SystemController))
 	apiMvc.Handle(new(api.LoginController))
 	apiMvc.Handle(new(api.AuditController))    // Can work with or without auth
 	apiMvc.Handle(new(api.DownloadController)) // Public download endpoint
+	apiMvc.Handle(new(api.ClientConfigController))
 
 	apiWithAuthParty := app.Party("/api")
 	apiWithAuthParty.Use(middleware.ApiAuth(app))
@@ -41,10 +45,18 @@ func SetRoute(app *iris.Application) {
 		adminWithAuthMvc.Handle(new(admin.UsersController))
 		adminWithAuthMvc.Handle(new(admin.SessionsController))
 		adminWithAuthMvc.Handle(new(admin.AuditController))
+		adminWithAuthMvc.Handle(new(admin.AlarmsController))
 		adminWithAuthMvc.Handle(new(admin.MailTemplateController))
 		adminWithAuthMvc.Handle(new(admin.MaiLogsController))
 		adminWithAuthMvc.Handle(new(admin.DevicesController))
 		adminWithAuthMvc.Handle(new(admin.AddressBooksController))
 		adminWithAuthMvc.Handle(new(admin.DocHelpController))
+		adminWithAuthMvc.Handle(new(admin.ConfigController))
+		adminWithAuthMvc.Handle(new(admin.SettingsController))
+		adminWithAuthMvc.Handle(new(admin.RetentionController))
+		adminWithAuthMvc.Handle(new(admin.JobsController))
+		adminWithAuthMvc.Handle(new(admin.RustdeskController))
+		adminWithAuthMvc.Handle(new(admin.InstallersController))
+		adminWithAuthMvc.Handle(new(admin.DownloadsController))
 	}
 }
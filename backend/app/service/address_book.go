@@ -0,0 +1,356 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rustdesk-api-server-pro/app/form/api"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/db"
+
+	"github.com/tidwall/gjson"
+	"xorm.io/xorm"
+)
+
+// Conflict strategies used when an imported peer already exists in the target address book
+const (
+	AB_IMPORT_SKIP      = "skip"
+	AB_IMPORT_OVERWRITE = "overwrite"
+	AB_IMPORT_RENAME    = "rename"
+)
+
+type AddressBookService struct {
+}
+
+var addressBookService *AddressBookService
+
+func NewAddressBookService() *AddressBookService {
+	// 单例模式
+	if addressBookService == nil {
+		addressBookService = &AddressBookService{}
+	}
+	return addressBookService
+}
+
+type AbImportResult struct {
+	Imported    int `json:"imported"`
+	Updated     int `json:"updated"`
+	Renamed     int `json:"renamed"`
+	Skipped     int `json:"skipped"`
+	TagsCreated int `json:"tags_created"`
+}
+
+func IsValidAbImportStrategy(strategy string) bool {
+	return strategy == AB_IMPORT_SKIP || strategy == AB_IMPORT_OVERWRITE || strategy == AB_IMPORT_RENAME
+}
+
+// Export builds the address book in the same data shape the RustDesk client syncs with GetAb, the peer passwords
+// are left out unless withPasswords is set
+func (service *AddressBookService) Export(ab *model.AddressBook, withPasswords bool) (*api.AbData, error) {
+	tagList := make([]model.AddressBookTag, 0)
+	err := db.DbEngine.Where("ab_id = ?", ab.Id).Find(&tagList)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0)
+	tagColors := make(map[string]int64)
+	for _, tag := range tagList {
+		if _, ok := tagColors[tag.Name]; ok {
+			continue
+		}
+		tags = append(tags, tag.Name)
+		tagColors[tag.Name] = tag.Color
+	}
+
+	peerList := make([]model.Peer, 0)
+	err = db.DbEngine.Where("ab_id = ?", ab.Id).Asc("id").Find(&peerList)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]api.AbPeer, 0)
+	for _, peer := range peerList {
+		peerTags := make([]string, 0)
+		if peer.Tags != "" {
+			_ = json.Unmarshal([]byte(peer.Tags), &peerTags)
+		}
+
+		forceAlwaysRelay := "false"
+		if peer.ForceAlwaysRelay {
+			forceAlwaysRelay = "true"
+		}
+		sameServer := ""
+		if peer.SameServer {
+			sameServer = "true"
+		}
+		password := ""
+		if withPasswords {
+			password = peer.Password.String()
+		}
+
+		peers = append(peers, api.AbPeer{
+			Id:               peer.RustdeskId,
+			Hash:             peer.Hash,
+			Username:         peer.Username,
+			Password:         password,
+			Hostname:         peer.Hostname,
+			Platform:         peer.Platform,
+			Alias:            peer.Alias,
+			Tags:             peerTags,
+			ForceAlwaysRelay: forceAlwaysRelay,
+			RdpPort:          peer.RdpPort,
+			RdpUsername:      peer.RdpUsername,
+			LoginName:        peer.LoginName,
+			SameServer:       sameServer,
+		})
+	}
+
+	tagColorsJson, err := json.Marshal(tagColors)
+	if err != nil {
+		tagColorsJson = []byte("{}")
+	}
+
+	return &api.AbData{
+		Tags:      tags,
+		Peers:     peers,
+		TagColors: string(tagColorsJson),
+	}, nil
+}
+
+// ParseImportData accepts either the GetAb / legacy AbForm shape ({"data":"<json string>"})
+// or the AbData object itself, where tag_colors may be a JSON string or an object
+func (service *AddressBookService) ParseImportData(body []byte) (*api.AbData, map[string]int64, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, nil, errors.New("invalid json")
+	}
+
+	dataResult := gjson.GetBytes(body, "data")
+	if dataResult.Exists() && dataResult.Type == gjson.String {
+		body = []byte(dataResult.String())
+		if !gjson.ValidBytes(body) {
+			return nil, nil, errors.New("invalid json in data field")
+		}
+	}
+
+	var abData api.AbData
+	abData.Tags = make([]string, 0)
+	for _, tag := range gjson.GetBytes(body, "tags").Array() {
+		abData.Tags = append(abData.Tags, tag.String())
+	}
+
+	peersResult := gjson.GetBytes(body, "peers")
+	if peersResult.Exists() {
+		err := json.Unmarshal([]byte(peersResult.Raw), &abData.Peers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid peers: %s", err.Error())
+		}
+	}
+
+	tagColors := make(map[string]int64)
+	tagColorsResult := gjson.GetBytes(body, "tag_colors")
+	tagColorsRaw := tagColorsResult.Raw
+	if tagColorsResult.Type == gjson.String {
+		tagColorsRaw = tagColorsResult.String()
+	}
+	if tagColorsRaw != "" {
+		err := json.Unmarshal([]byte(tagColorsRaw), &tagColors)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid tag_colors: %s", err.Error())
+		}
+	}
+	abData.TagColors = tagColorsRaw
+
+	return &abData, tagColors, nil
+}
+
+// Import merges the address book data into ab. Existing tags are matched by name and existing
+// peers by RustDesk id: skip and rename keep a matched peer, overwrite updates it. Rename also gives
+// a new peer whose alias another peer already uses a unique alias.
+func (service *AddressBookService) Import(ab *model.AddressBook, abData *api.AbData, tagColors map[string]int64, strategy string) (*AbImportResult, error) {
+	if !IsValidAbImportStrategy(strategy) {
+		return nil, errors.New("invalid import strategy")
+	}
+
+	result := &AbImportResult{}
+
+	session := db.DbEngine.NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// 标签：缺失的标签需要创建，peer 中引用但未声明的标签也一起创建
+	tagNames := make([]string, 0)
+	seenTags := make(map[string]bool)
+	for _, tag := range abData.Tags {
+		if tag != "" && !seenTags[tag] {
+			seenTags[tag] = true
+			tagNames = append(tagNames, tag)
+		}
+	}
+	for _, peer := range abData.Peers {
+		for _, tag := range peer.Tags {
+			if tag != "" && !seenTags[tag] {
+				seenTags[tag] = true
+				tagNames = append(tagNames, tag)
+			}
+		}
+	}
+
+	for _, name := range tagNames {
+		color, hasColor := tagColors[name]
+		if !hasColor {
+			color = 0xFF0000FF // Default blue color
+		}
+
+		var existingTag model.AddressBookTag
+		has, err := session.Where("ab_id = ? and name = ?", ab.Id, name).Get(&existingTag)
+		if err != nil {
+			_ = session.Rollback()
+			return nil, err
+		}
+		if has {
+			if strategy == AB_IMPORT_OVERWRITE && hasColor && existingTag.Color != color {
+				_, err = session.ID(existingTag.Id).Cols("color").Update(&model.AddressBookTag{
+					Color: color,
+				})
+				if err != nil {
+					_ = session.Rollback()
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		_, err = session.Insert(&model.AddressBookTag{
+			UserId: ab.UserId,
+			AbId:   ab.Id,
+			Name:   name,
+			Color:  color,
+		})
+		if err != nil {
+			_ = session.Rollback()
+			return nil, err
+		}
+		result.TagsCreated++
+	}
+
+	totalPeers, err := session.Where("ab_id = ?", ab.Id).Count(&model.Peer{})
+	if err != nil {
+		_ = session.Rollback()
+		return nil, err
+	}
+
+	seenPeers := make(map[string]bool)
+	for _, form := range abData.Peers {
+		// a device is one peer of the address book, also when the import lists it twice
+		if form.Id == "" || seenPeers[form.Id] {
+			result.Skipped++
+			continue
+		}
+		seenPeers[form.Id] = true
+
+		peer := service.peerFromForm(ab, form)
+
+		var existingPeer model.Peer
+		has, err := session.Where("ab_id = ? and rustdesk_id = ?", ab.Id, form.Id).Get(&existingPeer)
+		if err != nil {
+			_ = session.Rollback()
+			return nil, err
+		}
+
+		if has && strategy == AB_IMPORT_OVERWRITE {
+			cols := []string{"hash", "username", "hostname", "platform", "alias", "tags", "forceAlwaysRelay", "rdpPort", "rdpUsername", "loginName", "sameServer"}
+			// an export without passwords must not clear the stored ones
+			if form.Password != "" {
+				cols = append(cols, "password")
+			}
+			_, err = session.ID(existingPeer.Id).Cols(cols...).Update(peer)
+			if err != nil {
+				_ = session.Rollback()
+				return nil, err
+			}
+			result.Updated++
+			continue
+		}
+
+		// skip and rename keep the existing peer of the device
+		if has {
+			result.Skipped++
+			continue
+		}
+
+		// 限制单个地址簿最大Peer数量
+		if ab.MaxPeer > 0 && totalPeers >= int64(ab.MaxPeer) {
+			result.Skipped++
+			continue
+		}
+
+		renamed := false
+		if strategy == AB_IMPORT_RENAME && peer.Alias != "" {
+			alias := service.uniqueAlias(session, ab.Id, peer.Alias)
+			renamed = alias != peer.Alias
+			peer.Alias = alias
+		}
+
+		_, err = session.Insert(peer)
+		if err != nil {
+			_ = session.Rollback()
+			return nil, err
+		}
+		totalPeers++
+		if renamed {
+			result.Renamed++
+		} else {
+			result.Imported++
+		}
+	}
+
+	err = session.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (service *AddressBookService) peerFromForm(ab *model.AddressBook, form api.AbPeer) *model.Peer {
+	peerTags := "[]"
+	if len(form.Tags) > 0 {
+		b, err := json.Marshal(form.Tags)
+		if err == nil {
+			peerTags = string(b)
+		}
+	}
+	return &model.Peer{
+		UserId:           ab.UserId,
+		AbId:             ab.Id,
+		RustdeskId:       form.Id,
+		Hash:             form.Hash,
+		Username:         form.Username,
+		Password:         model.EncryptedString(form.Password),
+		Hostname:         form.Hostname,
+		Platform:         form.Platform,
+		Alias:            form.Alias,
+		Tags:             peerTags,
+		ForceAlwaysRelay: form.ForceAlwaysRelay == "true",
+		RdpPort:          form.RdpPort,
+		RdpUsername:      form.RdpUsername,
+		LoginName:        form.LoginName,
+		SameServer:       form.SameServer != "" && form.SameServer != "false",
+	}
+}
+
+// uniqueAlias appends " (n)" to the alias until no other peer in the address book uses it
+func (service *AddressBookService) uniqueAlias(session *xorm.Session, abId int, alias string) string {
+	candidate := alias
+	for i := 2; i < 1000; i++ {
+		count, err := session.Where("ab_id = ? and alias = ?", abId, candidate).Count(&model.Peer{})
+		if err != nil || count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", alias, i)
+	}
+	return candidate
+}
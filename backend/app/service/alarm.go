@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/syslog"
+	"rustdesk-api-server-pro/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"xorm.io/xorm"
+)
+
+var alarmTypeNames = map[int]string{
+	model.ALARM_TYPE_IP_WHITELIST:                "IP whitelist violation",
+	model.ALARM_TYPE_EXCEED_THIRTY_ATTEMPTS:      "More than 30 wrong password attempts",
+	model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE:  "6 wrong password attempts within one minute",
+	model.ALARM_TYPE_EXCEED_IPV6_PREFIX_ATTEMPTS: "Too many wrong password attempts from one IPv6 prefix",
+}
+
+var alarmSeverities = map[int]int{
+	model.ALARM_TYPE_IP_WHITELIST:                model.ALARM_SEVERITY_MEDIUM,
+	model.ALARM_TYPE_EXCEED_THIRTY_ATTEMPTS:      model.ALARM_SEVERITY_HIGH,
+	model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE:  model.ALARM_SEVERITY_HIGH,
+	model.ALARM_TYPE_EXCEED_IPV6_PREFIX_ATTEMPTS: model.ALARM_SEVERITY_HIGH,
+}
+
+var alarmSeverityNames = map[int]string{
+	model.ALARM_SEVERITY_LOW:    "low",
+	model.ALARM_SEVERITY_MEDIUM: "medium",
+	model.ALARM_SEVERITY_HIGH:   "high",
+}
+
+func AlarmTypeName(t int) string {
+	if name, ok := alarmTypeNames[t]; ok {
+		return name
+	}
+	return "Unknown alarm " + strconv.Itoa(t)
+}
+
+func AlarmSeverityName(severity int) string {
+	return alarmSeverityNames[severity]
+}
+
+// AlarmSeverity returns the severity of an alarm type, unknown types are low
+func AlarmSeverity(t int) int {
+	if severity, ok := alarmSeverities[t]; ok {
+		return severity
+	}
+	return model.ALARM_SEVERITY_LOW
+}
+
+// alarmRateLimit is the number of alarms per minute accepted from one device and from one address, the
+// endpoint takes reports without a token like the other audit endpoints
+const alarmRateLimit = 30
+
+var ErrAlarmRateLimited = errors.New("too many alarms, try again later")
+
+var alarmLimiter = NewRateLimiter(time.Minute, alarmRateLimit)
+
+// RateLimiter counts the hits of each key in a fixed window, the counts are dropped when the window ends
+type RateLimiter struct {
+	mu     sync.Mutex
+	window time.Duration
+	limit  int
+	start  time.Time
+	counts map[string]int
+}
+
+func NewRateLimiter(window time.Duration, limit int) *RateLimiter {
+	return &RateLimiter{window: window, limit: limit}
+}
+
+// Allow counts a hit for every key, unless one of them is over the limit
+func (l *RateLimiter) Allow(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.start) >= l.window {
+		l.start = now
+		l.counts = map[string]int{}
+	}
+	for _, key := range keys {
+		if l.counts[key] >= l.limit {
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.counts[key]++
+	}
+	return true
+}
+
+type AlarmService struct {
+	engine  *xorm.Engine
+	limiter *RateLimiter
+}
+
+func NewAlarmService() *AlarmService {
+	return &AlarmService{
+		engine:  db.DbEngine,
+		limiter: alarmLimiter,
+	}
+}
+
+// SetLimiter replaces the limiter shared by every alarm service, e.g. with a fresh one
+func (service *AlarmService) SetLimiter(limiter *RateLimiter) *AlarmService {
+	service.limiter = limiter
+	return service
+}
+
+// ParseAlarm decodes {"id":"...","uuid":"...","typ":1,"info":"{\"ip\":\"...\",\"id\":\"...\",\"name\":\"...\"}"}
+func ParseAlarm(body []byte) *model.Alarm {
+	t := gjson.GetBytes(body, "typ")
+	if !t.Exists() {
+		t = gjson.GetBytes(body, "type")
+	}
+
+	info := gjson.GetBytes(body, "info")
+	raw := info.Raw
+	if info.Type == gjson.String {
+		raw = info.String()
+	}
+	infoResult := gjson.Parse(raw)
+
+	alarm := &model.Alarm{
+		RustdeskId: gjson.GetBytes(body, "id").String(),
+		Uuid:       gjson.GetBytes(body, "uuid").String(),
+		Type:       int(t.Int()),
+		IP:         strings.TrimPrefix(infoResult.Get("ip").String(), "::ffff:"),
+		PeerId:     infoResult.Get("id").String(),
+		PeerName:   infoResult.Get("name").String(),
+		Info:       raw,
+		Status:     model.ALARM_STATUS_OPEN,
+	}
+	alarm.Severity = AlarmSeverity(alarm.Type)
+	return alarm
+}
+
+// Record stores an alarm reported by a client from remoteIp and notifies the admins when it is severe enough.
+// It returns ErrAlarmRateLimited when the device or the address sent more than alarmRateLimit alarms this minute.
+func (service *AlarmService) Record(body []byte, remoteIp string) (*model.Alarm, error) {
+	alarm := ParseAlarm(body)
+	keys := []string{"id:" + alarm.RustdeskId}
+	if remoteIp != "" {
+		keys = append(keys, "ip:"+remoteIp)
+	}
+	if !service.limiter.Allow(keys...) {
+		return nil, ErrAlarmRateLimited
+	}
+
+	var peer model.Peer
+	has, err := service.engine.Where("rustdesk_id = ? and user_id > 0", alarm.RustdeskId).Get(&peer)
+	if err == nil && has {
+		alarm.UserId = peer.UserId
+	}
+
+	// decided before the insert, so a burst of alarms only notifies once per cooldown
+	alarm.Notified = service.shouldNotify(alarm)
+
+	if _, err = service.engine.Insert(alarm); err != nil {
+		return nil, err
+	}
+
+	severity := syslog.SEVERITY_NOTICE
+	switch alarm.Severity {
+	case model.ALARM_SEVERITY_MEDIUM:
+		severity = syslog.SEVERITY_WARNING
+	case model.ALARM_SEVERITY_HIGH:
+		severity = syslog.SEVERITY_CRITICAL
+	}
+	EmitEvent(EVENT_ALARM, severity, AlarmTypeName(alarm.Type), map[string]string{
+		"rustdesk_id": alarm.RustdeskId,
+		"ip":          alarm.IP,
+		"peer_id":     alarm.PeerId,
+		"peer_name":   alarm.PeerName,
+		"alarm_type":  strconv.Itoa(alarm.Type),
+		"severity":    AlarmSeverityName(alarm.Severity),
+	})
+
+	if alarm.Notified {
+		go service.notify(alarm)
+	}
+	return alarm, nil
+}
+
+func (service *AlarmService) shouldNotify(alarm *model.Alarm) bool {
+	settings := NewSettingsService()
+	if alarm.Severity < settings.GetInt(SETTING_ALARM_NOTIFY_MIN_SEVERITY) {
+		return false
+	}
+	cooldown := settings.GetInt(SETTING_ALARM_NOTIFY_COOLDOWN)
+	if cooldown <= 0 {
+		return true
+	}
+	since := time.Now().Add(-time.Duration(cooldown) * time.Minute).Format(config.TimeFormat)
+	recent, err := service.engine.Where("rustdesk_id = ? and type = ? and notified = 1 and created_at >= ?", alarm.RustdeskId, alarm.Type, since).Count(&model.Alarm{})
+	return err == nil && recent == 0
+}
+
+func (service *AlarmService) notify(alarm *model.Alarm) {
+	recipients := adminRecipients(NewSettingsService().GetString(SETTING_ALARM_NOTIFY_EMAILS))
+	if len(recipients) == 0 {
+		serviceLog.Warn("Alarm has no recipient, add an email to a super admin or set "+SETTING_ALARM_NOTIFY_EMAILS, "alarm_id", alarm.Id, "type", AlarmTypeName(alarm.Type))
+		service.notifyFailed(alarm)
+		return
+	}
+
+	deviceName := alarm.RustdeskId
+	var device model.Device
+	has, err := service.engine.Where("rustdesk_id = ?", alarm.RustdeskId).Get(&device)
+	if err == nil && has && device.Hostname != "" {
+		deviceName = device.Hostname + " (" + alarm.RustdeskId + ")"
+	}
+
+	// html/template escapes the values
+	vars := map[string]string{
+		"device":      deviceName,
+		"rustdesk_id": alarm.RustdeskId,
+		"type":        AlarmTypeName(alarm.Type),
+		"severity":    AlarmSeverityName(alarm.Severity),
+		"ip":          alarm.IP,
+		"peer_id":     alarm.PeerId,
+		"peer_name":   alarm.PeerName,
+		"time":        alarm.CreatedAt.Format(config.TimeFormat),
+	}
+
+	mailService := NewMailService()
+	sent := false
+	for email, userId := range recipients {
+		if err := mailService.Send(userId, model.MAIL_TPL_TYPE_ALARM, email, util.GetUUID(), vars); err != nil {
+			serviceLog.Error("Alarm notification failed", "alarm_id", alarm.Id, "email", email, "error", err)
+			continue
+		}
+		sent = true
+	}
+
+	if !sent {
+		service.notifyFailed(alarm)
+	}
+}
+
+// notifyFailed clears the notified flag, so the next alarm of the device is not held back by the cooldown
+func (service *AlarmService) notifyFailed(alarm *model.Alarm) {
+	_, _ = service.engine.ID(alarm.Id).Cols("notified").Update(&model.Alarm{Notified: false})
+}
+
+// Acknowledge marks alarms as handled by userId
+func (service *AlarmService) Acknowledge(ids []int, userId int, note string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return service.engine.In("id", ids).Where("status = ?", model.ALARM_STATUS_OPEN).
+		Cols("status", "ack_user_id", "ack_note", "ack_at").
+		Update(&model.Alarm{
+			Status:    model.ALARM_STATUS_ACKNOWLEDGED,
+			AckUserId: userId,
+			AckNote:   note,
+			AckAt:     time.Now(),
+		})
+}
@@ -0,0 +1,383 @@
+package service
+
+import (
+	"errors"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/syslog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"xorm.io/xorm"
+)
+
+type AuditService struct {
+	engine *xorm.Engine
+}
+
+func NewAuditService() *AuditService {
+	return &AuditService{
+		engine: db.DbEngine,
+	}
+}
+
+// FileTransferInfo is the parsed info field of /api/audit/file
+type FileTransferInfo struct {
+	Files      []model.FileTransferItem
+	FileCount  int
+	TotalSize  int64
+	RemoteIp   string
+	RemoteName string
+}
+
+// ParseFileTransferInfo parses {"files":[["name",size],...],"ip":"...","name":"...","num":n}.
+// File names are relative to path, a single file has an empty name.
+func ParseFileTransferInfo(info, path string, isFile bool) (*FileTransferInfo, error) {
+	if !gjson.Valid(info) {
+		return nil, errors.New("invalid file transfer info")
+	}
+	result := gjson.Parse(info)
+	parsed := &FileTransferInfo{
+		Files:      make([]model.FileTransferItem, 0),
+		FileCount:  int(result.Get("num").Int()),
+		RemoteIp:   result.Get("ip").String(),
+		RemoteName: result.Get("name").String(),
+	}
+	for _, file := range result.Get("files").Array() {
+		name := file.Get("0").String()
+		size := file.Get("1").Int()
+		parsed.Files = append(parsed.Files, model.FileTransferItem{
+			Name: joinTransferPath(path, name, isFile),
+			Size: size,
+		})
+		parsed.TotalSize += size
+	}
+	if parsed.FileCount < len(parsed.Files) {
+		parsed.FileCount = len(parsed.Files)
+	}
+	return parsed, nil
+}
+
+// joinTransferPath joins with the separator of the remote path, which may be a windows path
+func joinTransferPath(path, name string, isFile bool) string {
+	if name == "" || (isFile && strings.HasSuffix(path, name)) {
+		return path
+	}
+	if path == "" {
+		return name
+	}
+	sep := "/"
+	if strings.Contains(path, "\\") && !strings.Contains(path, "/") {
+		sep = "\\"
+	}
+	return strings.TrimRight(path, sep) + sep + name
+}
+
+// RecordFileTransfer stores a /api/audit/file report with its files and links it to the connection audit.
+// token is the bearer token of the reporting client, it may be empty.
+func (service *AuditService) RecordFileTransfer(body []byte, token string) (*model.FileTransfer, error) {
+	transfer := &model.FileTransfer{
+		RustdeskId: gjson.GetBytes(body, "id").String(),
+		Info:       gjson.GetBytes(body, "info").String(),
+		IsFile:     gjson.GetBytes(body, "is_file").Bool(),
+		Path:       gjson.GetBytes(body, "path").String(),
+		PeerId:     gjson.GetBytes(body, "peer_id").String(),
+		Type:       int(gjson.GetBytes(body, "type").Int()),
+		Uuid:       gjson.GetBytes(body, "uuid").String(),
+	}
+
+	info, err := ParseFileTransferInfo(transfer.Info, transfer.Path, transfer.IsFile)
+	if err != nil {
+		// keep the raw report, it is still worth auditing
+		info = &FileTransferInfo{}
+	}
+	transfer.FileCount = info.FileCount
+	transfer.TotalSize = info.TotalSize
+	transfer.RemoteIp = info.RemoteIp
+	transfer.RemoteName = info.RemoteName
+
+	audit := service.findAuditSession(transfer.RustdeskId, transfer.PeerId, transfer.Uuid)
+	if audit != nil {
+		transfer.AuditId = audit.Id
+	}
+	transfer.UserId = service.resolveUserId(token, audit, transfer.RustdeskId)
+
+	_, err = service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := session.Insert(transfer); err != nil {
+			return nil, err
+		}
+		for i := range info.Files {
+			info.Files[i].FileTransferId = transfer.Id
+		}
+		if len(info.Files) > 0 {
+			if _, err := session.Insert(&info.Files); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	direction := "remote_to_local"
+	if transfer.Type == model.FILE_TRANSFER_LOCAL_TO_REMOTE {
+		direction = "local_to_remote"
+	}
+	EmitUserEvent(EVENT_FILE_TRANSFER, syslog.SEVERITY_INFO, "", transfer.UserId, map[string]string{
+		"rustdesk_id": transfer.RustdeskId,
+		"peer_id":     transfer.PeerId,
+		"ip":          transfer.RemoteIp,
+		"path":        transfer.Path,
+		"direction":   direction,
+		"files":       strconv.Itoa(transfer.FileCount),
+		"size":        strconv.FormatInt(transfer.TotalSize, 10),
+	})
+	return transfer, nil
+}
+
+// findAuditSession returns the connection the transfer happened in, preferring open sessions with the same peer
+func (service *AuditService) findAuditSession(rustdeskId, peerId, uuid string) *model.Audit {
+	queries := []func() *xorm.Session{
+		func() *xorm.Session {
+			return service.engine.Where("rustdesk_id = ? AND peer_id = ? AND "+openAuditCond, rustdeskId, peerId)
+		},
+		func() *xorm.Session {
+			return service.engine.Where("rustdesk_id = ? AND uuid = ? AND "+openAuditCond, rustdeskId, uuid)
+		},
+		func() *xorm.Session {
+			return service.engine.Where("rustdesk_id = ? AND peer_id = ? AND created_at >= ?", rustdeskId, peerId, time.Now().Add(-24*time.Hour).Format(config.TimeFormat))
+		},
+	}
+	for _, query := range queries {
+		var audit model.Audit
+		has, err := query().Desc("id").Get(&audit)
+		if err == nil && has {
+			return &audit
+		}
+	}
+	return nil
+}
+
+// resolveUserId attributes a report to the authenticated client user, else to the user of the session,
+// else to the owner of the device in an address book
+func (service *AuditService) resolveUserId(token string, audit *model.Audit, rustdeskId string) int {
+	if token != "" {
+		var authToken model.AuthToken
+		has, err := service.engine.Where("token = ? and expired > ? and status = 1 and is_admin = 0", token, time.Now().Format(config.TimeFormat)).Get(&authToken)
+		if err == nil && has {
+			return authToken.UserId
+		}
+	}
+	if audit != nil && audit.UserId > 0 {
+		return audit.UserId
+	}
+	var peer model.Peer
+	has, err := service.engine.Where("rustdesk_id = ? and user_id > 0", rustdeskId).Get(&peer)
+	if err == nil && has {
+		return peer.UserId
+	}
+	return 0
+}
+
+// openAuditCond matches sessions that are not closed yet
+const openAuditCond = "(closed_at IS NULL OR closed_at = '0001-01-01 00:00:00')"
+
+// RecordConn handles a /api/audit/conn report. Sessions are keyed on (rustdesk_id, conn_id) and the
+// session_id once it is known, conn ids are reused across devices and client restarts.
+func (service *AuditService) RecordConn(body []byte, token string) error {
+	rustdeskId := gjson.GetBytes(body, "id").String()
+	sessionId := gjson.GetBytes(body, "session_id").String()
+
+	connId := int(gjson.GetBytes(body, "conn_id").Int())
+
+	if note := gjson.GetBytes(body, "note"); note.Exists() { // 只更新备注
+		if sessionId == "" || sessionId == "0" {
+			return nil
+		}
+		// session ids are only unique per device, the client sends the conn id along when it knows it
+		q := service.engine.Where("rustdesk_id = ? AND session_id = ?", rustdeskId, sessionId)
+		if connId > 0 {
+			q.And("conn_id = ?", connId)
+		}
+		_, err := q.Cols("note").Update(&model.Audit{
+			Note: note.String(),
+		})
+		return err
+	}
+
+	if action := gjson.GetBytes(body, "action"); action.Exists() {
+		switch action.String() {
+		case "new":
+			// a still open session with the same conn id was never closed
+			if err := service.closeOpen(rustdeskId, connId, "", time.Now(), model.AUDIT_CLOSE_REASON_SUPERSEDED); err != nil {
+				return err
+			}
+			audit := &model.Audit{
+				UserId:     service.resolveUserId(token, nil, rustdeskId),
+				ConnId:     connId,
+				RustdeskId: rustdeskId,
+				IP:         strings.TrimPrefix(gjson.GetBytes(body, "ip").String(), "::ffff:"),
+				SessionId:  sessionId,
+				Uuid:       gjson.GetBytes(body, "uuid").String(),
+			}
+			if _, err := service.engine.Insert(audit); err != nil {
+				return err
+			}
+			EmitUserEvent(EVENT_CONN_START, syslog.SEVERITY_INFO, "", audit.UserId, map[string]string{
+				"rustdesk_id": audit.RustdeskId,
+				"conn_id":     strconv.Itoa(audit.ConnId),
+				"ip":          audit.IP,
+			})
+			return nil
+		case "close":
+			return service.closeOpen(rustdeskId, connId, sessionId, time.Now(), model.AUDIT_CLOSE_REASON_CLOSED)
+		}
+		return nil
+	}
+
+	if peer := gjson.GetBytes(body, "peer"); peer.Exists() {
+		audit, err := service.findOpen(rustdeskId, connId, sessionId)
+		if err != nil || audit == nil {
+			return err
+		}
+		peers := peer.Array()
+		update := &model.Audit{
+			SessionId: sessionId,
+			Type:      int(gjson.GetBytes(body, "type").Int()),
+			Peer:      peer.Raw,
+		}
+		if len(peers) > 0 {
+			update.PeerId = peers[0].String()
+		}
+		if len(peers) > 1 {
+			update.PeerName = peers[1].String()
+		}
+		_, err = service.engine.ID(audit.Id).Cols("session_id", "type", "peer", "peer_id", "peer_name").Update(update)
+		return err
+	}
+	return nil
+}
+
+// findOpen returns the newest open session of the connection, sessionId narrows it down when the session already has one
+func (service *AuditService) findOpen(rustdeskId string, connId int, sessionId string) (*model.Audit, error) {
+	q := service.engine.Where("rustdesk_id = ? AND conn_id = ? AND "+openAuditCond, rustdeskId, connId)
+	if sessionId != "" && sessionId != "0" {
+		q.And("(session_id = ? OR session_id = '0' OR session_id = '')", sessionId)
+	}
+	var audit model.Audit
+	has, err := q.Desc("id").Get(&audit)
+	if err != nil || !has {
+		return nil, err
+	}
+	return &audit, nil
+}
+
+func (service *AuditService) closeOpen(rustdeskId string, connId int, sessionId string, closedAt time.Time, reason string) error {
+	audit, err := service.findOpen(rustdeskId, connId, sessionId)
+	if err != nil || audit == nil {
+		return err
+	}
+	return service.close(audit, closedAt, reason)
+}
+
+func (service *AuditService) close(audit *model.Audit, closedAt time.Time, reason string) error {
+	if closedAt.Before(audit.CreatedAt) {
+		closedAt = audit.CreatedAt
+	}
+	duration := int(closedAt.Sub(audit.CreatedAt).Seconds())
+	_, err := service.engine.ID(audit.Id).Cols("closed_at", "duration", "close_reason").Update(&model.Audit{
+		ClosedAt:    closedAt,
+		Duration:    duration,
+		CloseReason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	EmitUserEvent(EVENT_CONN_END, syslog.SEVERITY_INFO, "", audit.UserId, map[string]string{
+		"rustdesk_id": audit.RustdeskId,
+		"conn_id":     strconv.Itoa(audit.ConnId),
+		"ip":          audit.IP,
+		"peer_id":     audit.PeerId,
+		"peer_name":   audit.PeerName,
+		"duration":    strconv.Itoa(duration),
+		"reason":      reason,
+	})
+	return nil
+}
+
+// ReconcileConns closes the open sessions of a device that its heartbeat no longer lists.
+// Sessions younger than a minute are kept, the heartbeat may have been sent before they started.
+// conns is nil when the heartbeat has no conns field, older clients do not send it and nothing is closed.
+func (service *AuditService) ReconcileConns(rustdeskId string, conns []int) error {
+	if conns == nil {
+		return nil
+	}
+	open := make([]model.Audit, 0)
+	err := service.engine.Where("rustdesk_id = ? AND "+openAuditCond+" AND created_at <= ?", rustdeskId, time.Now().Add(-time.Minute).Format(config.TimeFormat)).Find(&open)
+	if err != nil {
+		return err
+	}
+	active := make(map[int]bool, len(conns))
+	for _, connId := range conns {
+		active[connId] = true
+	}
+	for i := range open {
+		if active[open[i].ConnId] {
+			continue
+		}
+		if err = service.close(&open[i], time.Now(), model.AUDIT_CLOSE_REASON_LOST); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseAll closes every open session with reason, the server calls it when it stops
+func (service *AuditService) CloseAll(reason string) (int, error) {
+	open := make([]model.Audit, 0)
+	if err := service.engine.Where(openAuditCond).Find(&open); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for i := range open {
+		if err := service.close(&open[i], now, reason); err != nil {
+			return i, err
+		}
+	}
+	return len(open), nil
+}
+
+// CloseOffline closes the open sessions of devices that have been offline for longer than timeout,
+// the session ends when the device was last seen
+func (service *AuditService) CloseOffline(timeout time.Duration) (int, error) {
+	open := make([]model.Audit, 0)
+	err := service.engine.Where(openAuditCond+" AND created_at <= ?", time.Now().Add(-timeout).Format(config.TimeFormat)).Find(&open)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for i := range open {
+		var device model.Device
+		has, err := service.engine.Where("rustdesk_id = ?", open[i].RustdeskId).Cols("is_online", "last_seen_at").Get(&device)
+		if err != nil {
+			return closed, err
+		}
+		closedAt := time.Now()
+		if has {
+			if device.IsOnline || time.Since(device.LastSeenAt) < timeout {
+				continue
+			}
+			closedAt = device.LastSeenAt
+		}
+		if err = service.close(&open[i], closedAt, model.AUDIT_CLOSE_REASON_OFFLINE); err != nil {
+			return closed, err
+		}
+		closed++
+	}
+	return closed, nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strings"
+)
+
+// ClientInstructions tells how to put the server config in the client of each platform
+var ClientInstructions = map[string][]string{
+	"windows": {
+		"Download the configured installer, its file name carries the ID server, key, API and relay server; keep the name when saving it.",
+		"Or install RustDesk, open Settings > Network > ID/Relay server and paste the config code with Import server config.",
+		"Deployment tools can run: rustdesk.exe --config <config code>",
+	},
+	"macos": {
+		"Install RustDesk, open Settings > Network, unlock the network settings and paste the config code with Import server config.",
+		"Or enter the ID server, relay server, API server and key shown here by hand.",
+	},
+	"linux": {
+		"Install the package, open Settings > Network > ID/Relay server and paste the config code with Import server config.",
+		"Or run as root: rustdesk --config <config code>",
+	},
+	"android": {
+		"Open Settings > ID/Relay server and scan the QR code, or enter the ID server, relay server, API server and key.",
+	},
+	"ios": {
+		"Open Settings > ID/Relay server and scan the QR code, or enter the ID server, relay server, API server and key.",
+	},
+}
+
+type ClientConfigService struct{}
+
+func NewClientConfigService() *ClientConfigService {
+	return &ClientConfigService{}
+}
+
+// Build assembles the client config from the client.* settings and the active server key. requestUrl is the
+// url of this server as the client sees it, used when client.apiServer is not set. Host is empty until
+// client.idServer is set, the config string is not usable without it.
+func (service *ClientConfigService) Build(requestUrl string) (*rustdesk.ClientConfig, error) {
+	settings := NewSettingsService()
+	cfg := &rustdesk.ClientConfig{
+		Host:  strings.TrimSpace(settings.GetString(SETTING_CLIENT_ID_SERVER)),
+		Relay: strings.TrimSpace(settings.GetString(SETTING_CLIENT_RELAY_SERVER)),
+		Api:   ApiServerUrl(requestUrl),
+	}
+	if cfg.Relay == "" {
+		if r := config.GetServerConfig().Rustdesk; r != nil && len(r.RelayServers) > 0 {
+			cfg.Relay = r.RelayServers[0]
+		}
+	}
+	active, err := NewServerKeyService().Active()
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		cfg.Key = active.PublicKey
+	}
+	return cfg, nil
+}
+
+// ApiServerUrl is the url clients and mails use for this server, the client.apiServer setting or requestUrl
+func ApiServerUrl(requestUrl string) string {
+	if api := strings.TrimSpace(NewSettingsService().GetString(SETTING_CLIENT_API_SERVER)); api != "" {
+		return strings.TrimSuffix(api, "/")
+	}
+	return strings.TrimSuffix(requestUrl, "/")
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/url"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+var (
+	ErrDownloadLinkInvalid = errors.New("the download link is invalid")
+	ErrDownloadLinkExpired = errors.New("the download link has expired")
+	ErrDownloadLinkMissing = errors.New("downloads need a signed link")
+)
+
+// DOWNLOAD_PATH is the prefix of the download routes, the signature covers the path below it
+const DOWNLOAD_PATH = "/api/download/"
+
+// downloadSignature signs path with its query, sorted and without sig, using the sign key
+func downloadSignature(path string, query url.Values) string {
+	q := url.Values{}
+	for k, v := range query {
+		if k != "sig" {
+			q[k] = v
+		}
+	}
+	return util.HmacSha256(path+"?"+q.Encode(), config.GetServerConfig().SignKey)
+}
+
+// SignDownloadUrl returns baseUrl+path with an expires and sig parameter, query (e.g. arch or configured) is
+// signed too so it can not be changed. path is not escaped, the signature covers it as it is and the url has
+// every segment escaped, so installer names with spaces or # work.
+func SignDownloadUrl(baseUrl, path string, query url.Values, expires time.Time) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", downloadSignature(path, q))
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return baseUrl + strings.Join(segments, "/") + "?" + q.Encode()
+}
+
+// VerifyDownload checks the signature of a download request, path is the decoded request path. signed is false
+// for a request without one, which is refused with ErrDownloadLinkMissing when httpConfig.requireSignedDownloads is set.
+func VerifyDownload(path string, query url.Values) (signed bool, err error) {
+	sig := query.Get("sig")
+	if sig == "" && query.Get("expires") == "" {
+		if config.GetServerConfig().HttpConfig.RequireSignedDownloads {
+			return false, ErrDownloadLinkMissing
+		}
+		return false, nil
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(downloadSignature(path, query))) {
+		return false, ErrDownloadLinkInvalid
+	}
+	if time.Now().Unix() > expires {
+		return false, ErrDownloadLinkExpired
+	}
+	return true, nil
+}
+
+type DownloadCount struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version,omitempty"`
+	Count    int64  `json:"count"`
+}
+
+type DownloadStats struct {
+	Since      string          `json:"since"`
+	Total      int64           `json:"total"`
+	Signed     int64           `json:"signed"`
+	ByPlatform []DownloadCount `json:"byPlatform"`
+	ByVersion  []DownloadCount `json:"byVersion"`
+}
+
+type DownloadService struct {
+	engine *xorm.Engine
+}
+
+func NewDownloadService() *DownloadService {
+	return &DownloadService{
+		engine: db.DbEngine,
+	}
+}
+
+func (service *DownloadService) Record(event *model.DownloadEvent) {
+	if len(event.UserAgent) > 512 {
+		event.UserAgent = event.UserAgent[:512]
+	}
+	if _, err := service.engine.Insert(event); err != nil {
+		serviceLog.Error("Record download error", "file", event.File, "error", err)
+	}
+}
+
+// Stats counts the downloads since, by platform and by platform and version
+func (service *DownloadService) Stats(since time.Time) (*DownloadStats, error) {
+	stats := &DownloadStats{Since: since.Format(config.TimeFormat), ByPlatform: []DownloadCount{}, ByVersion: []DownloadCount{}}
+	sinceStr := since.Format(config.TimeFormat)
+	var err error
+	if stats.Total, err = service.engine.Where("created_at >= ?", sinceStr).Count(&model.DownloadEvent{}); err != nil {
+		return nil, err
+	}
+	if stats.Signed, err = service.engine.Where("created_at >= ? AND signed = ?", sinceStr, true).Count(&model.DownloadEvent{}); err != nil {
+		return nil, err
+	}
+	err = service.engine.Table(&model.DownloadEvent{}).Select("platform, count(*) AS count").
+		Where("created_at >= ?", sinceStr).GroupBy("platform").Desc("count").Find(&stats.ByPlatform)
+	if err != nil {
+		return nil, err
+	}
+	err = service.engine.Table(&model.DownloadEvent{}).Select("platform, version, count(*) AS count").
+		Where("created_at >= ?", sinceStr).GroupBy("platform, version").Desc("count").Find(&stats.ByVersion)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (service *DownloadService) Events(page, size int, platform string) (*db.Pagination, []model.DownloadEvent, error) {
+	query := func() *xorm.Session {
+		q := service.engine.Table(&model.DownloadEvent{})
+		if platform != "" {
+			q.Where("platform = ?", platform)
+		}
+		return q.Desc("id")
+	}
+	pagination := db.NewPagination(page, size)
+	events := make([]model.DownloadEvent, 0)
+	err := pagination.Paginate(query, &model.DownloadEvent{}, &events)
+	return pagination, events, err
+}
+
+// MailLink sends a signed download link to a customer, with the download link template of the language of the user
+func (service *DownloadService) MailLink(userId int, to, link, file string, expires time.Time) error {
+	err := NewMailService().Send(userId, model.MAIL_TPL_TYPE_DOWNLOAD_LINK, to, util.GetUUID(), map[string]string{
+		"link":    link,
+		"file":    file,
+		"expires": expires.Format(config.TimeFormat),
+	})
+	if err == nil {
+		NewMailQueueService().Kick()
+	}
+	return err
+}
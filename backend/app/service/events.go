@@ -0,0 +1,294 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/syslog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_CONN_START          = "conn.start"
+	EVENT_CONN_END            = "conn.end"
+	EVENT_FILE_TRANSFER       = "file.transfer"
+	EVENT_ALARM               = "alarm"
+	EVENT_LOGIN_SUCCESS       = "login.success"
+	EVENT_LOGIN_FAILURE       = "login.failure"
+	EVENT_ADMIN_LOGIN_SUCCESS = "admin.login.success"
+	EVENT_ADMIN_LOGIN_FAILURE = "admin.login.failure"
+	EVENT_ADMIN_SESSION_KILL  = "admin.session.kill"
+)
+
+const (
+	EVENT_FORMAT_RFC5424 = "rfc5424"
+	EVENT_FORMAT_CEF     = "cef"
+)
+
+var eventNames = map[string]string{
+	EVENT_CONN_START:          "Connection started",
+	EVENT_CONN_END:            "Connection ended",
+	EVENT_FILE_TRANSFER:       "File transfer",
+	EVENT_ALARM:               "Security alarm",
+	EVENT_LOGIN_SUCCESS:       "Client login",
+	EVENT_LOGIN_FAILURE:       "Client login failed",
+	EVENT_ADMIN_LOGIN_SUCCESS: "Admin login",
+	EVENT_ADMIN_LOGIN_FAILURE: "Admin login failed",
+	EVENT_ADMIN_SESSION_KILL:  "Admin killed sessions",
+}
+
+// cefFields renames the fields that have a CEF dictionary key, the others keep their name
+var cefFields = map[string]string{
+	"ip":          "src",
+	"username":    "suser",
+	"rustdesk_id": "dvchost",
+	"peer_id":     "shost",
+	"path":        "filePath",
+	"size":        "fsize",
+	"reason":      "reason",
+	"message":     "msg",
+}
+
+// structured data id, 32473 is the example enterprise number of RFC 5424
+const eventSdId = "rdapi@32473"
+
+const eventQueueSize = 1000
+
+type Event struct {
+	Type     string
+	Severity int // syslog severity
+	Time     time.Time
+	Message  string
+	Fields   map[string]string
+	UserId   int // resolved into the username field only when a target accepts the event
+}
+
+type eventTarget struct {
+	config   *config.SyslogTarget
+	facility int
+	writer   *syslog.Writer
+}
+
+type EventService struct {
+	appName  string
+	hostname string
+	targets  []*eventTarget
+}
+
+var (
+	eventService   *EventService
+	eventServiceMu sync.Mutex
+)
+
+func init() {
+	// reconnect with the new targets, queued events of the old ones are still sent
+	config.OnReload(func(old, cfg *config.ServerConfig) {
+		if !config.Changed(old, cfg, "syslog") {
+			return
+		}
+		eventServiceMu.Lock()
+		previous := eventService
+		if previous != nil {
+			eventService = newEventService(cfg.Syslog)
+		}
+		eventServiceMu.Unlock()
+		if previous != nil {
+			go previous.Close()
+		}
+	})
+}
+
+// NewEventService connects to the syslog targets, events emitted before the first call are not forwarded
+func NewEventService() *EventService {
+	eventServiceMu.Lock()
+	defer eventServiceMu.Unlock()
+
+	if eventService == nil {
+		eventService = newEventService(config.GetServerConfig().Syslog)
+	}
+	return eventService
+}
+
+// StopEventService flushes the queued events and stops forwarding, NewEventService starts it again
+func StopEventService() {
+	eventServiceMu.Lock()
+	service := eventService
+	eventService = nil
+	eventServiceMu.Unlock()
+	if service != nil {
+		service.Close()
+	}
+}
+
+func newEventService(cfg *config.Syslog) *EventService {
+	service := &EventService{targets: make([]*eventTarget, 0)}
+	if cfg != nil {
+		service.appName = cfg.AppName
+		service.hostname = cfg.Hostname
+		for i, target := range cfg.Targets {
+			t, err := newEventTarget(target)
+			if err != nil {
+				serviceLog.Error("Syslog target disabled", "target", i, "address", target.Address, "error", err)
+				continue
+			}
+			service.targets = append(service.targets, t)
+		}
+	}
+	if service.hostname == "" {
+		service.hostname, _ = os.Hostname()
+	}
+	return service
+}
+
+func newEventTarget(cfg *config.SyslogTarget) (*eventTarget, error) {
+	facility := 16 // local0
+	if cfg.Facility != "" {
+		f, ok := syslog.Facility(cfg.Facility)
+		if !ok {
+			return nil, errors.New("unknown facility " + cfg.Facility)
+		}
+		facility = f
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TlsInsecureSkipVerify}
+	if cfg.TlsCaFile != "" {
+		pem, err := os.ReadFile(cfg.TlsCaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + cfg.TlsCaFile)
+		}
+	}
+
+	writer, err := syslog.NewWriter(cfg.Network, cfg.Address, tlsConfig, eventQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	return &eventTarget{config: cfg, facility: facility, writer: writer}, nil
+}
+
+// Close flushes the queued events
+func (service *EventService) Close() {
+	for _, t := range service.targets {
+		t.writer.Close(5 * time.Second)
+	}
+}
+
+// Emit queues the event for every target whose filter matches, it never blocks
+func (service *EventService) Emit(event *Event) {
+	if len(service.targets) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	resolved := false
+	for _, t := range service.targets {
+		if !t.accepts(event.Type) {
+			continue
+		}
+		if !resolved && event.UserId > 0 {
+			if event.Fields == nil {
+				event.Fields = map[string]string{}
+			}
+			event.Fields["username"] = eventUsername(event.UserId)
+		}
+		resolved = true
+		t.writer.Send(service.format(t, event))
+	}
+}
+
+func (t *eventTarget) accepts(eventType string) bool {
+	if len(t.config.Events) == 0 {
+		return true
+	}
+	for _, pattern := range t.config.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (service *EventService) format(t *eventTarget, event *Event) string {
+	msg := &syslog.Message{
+		Facility: t.facility,
+		Severity: event.Severity,
+		Time:     event.Time,
+		Hostname: service.hostname,
+		AppName:  service.appName,
+		MsgId:    event.Type,
+	}
+
+	if t.config.Format == EVENT_FORMAT_CEF {
+		ext := map[string]string{
+			"rt": strconv.FormatInt(event.Time.UnixMilli(), 10),
+		}
+		if event.Message != "" {
+			ext["msg"] = event.Message
+		}
+		for key, value := range event.Fields {
+			if cefKey, ok := cefFields[key]; ok {
+				key = cefKey
+			}
+			ext[key] = value
+		}
+		cef := &syslog.CEF{
+			Vendor:      "RustDesk",
+			Product:     "rustdesk-api-server-pro",
+			Version:     "1",
+			SignatureId: event.Type,
+			Name:        eventNames[event.Type],
+			Severity:    syslog.CEFSeverity(event.Severity),
+			Extension:   ext,
+		}
+		msg.Msg = cef.Format()
+		return msg.Format()
+	}
+
+	if len(event.Fields) > 0 {
+		msg.StructuredData = syslog.StructuredData(eventSdId, event.Fields)
+	}
+	msg.Msg = event.Message
+	if msg.Msg == "" {
+		msg.Msg = eventNames[event.Type]
+	}
+	return msg.Format()
+}
+
+// EmitEvent forwards the event when the event service was started, the cli commands don't forward events
+func EmitEvent(eventType string, severity int, message string, fields map[string]string) {
+	EmitUserEvent(eventType, severity, message, 0, fields)
+}
+
+// EmitUserEvent is EmitEvent with the username of userId, looked up only when the event is forwarded
+func EmitUserEvent(eventType string, severity int, message string, userId int, fields map[string]string) {
+	eventServiceMu.Lock()
+	service := eventService
+	eventServiceMu.Unlock()
+	if service != nil {
+		service.Emit(&Event{Type: eventType, Severity: severity, Message: message, Fields: fields, UserId: userId})
+	}
+}
+
+// eventUsername resolves the user of an event, empty when there is none
+func eventUsername(userId int) string {
+	if userId <= 0 {
+		return ""
+	}
+	var user model.User
+	has, err := db.DbEngine.ID(userId).Cols("username").Get(&user)
+	if err != nil || !has {
+		return ""
+	}
+	return user.Username
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strconv"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const (
+	HEALTH_OK   = "ok"
+	HEALTH_FAIL = "fail"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// HealthCheck is one readiness check, only required checks make the server not ready
+type HealthCheck struct {
+	Name     string      `json:"name"`
+	Status   string      `json:"status"`
+	Required bool        `json:"required"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+type HealthService struct {
+	engine *xorm.Engine
+}
+
+func NewHealthService() *HealthService {
+	return &HealthService{
+		engine: db.DbEngine,
+	}
+}
+
+var (
+	schedulerProbe   func() error
+	schedulerProbeMu sync.RWMutex
+)
+
+// SetSchedulerProbe registers the check of the job scheduler, StartJobs sets it once the scheduler runs
+func SetSchedulerProbe(probe func() error) {
+	schedulerProbeMu.Lock()
+	schedulerProbe = probe
+	schedulerProbeMu.Unlock()
+}
+
+// Ready runs the readiness checks, smtp adds a connection test to the smtp server
+func (service *HealthService) Ready(ctx context.Context, smtp bool) (bool, []HealthCheck) {
+	checks := []HealthCheck{
+		check("database", true, func() (interface{}, error) { return nil, service.pingDb(ctx) }),
+		check("migrations", true, func() (interface{}, error) { return nil, service.migrations() }),
+		check("scheduler", true, func() (interface{}, error) { return nil, scheduler() }),
+	}
+	if smtp {
+		checks = append(checks, check("smtp", false, func() (interface{}, error) { return nil, smtpReachable() }))
+	}
+	if status := RustdeskStatus(); status != nil {
+		checks = append(checks, check("rustdesk", false, func() (interface{}, error) {
+			if !status.Hbbs || !status.Hbbr {
+				return status, errors.New("hbbs or hbbr is not running")
+			}
+			return status, nil
+		}))
+	}
+
+	ready := true
+	for _, c := range checks {
+		if c.Required && c.Status != HEALTH_OK {
+			ready = false
+		}
+	}
+	return ready, checks
+}
+
+func check(name string, required bool, fn func() (interface{}, error)) HealthCheck {
+	details, err := fn()
+	c := HealthCheck{Name: name, Status: HEALTH_OK, Required: required, Details: details}
+	if err != nil {
+		c.Status = HEALTH_FAIL
+		c.Error = err.Error()
+	}
+	return c
+}
+
+func (service *HealthService) pingDb(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return service.engine.PingContext(ctx)
+}
+
+// migrations checks that every table synced at startup exists
+func (service *HealthService) migrations() error {
+	for _, table := range model.Tables() {
+		exists, err := service.engine.IsTableExist(table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New("table " + service.engine.TableName(table) + " is missing, run sync")
+		}
+	}
+	return nil
+}
+
+func scheduler() error {
+	schedulerProbeMu.RLock()
+	probe := schedulerProbe
+	schedulerProbeMu.RUnlock()
+	if probe == nil {
+		return errors.New("the scheduler is not started")
+	}
+	return probe()
+}
+
+func smtpReachable() error {
+	cfg := config.GetServerConfig().SmtpConfig
+	if cfg == nil || cfg.Host == "" {
+		return errors.New("smtp is not configured")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)), healthCheckTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type RustdeskServerStatus struct {
+	Hbbs bool `json:"hbbs"`
+	Hbbr bool `json:"hbbr"`
+}
+
+// RustdeskStatus returns whether hbbs and hbbr run, nil when the server does not manage them
+func RustdeskStatus() *RustdeskServerStatus {
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		status := &RustdeskServerStatus{}
+		for _, p := range supervisor.Status() {
+			switch p.Name {
+			case "hbbs":
+				status.Hbbs = p.Running
+			case "hbbr":
+				status.Hbbr = p.Running
+			}
+		}
+		return status
+	}
+	hbbr, hbbs := rustdesk.GetRustdeskServerBin()
+	if hbbr == "" {
+		return nil
+	}
+	// os.Stat rather than util.FileExists, which keeps the file open, the probes run every few seconds
+	if _, err := os.Stat(hbbr); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(hbbs); err != nil {
+		return nil
+	}
+	hbbrRunning, hbbsRunning := rustdesk.Status()
+	return &RustdeskServerStatus{Hbbs: hbbsRunning, Hbbr: hbbrRunning}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/util"
+	"sort"
+	"strconv"
+	"strings"
+
+	"xorm.io/xorm"
+)
+
+var ErrInstallerNotFound = errors.New("installer not found")
+
+var InstallerPlatforms = []string{"windows", "macos", "linux", "android", "ios"}
+
+// installerArchs maps the names builds use for an arch to the one of the catalog
+var installerArchs = map[string]string{
+	"x86_64":    model.INSTALLER_ARCH_X86_64,
+	"amd64":     model.INSTALLER_ARCH_X86_64,
+	"x64":       model.INSTALLER_ARCH_X86_64,
+	"x86":       model.INSTALLER_ARCH_X86,
+	"i386":      model.INSTALLER_ARCH_X86,
+	"i686":      model.INSTALLER_ARCH_X86,
+	"386":       model.INSTALLER_ARCH_X86,
+	"arm64":     model.INSTALLER_ARCH_ARM64,
+	"aarch64":   model.INSTALLER_ARCH_ARM64,
+	"arm64v8":   model.INSTALLER_ARCH_ARM64,
+	"armv7":     model.INSTALLER_ARCH_ARMV7,
+	"armhf":     model.INSTALLER_ARCH_ARMV7,
+	"arm":       model.INSTALLER_ARCH_ARMV7,
+	"universal": model.INSTALLER_ARCH_UNIVERSAL,
+}
+
+// NormalizeArch returns the catalog name of arch, empty when it is unknown
+func NormalizeArch(arch string) string {
+	return installerArchs[strings.ToLower(strings.TrimSpace(arch))]
+}
+
+// CompareVersions compares dotted versions like 1.3.2 or v1.3.2, a pre-release (1.3.2-beta1) is older than its
+// release. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(strings.ToLower(a), "v"), strings.TrimPrefix(strings.ToLower(b), "v")
+	aCore, aPre, _ := strings.Cut(a, "-")
+	bCore, bPre, _ := strings.Cut(b, "-")
+	aParts, bParts := strings.Split(aCore, "."), strings.Split(bCore, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			y, _ = strconv.Atoi(bParts[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	}
+	return 1
+}
+
+type InstallerUpload struct {
+	Platform     string
+	Arch         string
+	Version      string
+	Channel      string
+	ReleaseNotes string
+	Sha256       string // expected checksum, the upload is refused when it differs
+}
+
+type InstallerService struct {
+	engine *xorm.Engine
+}
+
+func NewInstallerService() *InstallerService {
+	return &InstallerService{
+		engine: db.DbEngine,
+	}
+}
+
+// Dir is the installers directory, the catalog files are in <platform>/<arch>/<version>/ below it
+func (service *InstallerService) Dir() string {
+	dir := config.GetServerConfig().HttpConfig.InstallersDir
+	if dir == "" {
+		dir = "./data/installers"
+	}
+	return dir
+}
+
+func (service *InstallerService) FilePath(installer *model.Installer) string {
+	return filepath.Join(service.Dir(), filepath.FromSlash(installer.Path))
+}
+
+// Add stores an uploaded build in the catalog, the sha256 and size are computed while it is written
+func (service *InstallerService) Add(src io.Reader, filename string, upload InstallerUpload, userId int) (*model.Installer, error) {
+	installer := &model.Installer{
+		Platform:     strings.ToLower(strings.TrimSpace(upload.Platform)),
+		Arch:         NormalizeArch(upload.Arch),
+		Version:      strings.TrimSpace(upload.Version),
+		Channel:      strings.ToLower(strings.TrimSpace(upload.Channel)),
+		Filename:     filepath.Base(filepath.Clean("/" + filename)),
+		ReleaseNotes: upload.ReleaseNotes,
+		CreatedBy:    userId,
+	}
+	if installer.Channel == "" {
+		installer.Channel = model.INSTALLER_CHANNEL_STABLE
+	}
+	if err := validateInstaller(installer); err != nil {
+		return nil, err
+	}
+	if installer.Filename == "/" || installer.Filename == "." {
+		return nil, errors.New("the file has no name")
+	}
+	has, err := service.engine.Where("platform = ? AND arch = ? AND version = ? AND filename = ?",
+		installer.Platform, installer.Arch, installer.Version, installer.Filename).Exist(&model.Installer{})
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return nil, fmt.Errorf("%s %s %s %s is already in the catalog", installer.Platform, installer.Arch, installer.Version, installer.Filename)
+	}
+
+	installer.Path = filepath.ToSlash(filepath.Join(installer.Platform, installer.Arch, installer.Version, installer.Filename))
+	dst := service.FilePath(installer)
+	if err = config.EnsureDir(filepath.Dir(dst)); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	installer.Size, err = io.Copy(io.MultiWriter(tmp, h), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	installer.Sha256 = hex.EncodeToString(h.Sum(nil))
+	if expected := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(upload.Sha256), "sha256:")); expected != "" && expected != installer.Sha256 {
+		return nil, fmt.Errorf("checksum mismatch: expected %s, got %s", expected, installer.Sha256)
+	}
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return nil, err
+	}
+	if _, err = service.engine.Insert(installer); err != nil {
+		_ = os.Remove(dst)
+		return nil, err
+	}
+	return installer, nil
+}
+
+func validateInstaller(installer *model.Installer) error {
+	if !util.InArray(InstallerPlatforms, installer.Platform) {
+		return fmt.Errorf("platform must be one of %s", strings.Join(InstallerPlatforms, ", "))
+	}
+	if installer.Arch == "" {
+		return errors.New("arch must be x86_64, x86, arm64, armv7 or universal")
+	}
+	if installer.Version == "" || len(installer.Version) > 32 || strings.ContainsAny(installer.Version, `/\`) || installer.Version[0] == '.' {
+		return errors.New("version is required, e.g. 1.3.2")
+	}
+	if installer.Channel != model.INSTALLER_CHANNEL_STABLE && installer.Channel != model.INSTALLER_CHANNEL_BETA {
+		return errors.New("channel must be stable or beta")
+	}
+	return nil
+}
+
+func (service *InstallerService) Get(id int) (*model.Installer, error) {
+	installer := &model.Installer{}
+	has, err := service.engine.ID(id).Get(installer)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrInstallerNotFound
+	}
+	return installer, nil
+}
+
+// List returns the builds of the catalog, newest version first. Empty filters match everything, the beta channel
+// includes the stable builds.
+func (service *InstallerService) List(platform, arch, channel string) ([]model.Installer, error) {
+	q := service.engine.NewSession()
+	defer q.Close()
+	if platform != "" {
+		q.Where("platform = ?", platform)
+	}
+	if arch != "" {
+		q.In("arch", []string{arch, model.INSTALLER_ARCH_UNIVERSAL})
+	}
+	if channel == model.INSTALLER_CHANNEL_STABLE {
+		q.Where("channel = ?", channel)
+	}
+	installers := make([]model.Installer, 0)
+	if err := q.Desc("id").Find(&installers); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(installers, func(i, j int) bool {
+		return CompareVersions(installers[i].Version, installers[j].Version) > 0
+	})
+	return installers, nil
+}
+
+// Latest returns the newest build of platform for arch, an arch specific build before a universal one of the
+// same version. An empty arch takes any arch. Nil when the catalog has none.
+func (service *InstallerService) Latest(platform, arch, channel string) (*model.Installer, error) {
+	installers, err := service.List(platform, arch, channel)
+	if err != nil || len(installers) == 0 {
+		return nil, err
+	}
+	best := &installers[0]
+	for i := 1; i < len(installers) && CompareVersions(installers[i].Version, best.Version) == 0; i++ {
+		if best.Arch == model.INSTALLER_ARCH_UNIVERSAL && installers[i].Arch != model.INSTALLER_ARCH_UNIVERSAL {
+			best = &installers[i]
+		}
+	}
+	return best, nil
+}
+
+// Update changes the channel and the release notes of a build
+func (service *InstallerService) Update(id int, channel, releaseNotes string) (*model.Installer, error) {
+	installer, err := service.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	installer.Channel, installer.ReleaseNotes = channel, releaseNotes
+	if err = validateInstaller(installer); err != nil {
+		return nil, err
+	}
+	_, err = service.engine.ID(id).Cols("channel", "release_notes").Update(installer)
+	return installer, err
+}
+
+// Delete removes builds from the catalog and their files
+func (service *InstallerService) Delete(ids []int) (int, error) {
+	installers := make([]model.Installer, 0)
+	if err := service.engine.In("id", ids).Find(&installers); err != nil {
+		return 0, err
+	}
+	for i := range installers {
+		if _, err := service.engine.ID(installers[i].Id).Delete(&model.Installer{}); err != nil {
+			return i, err
+		}
+		if err := os.Remove(service.FilePath(&installers[i])); err != nil && !os.IsNotExist(err) {
+			serviceLog.Warn("Remove installer file error", "path", installers[i].Path, "error", err)
+		}
+	}
+	return len(installers), nil
+}
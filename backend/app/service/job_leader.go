@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/db"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// JOB_LEASE_SCHEDULER is the lease of the scheduled jobs, its holder runs all of them
+const JOB_LEASE_SCHEDULER = "scheduler"
+
+var ErrNotJobLeader = errors.New("another instance holds the job lease")
+
+// JobLeader keeps the scheduler lease in the job_lease table, so replicas sharing the database run
+// the scheduled jobs on one of them. The holder renews the lease every third of its ttl, the other
+// replicas take it over once it expired.
+type JobLeader struct {
+	engine   *xorm.Engine
+	instance string
+	ttl      time.Duration
+	mu       sync.RWMutex
+	until    time.Time // this instance holds the lease until then
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewJobLeader(instance string, ttl time.Duration) *JobLeader {
+	return &JobLeader{
+		engine:   db.DbEngine,
+		instance: instance,
+		ttl:      ttl,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// IsLeader implements gocron.Elector, the scheduler skips the runs while it returns an error
+func (l *JobLeader) IsLeader(_ context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if time.Now().Before(l.until) {
+		return nil
+	}
+	return ErrNotJobLeader
+}
+
+// Start takes or waits for the lease in the background until Stop
+func (l *JobLeader) Start() {
+	l.Renew()
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Renew()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Renew takes the lease when it is free or expired and extends it when this instance holds it
+func (l *JobLeader) Renew() {
+	held, err := l.acquire()
+	if err != nil {
+		// keep the lease until it runs out, the next renew may reach the database again
+		jobsLog.Error("Job lease renew error", "instance", l.instance, "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	wasLeader := time.Now().Before(l.until)
+	if held {
+		l.until = time.Now().Add(l.ttl)
+	} else {
+		l.until = time.Time{}
+	}
+	l.mu.Unlock()
+
+	if held && !wasLeader {
+		jobsLog.Info("Took the job lease, scheduled jobs run on this instance", "instance", l.instance)
+	} else if !held && wasLeader {
+		jobsLog.Warn("Lost the job lease to another instance", "instance", l.instance)
+	}
+}
+
+func (l *JobLeader) acquire() (bool, error) {
+	now := time.Now().Unix()
+	expires := time.Now().Add(l.ttl).Unix()
+	res, err := l.engine.Exec("UPDATE job_lease SET holder = ?, expires_at = ? WHERE name = ? AND (holder = ? OR expires_at < ?)",
+		l.instance, expires, JOB_LEASE_SCHEDULER, l.instance, now)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if rows > 0 {
+		return true, nil
+	}
+
+	exists, err := l.engine.Exist(&model.JobLease{Name: JOB_LEASE_SCHEDULER})
+	if err != nil || exists {
+		return false, err
+	}
+	// the first instance creates the lease, when two do it at once the primary key rejects one of them
+	if _, err = l.engine.Insert(&model.JobLease{Name: JOB_LEASE_SCHEDULER, Holder: l.instance, ExpiresAt: expires}); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Stop stops renewing and releases the lease, so another replica takes over without waiting for it to expire
+func (l *JobLeader) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+		<-l.done
+
+		l.mu.Lock()
+		l.until = time.Time{}
+		l.mu.Unlock()
+		if _, err := l.engine.Exec("UPDATE job_lease SET expires_at = 0 WHERE name = ? AND holder = ?", JOB_LEASE_SCHEDULER, l.instance); err != nil {
+			jobsLog.Error("Job lease release error", "instance", l.instance, "error", err)
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/logger"
+	"strconv"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const (
+	JOB_DEVICE_CHECK         = "device_check"
+	JOB_CLOSE_OFFLINE_AUDITS = "close_offline_audits"
+	JOB_RETENTION            = "retention"
+	JOB_MONTHLY_REPORT       = "monthly_report"
+	JOB_MAIL_QUEUE           = "mail_queue"
+)
+
+// jobRunHistory is the number of runs kept per job
+const jobRunHistory = 100
+
+// a successful scheduled run is only recorded when it took jobRunSlow or longer, or when the last recorded
+// one is jobRunQuiet old, so jobs running every few seconds do not write a row on every tick
+const (
+	jobRunSlow  = 10 * time.Second
+	jobRunQuiet = time.Hour
+)
+
+var jobsLog = logger.Module("jobs")
+
+var ErrJobRunning = errors.New("the job is already running on this instance")
+
+type registeredJob struct {
+	name        string
+	description string
+	run         func() (string, error)
+	nextRun     func() (time.Time, error)
+	running     sync.Mutex
+	recordedAt  time.Time // last recorded scheduled run, guarded by running
+}
+
+var (
+	jobs       = map[string]*registeredJob{}
+	jobsOrder  []string
+	jobsMu     sync.RWMutex
+	manualRuns sync.WaitGroup
+)
+
+// RegisterJob adds a background job, run returns a short summary of what the job did.
+// Registering a name again replaces the job.
+func RegisterJob(name, description string, run func() (string, error)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if _, ok := jobs[name]; !ok {
+		jobsOrder = append(jobsOrder, name)
+	}
+	jobs[name] = &registeredJob{name: name, description: description, run: run}
+}
+
+// UnregisterJob removes a job, a run in progress finishes
+func UnregisterJob(name string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if _, ok := jobs[name]; !ok {
+		return
+	}
+	delete(jobs, name)
+	for i, n := range jobsOrder {
+		if n == name {
+			jobsOrder = append(jobsOrder[:i:i], jobsOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// SetJobNextRun sets how to read the next scheduled run of a job, jobs without one are only run by hand
+func SetJobNextRun(name string, nextRun func() (time.Time, error)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if job, ok := jobs[name]; ok {
+		job.nextRun = nextRun
+	}
+}
+
+func getJob(name string) *registeredJob {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	return jobs[name]
+}
+
+// InstanceId names this replica in the job lease and the run history
+func InstanceId() string {
+	if cfg := config.GetServerConfig().JobsConfig; cfg != nil && cfg.InstanceId != "" {
+		return cfg.InstanceId
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}
+
+type JobStatus struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Running     bool          `json:"running"` // on this instance
+	NextRun     string        `json:"nextRun,omitempty"`
+	LastRun     *model.JobRun `json:"lastRun,omitempty"`
+}
+
+type JobsStatus struct {
+	Instance       string      `json:"instance"`
+	Leader         string      `json:"leader"`
+	LeaseExpiresAt string      `json:"leaseExpiresAt,omitempty"`
+	IsLeader       bool        `json:"isLeader"`
+	Jobs           []JobStatus `json:"jobs"`
+}
+
+type JobService struct {
+	engine *xorm.Engine
+}
+
+func NewJobService() *JobService {
+	return &JobService{
+		engine: db.DbEngine,
+	}
+}
+
+// Run runs a job now on this instance and records the run, the returned error is only set when the
+// job did not run, a failed job has the failed status. It does not check the scheduler lease, the scheduler
+// only calls it on the leader and a manual run is meant to run on the instance that receives it.
+// Successful scheduled runs are recorded now and then, see jobRunQuiet.
+func (service *JobService) Run(name, trigger string) (*model.JobRun, error) {
+	job, run, err := service.begin(name, trigger)
+	if err != nil {
+		return nil, err
+	}
+	service.execute(job, run)
+	return run, nil
+}
+
+// Start runs a job in the background and returns its run as it started, like Run it ignores the scheduler lease
+func (service *JobService) Start(name, trigger string) (*model.JobRun, error) {
+	job, run, err := service.begin(name, trigger)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	manualRuns.Add(1)
+	go func() {
+		defer manualRuns.Done()
+		service.execute(job, run)
+	}()
+	return &started, nil
+}
+
+// WaitStarted waits for the runs of Start to finish, at most timeout
+func WaitStarted(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		manualRuns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (service *JobService) begin(name, trigger string) (*registeredJob, *model.JobRun, error) {
+	job := getJob(name)
+	if job == nil {
+		return nil, nil, errors.New("unknown job " + name)
+	}
+	if !job.running.TryLock() {
+		return nil, nil, ErrJobRunning
+	}
+	run := &model.JobRun{
+		Job:       name,
+		Instance:  InstanceId(),
+		Trigger:   trigger,
+		Status:    model.JOB_STATUS_RUNNING,
+		StartedAt: time.Now(),
+	}
+	// a scheduled run is inserted when it finished, if it is recorded at all
+	if trigger == model.JOB_TRIGGER_SCHEDULE {
+		return job, run, nil
+	}
+	// the job still runs when its history cannot be written
+	if _, err := service.engine.Insert(run); err != nil {
+		jobsLog.Error("Job run insert error", "job", name, "error", err)
+	}
+	return job, run, nil
+}
+
+func (service *JobService) execute(job *registeredJob, run *model.JobRun) {
+	defer job.running.Unlock()
+
+	result, err := runJob(job)
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	run.Result = result
+	run.Status = model.JOB_STATUS_SUCCESS
+	if err != nil {
+		run.Status = model.JOB_STATUS_FAILED
+		run.Error = err.Error()
+		jobsLog.Error("Job failed", "job", run.Job, "trigger", run.Trigger, "error", err)
+	} else {
+		jobsLog.Debug("Job finished", "job", run.Job, "trigger", run.Trigger, "result", result, "duration_ms", run.Duration)
+	}
+
+	if run.Trigger == model.JOB_TRIGGER_SCHEDULE {
+		if err == nil && run.FinishedAt.Sub(run.StartedAt) < jobRunSlow && time.Since(job.recordedAt) < jobRunQuiet {
+			return
+		}
+		if _, err := service.engine.Insert(run); err != nil {
+			jobsLog.Error("Job run insert error", "job", run.Job, "error", err)
+			return
+		}
+		job.recordedAt = run.FinishedAt
+	} else {
+		if run.Id == 0 {
+			return
+		}
+		if _, err := service.engine.ID(run.Id).Cols("status", "result", "error", "finished_at", "duration").Update(run); err != nil {
+			jobsLog.Error("Job run update error", "job", run.Job, "error", err)
+			return
+		}
+	}
+	if err := service.trim(run.Job); err != nil {
+		jobsLog.Error("Job run history trim error", "job", run.Job, "error", err)
+	}
+}
+
+func runJob(job *registeredJob) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.run()
+}
+
+// trim keeps the last jobRunHistory runs of a job
+func (service *JobService) trim(name string) error {
+	oldest := &model.JobRun{}
+	has, err := service.engine.Where("job = ?", name).Desc("id").Limit(1, jobRunHistory).Cols("id").Get(oldest)
+	if err != nil || !has {
+		return err
+	}
+	_, err = service.engine.Where("job = ? AND id <= ?", name, oldest.Id).Delete(&model.JobRun{})
+	return err
+}
+
+// Leader returns the scheduler lease while an instance holds it, nil when no api server runs the jobs: the lease
+// is released on shutdown and expires jobsConfig.leaseSeconds after a crash
+func (service *JobService) Leader() (*model.JobLease, error) {
+	lease := &model.JobLease{}
+	has, err := service.engine.ID(JOB_LEASE_SCHEDULER).Get(lease)
+	if err != nil || !has || lease.ExpiresAt <= time.Now().Unix() {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// List returns the registered jobs with their last run and the holder of the scheduler lease
+func (service *JobService) List() (*JobsStatus, error) {
+	status := &JobsStatus{Instance: InstanceId(), Jobs: make([]JobStatus, 0)}
+
+	lease, err := service.Leader()
+	if err != nil {
+		return nil, err
+	}
+	if lease != nil {
+		status.Leader = lease.Holder
+		status.LeaseExpiresAt = time.Unix(lease.ExpiresAt, 0).Format(config.TimeFormat)
+		status.IsLeader = lease.Holder == status.Instance
+	}
+
+	jobsMu.RLock()
+	registered := make([]*registeredJob, 0, len(jobsOrder))
+	for _, name := range jobsOrder {
+		registered = append(registered, jobs[name])
+	}
+	jobsMu.RUnlock()
+
+	for _, job := range registered {
+		js := JobStatus{Name: job.name, Description: job.description}
+		if job.running.TryLock() {
+			job.running.Unlock()
+		} else {
+			js.Running = true
+		}
+		if job.nextRun != nil {
+			if next, err := job.nextRun(); err == nil && !next.IsZero() {
+				js.NextRun = next.Format(config.TimeFormat)
+			}
+		}
+		last := &model.JobRun{}
+		has, err := service.engine.Where("job = ?", job.name).Desc("id").Get(last)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			js.LastRun = last
+		}
+		status.Jobs = append(status.Jobs, js)
+	}
+	return status, nil
+}
+
+// Runs returns the run history, of one job when name is set
+func (service *JobService) Runs(name string, page, size int) (*db.Pagination, []model.JobRun, error) {
+	query := func() *xorm.Session {
+		q := service.engine.NewSession()
+		if name != "" {
+			q.Where("job = ?", name)
+		}
+		return q.Desc("id")
+	}
+	pagination := db.NewPagination(page, size)
+	runs := make([]model.JobRun, 0)
+	if err := pagination.Paginate(query, &model.JobRun{}, &runs); err != nil {
+		return nil, nil, err
+	}
+	return pagination, runs, nil
+}
@@ -0,0 +1,5 @@
+package service
+
+import "rustdesk-api-server-pro/helper/logger"
+
+var serviceLog = logger.Module("service")
@@ -5,107 +5,173 @@ import (
 	"rustdesk-api-server-pro/app/model"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/mailer"
 	"strings"
+	"sync"
+	"time"
 
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
 type MailService struct {
-	mailer *mail.SMTPServer
-	config *config.ServerConfig
+	transport mailer.Transport
+	config    *config.ServerConfig
 }
 
-var mailService *MailService
+var (
+	mailService   *MailService
+	mailServiceMu sync.Mutex
+)
 
+// NewMailService returns the service of the current config. The transport is kept while the config changes
+// elsewhere; a change of smtpConfig or mailConfig builds a new one, the sends in progress finish on the old one.
 func NewMailService() *MailService {
+	cfg := config.GetServerConfig()
+
+	mailServiceMu.Lock()
+	defer mailServiceMu.Unlock()
 
 	// 单例模式
-	if mailService != nil {
+	if mailService != nil && mailService.config == cfg {
 		return mailService
 	}
-
-	config := config.GetServerConfig()
-
-	mailer := mail.NewSMTPClient()
-
-	mailer.Host = config.SmtpConfig.Host
-	mailer.Port = config.SmtpConfig.Port
-	mailer.Username = config.SmtpConfig.Username
-	mailer.Password = config.SmtpConfig.Password
-	switch config.SmtpConfig.Encryption {
-	case "ssl/tls":
-		mailer.Encryption = mail.EncryptionSSLTLS
-	case "starttls":
-		mailer.Encryption = mail.EncryptionSTARTTLS
-	default:
-		mailer.Encryption = mail.EncryptionNone
+	if mailService != nil && !config.Changed(mailService.config, cfg, "smtpConfig") && !config.Changed(mailService.config, cfg, "mailConfig") {
+		mailService = &MailService{transport: mailService.transport, config: cfg}
+		return mailService
 	}
 
-	return &MailService{
-		mailer: mailer,
-		config: config,
+	if mailService != nil {
+		_ = mailService.transport.Close()
+	}
+	mailService = &MailService{
+		transport: newMailTransport(cfg),
+		config:    cfg,
 	}
+	return mailService
 }
 
-func (service *MailService) GetMailTemplateByType(t int) (*model.MailTemplate, error) {
-	var tpl model.MailTemplate
-	_, err := db.DbEngine.Where("type = ?", t).Desc("id").Get(&tpl)
-	if err != nil {
-		return nil, err
+func newMailTransport(cfg *config.ServerConfig) mailer.Transport {
+	m := cfg.MailConfig
+	switch m.Transport {
+	case mailer.TRANSPORT_SENDMAIL:
+		return &mailer.Sendmail{
+			Path:    m.Sendmail.Path,
+			Args:    m.Sendmail.Args,
+			Timeout: time.Duration(m.Sendmail.TimeoutSeconds) * time.Second,
+		}
+	case mailer.TRANSPORT_HTTP:
+		transport, err := mailer.NewHttpTransport(mailer.HttpOptions{
+			Url:     m.Http.Url,
+			Method:  m.Http.Method,
+			Headers: m.Http.Headers,
+			Body:    m.Http.Body,
+			Timeout: time.Duration(m.Http.TimeoutSeconds) * time.Second,
+		})
+		if err != nil {
+			return mailer.Failed(fmt.Errorf("mailConfig.http error: %w", err))
+		}
+		return transport
+	case mailer.TRANSPORT_FILE:
+		return &mailer.FileTransport{Dir: m.File.Dir}
 	}
-	return &tpl, nil
+	return mailer.NewSmtpPool(mailer.SmtpOptions{
+		Host:           cfg.SmtpConfig.Host,
+		Port:           cfg.SmtpConfig.Port,
+		Username:       cfg.SmtpConfig.Username,
+		Password:       cfg.SmtpConfig.Password,
+		Encryption:     cfg.SmtpConfig.Encryption,
+		PoolSize:       m.Smtp.PoolSize,
+		IdleTimeout:    time.Duration(m.Smtp.IdleSeconds) * time.Second,
+		ConnectTimeout: 10 * time.Second,
+		SendTimeout:    30 * time.Second,
+	})
 }
 
-func (service *MailService) Send(userId, tplId int, to, uuid string, vars map[string]string) error {
+// Send renders the template of tplType in the language of the user and queues the mail, the mail queue job
+// delivers it
+func (service *MailService) Send(userId, tplType int, to, uuid string, vars map[string]string) error {
+	return service.SendExpiring(userId, tplType, to, uuid, vars, time.Time{})
+}
 
-	sendLog := &model.MailLogs{
-		UserId: userId,
-		TplId:  tplId,
-		From:   service.config.SmtpConfig.From,
-		To:     to,
-		Uuid:   uuid,
+// SendExpiring queues a mail like Send that is given up when it is not sent by expiresAt, like a verification code
+func (service *MailService) SendExpiring(userId, tplType int, to, uuid string, vars map[string]string, expiresAt time.Time) error {
+	var content *MailContent
+	tpl, err := service.Template(tplType, userLanguage(userId))
+	if err == nil {
+		content, err = RenderMailTemplate(tpl, vars)
 	}
-
-	var template model.MailTemplate
-	get, err := db.DbEngine.Where("id = ?", tplId).Get(&template)
-	if err != nil || !get {
-		sendLog.Status = model.MAIL_SEND_ERR
-		sendLog.Logs = fmt.Sprintf("template not found or error: %s", err.Error())
-		db.DbEngine.Insert(sendLog)
+	if err != nil {
+		tplId := 0
+		if tpl != nil {
+			tplId = tpl.Id
+		}
+		db.DbEngine.Insert(&model.MailLogs{
+			UserId: userId,
+			TplId:  tplId,
+			From:   service.config.SmtpConfig.From,
+			To:     to,
+			Uuid:   uuid,
+			Status: model.MAIL_SEND_DEAD,
+			Logs:   fmt.Sprintf("template not found or error: %s", err.Error()),
+		})
 		return err
 	}
 
-	body := template.Contents
-	for k, v := range vars {
-		body = strings.Replace(body, k, v, -1)
-	}
+	return service.queue(userId, tpl.Id, to, uuid, content, expiresAt)
+}
 
-	message := mail.NewMSG()
-	message.SetFrom(service.config.SmtpConfig.From)
-	message.AddTo(to)
-	message.SetSubject(template.Subject)
-	message.SetBody(mail.TextHTML, body)
+// SendContent queues a mail without a template (tplId may be 0), it is logged like Send
+func (service *MailService) SendContent(userId, tplId int, to, uuid string, content *MailContent, attachments ...*mail.File) error {
+	return service.queue(userId, tplId, to, uuid, content, time.Time{}, attachments...)
+}
 
-	sender, err := service.mailer.Connect()
-	if err != nil {
-		sendLog.Status = model.MAIL_SEND_ERR
-		sendLog.Logs = fmt.Sprintf("can not connect smtp server error: %s", err.Error())
-		db.DbEngine.Insert(sendLog)
-		return err
+func (service *MailService) queue(userId, tplId int, to, uuid string, content *MailContent, expiresAt time.Time, attachments ...*mail.File) error {
+	text := content.Text
+	if text == "" {
+		text = HtmlToText(content.Html)
 	}
-	err = message.Send(sender)
-	if err != nil {
-		sendLog.Status = model.MAIL_SEND_ERR
-		sendLog.Logs = fmt.Sprintf("send error: %s", err.Error())
-		db.DbEngine.Insert(sendLog)
-		return err
-	}
-
-	sendLog.Subject = template.Subject
-	sendLog.Contents = body
-	sendLog.Status = model.MAIL_SEND_OK
+	return NewMailQueueService().Enqueue(&model.MailLogs{
+		UserId:       userId,
+		TplId:        tplId,
+		From:         service.config.SmtpConfig.From,
+		To:           to,
+		Uuid:         uuid,
+		Subject:      content.Subject,
+		Contents:     content.Html,
+		TextContents: text,
+		ExpiresAt:    expiresAt,
+	}, attachments...)
+}
 
-	db.DbEngine.Insert(sendLog)
+// deliver sends a queued mail through the transport
+func (service *MailService) deliver(sendLog *model.MailLogs, attachments []model.MailAttachment) error {
+	msg := &mailer.Message{
+		From:    sendLog.From,
+		To:      sendLog.To,
+		Subject: sendLog.Subject,
+		Html:    sendLog.Contents,
+		Text:    sendLog.TextContents,
+	}
+	for _, a := range attachments {
+		msg.Attachments = append(msg.Attachments, mailer.Attachment{Name: a.Name, MimeType: a.MimeType, Data: a.Data})
+	}
+	return service.transport.Send(msg)
+}
 
-	return nil
+// adminRecipients returns the super admins with an email address and the comma separated extra addresses,
+// mapped to their user id (0 for the extra ones)
+func adminRecipients(extra string) map[string]int {
+	recipients := make(map[string]int)
+	admins := make([]model.User, 0)
+	_ = db.DbEngine.Where("role = ? and status > 0 and email != ''", model.ROLE_SUPER_ADMIN).Cols("id", "email").Find(&admins)
+	for _, admin := range admins {
+		recipients[admin.Email] = admin.Id
+	}
+	for _, email := range strings.Split(extra, ",") {
+		email = strings.TrimSpace(email)
+		if _, ok := recipients[email]; email != "" && !ok {
+			recipients[email] = 0
+		}
+	}
+	return recipients
 }
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"sync/atomic"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+	"xorm.io/xorm"
+)
+
+// pendingMailCond matches the mails the queue still has to deliver: failed ones waiting for a retry, queued and
+// sending ones. MarkLegacyFailed moves the failures of the versions before the queue out of it.
+const pendingMailCond = "status IN (2, 3, 4)"
+
+// mailSendingTimeout gives back to the queue the mails of a worker that stopped while sending them
+const mailSendingTimeout = 10 * time.Minute
+
+// mailQueueKicked is set while a kicked drain runs, a second kick waits for the job
+var mailQueueKicked atomic.Bool
+
+type MailQueueResult struct {
+	Sent  int `json:"sent"`
+	Retry int `json:"retry"`
+	Dead  int `json:"dead"`
+}
+
+func (r *MailQueueResult) String() string {
+	return fmt.Sprintf("%d sent, %d to retry, %d dead", r.Sent, r.Retry, r.Dead)
+}
+
+type MailQueueService struct {
+	engine *xorm.Engine
+}
+
+func NewMailQueueService() *MailQueueService {
+	return &MailQueueService{
+		engine: db.DbEngine,
+	}
+}
+
+// Enqueue stores a mail and its attachments, it is sent by the next drain
+func (service *MailQueueService) Enqueue(sendLog *model.MailLogs, attachments ...*mail.File) error {
+	sendLog.Status = model.MAIL_SEND_QUEUED
+	sendLog.Attempts = 0
+	sendLog.NextAttemptAt = time.Now()
+	_, err := service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := session.Insert(sendLog); err != nil {
+			return nil, err
+		}
+		for _, file := range attachments {
+			data := file.Data
+			if data == nil && file.FilePath != "" {
+				return nil, fmt.Errorf("attachment %s: only in-memory attachments can be queued", file.FilePath)
+			}
+			if _, err := session.Insert(&model.MailAttachment{
+				MailLogId: sendLog.Id,
+				Name:      file.Name,
+				MimeType:  file.MimeType,
+				Data:      data,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// Kick drains the queue in the background, so a mail the user waits for does not wait for the job
+func (service *MailQueueService) Kick() {
+	if !mailQueueKicked.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer mailQueueKicked.Store(false)
+		if _, err := service.Drain(); err != nil {
+			serviceLog.Error("Mail queue drain error", "error", err)
+		}
+	}()
+}
+
+// Drain sends the mails that are due, a batch of jobsConfig.mailQueueJob.batchSize at a time
+func (service *MailQueueService) Drain() (*MailQueueResult, error) {
+	cfg := config.GetServerConfig().JobsConfig.MailQueueJob
+	result := &MailQueueResult{}
+	now := time.Now().Format(config.TimeFormat)
+
+	// the mails of a worker that died while sending them are retried
+	_, err := service.engine.Table(&model.MailLogs{}).
+		Where("status = ? AND updated_at < ?", model.MAIL_SEND_SENDING, time.Now().Add(-mailSendingTimeout).Format(config.TimeFormat)).
+		Update(map[string]interface{}{"status": model.MAIL_SEND_ERR, "next_attempt_at": now})
+	if err != nil {
+		return result, err
+	}
+
+	logs := make([]model.MailLogs, 0)
+	err = service.engine.In("status", model.MAIL_SEND_QUEUED, model.MAIL_SEND_ERR).
+		Where("next_attempt_at <= ?", now).
+		Asc("next_attempt_at", "id").Limit(cfg.BatchSize).Find(&logs)
+	if err != nil {
+		return result, err
+	}
+	for i := range logs {
+		// another worker may have taken it since the select
+		claimed, err := service.engine.ID(logs[i].Id).In("status", model.MAIL_SEND_QUEUED, model.MAIL_SEND_ERR).
+			Incr("attempts").Update(&model.MailLogs{Status: model.MAIL_SEND_SENDING})
+		if err != nil {
+			return result, err
+		}
+		if claimed == 0 {
+			continue
+		}
+		logs[i].Attempts++
+		if expired(&logs[i], time.Now()) {
+			if err = service.expire(&logs[i]); err != nil {
+				return result, err
+			}
+			result.Dead++
+			continue
+		}
+		switch status, err := service.deliver(&logs[i], cfg); {
+		case err != nil:
+			return result, err
+		case status == model.MAIL_SEND_OK:
+			result.Sent++
+		case status == model.MAIL_SEND_DEAD:
+			result.Dead++
+		default:
+			result.Retry++
+		}
+	}
+	return result, nil
+}
+
+// deliver sends a claimed mail and records the attempt, it returns the new status of the mail
+func (service *MailQueueService) deliver(sendLog *model.MailLogs, cfg *config.MailQueueJob) (int, error) {
+	attachments := make([]model.MailAttachment, 0)
+	if err := service.engine.Where("mail_log_id = ?", sendLog.Id).Asc("id").Find(&attachments); err != nil {
+		return 0, err
+	}
+
+	sendErr := NewMailService().deliver(sendLog, attachments)
+	now := time.Now()
+	update := map[string]interface{}{}
+	if sendErr == nil {
+		update["status"] = model.MAIL_SEND_OK
+		update["sent_at"] = now.Format(config.TimeFormat)
+		update["logs"] = sendLog.Logs + fmt.Sprintf("%s attempt %d: sent\n", now.Format(config.TimeFormat), sendLog.Attempts)
+	} else {
+		update["logs"] = sendLog.Logs + fmt.Sprintf("%s attempt %d: %s\n", now.Format(config.TimeFormat), sendLog.Attempts, sendErr.Error())
+		// a mail that would expire before the next attempt is given up now
+		next := now.Add(mailBackoff(cfg, sendLog.Attempts))
+		if sendLog.Attempts >= cfg.MaxAttempts || expired(sendLog, next) {
+			update["status"] = model.MAIL_SEND_DEAD
+			serviceLog.Warn("Mail is dead", "id", sendLog.Id, "to", sendLog.To, "attempts", sendLog.Attempts, "error", sendErr)
+		} else {
+			update["status"] = model.MAIL_SEND_ERR
+			update["next_attempt_at"] = next.Format(config.TimeFormat)
+		}
+	}
+	if _, err := service.engine.Table(&model.MailLogs{}).ID(sendLog.Id).Update(update); err != nil {
+		return 0, err
+	}
+	return update["status"].(int), nil
+}
+
+// expired reports whether a mail with an expiry is no longer of use at t
+func expired(sendLog *model.MailLogs, t time.Time) bool {
+	return !sendLog.ExpiresAt.IsZero() && !t.Before(sendLog.ExpiresAt)
+}
+
+// expire gives up a claimed mail that was not sent before its expiry
+func (service *MailQueueService) expire(sendLog *model.MailLogs) error {
+	now := time.Now().Format(config.TimeFormat)
+	_, err := service.engine.Table(&model.MailLogs{}).ID(sendLog.Id).Update(map[string]interface{}{
+		"status": model.MAIL_SEND_DEAD,
+		"logs":   sendLog.Logs + fmt.Sprintf("%s attempt %d: expired at %s, not sent\n", now, sendLog.Attempts, sendLog.ExpiresAt.Format(config.TimeFormat)),
+	})
+	return err
+}
+
+// MarkLegacyFailed moves the mails that failed before the queue existed to dead: they have status 2 like a mail
+// waiting for a retry but no next attempt and, mostly, no contents to send
+func (service *MailQueueService) MarkLegacyFailed() (int64, error) {
+	return service.engine.Table(&model.MailLogs{}).
+		Where("status = ? AND next_attempt_at IS NULL", model.MAIL_SEND_ERR).
+		Update(map[string]interface{}{"status": model.MAIL_SEND_DEAD})
+}
+
+// mailBackoff is the wait after the failed attempt n, doubled on each attempt up to maxBackoffSeconds
+func mailBackoff(cfg *config.MailQueueJob, n int) time.Duration {
+	backoff := time.Duration(cfg.BackoffSeconds) * time.Second
+	max := time.Duration(cfg.MaxBackoffSeconds) * time.Second
+	for i := 1; i < n && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// Resend queues mails again with a fresh number of attempts, the ones being delivered and the ones whose
+// contents were never logged are skipped
+func (service *MailQueueService) Resend(ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, errors.New("no mail selected")
+	}
+	return service.engine.Table(&model.MailLogs{}).In("id", ids).
+		In("status", model.MAIL_SEND_OK, model.MAIL_SEND_ERR, model.MAIL_SEND_DEAD).
+		Where("contents != ''").
+		Update(map[string]interface{}{
+			"status":          model.MAIL_SEND_QUEUED,
+			"attempts":        0,
+			"next_attempt_at": time.Now().Format(config.TimeFormat),
+		})
+}
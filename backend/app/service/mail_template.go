@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"regexp"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/db"
+	"strings"
+	texttemplate "text/template"
+)
+
+// MailTemplateVar is a variable the templates of a type can use, Sample fills the previews
+type MailTemplateVar struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Sample      string `json:"sample"`
+}
+
+var verifyCodeVars = []MailTemplateVar{
+	{Name: "username", Description: "Name of the user", Sample: "Alice"},
+	{Name: "code", Description: "Verification code", Sample: "X7K2QD"},
+	{Name: "expired", Description: "Minutes the code is valid", Sample: "10"},
+}
+
+// MailTemplateVars are the variables of each template type
+var MailTemplateVars = map[int][]MailTemplateVar{
+	model.MAIL_TPL_TYPE_LOGIN_VERIFY:    verifyCodeVars,
+	model.MAIL_TPL_TYPE_REGISTER_VERIFY: verifyCodeVars,
+	model.MAIL_TPL_TYPE_OTHER: {
+		{Name: "username", Description: "Name of the user", Sample: "Alice"},
+		{Name: "email", Description: "Email address of the user", Sample: "alice@example.com"},
+	},
+	model.MAIL_TPL_TYPE_ALARM: {
+		{Name: "device", Description: "Hostname and id of the device", Sample: "office-pc (123456789)"},
+		{Name: "rustdesk_id", Description: "RustDesk id of the device", Sample: "123456789"},
+		{Name: "type", Description: "Alarm type", Sample: "Brute force"},
+		{Name: "severity", Description: "Alarm severity", Sample: "high"},
+		{Name: "ip", Description: "Remote IP address", Sample: "203.0.113.7"},
+		{Name: "peer_id", Description: "RustDesk id of the remote peer", Sample: "987654321"},
+		{Name: "peer_name", Description: "Name of the remote peer", Sample: "unknown"},
+		{Name: "time", Description: "Time of the alarm", Sample: "2024-01-02 15:04:05"},
+	},
+	model.MAIL_TPL_TYPE_DOWNLOAD_LINK: {
+		{Name: "link", Description: "Signed download link", Sample: "https://rd.example.com/api/download/windows?expires=1700000000&sig=abc"},
+		{Name: "file", Description: "Name of the download", Sample: "rustdesk-1.3.2-x86_64.exe"},
+		{Name: "expires", Description: "Time the link expires", Sample: "2024-01-05 15:04:05"},
+	},
+}
+
+// defaultMailTemplates are used for a type until a template of it is created
+var defaultMailTemplates = map[int]model.MailTemplate{
+	model.MAIL_TPL_TYPE_LOGIN_VERIFY: {
+		Subject:  "[RustDesk] Login verification code",
+		Contents: `<p>Hello {{.username}},</p><p>Your login verification code is <b>{{.code}}</b>, it expires in {{.expired}} minutes.</p>`,
+	},
+	model.MAIL_TPL_TYPE_REGISTER_VERIFY: {
+		Subject:  "[RustDesk] Registration verification code",
+		Contents: `<p>Hello {{.username}},</p><p>Your registration verification code is <b>{{.code}}</b>, it expires in {{.expired}} minutes.</p>`,
+	},
+	model.MAIL_TPL_TYPE_ALARM: {
+		Subject: "[RustDesk] {{.type}} on {{.device}}",
+		Contents: `<p>RustDesk reported a <b>{{.severity}}</b> severity security alarm.</p>
+<ul>
+<li>Alarm: {{.type}}</li>
+<li>Device: {{.device}}</li>
+<li>Remote IP: {{.ip}}</li>
+<li>Remote ID: {{.peer_id}} {{.peer_name}}</li>
+<li>Time: {{.time}}</li>
+</ul>
+<p>Acknowledge it in the admin console.</p>`,
+	},
+	model.MAIL_TPL_TYPE_DOWNLOAD_LINK: {
+		Subject: "[RustDesk] Download {{.file}}",
+		Contents: `<p>Your RustDesk download is ready: <a href="{{.link}}">{{.file}}</a></p>
+<p>The link works until {{.expires}}.</p>`,
+	},
+}
+
+// MailContent is a rendered mail, Text is generated from Html when it is empty
+type MailContent struct {
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// legacyMailVar is a placeholder of the templates written before html/template, {$code} is {{.code}}
+var legacyMailVar = regexp.MustCompile(`\{\$([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func mailTemplateSource(s string) string {
+	return legacyMailVar.ReplaceAllString(s, "{{.$1}}")
+}
+
+// RenderMailTemplate renders a template with vars, the variables vars does not set are empty
+func RenderMailTemplate(tpl *model.MailTemplate, vars map[string]string) (*MailContent, error) {
+	return renderMailTemplate(tpl, vars, "missingkey=zero")
+}
+
+// ValidateMailTemplate renders a template with the sample values of its type, it fails on a syntax error and on a
+// variable the type does not declare
+func ValidateMailTemplate(tpl *model.MailTemplate) error {
+	if _, ok := MailTemplateVars[tpl.Type]; !ok {
+		return fmt.Errorf("unknown mail template type %d", tpl.Type)
+	}
+	_, err := renderMailTemplate(tpl, MailTemplateSamples(tpl.Type), "missingkey=error")
+	return err
+}
+
+// MailTemplateSamples are the sample values of the variables of a type
+func MailTemplateSamples(tplType int) map[string]string {
+	samples := make(map[string]string)
+	for _, v := range MailTemplateVars[tplType] {
+		samples[v.Name] = v.Sample
+	}
+	return samples
+}
+
+func renderMailTemplate(tpl *model.MailTemplate, vars map[string]string, missingKey string) (*MailContent, error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	renderText := func(name, src string) (string, error) {
+		t, err := texttemplate.New(name).Option(missingKey).Parse(mailTemplateSource(src))
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		err = t.Execute(&buf, vars)
+		return buf.String(), err
+	}
+
+	subject, err := renderText("subject", tpl.Subject)
+	if err != nil {
+		return nil, err
+	}
+	t, err := htmltemplate.New("contents").Option(missingKey).Parse(mailTemplateSource(tpl.Contents))
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err = t.Execute(&body, vars); err != nil {
+		return nil, err
+	}
+	content := &MailContent{
+		// a variable must not add headers through the subject
+		Subject: strings.Join(strings.Fields(subject), " "),
+		Html:    body.String(),
+	}
+	if tpl.TextContents != "" {
+		if content.Text, err = renderText("text_contents", tpl.TextContents); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+var (
+	htmlLinks  = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// HtmlToText is the plain text part of a mail without one, the links keep their address
+func HtmlToText(s string) string {
+	s = htmlLinks.ReplaceAllString(s, "$2 ($1)")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTags.ReplaceAllString(s, ""))
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// NormalizeLanguage returns a language tag like zh-cn for zh_CN, empty is the default variant
+func NormalizeLanguage(language string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+}
+
+// Template returns the template of tplType for language: the variant of the language, then of its base language
+// (zh for zh-cn), then the default variant and at last the built-in template of the type
+func (service *MailService) Template(tplType int, language string) (*model.MailTemplate, error) {
+	tpls := make([]model.MailTemplate, 0)
+	if err := db.DbEngine.Where("type = ?", tplType).Desc("id").Find(&tpls); err != nil {
+		return nil, err
+	}
+	language = NormalizeLanguage(language)
+	base, _, _ := strings.Cut(language, "-")
+	for _, want := range []string{language, base, ""} {
+		for i := range tpls {
+			if NormalizeLanguage(tpls[i].Language) == want {
+				return &tpls[i], nil
+			}
+		}
+	}
+	if len(tpls) > 0 {
+		// only variants in other languages
+		return &tpls[0], nil
+	}
+	if tpl, ok := defaultMailTemplates[tplType]; ok {
+		tpl.Type = tplType
+		return &tpl, nil
+	}
+	return nil, fmt.Errorf("no mail template of type %d", tplType)
+}
+
+// userLanguage is the mail language of a user, empty for the addresses without a user
+func userLanguage(userId int) string {
+	if userId <= 0 {
+		return ""
+	}
+	var user model.User
+	if _, err := db.DbEngine.ID(userId).Cols("language").Get(&user); err != nil {
+		return ""
+	}
+	return user.Language
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/util"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"xorm.io/xorm"
+)
+
+// ReportRow sums up the sessions of one user or device, the durations are seconds of the closed sessions
+type ReportRow struct {
+	Key           string `json:"key"`
+	Name          string `json:"name"`
+	Sessions      int64  `json:"sessions"`
+	TotalDuration int64  `json:"total_duration"`
+	AvgDuration   int64  `json:"avg_duration"`
+}
+
+type AuditReport struct {
+	From         time.Time            `json:"from"`
+	To           time.Time            `json:"to"`
+	Sessions     int64                `json:"sessions"`
+	AvgDuration  int64                `json:"avg_duration"`
+	Alarms       int64                `json:"alarms"`
+	ByUser       []ReportRow          `json:"by_user"`
+	ByDevice     []ReportRow          `json:"by_device"`
+	TopTransfers []model.FileTransfer `json:"top_transfers"`
+}
+
+type ReportService struct {
+	engine *xorm.Engine
+}
+
+func NewReportService() *ReportService {
+	return &ReportService{
+		engine: db.DbEngine,
+	}
+}
+
+type reportAggregate struct {
+	Key           string `xorm:"key_col"`
+	Sessions      int64  `xorm:"sessions"`
+	Closed        int64  `xorm:"closed"`
+	TotalDuration int64  `xorm:"total_duration"`
+}
+
+func (a reportAggregate) row(name string) ReportRow {
+	row := ReportRow{Key: a.Key, Name: name, Sessions: a.Sessions, TotalDuration: a.TotalDuration}
+	if a.Closed > 0 {
+		row.AvgDuration = a.TotalDuration / a.Closed
+	}
+	return row
+}
+
+// aggregate groups the sessions started in [from, to) by column
+func (service *ReportService) aggregate(column string, from, to time.Time) ([]reportAggregate, error) {
+	rows := make([]reportAggregate, 0)
+	err := service.engine.SQL(`
+		SELECT `+column+` AS key_col, COUNT(*) AS sessions,
+			SUM(CASE WHEN close_reason != '' THEN 1 ELSE 0 END) AS closed,
+			COALESCE(SUM(duration), 0) AS total_duration
+		FROM audit
+		WHERE created_at >= ? AND created_at < ?
+		GROUP BY `+column+`
+		ORDER BY sessions DESC`, from.Format(config.TimeFormat), to.Format(config.TimeFormat)).Find(&rows)
+	return rows, err
+}
+
+// AuditReport sums up the sessions, file transfers and alarms of [from, to), topTransfers limits the transfer list
+func (service *ReportService) AuditReport(from, to time.Time, topTransfers int) (*AuditReport, error) {
+	report := &AuditReport{From: from, To: to, ByUser: make([]ReportRow, 0), ByDevice: make([]ReportRow, 0)}
+
+	users, err := service.aggregate("user_id", from, to)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make(map[string]string)
+	list := make([]model.User, 0)
+	if err = service.engine.Cols("id", "username").Find(&list); err != nil {
+		return nil, err
+	}
+	for _, u := range list {
+		usernames[fmt.Sprint(u.Id)] = u.Username
+	}
+
+	var total reportAggregate
+	for _, u := range users {
+		name := usernames[u.Key]
+		if u.Key == "0" || u.Key == "" {
+			name = "-"
+		}
+		report.ByUser = append(report.ByUser, u.row(name))
+		total.Sessions += u.Sessions
+		total.Closed += u.Closed
+		total.TotalDuration += u.TotalDuration
+	}
+	report.Sessions = total.Sessions
+	report.AvgDuration = total.row("").AvgDuration
+
+	devices, err := service.aggregate("rustdesk_id", from, to)
+	if err != nil {
+		return nil, err
+	}
+	hostnames := make(map[string]string)
+	deviceList := make([]model.Device, 0)
+	if err = service.engine.Cols("rustdesk_id", "hostname").Find(&deviceList); err != nil {
+		return nil, err
+	}
+	for _, d := range deviceList {
+		hostnames[d.RustdeskId] = d.Hostname
+	}
+	for _, d := range devices {
+		report.ByDevice = append(report.ByDevice, d.row(hostnames[d.Key]))
+	}
+
+	report.TopTransfers = make([]model.FileTransfer, 0)
+	err = service.engine.Where("created_at >= ? AND created_at < ?", from.Format(config.TimeFormat), to.Format(config.TimeFormat)).
+		Desc("total_size").Limit(topTransfers).Find(&report.TopTransfers)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Alarms, err = service.engine.Where("created_at >= ? AND created_at < ?", from.Format(config.TimeFormat), to.Format(config.TimeFormat)).Count(&model.Alarm{})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RenderPDF renders the report with the pdf core fonts, characters outside of cp1252 are replaced
+func (report *AuditReport) RenderPDF() ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle("RustDesk audit report", true)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, 6, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, "RustDesk audit report", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, fmt.Sprintf("%s - %s", report.From.Format(config.TimeFormat), report.To.Format(config.TimeFormat)), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	summary := [][]string{
+		{"Sessions", fmt.Sprint(report.Sessions)},
+		{"Average duration", FormatDuration(report.AvgDuration)},
+		{"Alarms", fmt.Sprint(report.Alarms)},
+	}
+	for _, line := range summary {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(40, 6, line[0], "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(0, 6, line[1], "", 1, "L", false, 0, "")
+	}
+
+	table := func(title string, header []string, widths []float64, rows [][]string) {
+		pdf.Ln(6)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.CellFormat(0, 8, title, "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for i, h := range header {
+			pdf.CellFormat(widths[i], 7, h, "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 9)
+		if len(rows) == 0 {
+			pdf.CellFormat(0, 7, "No data", "1", 1, "L", false, 0, "")
+			return
+		}
+		for _, row := range rows {
+			for i, v := range row {
+				pdf.CellFormat(widths[i], 6, truncate(tr(v), widths[i], pdf), "1", 0, "L", false, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+	}
+
+	sessionRows := func(list []ReportRow) [][]string {
+		rows := make([][]string, 0, len(list))
+		for _, r := range list {
+			rows = append(rows, []string{r.Key, r.Name, fmt.Sprint(r.Sessions), FormatDuration(r.TotalDuration), FormatDuration(r.AvgDuration)})
+		}
+		return rows
+	}
+	widths := []float64{30, 60, 25, 37.5, 37.5}
+	table("Connections per user", []string{"User ID", "Username", "Sessions", "Total duration", "Average"}, widths, sessionRows(report.ByUser))
+	table("Connections per device", []string{"RustDesk ID", "Hostname", "Sessions", "Total duration", "Average"}, widths, sessionRows(report.ByDevice))
+
+	transferRows := make([][]string, 0, len(report.TopTransfers))
+	for _, t := range report.TopTransfers {
+		direction := "remote to local"
+		if t.Type == model.FILE_TRANSFER_LOCAL_TO_REMOTE {
+			direction = "local to remote"
+		}
+		transferRows = append(transferRows, []string{t.CreatedAt.Format(config.TimeFormat), t.RustdeskId, direction, t.Path, fmt.Sprint(t.FileCount), util.FormatBytes(t.TotalSize)})
+	}
+	table("Top file transfers", []string{"Time", "RustDesk ID", "Direction", "Path", "Files", "Size"}, []float64{32, 22, 25, 71, 15, 25}, transferRows)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// truncate shortens s to fit a cell of width w
+func truncate(s string, w float64, pdf *fpdf.Fpdf) string {
+	limit := w - 2
+	if pdf.GetStringWidth(s) <= limit {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > limit {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// FormatDuration formats seconds as 1h02m03s
+func FormatDuration(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// PreviousMonth returns the first second of the last month and of this month in the database time zone
+func PreviousMonth(now time.Time) (time.Time, time.Time) {
+	location, err := time.LoadLocation(config.GetServerConfig().Db.TimeZone)
+	if err == nil {
+		now = now.In(location)
+	}
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return to.AddDate(0, -1, 0), to
+}
+
+// SendMonthlyReport mails the report of the previous month to the super admins and report.emails
+func (service *ReportService) SendMonthlyReport(now time.Time) error {
+	from, to := PreviousMonth(now)
+	report, err := service.AuditReport(from, to, 20)
+	if err != nil {
+		return err
+	}
+	data, err := report.RenderPDF()
+	if err != nil {
+		return err
+	}
+
+	recipients := adminRecipients(NewSettingsService().GetString(SETTING_REPORT_EMAILS))
+	if len(recipients) == 0 {
+		return fmt.Errorf("the monthly report has no recipient, add an email to a super admin or set %s", SETTING_REPORT_EMAILS)
+	}
+
+	month := from.Format("2006-01")
+	subject := "[RustDesk] Audit report " + month
+	body := fmt.Sprintf("<p>The audit report of %s is attached: %d sessions, average duration %s, %d alarms.</p>",
+		month, report.Sessions, FormatDuration(report.AvgDuration), report.Alarms)
+	var lastErr error
+	for email, userId := range recipients {
+		err = NewMailService().SendContent(userId, 0, email, util.GetUUID(), &MailContent{Subject: subject, Html: body}, &mail.File{
+			Name:     "audit-report-" + month + ".pdf",
+			MimeType: "application/pdf",
+			Data:     data,
+		})
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
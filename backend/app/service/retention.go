@@ -0,0 +1,312 @@
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"strings"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// retentionPause is the gap between two delete batches, so client reports get the sqlite write lock in between
+const retentionPause = 50 * time.Millisecond
+
+type retentionTable struct {
+	Table      string
+	TimeColumn string
+	Where      string // rows that must be kept whatever their age
+	Child      string // table whose rows belong to a row of Table
+	ChildKey   string
+	Ref        string // table whose rows point to a row of Table but are kept, the reference is reset to 0
+	RefKey     string
+	Policy     func(*config.Retention) *config.RetentionPolicy
+}
+
+var retentionTables = []retentionTable{
+	{
+		Table:      "audit",
+		TimeColumn: "created_at",
+		Where:      "NOT " + openAuditCond,
+		Ref:        "file_transfer",
+		RefKey:     "audit_id",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.Audit },
+	},
+	{
+		Table:      "file_transfer",
+		TimeColumn: "created_at",
+		Child:      "file_transfer_item",
+		ChildKey:   "file_transfer_id",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.FileTransfer },
+	},
+	{
+		Table:      "alarm",
+		TimeColumn: "created_at",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.Alarm },
+	},
+	{
+		Table:      "mail_logs",
+		TimeColumn: "created_at",
+		Where:      "NOT " + pendingMailCond,
+		Child:      "mail_attachment",
+		ChildKey:   "mail_log_id",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.MailLogs },
+	},
+	{
+		Table:      "verify_code",
+		TimeColumn: "created_at",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.VerifyCode },
+	},
+	{
+		Table:      "auth_token",
+		TimeColumn: "expired",
+		Policy:     func(r *config.Retention) *config.RetentionPolicy { return r.AuthToken },
+	},
+}
+
+// PruneResult reports one table, Rows are the deleted rows or the rows a dry run would delete
+type PruneResult struct {
+	Table        string   `json:"table"`
+	Days         int      `json:"days"`
+	Archive      bool     `json:"archive"`
+	Before       string   `json:"before"`
+	Rows         int64    `json:"rows"`
+	ChildRows    int64    `json:"childRows,omitempty"`
+	ArchiveFiles []string `json:"archiveFiles,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type RetentionService struct {
+	engine *xorm.Engine
+}
+
+// pruneMu keeps the scheduled job, the cli and the admin console from pruning at the same time
+var pruneMu sync.Mutex
+
+func NewRetentionService() *RetentionService {
+	return &RetentionService{
+		engine: db.DbEngine,
+	}
+}
+
+// Prune applies the retention policies of server.yaml, a dry run only counts the rows.
+// Tables without a policy (0 days) are skipped, an error in one table does not stop the others.
+func (service *RetentionService) Prune(dryRun bool) ([]PruneResult, error) {
+	retention := config.GetServerConfig().Retention
+	if retention == nil {
+		return []PruneResult{}, nil
+	}
+	if !dryRun {
+		if !pruneMu.TryLock() {
+			return nil, errors.New("pruning is already running")
+		}
+		defer pruneMu.Unlock()
+	}
+
+	results := make([]PruneResult, 0)
+	for _, t := range retentionTables {
+		policy := t.Policy(retention)
+		if policy == nil || policy.Days <= 0 {
+			continue
+		}
+		result := PruneResult{
+			Table:   t.Table,
+			Days:    policy.Days,
+			Archive: policy.Archive,
+			Before:  time.Now().AddDate(0, 0, -policy.Days).Format(config.TimeFormat),
+		}
+		var err error
+		if dryRun {
+			err = service.count(t, &result)
+		} else {
+			err = service.prune(t, retention, &result)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (service *RetentionService) cond(t retentionTable) string {
+	cond := t.TimeColumn + " < ?"
+	if t.Where != "" {
+		cond += " AND " + t.Where
+	}
+	return cond
+}
+
+func (service *RetentionService) count(t retentionTable, result *PruneResult) error {
+	rows, err := service.engine.Table(t.Table).Where(service.cond(t), result.Before).Count()
+	if err != nil {
+		return err
+	}
+	result.Rows = rows
+	if t.Child != "" {
+		result.ChildRows, err = service.engine.Table(t.Child).
+			Where(t.ChildKey+" IN (SELECT id FROM "+service.engine.Quote(t.Table)+" WHERE "+service.cond(t)+")", result.Before).Count()
+	}
+	return err
+}
+
+// prune deletes in batches of retention.batchSize, each batch is archived first and deleted in its own transaction
+func (service *RetentionService) prune(t retentionTable, retention *config.Retention, result *PruneResult) error {
+	var archive, childArchive *ndjsonArchive
+	defer func() {
+		for _, a := range []*ndjsonArchive{archive, childArchive} {
+			if a != nil {
+				_ = a.Close()
+			}
+		}
+	}()
+
+	batchSize := retention.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	for {
+		rows, err := service.engine.Table(t.Table).Where(service.cond(t), result.Before).Asc("id").Limit(batchSize).QueryInterface()
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row["id"])
+		}
+
+		var childRows []map[string]interface{}
+		if t.Child != "" && result.Archive {
+			childRows, err = service.engine.Table(t.Child).In(t.ChildKey, ids...).Asc("id").QueryInterface()
+			if err != nil {
+				return err
+			}
+		}
+
+		if result.Archive {
+			if archive == nil {
+				if archive, err = newNdjsonArchive(retention.ArchiveDir, t.Table); err != nil {
+					return err
+				}
+				result.ArchiveFiles = append(result.ArchiveFiles, archive.File)
+			}
+			if err = archive.Write(rows); err != nil {
+				return err
+			}
+			if len(childRows) > 0 {
+				if childArchive == nil {
+					if childArchive, err = newNdjsonArchive(retention.ArchiveDir, t.Child); err != nil {
+						return err
+					}
+					result.ArchiveFiles = append(result.ArchiveFiles, childArchive.File)
+				}
+				if err = childArchive.Write(childRows); err != nil {
+					return err
+				}
+			}
+		}
+
+		var deleted, childDeleted int64
+		_, err = service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+			if t.Child != "" {
+				res, err := session.Exec(append([]interface{}{"DELETE FROM " + service.engine.Quote(t.Child) + " WHERE " + t.ChildKey + " IN (" + placeholders(len(ids)) + ")"}, ids...)...)
+				if err != nil {
+					return nil, err
+				}
+				childDeleted, _ = res.RowsAffected()
+			}
+			if t.Ref != "" {
+				_, err := session.Exec(append([]interface{}{"UPDATE " + service.engine.Quote(t.Ref) + " SET " + t.RefKey + " = 0 WHERE " + t.RefKey + " IN (" + placeholders(len(ids)) + ")"}, ids...)...)
+				if err != nil {
+					return nil, err
+				}
+			}
+			res, err := session.Exec(append([]interface{}{"DELETE FROM " + service.engine.Quote(t.Table) + " WHERE id IN (" + placeholders(len(ids)) + ")"}, ids...)...)
+			if err != nil {
+				return nil, err
+			}
+			deleted, _ = res.RowsAffected()
+			return nil, nil
+		})
+		if err != nil {
+			return err
+		}
+		result.Rows += deleted
+		result.ChildRows += childDeleted
+
+		if len(rows) < batchSize {
+			return nil
+		}
+		time.Sleep(retentionPause)
+	}
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// Vacuum gives the pages freed by pruning back to the filesystem, only sqlite needs it
+func (service *RetentionService) Vacuum() error {
+	if config.GetServerConfig().Db.Driver != "sqlite" {
+		return nil
+	}
+	_, err := service.engine.Exec("VACUUM")
+	return err
+}
+
+// ndjsonArchive writes one json object per line to a gzip file
+type ndjsonArchive struct {
+	File    string
+	file    *os.File
+	gz      *gzip.Writer
+	encoder *json.Encoder
+}
+
+func newNdjsonArchive(dir, table string) (*ndjsonArchive, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.ndjson.gz", table, time.Now().Format("20060102-150405")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	return &ndjsonArchive{File: name, file: file, gz: gz, encoder: json.NewEncoder(gz)}, nil
+}
+
+// Write appends rows and syncs the file, the rows are only deleted once they are on disk
+func (a *ndjsonArchive) Write(rows []map[string]interface{}) error {
+	for _, row := range rows {
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		if err := a.encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *ndjsonArchive) Close() error {
+	if err := a.gz.Close(); err != nil {
+		_ = a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
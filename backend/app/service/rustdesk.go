@@ -0,0 +1,348 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/github"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	rustdeskSupervisor   *rustdesk.Supervisor
+	rustdeskSupervisorMu sync.RWMutex
+)
+
+// SetRustdeskSupervisor registers the supervisor of hbbs and hbbr, the lifecycle sets it when `start` supervises them
+func SetRustdeskSupervisor(s *rustdesk.Supervisor) {
+	rustdeskSupervisorMu.Lock()
+	rustdeskSupervisor = s
+	rustdeskSupervisorMu.Unlock()
+}
+
+// RustdeskSupervisor returns nil when hbbs and hbbr are not supervised by this server
+func RustdeskSupervisor() *rustdesk.Supervisor {
+	rustdeskSupervisorMu.RLock()
+	defer rustdeskSupervisorMu.RUnlock()
+	return rustdeskSupervisor
+}
+
+// ConfigureRustdesk points the rustdesk helpers at the bin dir and the releases api of the rustdesk section
+func ConfigureRustdesk(cfg *config.Rustdesk) {
+	rustdesk.SetServerBinDir(cfg.BinDir)
+	if cfg.ReleasesUrl != "" {
+		github.ApiBaseUrl = strings.TrimSuffix(cfg.ReleasesUrl, "/")
+	}
+	github.SetReleasesCache(cfg.BinDir, time.Duration(cfg.ReleasesCacheMinutes)*time.Minute)
+}
+
+// RustdeskProcesses turns the rustdesk section of server.yaml into the hbbs and hbbr command lines
+func RustdeskProcesses(cfg *config.Rustdesk) []rustdesk.ProcessOptions {
+	dir, _ := filepath.Abs(cfg.BinDir)
+	hbbr, hbbs := rustdesk.BinIn(dir)
+
+	hbbsArgs := make([]string, 0)
+	if cfg.HbbsPort > 0 {
+		hbbsArgs = append(hbbsArgs, "-p", strconv.Itoa(cfg.HbbsPort))
+	}
+	if len(cfg.RelayServers) > 0 {
+		hbbsArgs = append(hbbsArgs, "-r", strings.Join(cfg.RelayServers, ","))
+	}
+	hbbrArgs := make([]string, 0)
+	if cfg.HbbrPort > 0 {
+		hbbrArgs = append(hbbrArgs, "-p", strconv.Itoa(cfg.HbbrPort))
+	}
+	if cfg.Key != "" {
+		hbbsArgs = append(hbbsArgs, "-k", cfg.Key)
+		hbbrArgs = append(hbbrArgs, "-k", cfg.Key)
+	}
+	hbbsArgs = append(hbbsArgs, cfg.HbbsArgs...)
+	hbbrArgs = append(hbbrArgs, cfg.HbbrArgs...)
+
+	process := func(name, bin string, args []string) rustdesk.ProcessOptions {
+		opts := rustdesk.ProcessOptions{
+			Name:          name,
+			Bin:           bin,
+			Dir:           dir,
+			Args:          args,
+			PidFile:       filepath.Join(dir, name+".pid"),
+			LogMaxSizeMB:  cfg.LogMaxSizeMB,
+			LogMaxBackups: cfg.LogBackups,
+			MaxBackoff:    time.Duration(cfg.MaxBackoff) * time.Second,
+		}
+		if cfg.LogDir != "" {
+			opts.LogFile = filepath.Join(cfg.LogDir, name+".log")
+		}
+		return opts
+	}
+	return []rustdesk.ProcessOptions{process("hbbr", hbbr, hbbrArgs), process("hbbs", hbbs, hbbsArgs)}
+}
+
+// upgradeCheckDelay is how long the upgraded hbbs and hbbr must keep running before the upgrade is kept
+var upgradeCheckDelay = 5 * time.Second
+
+// SetUpgradeCheckDelay shortens the upgrade check in tests
+func SetUpgradeCheckDelay(d time.Duration) {
+	upgradeCheckDelay = d
+}
+
+var ErrRustdeskUpgrading = errors.New("an upgrade of rustdesk-server is already running")
+
+// upgradeMu also keeps start, stop and restart out of a running upgrade
+var upgradeMu sync.Mutex
+
+type RustdeskService struct{}
+
+func NewRustdeskService() *RustdeskService {
+	return &RustdeskService{}
+}
+
+type RustdeskRelease struct {
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	PublishedAt string `json:"publishedAt"`
+	Prerelease  bool   `json:"prerelease"`
+	Asset       string `json:"asset"` // zip of this system, empty when the release has none
+	Size        int    `json:"size"`
+	Digest      string `json:"digest,omitempty"`
+}
+
+type RustdeskUpgrade struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Asset    string `json:"asset"`
+	Sha256   string `json:"sha256"`
+	Verified bool   `json:"verified"` // hbbs and hbbr were running and kept running with the new binaries
+}
+
+// Status reports hbbs and hbbr, with the pid, uptime and restarts when this server supervises them
+func (service *RustdeskService) Status() map[string]interface{} {
+	status := map[string]interface{}{
+		"binDir":  rustdesk.GetRustdeskServerBinDir(),
+		"version": rustdesk.InstalledVersion(),
+	}
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		status["supervised"] = true
+		status["processes"] = supervisor.Status()
+		return status
+	}
+	s := RustdeskStatus()
+	status["supervised"] = false
+	status["installed"] = s != nil
+	status["status"] = s
+	return status
+}
+
+func (service *RustdeskService) Start() error {
+	if !upgradeMu.TryLock() {
+		return ErrRustdeskUpgrading
+	}
+	defer upgradeMu.Unlock()
+	return service.start()
+}
+
+func (service *RustdeskService) Stop() error {
+	if !upgradeMu.TryLock() {
+		return ErrRustdeskUpgrading
+	}
+	defer upgradeMu.Unlock()
+	service.stop()
+	return nil
+}
+
+func (service *RustdeskService) Restart() error {
+	if !upgradeMu.TryLock() {
+		return ErrRustdeskUpgrading
+	}
+	defer upgradeMu.Unlock()
+	service.stop()
+	return service.start()
+}
+
+func (service *RustdeskService) start() error {
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		supervisor.Start()
+		return nil
+	}
+	hbbr, hbbs := rustdesk.GetRustdeskServerBin()
+	if hbbr == "" {
+		return errors.New("rustdesk-server does not support " + runtime.GOOS)
+	}
+	for _, bin := range []string{hbbr, hbbs} {
+		if _, err := os.Stat(bin); err != nil {
+			return errors.New("rustdesk-server is not installed in " + rustdesk.GetRustdeskServerBinDir())
+		}
+	}
+	if hbbrRunning, hbbsRunning := rustdesk.Status(); hbbrRunning || hbbsRunning {
+		return errors.New("rustdesk-server is already running")
+	}
+	_, err := rustdesk.StartServer()
+	return err
+}
+
+func (service *RustdeskService) stop() {
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		supervisor.Stop(shutdownTimeout())
+		return
+	}
+	rustdesk.StopServer()
+}
+
+func (service *RustdeskService) running() bool {
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		for _, p := range supervisor.Status() {
+			if !p.Running {
+				return false
+			}
+		}
+		return true
+	}
+	hbbrRunning, hbbsRunning := rustdesk.Status()
+	return hbbrRunning && hbbsRunning
+}
+
+func (service *RustdeskService) restarts() int {
+	if supervisor := RustdeskSupervisor(); supervisor != nil {
+		return supervisor.Restarts()
+	}
+	return 0
+}
+
+// Keys returns the keypair of hbbs, the private key only when asked for
+func (service *RustdeskService) Keys(private bool) map[string]string {
+	public, privateKey := rustdesk.Keys()
+	keys := map[string]string{"public": strings.TrimSpace(public)}
+	if private {
+		keys["private"] = strings.TrimSpace(privateKey)
+	}
+	return keys
+}
+
+// Releases lists the rustdesk-server releases with the zip of this system
+func (service *RustdeskService) Releases() ([]RustdeskRelease, error) {
+	releases, err := github.FetchReleases(rustdesk.REPO)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]RustdeskRelease, 0, len(releases))
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+		asset, _ := rustdesk.AssetFor(r.Assets)
+		list = append(list, RustdeskRelease{
+			Version:     r.TagName,
+			Name:        r.Name,
+			PublishedAt: r.PublishedAt,
+			Prerelease:  r.Prerelease,
+			Asset:       asset.Name,
+			Size:        asset.Size,
+			Digest:      asset.Digest,
+		})
+	}
+	return list, nil
+}
+
+// Upgrade installs a release, checksum is the sha256 of its zip and defaults to the digest published by github.
+// hbbs and hbbr are stopped while the binaries are replaced. When they were running they are started again and
+// must keep running for a few seconds, or the previous binaries are put back.
+func (service *RustdeskService) Upgrade(version, checksum string) (*RustdeskUpgrade, error) {
+	if !upgradeMu.TryLock() {
+		return nil, ErrRustdeskUpgrading
+	}
+	defer upgradeMu.Unlock()
+
+	release, err := github.FetchRelease(rustdesk.REPO, version)
+	if err != nil {
+		return nil, err
+	}
+	asset, _ := rustdesk.AssetFor(release.Assets)
+	if asset.Name == "" {
+		return nil, errors.New("release " + release.TagName + " has no rustdesk-server for " + runtime.GOOS + "/" + runtime.GOARCH)
+	}
+	if checksum == "" {
+		checksum = asset.Digest
+	}
+	if checksum == "" {
+		return nil, errors.New("github publishes no checksum for " + asset.Name + ", pass its sha256")
+	}
+
+	binDir := rustdesk.GetRustdeskServerBinDir()
+	if err = os.MkdirAll(binDir, 0755); err != nil {
+		return nil, err
+	}
+	// in binDir, so the binaries are renamed into place on the same file system
+	tmp, err := os.MkdirTemp(binDir, ".upgrade-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	zipFile := filepath.Join(tmp, asset.Name)
+	if err = rustdesk.Download(asset.BrowserDownloadURL, zipFile); err != nil {
+		return nil, err
+	}
+	if err = rustdesk.VerifySha256(zipFile, checksum); err != nil {
+		return nil, err
+	}
+	newDir := filepath.Join(tmp, "bin")
+	if err = rustdesk.ExtractBinaries(zipFile, newDir); err != nil {
+		return nil, err
+	}
+
+	result := &RustdeskUpgrade{From: rustdesk.InstalledVersion(), To: release.TagName, Asset: asset.Name}
+	result.Sha256, _ = rustdesk.Sha256File(zipFile)
+
+	wasRunning := service.running()
+	service.stop()
+	rollback, err := rustdesk.ReplaceBinaries(newDir, binDir)
+	if err != nil {
+		return nil, service.rollback(rollback, wasRunning, err)
+	}
+	if wasRunning {
+		restarts := service.restarts()
+		if err = service.start(); err == nil {
+			time.Sleep(upgradeCheckDelay)
+			if !service.running() || service.restarts() != restarts {
+				err = errors.New("hbbs or hbbr did not keep running")
+			}
+		}
+		if err != nil {
+			service.stop()
+			return nil, service.rollback(rollback, wasRunning, err)
+		}
+		result.Verified = true
+	}
+	if err = rustdesk.SetInstalledVersion(release.TagName); err != nil {
+		serviceLog.Warn("Write rustdesk-server version error", "error", err)
+	}
+	serviceLog.Info("rustdesk-server upgraded", "from", result.From, "to", result.To, "verified", result.Verified)
+	return result, nil
+}
+
+func (service *RustdeskService) rollback(rollback func() error, wasRunning bool, cause error) error {
+	serviceLog.Error("rustdesk-server upgrade failed, rolling back", "error", cause)
+	if err := rollback(); err != nil {
+		return fmt.Errorf("upgrade failed: %s, rollback failed: %s", cause, err)
+	}
+	if wasRunning {
+		if err := service.start(); err != nil {
+			return fmt.Errorf("upgrade failed: %s, rolled back but the previous binaries do not start: %s", cause, err)
+		}
+	}
+	return fmt.Errorf("upgrade failed, rolled back to the previous binaries: %s", cause)
+}
+
+func shutdownTimeout() time.Duration {
+	if cfg := config.GetServerConfig().Shutdown; cfg != nil && cfg.Timeout > 0 {
+		return time.Duration(cfg.Timeout) * time.Second
+	}
+	return 30 * time.Second
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/secret"
+	"strings"
+
+	"xorm.io/xorm"
+)
+
+// encryptedColumns lists every column that is stored with model.EncryptedString
+var encryptedColumns = []struct {
+	Table  string
+	Column string
+}{
+	{"peer", "password"},
+	{"user", "tfa_secret"},
+	{"server_key", "private_key"},
+}
+
+type SecretService struct {
+	engine *xorm.Engine
+}
+
+func NewSecretService() *SecretService {
+	return &SecretService{
+		engine: db.DbEngine,
+	}
+}
+
+// SaveGeneratedKey writes the master key that was generated because the key file did not exist. It refuses when
+// the database has encrypted values: they need the lost key, starting with a new one would make them unreadable.
+func (service *SecretService) SaveGeneratedKey() error {
+	ring := secret.Default()
+	if ring == nil || !ring.Generated {
+		return nil
+	}
+	count, err := service.countEncrypted()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("the master key file %s does not exist but %d stored secrets are encrypted, restore the file, "+
+			"set security.masterKeyFile to its path or pass the keys in %s", ring.File, count, secret.MasterKeyEnv)
+	}
+	return ring.Save()
+}
+
+// countEncrypted counts the encrypted values, the tables that do not exist yet have none
+func (service *SecretService) countEncrypted() (int64, error) {
+	var total int64
+	for _, c := range encryptedColumns {
+		exists, err := service.engine.IsTableExist(c.Table)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		count, err := service.engine.Table(c.Table).Where(service.engine.Quote(c.Column)+" LIKE ?", secret.Prefix+"%").Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// WidenColumns changes the encrypted columns that older versions created as varchar to text. Sync does not alter
+// the type of an existing column, MySQL would cut the ciphertexts; sqlite does not enforce the length.
+func (service *SecretService) WidenColumns() error {
+	if service.engine.DriverName() != "mysql" {
+		return nil
+	}
+	for _, c := range encryptedColumns {
+		var dataType string
+		has, err := service.engine.SQL("SELECT DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", c.Table, c.Column).Get(&dataType)
+		if err != nil {
+			return err
+		}
+		if !has || !strings.Contains(strings.ToLower(dataType), "char") {
+			continue
+		}
+		if _, err = service.engine.Exec("ALTER TABLE " + service.engine.Quote(c.Table) + " MODIFY " + service.engine.Quote(c.Column) + " TEXT"); err != nil {
+			return err
+		}
+		serviceLog.Info("Widened encrypted column to text", "table", c.Table, "column", c.Column, "was", dataType)
+	}
+	return nil
+}
+
+// EncryptPlaintext encrypts the values that were saved before encryption at rest existed
+func (service *SecretService) EncryptPlaintext() (int, error) {
+	return service.reencrypt(secret.Default(), func(value string) bool {
+		return !secret.IsEncrypted(value)
+	})
+}
+
+// Reencrypt re-encrypts every value that was not encrypted with the primary key of ring
+func (service *SecretService) Reencrypt(ring *secret.KeyRing) (int, error) {
+	primaryId := ring.Primary().Id
+	return service.reencrypt(ring, func(value string) bool {
+		return secret.KeyId(value) != primaryId
+	})
+}
+
+func (service *SecretService) reencrypt(ring *secret.KeyRing, match func(value string) bool) (int, error) {
+	if ring == nil {
+		return 0, secret.ErrNoKeyRing
+	}
+	// a ciphertext is longer than the varchar(255) of the older versions
+	if err := service.WidenColumns(); err != nil {
+		return 0, err
+	}
+
+	type row struct {
+		Id    int    `xorm:"id"`
+		Value string `xorm:"value"`
+	}
+
+	total := 0
+	for _, c := range encryptedColumns {
+		table := service.engine.Quote(c.Table)
+		column := service.engine.Quote(c.Column)
+
+		lastId := 0
+		for {
+			rows := make([]row, 0)
+			err := service.engine.SQL("SELECT id, "+column+" AS value FROM "+table+" WHERE id > ? AND "+column+" IS NOT NULL AND "+column+" != '' ORDER BY id ASC LIMIT 500", lastId).Find(&rows)
+			if err != nil {
+				return total, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+
+			for _, r := range rows {
+				lastId = r.Id
+				if !match(r.Value) {
+					continue
+				}
+				plaintext, err := ring.Decrypt(r.Value)
+				if err != nil {
+					return total, err
+				}
+				value, err := ring.Encrypt(plaintext)
+				if err != nil {
+					return total, err
+				}
+				_, err = service.engine.Exec("UPDATE "+table+" SET "+column+" = ? WHERE id = ?", value, r.Id)
+				if err != nil {
+					return total, err
+				}
+				total++
+			}
+		}
+	}
+	return total, nil
+}
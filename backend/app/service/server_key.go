@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strings"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const JOB_SERVER_KEY_ROTATION = "server_key_rotation"
+
+// SERVER_KEY_ROTATION_WARNING is returned with every rotation
+const SERVER_KEY_ROTATION_WARNING = "Clients that use the server key (-k or the key field of their network settings) " +
+	"reject the new key until they are updated. The device list only covers devices whose deployment agent reports " +
+	"the key, the stock rustdesk client never does, so an empty list does not mean no client is affected."
+
+// SERVER_KEY_DEVICES_NOTE goes with every device list of the keys, %d is the number of devices without a reported key
+const SERVER_KEY_DEVICES_NOTE = "Only devices whose deployment agent reports the server key are listed. " +
+	"%d devices never reported a key and are not checked."
+
+// serverKeyMu keeps two rotations, or a rotation and the activation job, apart
+var serverKeyMu sync.Mutex
+
+type ServerKeyService struct {
+	engine *xorm.Engine
+}
+
+func NewServerKeyService() *ServerKeyService {
+	return &ServerKeyService{
+		engine: db.DbEngine,
+	}
+}
+
+// Active returns the key hbbs uses. It only reads: a keypair hbbs generated itself or put in place by hand is
+// returned from the files and recorded by SyncFiles.
+func (service *ServerKeyService) Active() (*model.ServerKey, error) {
+	active, err := service.recorded()
+	if err != nil {
+		return nil, err
+	}
+	if key := fileKey(); key != nil && (active == nil || active.PublicKey != key.PublicKey) {
+		return key, nil
+	}
+	return active, nil
+}
+
+// SyncFiles records the keypair of the files when it is not the active key, at startup and by the rotation job
+func (service *ServerKeyService) SyncFiles() (*model.ServerKey, error) {
+	serverKeyMu.Lock()
+	defer serverKeyMu.Unlock()
+	return service.syncFiles()
+}
+
+func (service *ServerKeyService) syncFiles() (*model.ServerKey, error) {
+	active, err := service.recorded()
+	if err != nil {
+		return nil, err
+	}
+	key := fileKey()
+	if key == nil || (active != nil && active.PublicKey == key.PublicKey) {
+		return active, nil
+	}
+
+	// the files changed outside the api server
+	_, err = service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := retireActive(session); err != nil {
+			return nil, err
+		}
+		return session.Insert(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	serviceLog.Info("Server key of hbbs recorded", "public_key", key.PublicKey)
+	return key, nil
+}
+
+// recorded returns the active key of the server_key table, nil when there is none
+func (service *ServerKeyService) recorded() (*model.ServerKey, error) {
+	active := &model.ServerKey{}
+	has, err := service.engine.Where("status = ?", model.SERVER_KEY_ACTIVE).Desc("id").Get(active)
+	if err != nil || !has {
+		return nil, err
+	}
+	return active, nil
+}
+
+// fileKey is the keypair hbbs loads, nil when it has none yet
+func fileKey() *model.ServerKey {
+	public, private := rustdesk.Keys()
+	public, private = strings.TrimSpace(public), strings.TrimSpace(private)
+	if public == "" {
+		return nil
+	}
+	return &model.ServerKey{
+		PublicKey:   public,
+		PrivateKey:  model.EncryptedString(private),
+		Status:      model.SERVER_KEY_ACTIVE,
+		Source:      model.SERVER_KEY_SOURCE_HBBS,
+		ActivatedAt: time.Now(),
+	}
+}
+
+// Pending returns the key waiting for the end of its grace period, nil when there is none
+func (service *ServerKeyService) Pending() (*model.ServerKey, error) {
+	key := &model.ServerKey{}
+	has, err := service.engine.Where("status = ?", model.SERVER_KEY_PENDING).Desc("id").Get(key)
+	if err != nil || !has {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (service *ServerKeyService) History() ([]model.ServerKey, error) {
+	keys := make([]model.ServerKey, 0)
+	err := service.engine.Omit("private_key").Desc("id").Find(&keys)
+	return keys, err
+}
+
+// Rotate replaces the key of hbbs with a new one, or with privateKey when it is set. With a grace period the
+// new key is published as the next key first and put in place by the rotation job when the period ends.
+func (service *ServerKeyService) Rotate(privateKey string, grace time.Duration, userId int) (*model.ServerKey, error) {
+	source := model.SERVER_KEY_SOURCE_IMPORTED
+	var public, private string
+	var err error
+	if privateKey != "" {
+		public, private, err = rustdesk.ParsePrivateKey(privateKey)
+	} else {
+		source = model.SERVER_KEY_SOURCE_GENERATED
+		public, private, err = rustdesk.GenerateKeyPair()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serverKeyMu.Lock()
+	defer serverKeyMu.Unlock()
+	active, err := service.syncFiles()
+	if err != nil {
+		return nil, err
+	}
+	if active != nil && active.PublicKey == public {
+		return nil, errors.New("this key is already the server key")
+	}
+
+	key := &model.ServerKey{
+		PublicKey:  public,
+		PrivateKey: model.EncryptedString(private),
+		Status:     model.SERVER_KEY_PENDING,
+		Source:     source,
+		ActivateAt: time.Now().Add(grace),
+		CreatedBy:  userId,
+	}
+	_, err = service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		// a new rotation replaces the one that was waiting
+		if _, err := session.Where("status = ?", model.SERVER_KEY_PENDING).Delete(&model.ServerKey{}); err != nil {
+			return nil, err
+		}
+		return session.Insert(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if grace > 0 {
+		serviceLog.Info("Server key rotation scheduled", "public_key", public, "activate_at", key.ActivateAt)
+		return key, nil
+	}
+	return key, service.activate(key)
+}
+
+// CancelPending drops the key waiting for its grace period
+func (service *ServerKeyService) CancelPending() (bool, error) {
+	serverKeyMu.Lock()
+	defer serverKeyMu.Unlock()
+	count, err := service.engine.Where("status = ?", model.SERVER_KEY_PENDING).Delete(&model.ServerKey{})
+	return count > 0, err
+}
+
+// ActivateDue puts the pending key in place once its grace period ended, the rotation job runs it. The keypair
+// hbbs generated since the last run is recorded first.
+func (service *ServerKeyService) ActivateDue() (*model.ServerKey, error) {
+	serverKeyMu.Lock()
+	defer serverKeyMu.Unlock()
+	if _, err := service.syncFiles(); err != nil {
+		return nil, err
+	}
+	key, err := service.Pending()
+	if err != nil || key == nil || key.ActivateAt.After(time.Now()) {
+		return nil, err
+	}
+	return key, service.activate(key)
+}
+
+// activate writes the key for hbbs and restarts it when it runs
+func (service *ServerKeyService) activate(key *model.ServerKey) error {
+	if err := rustdesk.WriteKeys(key.PublicKey, key.PrivateKey.String()); err != nil {
+		return err
+	}
+	key.Status = model.SERVER_KEY_ACTIVE
+	key.ActivatedAt = time.Now()
+	_, err := service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := retireActive(session); err != nil {
+			return nil, err
+		}
+		return session.ID(key.Id).Cols("status", "activated_at").Update(key)
+	})
+	if err != nil {
+		return err
+	}
+	serviceLog.Warn("Server key rotated, clients using the previous key must be updated", "public_key", key.PublicKey)
+
+	rs := NewRustdeskService()
+	if hbbrRunning, hbbsRunning := rustdesk.Status(); RustdeskSupervisor() != nil || hbbrRunning || hbbsRunning {
+		if err = rs.Restart(); err != nil {
+			return errors.New("the key is in place but hbbs did not restart: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func retireActive(session *xorm.Session) (int64, error) {
+	return session.Where("status = ?", model.SERVER_KEY_ACTIVE).Cols("status", "retired_at").
+		Update(&model.ServerKey{Status: model.SERVER_KEY_RETIRED, RetiredAt: time.Now()})
+}
+
+// OutdatedDevices lists the devices whose last reported key is not the active key. The rustdesk client does not
+// report its key, only the devices of a deployment agent (sysinfo key, client-config with id and uuid) are listed.
+func (service *ServerKeyService) OutdatedDevices() ([]model.Device, error) {
+	devices := make([]model.Device, 0)
+	active, err := service.Active()
+	if err != nil || active == nil {
+		return devices, err
+	}
+	err = service.engine.Where("server_key <> '' AND server_key <> ?", active.PublicKey).Desc("last_seen_at").Find(&devices)
+	return devices, err
+}
+
+// UnreportedDevices counts the devices that never reported a key, the key lists can not tell whether they are affected
+func (service *ServerKeyService) UnreportedDevices() (int64, error) {
+	return service.engine.Where("server_key = '' OR server_key IS NULL").Count(&model.Device{})
+}
+
+// DevicesWithKey lists the devices that last reported publicKey, those a rotation away from it affects
+func (service *ServerKeyService) DevicesWithKey(publicKey string) ([]model.Device, error) {
+	devices := make([]model.Device, 0)
+	err := service.engine.Where("server_key = ?", publicKey).Desc("last_seen_at").Find(&devices)
+	return devices, err
+}
+
+// ReportKey records the server key a device uses, uuid must match the device
+func (service *ServerKeyService) ReportKey(rustdeskId, uuid, publicKey string) error {
+	publicKey = strings.TrimSpace(publicKey)
+	if rustdeskId == "" || publicKey == "" || len(publicKey) > 64 {
+		return nil
+	}
+	// NoAutoTime: updated_at is when the device was last seen by the heartbeat
+	_, err := service.engine.Where("rustdesk_id = ? AND uuid = ?", rustdeskId, uuid).Cols("server_key", "server_key_at").NoAutoTime().
+		Update(&model.Device{ServerKey: publicKey, ServerKeyAt: time.Now()})
+	return err
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const (
+	SETTING_TYPE_BOOL   = "bool"
+	SETTING_TYPE_INT    = "int"
+	SETTING_TYPE_STRING = "string"
+)
+
+const (
+	SETTING_SOURCE_DB      = "db"
+	SETTING_SOURCE_YAML    = "yaml"
+	SETTING_SOURCE_DEFAULT = "default"
+)
+
+const (
+	SETTING_DEFAULT_LICENSED_DEVICES  = "user.defaultLicensedDevices"
+	SETTING_CLIENT_SESSION_DAYS       = "session.clientTokenDays"
+	SETTING_ADMIN_SESSION_HOURS       = "session.adminTokenHours"
+	SETTING_AUDIT_ORPHAN_TIMEOUT_MINS = "audit.orphanTimeoutMinutes"
+	SETTING_ALARM_NOTIFY_EMAILS       = "alarm.notifyEmails"
+	SETTING_ALARM_NOTIFY_MIN_SEVERITY = "alarm.notifyMinSeverity"
+	SETTING_ALARM_NOTIFY_COOLDOWN     = "alarm.notifyCooldownMinutes"
+	SETTING_REPORT_MONTHLY_ENABLED    = "report.monthlyEnabled"
+	SETTING_REPORT_EMAILS             = "report.emails"
+	SETTING_CLIENT_ID_SERVER          = "client.idServer"
+	SETTING_CLIENT_RELAY_SERVER       = "client.relayServer"
+	SETTING_CLIENT_API_SERVER         = "client.apiServer"
+)
+
+type SettingDef struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Min         int    `json:"min,omitempty"`
+	Max         int    `json:"max,omitempty"`
+	Description string `json:"description"`
+}
+
+// settingDefs declares every runtime setting, unknown keys are rejected. There is no registration setting, users are
+// only created by an admin and the server has no self-registration to turn on or off.
+var settingDefs = []SettingDef{
+	{
+		Key:         SETTING_DEFAULT_LICENSED_DEVICES,
+		Name:        "Default licensed devices",
+		Type:        SETTING_TYPE_INT,
+		Default:     "0",
+		Min:         0,
+		Max:         100000,
+		Description: "Licensed devices of new users when none is given, 0 is unlimited",
+	},
+	{
+		Key:         SETTING_CLIENT_SESSION_DAYS,
+		Name:        "Client session timeout (days)",
+		Type:        SETTING_TYPE_INT,
+		Default:     "90",
+		Min:         1,
+		Max:         3650,
+		Description: "Lifetime of the token a RustDesk client gets at login",
+	},
+	{
+		Key:         SETTING_ADMIN_SESSION_HOURS,
+		Name:        "Admin session timeout (hours)",
+		Type:        SETTING_TYPE_INT,
+		Default:     "2",
+		Min:         1,
+		Max:         720,
+		Description: "Lifetime of an admin console token, it is extended while the admin is active",
+	},
+	{
+		Key:         SETTING_AUDIT_ORPHAN_TIMEOUT_MINS,
+		Name:        "Orphaned audit timeout (minutes)",
+		Type:        SETTING_TYPE_INT,
+		Default:     "10",
+		Min:         1,
+		Max:         10080,
+		Description: "Open connection audits of devices offline for longer than this are closed at the last heartbeat",
+	},
+	{
+		Key:         SETTING_ALARM_NOTIFY_EMAILS,
+		Name:        "Alarm notification emails",
+		Type:        SETTING_TYPE_STRING,
+		Default:     "",
+		Description: "Comma separated addresses that are mailed on alarms, in addition to the super admins",
+	},
+	{
+		Key:         SETTING_ALARM_NOTIFY_MIN_SEVERITY,
+		Name:        "Alarm notification severity",
+		Type:        SETTING_TYPE_INT,
+		Default:     "3",
+		Min:         1,
+		Max:         4,
+		Description: "Lowest alarm severity that sends a notification, 1=low 2=medium 3=high 4=never",
+	},
+	{
+		Key:         SETTING_ALARM_NOTIFY_COOLDOWN,
+		Name:        "Alarm notification cooldown (minutes)",
+		Type:        SETTING_TYPE_INT,
+		Default:     "15",
+		Min:         0,
+		Max:         1440,
+		Description: "Repeated alarms of the same type from the same device are not notified again within this time",
+	},
+	{
+		Key:         SETTING_REPORT_MONTHLY_ENABLED,
+		Name:        "Monthly audit report",
+		Type:        SETTING_TYPE_BOOL,
+		Default:     "false",
+		Description: "Mail a PDF summary of the previous month on the 1st of every month",
+	},
+	{
+		Key:         SETTING_REPORT_EMAILS,
+		Name:        "Audit report emails",
+		Type:        SETTING_TYPE_STRING,
+		Default:     "",
+		Description: "Comma separated addresses that get the monthly report, in addition to the super admins",
+	},
+	{
+		Key:         SETTING_CLIENT_ID_SERVER,
+		Name:        "Client ID server",
+		Type:        SETTING_TYPE_STRING,
+		Default:     "",
+		Description: "Host of hbbs the clients connect to, with :port when it is not 21116. Required for the client config code",
+	},
+	{
+		Key:         SETTING_CLIENT_RELAY_SERVER,
+		Name:        "Client relay server",
+		Type:        SETTING_TYPE_STRING,
+		Default:     "",
+		Description: "Host of hbbr for the client config, empty uses the first rustdesk.relayServers or lets hbbs tell the clients",
+	},
+	{
+		Key:         SETTING_CLIENT_API_SERVER,
+		Name:        "Client API server",
+		Type:        SETTING_TYPE_STRING,
+		Default:     "",
+		Description: "Url of this api server for the client config, empty uses the url the config is requested from",
+	},
+}
+
+// settingsCacheTTL limits how long other instances keep serving a changed value
+const settingsCacheTTL = time.Minute
+
+type SettingValue struct {
+	SettingDef
+	Value  string `json:"value"`
+	Source string `json:"source"` // db yaml default
+}
+
+type SettingsService struct {
+	engine *xorm.Engine
+}
+
+var (
+	settingsCache     map[string]string
+	settingsCacheTime time.Time
+	settingsCacheMu   sync.RWMutex
+)
+
+func NewSettingsService() *SettingsService {
+	return &SettingsService{
+		engine: db.DbEngine,
+	}
+}
+
+func GetSettingDef(key string) (SettingDef, bool) {
+	for _, def := range settingDefs {
+		if def.Key == key {
+			return def, true
+		}
+	}
+	return SettingDef{}, false
+}
+
+// Validate checks value against the declared type and range of key
+func (def SettingDef) Validate(value string) error {
+	switch def.Type {
+	case SETTING_TYPE_BOOL:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", def.Key, value)
+		}
+	case SETTING_TYPE_INT:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", def.Key, value)
+		}
+		if n < def.Min || (def.Max > 0 && n > def.Max) {
+			return fmt.Errorf("%s: must be between %d and %d", def.Key, def.Min, def.Max)
+		}
+	}
+	return nil
+}
+
+// ValidateYaml checks the settings section of server.yaml
+func (service *SettingsService) ValidateYaml(cfg *config.ServerConfig) error {
+	keys := make([]string, 0, len(cfg.Settings))
+	for key := range cfg.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		def, ok := GetSettingDef(key)
+		if !ok {
+			return fmt.Errorf("settings.%s: unknown setting", key)
+		}
+		if err := def.Validate(cfg.Settings[key]); err != nil {
+			return errors.New("settings." + err.Error())
+		}
+	}
+	return nil
+}
+
+// Get returns the value of key, the database wins over server.yaml, server.yaml over the default
+func (service *SettingsService) Get(key string) SettingValue {
+	def, ok := GetSettingDef(key)
+	if !ok {
+		panic("undeclared setting " + key)
+	}
+
+	if value, ok := service.dbValues()[key]; ok && def.Validate(value) == nil {
+		return SettingValue{SettingDef: def, Value: value, Source: SETTING_SOURCE_DB}
+	}
+	if value, ok := config.GetServerConfig().Settings[key]; ok && def.Validate(value) == nil {
+		return SettingValue{SettingDef: def, Value: value, Source: SETTING_SOURCE_YAML}
+	}
+	return SettingValue{SettingDef: def, Value: def.Default, Source: SETTING_SOURCE_DEFAULT}
+}
+
+func (service *SettingsService) GetBool(key string) bool {
+	b, _ := strconv.ParseBool(service.Get(key).Value)
+	return b
+}
+
+func (service *SettingsService) GetInt(key string) int {
+	n, _ := strconv.Atoi(service.Get(key).Value)
+	return n
+}
+
+func (service *SettingsService) GetString(key string) string {
+	return service.Get(key).Value
+}
+
+func (service *SettingsService) List() []SettingValue {
+	list := make([]SettingValue, 0, len(settingDefs))
+	for _, def := range settingDefs {
+		list = append(list, service.Get(def.Key))
+	}
+	return list
+}
+
+// Save validates every value first and stores them in one transaction
+func (service *SettingsService) Save(values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		def, ok := GetSettingDef(key)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting", key)
+		}
+		if err := def.Validate(values[key]); err != nil {
+			return err
+		}
+	}
+
+	_, err := service.engine.Transaction(func(session *xorm.Session) (interface{}, error) {
+		for key, value := range values {
+			def, _ := GetSettingDef(key)
+			var setting model.SystemSettings
+			has, err := session.Where(service.engine.Quote("key")+" = ?", key).Get(&setting)
+			if err != nil {
+				return nil, err
+			}
+			if has {
+				_, err = session.ID(setting.Id).Cols("name", "value").Update(&model.SystemSettings{Name: def.Name, Value: value})
+			} else {
+				_, err = session.Insert(&model.SystemSettings{Name: def.Name, Key: key, Value: value})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	service.invalidate()
+	return err
+}
+
+// Reset removes the database value, server.yaml or the default applies again
+func (service *SettingsService) Reset(key string) error {
+	if _, ok := GetSettingDef(key); !ok {
+		return fmt.Errorf("%s: unknown setting", key)
+	}
+	_, err := service.engine.Where(service.engine.Quote("key")+" = ?", key).Delete(&model.SystemSettings{})
+	service.invalidate()
+	return err
+}
+
+func (service *SettingsService) dbValues() map[string]string {
+	settingsCacheMu.RLock()
+	values, loadedAt := settingsCache, settingsCacheTime
+	settingsCacheMu.RUnlock()
+	if values != nil && time.Since(loadedAt) < settingsCacheTTL {
+		return values
+	}
+
+	settings := make([]model.SystemSettings, 0)
+	if err := service.engine.Find(&settings); err != nil {
+		// keep serving the old values (or the defaults) while the database is unavailable
+		return values
+	}
+	values = make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.Key] = s.Value
+	}
+
+	settingsCacheMu.Lock()
+	settingsCache, settingsCacheTime = values, time.Now()
+	settingsCacheMu.Unlock()
+	return values
+}
+
+func (service *SettingsService) invalidate() {
+	settingsCacheMu.Lock()
+	settingsCache = nil
+	settingsCacheMu.Unlock()
+}
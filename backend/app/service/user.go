@@ -6,6 +6,7 @@ import (
 	"rustdesk-api-server-pro/app/model"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/syslog"
 	"rustdesk-api-server-pro/util"
 	"strconv"
 	"strings"
@@ -45,12 +46,14 @@ func (service *UserService) Login(loginForm api.LoginForm) iris.Map {
 	}
 
 	if !get {
+		loginEvent(loginForm, "account", loginForm.Username, "user not found")
 		return iris.Map{
 			"error": "Username Or Password Error",
 		}
 	}
 
 	if !util.PasswordVerify(loginForm.Password, user.Password) {
+		loginEvent(loginForm, "account", user.Username, "wrong password")
 		return iris.Map{
 			"error": "Username Or Password Error",
 		}
@@ -68,31 +71,25 @@ func (service *UserService) Login(loginForm api.LoginForm) iris.Map {
 		uuid := util.GetUUID()
 		s := NewMailService()
 
-		// 查询登录验证的邮件模板
-		tpl, err := s.GetMailTemplateByType(model.MAIL_TPL_TYPE_LOGIN_VERIFY)
-		if err != nil {
-			return iris.Map{
-				"error": err.Error(),
-			}
-		}
-
 		code := util.RandomString(6)
 		code = strings.ToUpper(code)
 
 		expired := 10
 
-		// 发送邮件
-		err = s.Send(user.Id, tpl.Id, user.Email, uuid, map[string]string{
-			"{$username}": user.Name,
-			"{$code}":     code,
-			"{$expired}":  strconv.Itoa(expired),
-		})
+		// 发送邮件, the mail is queued and sent in the background so a slow smtp server does not hold the login
+		// a code that arrives after it expired is of no use, the queue gives it up
+		err := s.SendExpiring(user.Id, model.MAIL_TPL_TYPE_LOGIN_VERIFY, user.Email, uuid, map[string]string{
+			"username": user.Name,
+			"code":     code,
+			"expired":  strconv.Itoa(expired),
+		}, time.Now().Add(time.Duration(expired)*time.Minute))
 
 		if err != nil {
 			return iris.Map{
 				"error": err.Error(),
 			}
 		}
+		NewMailQueueService().Kick()
 
 		db.DbEngine.Insert(&model.VerifyCode{
 			UserId:     user.Id,
@@ -133,6 +130,7 @@ func (service *UserService) Login(loginForm api.LoginForm) iris.Map {
 	// account 就是直接登录
 
 	token := service.GetLoginToken(loginForm, user.Id)
+	loginEvent(loginForm, "account", user.Username, "")
 	return iris.Map{
 		"access_token": token,
 		"type":         model.LOGIN_ACCESS_TOKEN,
@@ -156,7 +154,7 @@ func (service *UserService) GetLoginToken(loginForm api.LoginForm, userId int) s
 
 	signStr := fmt.Sprintf("%s_%s_%d_%s", loginForm.RustdeskId, loginForm.Uuid, userId, time.Now().String())
 	token := util.HmacSha256(signStr, service.config.SignKey)
-	expired := 90 * 24 * time.Hour // 3 months
+	expired := time.Duration(NewSettingsService().GetInt(SETTING_CLIENT_SESSION_DAYS)) * 24 * time.Hour
 
 	authToken := &model.AuthToken{
 		UserId:     userId,
@@ -186,6 +184,7 @@ func (service *UserService) LoginVerifyByEmailCode(loginForm api.LoginForm) iris
 		}
 	}
 	if !get {
+		loginEvent(loginForm, "email_code", "", "wrong verification code")
 		return iris.Map{
 			"error": "Verification Code Error",
 		}
@@ -194,6 +193,7 @@ func (service *UserService) LoginVerifyByEmailCode(loginForm api.LoginForm) iris
 		verifyCode.Status = model.VC_STATUS_EXPIRED
 		db.DbEngine.ID(verifyCode.Id).Update(&verifyCode)
 
+		loginEvent(loginForm, "email_code", eventUsername(verifyCode.UserId), "verification code expired")
 		return iris.Map{
 			"error": "Verification Code Error",
 		}
@@ -216,6 +216,7 @@ func (service *UserService) LoginVerifyByEmailCode(loginForm api.LoginForm) iris
 	}
 
 	token := service.GetLoginToken(loginForm, verifyCode.UserId)
+	loginEvent(loginForm, "email_code", user.Username, "")
 
 	return iris.Map{
 		"access_token": token,
@@ -241,6 +242,7 @@ func (service *UserService) LoginVerifyBy2FACode(loginForm api.LoginForm) iris.M
 		}
 	}
 	if !get {
+		loginEvent(loginForm, "tfa_code", "", "wrong verification code")
 		return iris.Map{
 			"error": "Verification Code Error",
 		}
@@ -260,7 +262,8 @@ func (service *UserService) LoginVerifyBy2FACode(loginForm api.LoginForm) iris.M
 		}
 	}
 
-	if !totp.Validate(loginForm.TfaCode, user.TwoFactorAuthSecret) {
+	if !totp.Validate(loginForm.TfaCode, user.TwoFactorAuthSecret.String()) {
+		loginEvent(loginForm, "tfa_code", user.Username, "wrong 2fa code")
 		return iris.Map{
 			"error": "Verification Code Error",
 		}
@@ -270,6 +273,7 @@ func (service *UserService) LoginVerifyBy2FACode(loginForm api.LoginForm) iris.M
 	db.DbEngine.ID(verifyCode.Id).Update(&verifyCode)
 
 	token := service.GetLoginToken(loginForm, user.Id)
+	loginEvent(loginForm, "tfa_code", user.Username, "")
 
 	return iris.Map{
 		"access_token": token,
@@ -283,3 +287,19 @@ func (service *UserService) LoginVerifyBy2FACode(loginForm api.LoginForm) iris.M
 		},
 	}
 }
+
+// loginEvent emits login.success, or login.failure when there is a reason
+func loginEvent(loginForm api.LoginForm, method, username, reason string) {
+	fields := map[string]string{
+		"username":    username,
+		"rustdesk_id": loginForm.RustdeskId,
+		"ip":          loginForm.RemoteIp,
+		"method":      method,
+	}
+	if reason == "" {
+		EmitEvent(EVENT_LOGIN_SUCCESS, syslog.SEVERITY_INFO, "", fields)
+		return
+	}
+	fields["reason"] = reason
+	EmitEvent(EVENT_LOGIN_FAILURE, syslog.SEVERITY_WARNING, "", fields)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"rustdesk-api-server-pro/config"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show or validate the server config",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective config (file + environment) with secrets redacted",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadServerConfig()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		bytes, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			fmt.Println("config marshal error:", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("# " + config.GetConfigFile())
+		fmt.Print(string(bytes))
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the config file and the environment variables",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := config.LoadServerConfig()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(config.GetConfigFile() + ": ok")
+	},
+}
+
+var configEnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "List the environment variables that override the config file",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, name := range config.EnvNames() {
+			fmt.Println(name)
+		}
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configEnvCmd)
+	RootCmd.AddCommand(configCmd)
+}
@@ -31,10 +31,18 @@ var initCmd = &cobra.Command{
 			new(model.AuthToken),
 			new(model.Audit),
 			new(model.FileTransfer),
+			new(model.FileTransferItem),
+			new(model.Alarm),
 			new(model.MailLogs),
 			new(model.MailTemplate),
 			new(model.SystemSettings),
 			new(model.VerifyCode),
+			new(model.JobLease),
+			new(model.JobRun),
+			new(model.ServerKey),
+			new(model.Installer),
+			new(model.DownloadEvent),
+			new(model.MailAttachment),
 		)
 		if err != nil {
 			fmt.Println("Database sync error:", err)
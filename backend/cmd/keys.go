@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"rustdesk-api-server-pro/helper/secret"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Master key management for secrets encrypted at rest",
+}
+
+var keysPrune bool
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Generate a new master key and re-encrypt every stored secret with it",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.GetServerConfig()
+		ring, err := config.LoadKeyRing(cfg)
+		if err != nil {
+			fmt.Println("Master key load error:", err)
+			return
+		}
+		_, err = db.NewEngine(cfg.Db)
+		if err != nil {
+			fmt.Println("Db Engine create error:", err)
+			return
+		}
+		if err = service.NewSecretService().SaveGeneratedKey(); err != nil {
+			fmt.Println("Master key error:", err)
+			return
+		}
+
+		// a running api server keeps encrypting with the key it loaded, pruning it would make those secrets unreadable
+		if keysPrune {
+			lease, err := service.NewJobService().Leader()
+			if err != nil {
+				fmt.Println("Job lease check error:", err)
+				return
+			}
+			if lease != nil {
+				fmt.Println("The api server " + lease.Holder + " is running, stop every instance before rotating with --prune")
+				return
+			}
+		}
+
+		newRing, err := ring.Rotate()
+		if err != nil {
+			fmt.Println("Master key generate error:", err)
+			return
+		}
+
+		// Save the new key before anything is encrypted with it
+		if !newRing.FromEnv {
+			err = newRing.Save()
+			if err != nil {
+				fmt.Println("Master key save error:", err)
+				return
+			}
+		}
+		secret.SetDefault(newRing)
+
+		count, err := service.NewSecretService().Reencrypt(newRing)
+		if err != nil {
+			fmt.Println("Re-encrypt error:", err, "(the new key is saved, run rotate again after fixing the error)")
+			return
+		}
+		fmt.Printf("Re-encrypted %d stored secrets with master key %s\n", count, newRing.Primary().Id)
+
+		yamlSecrets := [][]string{{"smtpConfig", "password"}}
+		if cfg.MailConfig != nil && cfg.MailConfig.Http != nil {
+			for name := range cfg.MailConfig.Http.Headers {
+				yamlSecrets = append(yamlSecrets, []string{"mailConfig", "http", "headers", name})
+			}
+		}
+		for _, keys := range yamlSecrets {
+			if err = reencryptYamlValue(newRing, keys...); err != nil {
+				fmt.Println(strings.Join(keys, ".")+" re-encrypt error:", err)
+				return
+			}
+		}
+
+		if keysPrune {
+			newRing = newRing.WithoutOldKeys()
+			if !newRing.FromEnv {
+				err = newRing.Save()
+				if err != nil {
+					fmt.Println("Master key save error:", err)
+					return
+				}
+			}
+			fmt.Println("Old master keys removed")
+		}
+
+		if newRing.FromEnv {
+			fmt.Println("The master keys come from " + secret.MasterKeyEnv + ", update it before the next start:")
+			fmt.Println(secret.MasterKeyEnv + "=" + newRing.EnvValue())
+		} else {
+			fmt.Println("Master keys saved to", newRing.File)
+		}
+	},
+}
+
+var keysEncryptCmd = &cobra.Command{
+	Use:   "encrypt <value>",
+	Short: "Encrypt a value for server.yaml (e.g. smtpConfig.password)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.GetServerConfig()
+		ring, err := config.LoadKeyRing(cfg)
+		if err != nil {
+			fmt.Println("Master key load error:", err)
+			return
+		}
+		if ring.Generated {
+			if _, err = db.NewEngine(cfg.Db); err != nil {
+				fmt.Println("Db Engine create error:", err)
+				return
+			}
+			if err = service.NewSecretService().SaveGeneratedKey(); err != nil {
+				fmt.Println("Master key error:", err)
+				return
+			}
+		}
+		value, err := ring.Encrypt(args[0])
+		if err != nil {
+			fmt.Println("Encrypt error:", err)
+			return
+		}
+		fmt.Println(value)
+	},
+}
+
+func init() {
+	keysRotateCmd.Flags().BoolVar(&keysPrune, "prune", false, "Remove the old master keys after every secret is re-encrypted, the api server must be stopped")
+	keysCmd.AddCommand(keysRotateCmd)
+	keysCmd.AddCommand(keysEncryptCmd)
+	RootCmd.AddCommand(keysCmd)
+}
+
+// reencryptYamlValue encrypts a secret of server.yaml with the primary key of ring, plaintext values are left alone
+func reencryptYamlValue(ring *secret.KeyRing, keys ...string) error {
+	current, ok := config.ReadYamlValue(keys...)
+	if !ok || !secret.IsEncrypted(current) {
+		return nil
+	}
+	plaintext, err := ring.Decrypt(current)
+	if err != nil {
+		return err
+	}
+	value, err := ring.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	if err = config.UpdateYamlValue(value, keys...); err != nil {
+		return err
+	}
+	fmt.Println("Re-encrypted " + strings.Join(keys, ".") + " in server.yaml")
+	return nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneDryRun bool
+	pruneVacuum bool
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete (or archive) old rows according to the retention section of the config",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.GetServerConfig()
+		_, err := db.NewEngine(cfg.Db)
+		if err != nil {
+			fmt.Println("Db Engine create error:", err)
+			os.Exit(1)
+		}
+
+		results, err := service.NewRetentionService().Prune(pruneDryRun)
+		if err != nil {
+			fmt.Println("Prune error:", err)
+			os.Exit(1)
+		}
+		if len(results) == 0 {
+			fmt.Println("No retention policy is set")
+			return
+		}
+
+		failed := false
+		for _, r := range results {
+			verb := "deleted"
+			if pruneDryRun {
+				verb = "would delete"
+			}
+			line := fmt.Sprintf("%-14s %s %d rows before %s", r.Table, verb, r.Rows, r.Before)
+			if r.ChildRows > 0 {
+				line += fmt.Sprintf(" (+%d child rows)", r.ChildRows)
+			}
+			if len(r.ArchiveFiles) > 0 {
+				line += ", archived to " + strings.Join(r.ArchiveFiles, ", ")
+			}
+			if r.Error != "" {
+				line += ", error: " + r.Error
+				failed = true
+			}
+			fmt.Println(line)
+		}
+
+		if pruneVacuum && !pruneDryRun {
+			if err = service.NewRetentionService().Vacuum(); err != nil {
+				fmt.Println("Vacuum error:", err)
+				os.Exit(1)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "only count the rows that would be deleted")
+	pruneCmd.Flags().BoolVar(&pruneVacuum, "vacuum", false, "shrink the sqlite file afterwards, it locks the database while running")
+	RootCmd.AddCommand(pruneCmd)
+}
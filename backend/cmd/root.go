@@ -1,9 +1,22 @@
 package cmd
 
 import (
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/version"
+
 	"github.com/spf13/cobra"
 )
 
+var configFile string
+
 var RootCmd = &cobra.Command{
-	Use: "rustdesk-api-server-pro [command]",
+	Use:     "rustdesk-api-server-pro [command]",
+	Version: version.Get().String(),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		config.SetConfigFile(configFile)
+	},
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Config file (default ./server.yaml, or "+config.ConfigFileEnv+")")
 }
@@ -3,12 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path"
-	"runtime"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/helper/github"
 	"rustdesk-api-server-pro/helper/rustdesk"
 	"rustdesk-api-server-pro/util"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -16,12 +15,21 @@ import (
 var rustdeskServerCmd = &cobra.Command{
 	Use:   "rustdesk [command]",
 	Short: "About rustdesk-server command",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		config.SetConfigFile(configFile)
+		// an invalid config keeps the default ./rustdesk-server, these commands do not need the rest of it
+		if cfg, err := config.LoadServerConfig(); err == nil && cfg.Rustdesk != nil {
+			service.ConfigureRustdesk(cfg.Rustdesk)
+		}
+	},
 }
 
 var rustdeskInstallCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Download and run rustdesk-server",
-	Long:  "This command will be download rustdesk-server from https://github.com/rustdesk/rustdesk-server/releases and run it.",
+	Long: `This command will be download rustdesk-server from https://github.com/rustdesk/rustdesk-server/releases and run it.
+Without network access --from installs a downloaded zip, a directory holding the zip or the binaries, or a mirror url
+serving the zips, verified with --sha256 or --checksum-file (sha256sum format).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		hbbr, hbbs := rustdesk.GetRustdeskServerBin()
 		if util.FileExists(hbbr) && util.FileExists(hbbs) {
@@ -29,67 +37,31 @@ var rustdeskInstallCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
-		repo := "rustdesk/rustdesk-server"
-		release := &github.Release{}
-		rustdeskServerVersion := cmd.Flag("version").Value.String()
-		if rustdeskServerVersion == "latest" {
-			release = github.GetLatestRelease(repo)
-		} else {
-			release = github.GetReleaseByTag(repo, rustdeskServerVersion)
-		}
-		matchedAsset := github.Asset{}
-		arch := runtime.GOARCH
-		for _, asset := range release.Assets {
-			if runtime.GOOS == "windows" {
-				if strings.Contains(asset.Name, "windows") {
-					matchedAsset = asset
-					arch = "x86_64"
-					break
-				}
-			}
-			if runtime.GOOS == "linux" {
-				if arch == "arm64" {
-					if asset.Name == "rustdesk-server-linux-arm64v8.zip" {
-						matchedAsset = asset
-						arch = "arm64v8"
-						break
-					}
-				}
-				if arch == "amd64" {
-					if asset.Name == "rustdesk-server-linux-amd64.zip" {
-						matchedAsset = asset
-						arch = "amd64"
-						break
-					}
-				}
-			}
-		}
-		if matchedAsset.Name == "" {
-			fmt.Println("Your operating system is not supported, only support windows and linux ")
-			os.Exit(0)
-		}
-
-		// Unzip the rustdesk-server zip if it already exists locally, otherwise download it from github
-		if !util.FileExists(matchedAsset.Name) {
-			proxyServer := cmd.Flag("proxy").Value.String()
-			util.SetHttpProxy(proxyServer)
-			err := util.DownloadFile(matchedAsset.BrowserDownloadURL, matchedAsset.Name, true)
-			if err != nil {
-				fmt.Println("rustdesk-server download error: ", err)
+		proxyServer := cmd.Flag("proxy").Value.String()
+		util.SetHttpProxy(proxyServer)
+		from := cmd.Flag("from").Value.String()
+		if from == "" {
+			if _, arch := rustdesk.AssetName(); arch == "" {
+				fmt.Println("Your operating system is not supported, only support windows and linux ")
 				os.Exit(0)
 			}
 		}
-
-		fmt.Println("unzipping", matchedAsset.Name)
-		err := util.Unzip(matchedAsset.Name, rustdesk.GetRustdeskServerBinDir())
+		result, err := rustdesk.Install(rustdesk.InstallOptions{
+			From:         from,
+			Version:      cmd.Flag("version").Value.String(),
+			Sha256:       cmd.Flag("sha256").Value.String(),
+			ChecksumFile: cmd.Flag("checksum-file").Value.String(),
+		})
 		if err != nil {
-			fmt.Println(matchedAsset.Name, "unzipped error: ", err)
-			os.Exit(0)
+			fmt.Println("rustdesk-server install error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("installed from", result.Source)
+		if result.Verified {
+			fmt.Println("sha256 verified")
+		} else {
+			fmt.Println("warning: no checksum to verify the rustdesk-server binaries, use --sha256 or --checksum-file")
 		}
-		src := path.Join(rustdesk.GetRustdeskServerBinDir(), arch)
-		util.MoveFiles(src, rustdesk.GetRustdeskServerBinDir())
-		os.Remove(matchedAsset.Name)
-		os.RemoveAll(src)
 		fmt.Println("The rustdesk-server has been initialized.")
 	},
 }
@@ -172,9 +144,13 @@ var rustdeskListCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		proxyServer := cmd.Flag("proxy").Value.String()
 		util.SetHttpProxy(proxyServer)
-		releases := github.GetReleases("rustdesk/rustdesk-server")
+		releases, err := github.FetchReleases(rustdesk.REPO)
+		if err != nil {
+			fmt.Println("rustdesk-server releases error:", err)
+			os.Exit(1)
+		}
 		fmt.Printf("%-20s%s\n", "Version", "Published")
-		for _, release := range *releases {
+		for _, release := range releases {
 			fmt.Printf("%-20s%s\n", release.TagName, release.PublishedAt)
 		}
 	},
@@ -183,6 +159,9 @@ var rustdeskListCmd = &cobra.Command{
 func init() {
 	rustdeskInstallCmd.Flags().StringP("proxy", "p", "", "Setting up a proxy to download rustdesk-server program (e.g [http|https|socks5]://proxy-host:port)")
 	rustdeskInstallCmd.Flags().StringP("version", "v", "latest", "Setting the rustdesk-server program version")
+	rustdeskInstallCmd.Flags().String("from", "", "Install from a local zip, a directory or a mirror url instead of github")
+	rustdeskInstallCmd.Flags().String("sha256", "", "Expected SHA-256 of the rustdesk-server zip")
+	rustdeskInstallCmd.Flags().String("checksum-file", "", "Path or url of a sha256sum file for the zip or the binaries")
 	rustdeskServerCmd.AddCommand(rustdeskInstallCmd)
 	rustdeskServerCmd.AddCommand(rustdeskStartCmd)
 	rustdeskServerCmd.AddCommand(rustdeskStopCmd)
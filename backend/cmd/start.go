@@ -7,11 +7,11 @@ import (
 )
 
 var startCmd = &cobra.Command{
-	Use:                   "start",
-	Short:                 "Start the api-server",
-	DisableFlagsInUseLine: true,
+	Use:   "start",
+	Short: "Start the api-server",
 	Run: func(cmd *cobra.Command, args []string) {
-		_, err := app.StartServer()
+		supervise, _ := cmd.Flags().GetBool("supervise")
+		_, err := app.StartServer(supervise)
 		if err != nil {
 			fmt.Println("api-server failed to start:", err.Error())
 		}
@@ -19,5 +19,6 @@ var startCmd = &cobra.Command{
 }
 
 func init() {
+	startCmd.Flags().Bool("supervise", false, "Also run hbbs and hbbr and restart them when they exit (see rustdesk in server.yaml)")
 	RootCmd.AddCommand(startCmd)
 }
@@ -26,11 +26,19 @@ var dbSyncCmd = &cobra.Command{
 			new(model.AuthToken),
 			new(model.Audit),
 			new(model.FileTransfer),
+			new(model.FileTransferItem),
+			new(model.Alarm),
 			new(model.Device),
 			new(model.AddressBook),
 			new(model.AddressBookTag),
 			new(model.MailLogs),
 			new(model.VerifyCode),
+			new(model.JobLease),
+			new(model.JobRun),
+			new(model.ServerKey),
+			new(model.Installer),
+			new(model.DownloadEvent),
+			new(model.MailAttachment),
 			new(model.SystemSettings),
 			new(model.MailTemplate),
 		}
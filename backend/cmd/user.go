@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
 	"rustdesk-api-server-pro/config"
 	"rustdesk-api-server-pro/db"
 	"rustdesk-api-server-pro/util"
@@ -46,14 +47,13 @@ var userAddCmd = &cobra.Command{
 		isAdminAccess := role >= model.ROLE_SUPPORT_N2
 
 		user := &model.User{
-			Username:        username,
-			Password:        password,
-			Name:            username,
-			LicensedDevices: 0,
-			LoginVerify:     model.LOGIN_ACCESS_TOKEN,
-			IsAdmin:         isAdminAccess,
-			Role:            role,
-			Status:          1,
+			Username:    username,
+			Password:    password,
+			Name:        username,
+			LoginVerify: model.LOGIN_ACCESS_TOKEN,
+			IsAdmin:     isAdminAccess,
+			Role:        role,
+			Status:      1,
 		}
 		cfg := config.GetServerConfig()
 		engine, err := db.NewEngine(cfg.Db)
@@ -74,10 +74,18 @@ var userAddCmd = &cobra.Command{
 			new(model.AuthToken),
 			new(model.Audit),
 			new(model.FileTransfer),
+			new(model.FileTransferItem),
+			new(model.Alarm),
 			new(model.MailLogs),
 			new(model.MailTemplate),
 			new(model.SystemSettings),
 			new(model.VerifyCode),
+			new(model.JobLease),
+			new(model.JobRun),
+			new(model.ServerKey),
+			new(model.Installer),
+			new(model.DownloadEvent),
+			new(model.MailAttachment),
 		)
 		if err != nil {
 			fmt.Println("Database sync error:", err)
@@ -85,6 +93,8 @@ var userAddCmd = &cobra.Command{
 		}
 		fmt.Println("Database tables synced successfully!")
 
+		user.LicensedDevices = service.NewSettingsService().GetInt(service.SETTING_DEFAULT_LICENSED_DEVICES)
+
 		_, err = engine.Insert(user)
 		if err != nil {
 			fmt.Println("Add error:", err)
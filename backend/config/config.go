@@ -1,20 +1,37 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"path"
+	"rustdesk-api-server-pro/helper/secret"
 	"rustdesk-api-server-pro/util"
+	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ServerConfig struct {
-	DebugMode  bool        `yaml:"debugMode"`
-	Db         *DbConfig   `yaml:"db"`
-	SignKey    string      `yaml:"signKey"`
-	HttpConfig *HttpConfig `yaml:"httpConfig"`
-	SmtpConfig *SmtpConfig `yaml:"smtpConfig"`
-	JobsConfig *JobsConfig `yaml:"jobsConfig"`
+	DebugMode  bool          `yaml:"debugMode"`
+	Db         *DbConfig     `yaml:"db" env:"DB"`
+	SignKey    string        `yaml:"signKey"`
+	HttpConfig *HttpConfig   `yaml:"httpConfig" env:"HTTP"`
+	SmtpConfig *SmtpConfig   `yaml:"smtpConfig" env:"SMTP"`
+	MailConfig *MailConfig   `yaml:"mailConfig" env:"MAIL"`
+	JobsConfig *JobsConfig   `yaml:"jobsConfig" env:"JOBS"`
+	Security   *Security     `yaml:"security"`
+	Reload     *ReloadConfig `yaml:"reload" env:"RELOAD"`
+	Retention  *Retention    `yaml:"retention" env:"RETENTION"`
+	Syslog     *Syslog       `yaml:"syslog" env:"SYSLOG"`
+	Log        *LogConfig    `yaml:"log" env:"LOG"`
+	Shutdown   *Shutdown     `yaml:"shutdown" env:"SHUTDOWN"`
+	Rustdesk   *Rustdesk     `yaml:"rustdesk" env:"RUSTDESK"`
+	// Settings are defaults for the runtime settings, values saved in the admin console take precedence
+	Settings map[string]string `yaml:"settings"`
 }
 
 type DbConfig struct {
@@ -30,6 +47,42 @@ type HttpConfig struct {
 	StaticDir       string         `yaml:"staticdir"`
 	InstallersDir   string         `yaml:"installersDir"`
 	ExternalLinks   *ExternalLinks `yaml:"externalLinks"`
+	// RequireSignedDownloads refuses installer downloads without a signed link from /admin/downloads/link
+	RequireSignedDownloads bool `yaml:"requireSignedDownloads"`
+	DownloadLinkHours      int  `yaml:"downloadLinkHours"` // default lifetime of a signed link
+	// TrustedProxies are the IPs or CIDRs of the reverse proxies whose X-Forwarded-Proto and X-Forwarded-Host are used
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// ParseTrustedProxy parses an entry of httpConfig.trustedProxies, an IP is a CIDR of a single address
+func ParseTrustedProxy(proxy string) (*net.IPNet, error) {
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(proxy)
+	return ipNet, err
+}
+
+// IsTrustedProxy reports whether ip is one of the trusted reverse proxies
+func (h *HttpConfig) IsTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if h == nil || addr == nil {
+		return false
+	}
+	for _, proxy := range h.TrustedProxies {
+		if ipNet, err := ParseTrustedProxy(proxy); err == nil && ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 type ExternalLinks struct {
@@ -47,24 +100,190 @@ type SmtpConfig struct {
 	Host       string `yaml:"host"`
 	Port       int    `yaml:"port"`
 	Username   string `yaml:"username"`
-	Password   string `yaml:"password"`
+	Password   string `yaml:"password"`   // plaintext or enc:v1:... (see `keys encrypt`)
 	Encryption string `yaml:"encryption"` // none ssl/tls starttls
 	From       string `yaml:"from"`
 }
 
+// MailConfig selects the transport of the queued mails: smtp (smtpConfig), sendmail, http or file. The sender
+// address is smtpConfig.from with all of them.
+type MailConfig struct {
+	Transport string        `yaml:"transport"`
+	Smtp      *MailSmtp     `yaml:"smtp"`
+	Sendmail  *MailSendmail `yaml:"sendmail"`
+	Http      *MailHttp     `yaml:"http"`
+	File      *MailFile     `yaml:"file"`
+}
+
+type MailSmtp struct {
+	PoolSize    int `yaml:"poolSize"`    // idle connections kept open
+	IdleSeconds int `yaml:"idleSeconds"` // an idle connection unused for longer is closed
+}
+
+type MailSendmail struct {
+	Path           string   `yaml:"path"`
+	Args           []string `yaml:"args"`
+	TimeoutSeconds int      `yaml:"timeoutSeconds"`
+}
+
+// MailHttp posts the mails to a mail API, Body is a text/template of the request body (see mailer.DefaultHttpBody)
+type MailHttp struct {
+	Url            string            `yaml:"url"`
+	Method         string            `yaml:"method"`
+	Headers        map[string]string `yaml:"headers"` // plaintext or enc:v1:... (see `keys encrypt`)
+	Body           string            `yaml:"body"`
+	TimeoutSeconds int               `yaml:"timeoutSeconds"`
+}
+
+type MailFile struct {
+	Dir string `yaml:"dir"`
+}
+
 type DeviceCheckJob struct {
 	Duration int `yaml:"duration"`
 }
 
+type RetentionJob struct {
+	Duration int `yaml:"duration"`
+}
+
+// MailQueueJob delivers the queued mails every Duration seconds. A failed mail is retried after BackoffSeconds,
+// doubled on each attempt up to MaxBackoffSeconds, and is dead after MaxAttempts attempts.
+type MailQueueJob struct {
+	Duration          int `yaml:"duration"`
+	BatchSize         int `yaml:"batchSize"`
+	MaxAttempts       int `yaml:"maxAttempts"`
+	BackoffSeconds    int `yaml:"backoffSeconds"`
+	MaxBackoffSeconds int `yaml:"maxBackoffSeconds"`
+}
+
 type JobsConfig struct {
 	DeviceCheckJob *DeviceCheckJob `yaml:"deviceCheckJob"`
+	RetentionJob   *RetentionJob   `yaml:"retentionJob"`
+	MailQueueJob   *MailQueueJob   `yaml:"mailQueueJob"`
+	// InstanceId names this replica in the job lease and run history, defaults to hostname-pid
+	InstanceId string `yaml:"instanceId"`
+	// LeaseSeconds is how long the scheduler lease lasts without renewal, the leader renews it every third of it
+	LeaseSeconds int `yaml:"leaseSeconds"`
 }
 
+// RetentionPolicy keeps the rows of a table for Days days, 0 keeps them forever.
+// Archive writes the rows to a gzipped NDJSON file in Retention.ArchiveDir before they are deleted.
+type RetentionPolicy struct {
+	Days    int  `yaml:"days"`
+	Archive bool `yaml:"archive"`
+}
+
+type Retention struct {
+	ArchiveDir   string           `yaml:"archiveDir"`
+	BatchSize    int              `yaml:"batchSize"` // rows deleted per transaction, keeps sqlite write locks short
+	Audit        *RetentionPolicy `yaml:"audit"`
+	FileTransfer *RetentionPolicy `yaml:"fileTransfer"`
+	Alarm        *RetentionPolicy `yaml:"alarm"`
+	MailLogs     *RetentionPolicy `yaml:"mailLogs"`
+	VerifyCode   *RetentionPolicy `yaml:"verifyCode"`
+	AuthToken    *RetentionPolicy `yaml:"authToken"` // days after the token expired
+}
+
+// Syslog forwards security events to the targets, see service.EventService
+type Syslog struct {
+	AppName  string          `yaml:"appName"`
+	Hostname string          `yaml:"hostname"` // defaults to the os hostname
+	Targets  []*SyslogTarget `yaml:"targets"`
+}
+
+type SyslogTarget struct {
+	Name                  string   `yaml:"name"`
+	Network               string   `yaml:"network"` // udp tcp tls
+	Address               string   `yaml:"address"` // host:port
+	Format                string   `yaml:"format"`  // rfc5424 cef
+	Facility              string   `yaml:"facility"`
+	Events                []string `yaml:"events"` // patterns like conn.* or login.failure, empty forwards every event
+	TlsCaFile             string   `yaml:"tlsCaFile"`
+	TlsInsecureSkipVerify bool     `yaml:"tlsInsecureSkipVerify"`
+}
+
+// LogConfig is applied on reload too, debugMode lowers an empty level to debug
+type LogConfig struct {
+	Level      string            `yaml:"level"`   // debug info warn error
+	Format     string            `yaml:"format"`  // text json
+	Modules    map[string]string `yaml:"modules"` // per module levels, e.g. http: warn
+	File       string            `yaml:"file"`    // empty logs to stdout
+	MaxSizeMB  int               `yaml:"maxSizeMB"`
+	MaxBackups int               `yaml:"maxBackups"`
+}
+
+type Security struct {
+	// MasterKeyFile holds the keys used to encrypt secrets at rest, RDAPI_MASTER_KEY takes precedence
+	MasterKeyFile string `yaml:"masterKeyFile"`
+}
+
+// Shutdown is read when the server stops, so it can be reloaded
+type Shutdown struct {
+	Timeout            int  `yaml:"timeout"`            // seconds to finish the requests and jobs in flight
+	CloseAuditSessions bool `yaml:"closeAuditSessions"` // close the open audit sessions with reason "shutdown" when holding the job lease
+}
+
+// Rustdesk configures hbbs and hbbr when `start` supervises them, it is read at startup
+type Rustdesk struct {
+	Supervise    bool     `yaml:"supervise"` // run hbbs and hbbr as child processes and restart them when they exit
+	BinDir       string   `yaml:"binDir"`    // holds hbbs, hbbr and their id_ed25519 keys
+	RelayServers []string `yaml:"relayServers"`
+	Key          string   `yaml:"key"`      // -k, "_" only accepts clients that use the server key
+	HbbsPort     int      `yaml:"hbbsPort"` // 0 keeps the rustdesk default (21116, hbbr 21117)
+	HbbrPort     int      `yaml:"hbbrPort"`
+	HbbsArgs     []string `yaml:"hbbsArgs"` // more arguments, passed as they are
+	HbbrArgs     []string `yaml:"hbbrArgs"`
+	LogDir       string   `yaml:"logDir"` // hbbs.log and hbbr.log, rotated like log.file
+	LogMaxSizeMB int      `yaml:"logMaxSizeMB"`
+	LogBackups   int      `yaml:"logBackups"`
+	MaxBackoff   int      `yaml:"maxBackoff"` // seconds, the restart delay doubles from 1s up to it
+	// ReleasesUrl replaces https://api.github.com, a mirror serving the same /repos/{repo}/releases json
+	ReleasesUrl string `yaml:"releasesUrl"`
+	// ReleasesCacheMinutes keeps the releases list in binDir, it is also used when the api can not be reached
+	ReleasesCacheMinutes int `yaml:"releasesCacheMinutes"`
+}
+
+type ReloadConfig struct {
+	// WatchFile reloads the config when the file changes, SIGHUP and the admin api always work
+	WatchFile     bool `yaml:"watchFile"`
+	WatchInterval int  `yaml:"watchInterval"` // seconds
+}
+
+// ConfigFileEnv can be used instead of the --config flag
+const ConfigFileEnv = "RDAPI_CONFIG"
+
 var (
-	wd, _    = os.Getwd()
-	yamlFile = path.Join(wd, "server.yaml")
+	wd, _ = os.Getwd()
+
+	configFile         = path.Join(wd, "server.yaml")
+	configFileExplicit = false
+
+	serverConfig *ServerConfig
+	configMu     sync.RWMutex
 )
 
+func init() {
+	if f := os.Getenv(ConfigFileEnv); f != "" {
+		SetConfigFile(f)
+	}
+}
+
+// SetConfigFile changes the config file and returns the previous one, it must be called before the config is loaded
+func SetConfigFile(file string) string {
+	old := configFile
+	if file == "" {
+		return old
+	}
+	configFile = file
+	configFileExplicit = true
+	return old
+}
+
+func GetConfigFile() string {
+	return configFile
+}
+
 func GetDefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
 		DebugMode: false,
@@ -75,38 +294,338 @@ func GetDefaultServerConfig() *ServerConfig {
 			TimeZone: "Asia/Shanghai",
 		},
 		HttpConfig: &HttpConfig{
-			Port:          ":8080",
-			StaticDir:     "dist",
-			InstallersDir: "./data/installers",
+			Port:              ":8080",
+			StaticDir:         "dist",
+			InstallersDir:     "./data/installers",
+			DownloadLinkHours: 72,
+		},
+		SmtpConfig: &SmtpConfig{
+			Encryption: "none",
+		},
+		MailConfig: &MailConfig{
+			Transport: "smtp",
+			Smtp: &MailSmtp{
+				PoolSize:    2,
+				IdleSeconds: 30,
+			},
+			Sendmail: &MailSendmail{
+				Path:           "/usr/sbin/sendmail",
+				Args:           []string{"-t", "-i"},
+				TimeoutSeconds: 30,
+			},
+			Http: &MailHttp{
+				Method:         "POST",
+				TimeoutSeconds: 30,
+			},
+			File: &MailFile{
+				Dir: "./data/mail",
+			},
 		},
-		SignKey: util.RandomString(32),
 		JobsConfig: &JobsConfig{
 			DeviceCheckJob: &DeviceCheckJob{
 				Duration: 30,
 			},
+			RetentionJob: &RetentionJob{
+				Duration: 3600,
+			},
+			MailQueueJob: &MailQueueJob{
+				Duration:          10,
+				BatchSize:         50,
+				MaxAttempts:       6,
+				BackoffSeconds:    30,
+				MaxBackoffSeconds: 3600,
+			},
+			LeaseSeconds: 30,
+		},
+		Security: &Security{
+			MasterKeyFile: "./master.key",
+		},
+		Reload: &ReloadConfig{
+			WatchFile:     true,
+			WatchInterval: 5,
+		},
+		Shutdown: &Shutdown{
+			Timeout:            30,
+			CloseAuditSessions: false,
+		},
+		Log: &LogConfig{
+			Format:     "text",
+			Modules:    map[string]string{},
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Syslog: &Syslog{
+			AppName: "rustdesk-api",
+			Targets: []*SyslogTarget{},
+		},
+		Rustdesk: &Rustdesk{
+			BinDir:               "./rustdesk-server",
+			RelayServers:         []string{},
+			HbbsArgs:             []string{},
+			HbbrArgs:             []string{},
+			LogDir:               "./data/logs",
+			LogMaxSizeMB:         20,
+			LogBackups:           3,
+			MaxBackoff:           60,
+			ReleasesUrl:          "https://api.github.com",
+			ReleasesCacheMinutes: 60,
+		},
+		Retention: &Retention{
+			ArchiveDir:   "./data/archive",
+			BatchSize:    500,
+			Audit:        &RetentionPolicy{},
+			FileTransfer: &RetentionPolicy{},
+			Alarm:        &RetentionPolicy{},
+			MailLogs:     &RetentionPolicy{},
+			VerifyCode:   &RetentionPolicy{Days: 7},
+			AuthToken:    &RetentionPolicy{Days: 30},
 		},
 	}
 }
 
+// GetServerConfig returns the loaded config, the first call loads it and exits on an invalid config.
+// Use LoadServerConfig to handle the error yourself.
 func GetServerConfig() *ServerConfig {
-	cfg := GetDefaultServerConfig()
-	bytes, err := os.ReadFile(yamlFile)
-	if err != nil {
-		WriteServerConfig(cfg)
+	configMu.RLock()
+	cfg := serverConfig
+	configMu.RUnlock()
+	if cfg != nil {
 		return cfg
 	}
 
-	err = yaml.Unmarshal(bytes, cfg)
+	cfg, err := LoadServerConfig()
 	if err != nil {
-		WriteServerConfig(cfg)
-		return cfg
+		fmt.Println("config error:", err.Error())
+		os.Exit(1)
 	}
+	SetServerConfig(cfg)
 	return cfg
 }
 
-func WriteServerConfig(cfg *ServerConfig) {
+// SetServerConfig replaces the config and returns the previous one, nil when none was loaded. nil unloads the
+// config, the next one set or loaded is the startup config again.
+func SetServerConfig(cfg *ServerConfig) *ServerConfig {
+	configMu.Lock()
+	old := serverConfig
+	serverConfig = cfg
+	if cfg == nil || startupConfig == nil {
+		startupConfig = cfg
+	}
+	configMu.Unlock()
+	return old
+}
+
+// LoadServerConfig reads the config file, applies the RDAPI_* environment variables and validates the result.
+// Only a missing default server.yaml is created, an invalid file is never overwritten.
+func LoadServerConfig() (*ServerConfig, error) {
+	cfg, err := ReadServerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	err = decryptSecrets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ReadServerConfig reads the config file and the environment variables without validating them
+func ReadServerConfig() (*ServerConfig, error) {
+	cfg := GetDefaultServerConfig()
+
+	bytes, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) && !configFileExplicit {
+		// first start: create server.yaml with a random sign key, read-only setups only need the env variables
+		if _, ok, _ := lookupEnv(envPrefix + "SIGN_KEY"); !ok {
+			cfg.SignKey = util.RandomString(32)
+			if err := WriteServerConfig(cfg); err != nil {
+				fmt.Println("config: can not create", configFile+":", err.Error())
+			}
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("read %s: %s", configFile, err.Error())
+	} else {
+		decoder := yaml.NewDecoder(strings.NewReader(string(bytes)))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parse %s: %s", configFile, err.Error())
+		}
+	}
+
+	err = applyEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func WriteServerConfig(cfg *ServerConfig) error {
+	bytes, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, bytes, 0600)
+}
+
+// Redacted returns a copy of the config that is safe to print
+func (cfg *ServerConfig) Redacted() *ServerConfig {
 	bytes, _ := yaml.Marshal(cfg)
-	_ = os.WriteFile(yamlFile, bytes, 0755)
+	c := &ServerConfig{}
+	_ = yaml.Unmarshal(bytes, c)
+
+	if c.SignKey != "" {
+		c.SignKey = redacted
+	}
+	if c.SmtpConfig != nil && c.SmtpConfig.Password != "" {
+		c.SmtpConfig.Password = redacted
+	}
+	if c.Db != nil {
+		c.Db.Dsn = redactDsn(c.Db.Dsn)
+	}
+	// the headers of the mail api carry its credentials
+	if c.MailConfig != nil && c.MailConfig.Http != nil {
+		for name := range c.MailConfig.Http.Headers {
+			c.MailConfig.Http.Headers[name] = redacted
+		}
+	}
+	return c
+}
+
+const redacted = "******"
+
+// redactDsn hides the password of user:password@tcp(host)/db style DSNs
+func redactDsn(dsn string) string {
+	at := strings.LastIndex(dsn, "@")
+	if at < 0 {
+		return dsn
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return dsn
+	}
+	return dsn[:colon+1] + redacted + dsn[at:]
+}
+
+// LoadKeyRing loads the master keys once, later calls reuse them
+func LoadKeyRing(cfg *ServerConfig) (*secret.KeyRing, error) {
+	if ring := secret.Default(); ring != nil {
+		return ring, nil
+	}
+	keyFile := "./master.key"
+	if cfg.Security != nil && cfg.Security.MasterKeyFile != "" {
+		keyFile = cfg.Security.MasterKeyFile
+	}
+	ring, err := secret.LoadKeyRing(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	secret.SetDefault(ring)
+	return ring, nil
+}
+
+func decryptSecrets(cfg *ServerConfig) error {
+	if _, err := LoadKeyRing(cfg); err != nil {
+		return errors.New("master key load error: " + err.Error())
+	}
+	if cfg.SmtpConfig != nil && secret.IsEncrypted(cfg.SmtpConfig.Password) {
+		password, err := secret.Decrypt(cfg.SmtpConfig.Password)
+		if err != nil {
+			return errors.New("smtpConfig.password decrypt error: " + err.Error())
+		}
+		cfg.SmtpConfig.Password = password
+	}
+	if cfg.MailConfig != nil && cfg.MailConfig.Http != nil {
+		for name, value := range cfg.MailConfig.Http.Headers {
+			if !secret.IsEncrypted(value) {
+				continue
+			}
+			plaintext, err := secret.Decrypt(value)
+			if err != nil {
+				return fmt.Errorf("mailConfig.http.headers.%s decrypt error: %s", name, err.Error())
+			}
+			cfg.MailConfig.Http.Headers[name] = plaintext
+		}
+	}
+	return nil
+}
+
+// ReadYamlValue returns the raw value at keys (e.g. "smtpConfig", "password") in server.yaml
+func ReadYamlValue(keys ...string) (string, bool) {
+	root, err := readYamlNode()
+	if err != nil {
+		return "", false
+	}
+	node := findYamlNode(root, keys)
+	if node == nil {
+		return "", false
+	}
+	return node.Value, true
+}
+
+// UpdateYamlValue replaces one existing scalar in server.yaml and keeps the comments of the file
+func UpdateYamlValue(value string, keys ...string) error {
+	root, err := readYamlNode()
+	if err != nil {
+		return err
+	}
+	node := findYamlNode(root, keys)
+	if node == nil {
+		return fmt.Errorf("%v not found in %s", keys, configFile)
+	}
+	node.Value = value
+	node.Tag = "!!str"
+	node.Style = yaml.DoubleQuotedStyle
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(root)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, []byte(buf.String()), 0600)
+}
+
+func readYamlNode() (*yaml.Node, error) {
+	bytes, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	err = yaml.Unmarshal(bytes, &root)
+	if err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+func findYamlNode(node *yaml.Node, keys []string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
 }
 
 func EnsureDir(dir string) error {
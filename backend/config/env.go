@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Every ServerConfig field can be overridden with an environment variable, the name is built from the
+// env tag of the section and the yaml key of the field, e.g. db.dsn -> RDAPI_DB_DSN,
+// smtpConfig.password -> RDAPI_SMTP_PASSWORD. Appending _FILE reads the value from a file (docker secrets).
+const envPrefix = "RDAPI_"
+
+// applyEnv sets every field that has an environment variable
+func applyEnv(cfg *ServerConfig) error {
+	errs := make([]string, 0)
+	walkEnv(reflect.ValueOf(cfg).Elem(), envPrefix, func(name string, field reflect.Value) {
+		value, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+		if !ok {
+			return
+		}
+		if err = setField(field, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// EnvNames returns the environment variable of every config field, sorted
+func EnvNames() []string {
+	names := make([]string, 0)
+	walkEnv(reflect.ValueOf(GetDefaultServerConfig()).Elem(), envPrefix, func(name string, field reflect.Value) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+func walkEnv(v reflect.Value, prefix string, fn func(name string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		field := v.Field(i)
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || field.Kind() == reflect.Map || field.Kind() == reflect.Slice {
+			continue
+		}
+
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Struct {
+			// sections use their env tag (or nothing), nested structs use their yaml key
+			sectionPrefix := prefix
+			if env, ok := sf.Tag.Lookup("env"); ok {
+				if env != "" {
+					sectionPrefix += env + "_"
+				}
+			} else if prefix != envPrefix {
+				sectionPrefix += envName(key) + "_"
+			}
+			walkEnv(field, sectionPrefix, fn)
+			continue
+		}
+		fn(prefix+envName(key), field)
+	}
+}
+
+// lookupEnv reads NAME, or the content of the file in NAME_FILE
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	file, ok := os.LookupEnv(name + "_FILE")
+	if !ok || file == "" {
+		return "", false, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %s", name, err.Error())
+	}
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
+
+// envName turns a yaml key into an environment variable name, e.g. printRequestLog -> PRINT_REQUEST_LOG
+func envName(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
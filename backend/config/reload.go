@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restartFields are only read at startup, changing them needs a restart.
+// Everything else is read through GetServerConfig or rebuilt by an OnReload listener.
+var restartFields = []string{
+	"db",
+	"signKey",
+	"httpConfig.port",
+	"httpConfig.staticdir",
+	"security",
+	"reload",
+	"jobsConfig.instanceId",
+	"jobsConfig.leaseSeconds",
+	"rustdesk",
+}
+
+type ReloadResult struct {
+	Changed         []string  `json:"changed"`
+	RestartRequired []string  `json:"restartRequired"`
+	ReloadedAt      time.Time `json:"reloadedAt"`
+}
+
+var (
+	reloadMu        sync.Mutex
+	reloadListeners []*reloadListener
+	startupConfig   *ServerConfig
+	lastReload      *ReloadResult
+)
+
+type reloadListener struct {
+	fn func(old, cfg *ServerConfig)
+}
+
+// OnReload registers fn, it is called after a reloaded config replaced old.
+// Listeners run one after another, rebuild your subsystem and swap it in, don't block.
+// The returned func unregisters fn.
+func OnReload(fn func(old, cfg *ServerConfig)) (remove func()) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listener := &reloadListener{fn: fn}
+	reloadListeners = append(reloadListeners, listener)
+	return func() {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		for i, l := range reloadListeners {
+			if l == listener {
+				reloadListeners = append(reloadListeners[:i:i], reloadListeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Reload reads the config again and swaps it in, an invalid config is rejected and the current one is kept
+func Reload() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := GetServerConfig()
+	cfg, err := LoadServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	SetServerConfig(cfg)
+
+	for _, l := range reloadListeners {
+		l.fn(old, cfg)
+	}
+
+	result := &ReloadResult{
+		Changed:         diff(old, cfg),
+		RestartRequired: restartRequired(),
+		ReloadedAt:      time.Now(),
+	}
+	lastReload = result
+	return result, nil
+}
+
+// LastReload returns the result of the last successful reload, nil when the config was never reloaded
+func LastReload() *ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return lastReload
+}
+
+// RestartRequired returns the settings that changed since startup but are only applied by a restart
+func RestartRequired() []string {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return restartRequired()
+}
+
+func restartRequired() []string {
+	configMu.RLock()
+	startup, current := startupConfig, serverConfig
+	configMu.RUnlock()
+	if startup == nil || current == nil {
+		return []string{}
+	}
+	fields := make([]string, 0)
+	for _, field := range diff(startup, current) {
+		if isRestartField(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func isRestartField(field string) bool {
+	for _, f := range restartFields {
+		if field == f || strings.HasPrefix(field, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed reports whether any setting below prefix (e.g. "smtpConfig") differs between old and cfg
+func Changed(old, cfg *ServerConfig, prefix string) bool {
+	for _, field := range diff(old, cfg) {
+		if field == prefix || strings.HasPrefix(field, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diff returns the yaml paths of the settings that differ, e.g. smtpConfig.host
+func diff(old, cfg *ServerConfig) []string {
+	a, b := flatten(old), flatten(cfg)
+	fields := make([]string, 0)
+	for key, value := range b {
+		if a[key] != value {
+			fields = append(fields, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func flatten(cfg *ServerConfig) map[string]string {
+	values := make(map[string]string)
+	if cfg != nil {
+		flattenValue(reflect.ValueOf(cfg).Elem(), "", values)
+	}
+	return values
+}
+
+func flattenValue(v reflect.Value, prefix string, values map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		flattenField(v.Field(i), prefix+key, values)
+	}
+}
+
+// flattenField adds a value, list items are keyed by their index, e.g. syslog.targets.0.address
+func flattenField(field reflect.Value, key string, values map[string]string) {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return
+		}
+		field = field.Elem()
+	}
+	switch field.Kind() {
+	case reflect.Struct:
+		flattenValue(field, key+".", values)
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			flattenField(field.Index(i), key+"."+strconv.Itoa(i), values)
+		}
+	default:
+		values[key] = fmt.Sprint(field.Interface())
+	}
+}
+
+// WatchConfigFile polls the config file and reloads it when it changes, until stop is closed
+func WatchConfigFile(interval time.Duration, stop <-chan struct{}, onReload func(result *ReloadResult, err error)) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(configFile)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m, s := stat()
+			if s < 0 || (m.Equal(modTime) && s == size) {
+				continue
+			}
+			modTime, size = m, s
+			onReload(Reload())
+		}
+	}
+}
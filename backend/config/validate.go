@@ -0,0 +1,275 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"rustdesk-api-server-pro/helper/logger"
+	"rustdesk-api-server-pro/helper/mailer"
+	"rustdesk-api-server-pro/helper/syslog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minSignKeyLength = 16
+
+// ValidationError holds every invalid field, so all of them can be fixed at once
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Errors, "\n  ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Errors = append(e.Errors, field+": "+fmt.Sprintf(format, args...))
+}
+
+// Validate checks the config, the error is a *ValidationError
+func (cfg *ServerConfig) Validate() error {
+	e := &ValidationError{}
+
+	if cfg.SignKey == "" {
+		e.add("signKey", "is required (or set %sSIGN_KEY)", envPrefix)
+	} else if len(cfg.SignKey) < minSignKeyLength {
+		e.add("signKey", "must be at least %d characters", minSignKeyLength)
+	}
+
+	if cfg.Db == nil {
+		e.add("db", "is required")
+	} else {
+		switch cfg.Db.Driver {
+		case "sqlite", "mysql":
+		default:
+			e.add("db.driver", "must be sqlite or mysql, got %q", cfg.Db.Driver)
+		}
+		if cfg.Db.Dsn == "" {
+			e.add("db.dsn", "is required")
+		}
+		if _, err := time.LoadLocation(cfg.Db.TimeZone); err != nil {
+			e.add("db.timeZone", "unknown time zone %q", cfg.Db.TimeZone)
+		}
+	}
+
+	if cfg.HttpConfig == nil {
+		e.add("httpConfig", "is required")
+	} else {
+		_, port, err := net.SplitHostPort(cfg.HttpConfig.Port)
+		if err != nil {
+			e.add("httpConfig.port", "must be [host]:port, got %q", cfg.HttpConfig.Port)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			e.add("httpConfig.port", "invalid port %q", port)
+		}
+		if cfg.HttpConfig.ExternalLinks != nil {
+			links := cfg.HttpConfig.ExternalLinks
+			for i, link := range []*ExternalDownload{links.Windows, links.MacOS, links.Linux} {
+				if link != nil && link.URL != "" && !strings.HasPrefix(link.URL, "http://") && !strings.HasPrefix(link.URL, "https://") {
+					e.add("httpConfig.externalLinks."+[]string{"windows", "macos", "linux"}[i]+".url", "must be an http(s) url")
+				}
+			}
+		}
+	}
+
+	if cfg.SmtpConfig != nil {
+		switch cfg.SmtpConfig.Encryption {
+		case "", "none", "ssl/tls", "starttls":
+		default:
+			e.add("smtpConfig.encryption", "must be none, ssl/tls or starttls, got %q", cfg.SmtpConfig.Encryption)
+		}
+		if cfg.SmtpConfig.Host != "" && (cfg.SmtpConfig.Port <= 0 || cfg.SmtpConfig.Port > 65535) {
+			e.add("smtpConfig.port", "invalid port %d", cfg.SmtpConfig.Port)
+		}
+	}
+
+	if m := cfg.MailConfig; m != nil {
+		switch m.Transport {
+		case mailer.TRANSPORT_SMTP:
+			if m.Smtp != nil && m.Smtp.PoolSize < 1 {
+				e.add("mailConfig.smtp.poolSize", "must be 1 or more")
+			}
+			if m.Smtp != nil && m.Smtp.IdleSeconds < 0 {
+				e.add("mailConfig.smtp.idleSeconds", "must not be negative")
+			}
+		case mailer.TRANSPORT_SENDMAIL:
+			if m.Sendmail == nil || m.Sendmail.Path == "" {
+				e.add("mailConfig.sendmail.path", "is required by the sendmail transport")
+			}
+		case mailer.TRANSPORT_HTTP:
+			if m.Http == nil {
+				e.add("mailConfig.http.url", "is required by the http transport")
+				break
+			}
+			if u, err := url.Parse(m.Http.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				e.add("mailConfig.http.url", "must be an http or https url, got %q", m.Http.Url)
+			}
+			if _, err := mailer.ParseHttpBody(m.Http.Body); err != nil {
+				e.add("mailConfig.http.body", "invalid template: %s", err)
+			}
+		case mailer.TRANSPORT_FILE:
+			if m.File == nil || m.File.Dir == "" {
+				e.add("mailConfig.file.dir", "is required by the file transport")
+			}
+		default:
+			e.add("mailConfig.transport", "must be one of %s, got %q", strings.Join(mailer.Transports, ", "), m.Transport)
+		}
+	}
+
+	if cfg.JobsConfig != nil && cfg.JobsConfig.DeviceCheckJob != nil && cfg.JobsConfig.DeviceCheckJob.Duration <= 0 {
+		e.add("jobsConfig.deviceCheckJob.duration", "must be greater than 0")
+	}
+
+	if cfg.HttpConfig != nil {
+		if cfg.HttpConfig.DownloadLinkHours < 1 {
+			e.add("httpConfig.downloadLinkHours", "must be 1 or more")
+		}
+		for i, proxy := range cfg.HttpConfig.TrustedProxies {
+			if _, err := ParseTrustedProxy(proxy); err != nil {
+				e.add(fmt.Sprintf("httpConfig.trustedProxies[%d]", i), "must be an IP or a CIDR, got %q", proxy)
+			}
+		}
+	}
+
+	if r := cfg.Rustdesk; r != nil {
+		if r.Supervise && r.BinDir == "" {
+			e.add("rustdesk.binDir", "is required to supervise hbbs and hbbr")
+		}
+		if r.HbbsPort < 0 || r.HbbsPort > 65535 {
+			e.add("rustdesk.hbbsPort", "must be a port, got %d", r.HbbsPort)
+		}
+		if r.HbbrPort < 0 || r.HbbrPort > 65535 {
+			e.add("rustdesk.hbbrPort", "must be a port, got %d", r.HbbrPort)
+		}
+		if r.MaxBackoff < 1 {
+			e.add("rustdesk.maxBackoff", "must be 1 or more")
+		}
+		if u, err := url.Parse(r.ReleasesUrl); r.ReleasesUrl != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+			e.add("rustdesk.releasesUrl", "must be an http or https url, got %q", r.ReleasesUrl)
+		}
+		if r.ReleasesCacheMinutes < 0 {
+			e.add("rustdesk.releasesCacheMinutes", "must not be negative")
+		}
+	}
+
+	if cfg.JobsConfig != nil && cfg.JobsConfig.LeaseSeconds < 3 {
+		e.add("jobsConfig.leaseSeconds", "must be 3 or more")
+	}
+	if cfg.JobsConfig != nil && cfg.JobsConfig.RetentionJob != nil && cfg.JobsConfig.RetentionJob.Duration <= 0 {
+		e.add("jobsConfig.retentionJob.duration", "must be greater than 0")
+	}
+	if cfg.JobsConfig != nil && cfg.JobsConfig.MailQueueJob != nil {
+		q := cfg.JobsConfig.MailQueueJob
+		if q.Duration <= 0 {
+			e.add("jobsConfig.mailQueueJob.duration", "must be greater than 0")
+		}
+		if q.BatchSize <= 0 {
+			e.add("jobsConfig.mailQueueJob.batchSize", "must be greater than 0")
+		}
+		if q.MaxAttempts < 1 {
+			e.add("jobsConfig.mailQueueJob.maxAttempts", "must be 1 or more")
+		}
+		if q.BackoffSeconds < 0 {
+			e.add("jobsConfig.mailQueueJob.backoffSeconds", "must not be negative")
+		}
+		if q.MaxBackoffSeconds < q.BackoffSeconds {
+			e.add("jobsConfig.mailQueueJob.maxBackoffSeconds", "must not be less than backoffSeconds")
+		}
+	}
+
+	if cfg.Retention != nil {
+		if cfg.Retention.BatchSize <= 0 {
+			e.add("retention.batchSize", "must be greater than 0")
+		}
+		policies := map[string]*RetentionPolicy{
+			"audit":        cfg.Retention.Audit,
+			"fileTransfer": cfg.Retention.FileTransfer,
+			"alarm":        cfg.Retention.Alarm,
+			"mailLogs":     cfg.Retention.MailLogs,
+			"verifyCode":   cfg.Retention.VerifyCode,
+			"authToken":    cfg.Retention.AuthToken,
+		}
+		for _, key := range []string{"audit", "fileTransfer", "alarm", "mailLogs", "verifyCode", "authToken"} {
+			policy := policies[key]
+			if policy == nil {
+				continue
+			}
+			if policy.Days < 0 {
+				e.add("retention."+key+".days", "must be 0 (keep forever) or more")
+			}
+			if policy.Archive && cfg.Retention.ArchiveDir == "" {
+				e.add("retention.archiveDir", "is required to archive %s", key)
+			}
+		}
+	}
+
+	if cfg.Syslog != nil {
+		for i, target := range cfg.Syslog.Targets {
+			key := fmt.Sprintf("syslog.targets.%d", i)
+			if target == nil {
+				e.add(key, "is empty")
+				continue
+			}
+			switch target.Network {
+			case "udp", "tcp", "tls":
+			default:
+				e.add(key+".network", "must be udp, tcp or tls, got %q", target.Network)
+			}
+			if _, _, err := net.SplitHostPort(target.Address); err != nil {
+				e.add(key+".address", "must be host:port, got %q", target.Address)
+			}
+			switch target.Format {
+			case "", "rfc5424", "cef":
+			default:
+				e.add(key+".format", "must be rfc5424 or cef, got %q", target.Format)
+			}
+			if _, ok := syslog.Facility(target.Facility); target.Facility != "" && !ok {
+				e.add(key+".facility", "unknown facility %q", target.Facility)
+			}
+			for _, pattern := range target.Events {
+				if _, err := path.Match(pattern, ""); err != nil {
+					e.add(key+".events", "invalid pattern %q", pattern)
+				}
+			}
+			if target.TlsCaFile != "" {
+				if _, err := os.Stat(target.TlsCaFile); err != nil {
+					e.add(key+".tlsCaFile", "%s", err.Error())
+				}
+			}
+		}
+	}
+
+	if cfg.Log != nil {
+		if _, err := logger.ParseLevel(cfg.Log.Level); err != nil {
+			e.add("log.level", "must be debug, info, warn or error, got %q", cfg.Log.Level)
+		}
+		for module, level := range cfg.Log.Modules {
+			if _, err := logger.ParseLevel(level); err != nil {
+				e.add("log.modules."+module, "must be debug, info, warn or error, got %q", level)
+			}
+		}
+		switch cfg.Log.Format {
+		case "", logger.FORMAT_TEXT, logger.FORMAT_JSON:
+		default:
+			e.add("log.format", "must be text or json, got %q", cfg.Log.Format)
+		}
+		if cfg.Log.MaxSizeMB < 0 || cfg.Log.MaxBackups < 0 {
+			e.add("log", "maxSizeMB and maxBackups must be 0 or more")
+		}
+	}
+
+	if cfg.Shutdown != nil && cfg.Shutdown.Timeout <= 0 {
+		e.add("shutdown.timeout", "must be greater than 0")
+	}
+
+	if cfg.Reload != nil && cfg.Reload.WatchFile && cfg.Reload.WatchInterval <= 0 {
+		e.add("reload.watchInterval", "must be greater than 0")
+	}
+
+	if len(e.Errors) > 0 {
+		return e
+	}
+	return nil
+}
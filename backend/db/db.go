@@ -2,6 +2,7 @@ package db
 
 import (
 	"rustdesk-api-server-pro/config"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -12,7 +13,7 @@ import (
 var DbEngine *xorm.Engine
 
 func NewEngine(cfg *config.DbConfig) (*xorm.Engine, error) {
-	engine, err := xorm.NewEngine(cfg.Driver, cfg.Dsn)
+	engine, err := xorm.NewEngine(cfg.Driver, sqliteDsn(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -25,3 +26,16 @@ func NewEngine(cfg *config.DbConfig) (*xorm.Engine, error) {
 	DbEngine = engine
 	return engine, nil
 }
+
+// sqliteDsn waits for the write lock instead of failing with SQLITE_BUSY when the jobs and the requests write at
+// the same time, unless the dsn sets its own busy_timeout
+func sqliteDsn(cfg *config.DbConfig) string {
+	if cfg.Driver != "sqlite" || strings.Contains(cfg.Dsn, "busy_timeout") {
+		return cfg.Dsn
+	}
+	sep := "?"
+	if strings.Contains(cfg.Dsn, "?") {
+		sep = "&"
+	}
+	return cfg.Dsn + sep + "_pragma=busy_timeout(5000)"
+}
@@ -0,0 +1,120 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_XLSX = "xlsx"
+)
+
+// Writer writes a table row by row, nothing is buffered beyond the current row
+type Writer interface {
+	WriteRow(values ...interface{}) error
+	Close() error
+}
+
+func IsValidFormat(format string) bool {
+	return format == FORMAT_CSV || format == FORMAT_XLSX
+}
+
+func ContentType(format string) string {
+	if format == FORMAT_XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewWriter returns a writer of format, the first row is header
+func NewWriter(format string, w io.Writer, sheet string, header []string) (Writer, error) {
+	var writer Writer
+	switch format {
+	case FORMAT_CSV:
+		writer = newCsvWriter(w)
+	case FORMAT_XLSX:
+		x, err := newXlsxWriter(w, sheet)
+		if err != nil {
+			return nil, err
+		}
+		writer = x
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+
+	values := make([]interface{}, len(header))
+	for i, h := range header {
+		values[i] = h
+	}
+	if err := writer.WriteRow(values...); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCsvWriter(w io.Writer) *csvWriter {
+	// the BOM makes excel open the file as utf-8
+	_, _ = w.Write([]byte("\xEF\xBB\xBF"))
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) WriteRow(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatValue(v)
+		if _, ok := v.(string); ok {
+			record[i] = csvText(record[i])
+		}
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	// flush every row, the response is streamed
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvText keeps a spreadsheet from running a text cell as a formula, peer names and paths come from the clients
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
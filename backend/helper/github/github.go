@@ -3,8 +3,13 @@ package github
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/helper/logger"
 	"rustdesk-api-server-pro/util"
+	"strings"
+	"time"
 )
 
 type Release struct {
@@ -31,52 +36,136 @@ type Asset struct {
 	CreatedAt          string `json:"created_at"`
 	UpdatedAt          string `json:"updated_at"`
 	BrowserDownloadURL string `json:"browser_download_url"`
+	Digest             string `json:"digest"` // sha256:<hex>, set by github for the assets uploaded since 2025
 }
 
-func GetReleases(repo string) *[]Release {
-	repo = fmt.Sprintf("https://api.github.com/repos/%s/releases", repo)
-	resp, err := util.HttpGetString(repo)
-	if err != nil {
-		fmt.Println("GetReleases:: Request error:", err)
-		os.Exit(0)
+// ApiBaseUrl is the github api the releases are read from, or a mirror serving the same json
+var ApiBaseUrl = "https://api.github.com"
+
+var log = logger.Module("github")
+
+var (
+	cacheDir string
+	cacheTTL time.Duration
+)
+
+// SetReleasesCache keeps the releases lists in dir for ttl, an empty dir or a ttl of 0 disables the cache.
+// An expired list is still used when the api can not be reached.
+func SetReleasesCache(dir string, ttl time.Duration) {
+	cacheDir, cacheTTL = dir, ttl
+}
+
+type releasesCache struct {
+	Url      string    `json:"url"`
+	Releases []Release `json:"releases"`
+}
+
+// FetchReleases returns the releases of repo, newest first
+func FetchReleases(repo string) ([]Release, error) {
+	if releases, ok := cachedReleases(repo, false); ok {
+		return releases, nil
 	}
-	releases := &[]Release{}
-	err = json.Unmarshal([]byte(resp), releases)
+	releases := make([]Release, 0)
+	err := getJson(fmt.Sprintf("%s/repos/%s/releases", ApiBaseUrl, repo), &releases)
 	if err != nil {
-		fmt.Println("GetReleases:: json.Unmarshal:", err)
-		os.Exit(0)
+		if cached, ok := cachedReleases(repo, true); ok {
+			log.Warn("Releases api unreachable, using the cached list", "repo", repo, "error", err)
+			return cached, nil
+		}
+		return releases, err
 	}
-	return releases
+	writeReleasesCache(repo, releases)
+	return releases, nil
 }
 
-func GetLatestRelease(repo string) *Release {
-	repo = fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
-	resp, err := util.HttpGetString(repo)
-	if err != nil {
-		fmt.Println("GetLatestRelease:: Request error:", err)
-		os.Exit(0)
+// FetchRelease returns the release with tag, latest returns the latest release
+func FetchRelease(repo, tag string) (*Release, error) {
+	if releases, ok := cachedReleases(repo, false); ok {
+		if release := findRelease(releases, tag); release != nil {
+			return release, nil
+		}
+	}
+	url := fmt.Sprintf("%s/repos/%s/releases/tags/%s", ApiBaseUrl, repo, tag)
+	if tag == "latest" {
+		url = fmt.Sprintf("%s/repos/%s/releases/latest", ApiBaseUrl, repo)
 	}
 	release := &Release{}
-	err = json.Unmarshal([]byte(resp), release)
+	if err := getJson(url, release); err != nil {
+		if releases, ok := cachedReleases(repo, true); ok {
+			if cached := findRelease(releases, tag); cached != nil {
+				log.Warn("Releases api unreachable, using the cached release", "repo", repo, "tag", tag, "error", err)
+				return cached, nil
+			}
+		}
+		return nil, err
+	}
+	return release, nil
+}
+
+// findRelease looks tag up in releases, latest is the newest release that is neither a draft nor a prerelease
+func findRelease(releases []Release, tag string) *Release {
+	for i, r := range releases {
+		if r.TagName == tag || (tag == "latest" && !r.Draft && !r.Prerelease) {
+			return &releases[i]
+		}
+	}
+	return nil
+}
+
+func cacheFile(repo string) string {
+	return filepath.Join(cacheDir, "releases-"+strings.ReplaceAll(repo, "/", "_")+".json")
+}
+
+// cachedReleases reads the cached list of repo, expired ones only with stale
+func cachedReleases(repo string, stale bool) ([]Release, bool) {
+	if cacheDir == "" || cacheTTL <= 0 {
+		return nil, false
+	}
+	file := cacheFile(repo)
+	info, err := os.Stat(file)
+	if err != nil || (!stale && time.Since(info.ModTime()) > cacheTTL) {
+		return nil, false
+	}
+	b, err := os.ReadFile(file)
 	if err != nil {
-		fmt.Println("GetLatestRelease:: json.Unmarshal:", err)
-		os.Exit(0)
+		return nil, false
+	}
+	cache := &releasesCache{}
+	// a list read from another url is not used
+	if err = json.Unmarshal(b, cache); err != nil || cache.Url != ApiBaseUrl {
+		return nil, false
 	}
-	return release
+	return cache.Releases, true
 }
 
-func GetReleaseByTag(repo, tag string) *Release {
-	repo = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repo, tag)
-	resp, err := util.HttpGetString(repo)
+func writeReleasesCache(repo string, releases []Release) {
+	if cacheDir == "" || cacheTTL <= 0 {
+		return
+	}
+	b, err := json.Marshal(&releasesCache{Url: ApiBaseUrl, Releases: releases})
+	if err == nil {
+		err = os.MkdirAll(cacheDir, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(cacheFile(repo), b, 0644)
+	}
 	if err != nil {
-		fmt.Println("GetReleaseByTag:: Request error:", err)
-		os.Exit(0)
+		log.Warn("Write releases cache error", "repo", repo, "error", err)
 	}
-	release := &Release{}
-	err = json.Unmarshal([]byte(resp), release)
+}
+
+func getJson(url string, v interface{}) error {
+	client, err := util.HttpClient()
 	if err != nil {
-		fmt.Println("GetReleaseByTag:: json.Unmarshal:", err)
-		os.Exit(0)
+		return err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
 	}
-	return release
+	return json.NewDecoder(resp.Body).Decode(v)
 }
@@ -0,0 +1,172 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+// REQUEST_ID_KEY is the attribute holding the id of the http request
+const REQUEST_ID_KEY = "request_id"
+
+type Options struct {
+	Level      string            // debug info warn error
+	Format     string            // text json
+	Modules    map[string]string // per module levels, e.g. http: warn
+	File       string            // empty logs to stdout
+	MaxSizeMB  int               // rotates the file at this size, 0 never rotates
+	MaxBackups int               // rotated files to keep
+}
+
+type state struct {
+	handler slog.Handler
+	level   slog.Level
+	modules map[string]slog.Level
+	closer  io.Closer
+}
+
+var (
+	current   *state
+	currentMu sync.RWMutex
+)
+
+func init() {
+	current = &state{handler: newHandler(os.Stdout, FORMAT_TEXT), level: slog.LevelInfo, modules: map[string]slog.Level{}}
+}
+
+// ParseLevel accepts debug, info, warn (warning) and error
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, errors.New("unknown log level " + level)
+}
+
+// Configure swaps the output of every logger, the loggers returned by Module before keep working
+func Configure(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	modules := make(map[string]slog.Level, len(opts.Modules))
+	for module, l := range opts.Modules {
+		if modules[module], err = ParseLevel(l); err != nil {
+			return errors.New(module + ": " + err.Error())
+		}
+	}
+	switch opts.Format {
+	case "", FORMAT_TEXT, FORMAT_JSON:
+	default:
+		return errors.New("unknown log format " + opts.Format)
+	}
+
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+	if opts.File != "" {
+		w, err := NewRotateWriter(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+		out, closer = w, w
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = &state{handler: newHandler(out, opts.Format), level: level, modules: modules, closer: closer}
+	currentMu.Unlock()
+	if previous.closer != nil {
+		_ = previous.closer.Close()
+	}
+	return nil
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	// the level is checked by moduleHandler, so the module levels can be lower than the default one
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactAttr}
+	if format == FORMAT_JSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Module returns the logger of a module like http, jobs or admin
+func Module(name string) *slog.Logger {
+	return slog.New(&moduleHandler{module: name})
+}
+
+type contextKey struct{}
+
+// WithRequestId stores the request id, the records logged with the context get a request_id attribute
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// moduleHandler resolves the current output and level on every record, so Configure also applies
+// to the loggers held by services and controllers
+type moduleHandler struct {
+	module string
+	with   []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	min, ok := current.modules[h.module]
+	if !ok {
+		min = current.level
+	}
+	return level >= min
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, record slog.Record) error {
+	currentMu.RLock()
+	handler := current.handler
+	currentMu.RUnlock()
+
+	handler = handler.WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	for _, fn := range h.with {
+		handler = fn(handler)
+	}
+	if id := RequestId(ctx); id != "" {
+		record.AddAttrs(slog.String(REQUEST_ID_KEY, id))
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.clone(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.clone(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *moduleHandler) clone(fn func(slog.Handler) slog.Handler) *moduleHandler {
+	with := make([]func(slog.Handler) slog.Handler, len(h.with), len(h.with)+1)
+	copy(with, h.with)
+	return &moduleHandler{module: h.module, with: append(with, fn)}
+}
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const REDACTED = "[REDACTED]"
+
+// secretKeys are compared lower case without - and _
+var secretKeys = map[string]bool{
+	"password":         true,
+	"oldpassword":      true,
+	"newpassword":      true,
+	"token":            true,
+	"accesstoken":      true,
+	"authorization":    true,
+	"cookie":           true,
+	"setcookie":        true,
+	"secret":           true,
+	"tfacode":          true,
+	"verificationcode": true,
+	"captcha":          true,
+	"apikey":           true,
+	"xapikey":          true,
+}
+
+// IsSecret reports whether a header, json field or log attribute with this name must not be logged
+func IsSecret(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(key))
+	return secretKeys[key]
+}
+
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if IsSecret(attr.Key) {
+		return slog.String(attr.Key, REDACTED)
+	}
+	return attr
+}
+
+// RedactHeaders returns the headers as a map with the secret values replaced
+func RedactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if IsSecret(name) {
+			headers[name] = REDACTED
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+// RedactBody replaces the secret fields of a json body at any depth, other bodies are only shortened to limit bytes
+func RedactBody(body []byte, limit int) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		redactValue(v)
+		if b, err := json.Marshal(v); err == nil {
+			body = b
+		}
+	}
+	if limit > 0 && len(body) > limit {
+		return string(body[:limit]) + "..."
+	}
+	return string(body)
+}
+
+func redactValue(v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if IsSecret(key) {
+				value[key] = REDACTED
+				continue
+			}
+			redactValue(item)
+		}
+	case []interface{}:
+		for _, item := range value {
+			redactValue(item)
+		}
+	}
+}
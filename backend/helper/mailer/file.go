@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"os"
+	"time"
+)
+
+// FileTransport writes each message to an .eml file in Dir, for development and tests
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Send(msg *Message) error {
+	raw, err := Raw(msg)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(t.Dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(t.Dir, time.Now().Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(raw)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (t *FileTransport) Close() error {
+	return nil
+}
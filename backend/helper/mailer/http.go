@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// DefaultHttpBody is the request body when HttpOptions.Body is empty
+const DefaultHttpBody = `{"from": {{json .From}}, "to": [{{json .To}}], "subject": {{json .Subject}}, "html": {{json .Html}}, ` +
+	`"text": {{json .Text}}, "attachments": [{{range $i, $a := .Attachments}}{{if $i}}, {{end}}` +
+	`{"filename": {{json $a.Name}}, "content_type": {{json $a.MimeType}}, "content": {{base64 $a.Data}}}{{end}}]}`
+
+// httpBodyFuncs quote the values for a JSON body, base64 encodes the attachments
+var httpBodyFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"base64": func(data []byte) string {
+		b, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+		return string(b)
+	},
+}
+
+type HttpOptions struct {
+	Url     string
+	Method  string
+	Headers map[string]string
+	// Body is a text/template of the request body over the Message, DefaultHttpBody when empty
+	Body    string
+	Timeout time.Duration
+}
+
+// HttpTransport posts the messages to a mail API, any 2xx status is a success
+type HttpTransport struct {
+	opts   HttpOptions
+	body   *template.Template
+	client *http.Client
+}
+
+// ParseHttpBody checks a request body template
+func ParseHttpBody(body string) (*template.Template, error) {
+	if body == "" {
+		body = DefaultHttpBody
+	}
+	return template.New("body").Funcs(httpBodyFuncs).Option("missingkey=error").Parse(body)
+}
+
+func NewHttpTransport(opts HttpOptions) (*HttpTransport, error) {
+	body, err := ParseHttpBody(opts.Body)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+	return &HttpTransport{opts: opts, body: body, client: &http.Client{Timeout: opts.Timeout}}, nil
+}
+
+func (t *HttpTransport) Send(msg *Message) error {
+	var body bytes.Buffer
+	if err := t.body.Execute(&body, msg); err != nil {
+		return fmt.Errorf("request body error: %w", err)
+	}
+	req, err := http.NewRequest(t.opts.Method, t.opts.Url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mail api error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(fmt.Sprintf("mail api status %d", resp.StatusCode), resp.Body)
+	}
+	return nil
+}
+
+func (t *HttpTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package mailer delivers mails through a Transport: a pooled smtp server, the sendmail binary, an http mail API or
+// .eml files.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+const (
+	TRANSPORT_SMTP     = "smtp"
+	TRANSPORT_SENDMAIL = "sendmail"
+	TRANSPORT_HTTP     = "http"
+	TRANSPORT_FILE     = "file"
+)
+
+var Transports = []string{TRANSPORT_SMTP, TRANSPORT_SENDMAIL, TRANSPORT_HTTP, TRANSPORT_FILE}
+
+type Attachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// Message is a mail to one recipient, it is multipart/alternative when it has a Text part
+type Message struct {
+	From        string
+	To          string
+	Subject     string
+	Html        string
+	Text        string
+	Attachments []Attachment
+}
+
+// Transport sends messages, it is safe for concurrent use. Close releases its connections, a closed transport
+// may still finish the sends in progress.
+type Transport interface {
+	Send(msg *Message) error
+	Close() error
+}
+
+// Build returns the go-simple-mail message of msg
+func Build(msg *Message) (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(msg.From)
+	email.AddTo(msg.To)
+	email.SetSubject(msg.Subject)
+	if msg.Text != "" {
+		// the html part last as the preferred one
+		email.SetBody(mail.TextPlain, msg.Text)
+		email.AddAlternative(mail.TextHTML, msg.Html)
+	} else {
+		email.SetBody(mail.TextHTML, msg.Html)
+	}
+	for _, a := range msg.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.MimeType, Data: a.Data})
+	}
+	if email.Error != nil {
+		return nil, fmt.Errorf("message error: %w", email.Error)
+	}
+	return email, nil
+}
+
+// Raw returns msg as a MIME message with CRLF line endings
+func Raw(msg *Message) ([]byte, error) {
+	email, err := Build(msg)
+	if err != nil {
+		return nil, err
+	}
+	raw := email.GetMessage()
+	if email.Error != nil {
+		return nil, fmt.Errorf("message error: %w", email.Error)
+	}
+	return []byte(raw), nil
+}
+
+// failedTransport is a transport that could not be built, each send reports why
+type failedTransport struct {
+	err error
+}
+
+func Failed(err error) Transport {
+	return &failedTransport{err: err}
+}
+
+func (t *failedTransport) Send(*Message) error {
+	return t.err
+}
+
+func (t *failedTransport) Close() error {
+	return nil
+}
+
+// responseError reads the start of an error response for the message of the error
+func responseError(prefix string, r io.Reader) error {
+	body, _ := io.ReadAll(io.LimitReader(r, 512))
+	if s := strings.TrimSpace(string(body)); s != "" {
+		return errors.New(prefix + ": " + s)
+	}
+	return errors.New(prefix)
+}
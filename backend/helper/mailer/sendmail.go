@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Sendmail pipes the messages to a sendmail compatible binary, with -t it takes the recipient from the To header
+type Sendmail struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+func (s *Sendmail) Send(msg *Message) error {
+	raw, err := Raw(msg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, s.Path, s.Args...)
+	// sendmail reads the message with the line endings of the system
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")))
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err = cmd.Run(); err != nil {
+		if s := strings.TrimSpace(out.String()); s != "" {
+			return fmt.Errorf("sendmail error: %w: %s", err, s)
+		}
+		return fmt.Errorf("sendmail error: %w", err)
+	}
+	return nil
+}
+
+func (s *Sendmail) Close() error {
+	return nil
+}
@@ -0,0 +1,156 @@
+package mailer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+type SmtpOptions struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string // none ssl/tls starttls
+	// PoolSize is the number of idle connections kept open, IdleTimeout closes the ones unused for longer
+	PoolSize       int
+	IdleTimeout    time.Duration
+	ConnectTimeout time.Duration
+	SendTimeout    time.Duration
+}
+
+// SmtpPool sends through an smtp server and keeps the connections open between the messages
+type SmtpPool struct {
+	server *mail.SMTPServer
+	size   int
+	idle   time.Duration
+
+	mu     sync.Mutex
+	conns  []*smtpConn // idle connections, the most recently used last
+	closed bool
+}
+
+type smtpConn struct {
+	client   *mail.SMTPClient
+	lastUsed time.Time
+}
+
+func NewSmtpPool(opts SmtpOptions) *SmtpPool {
+	server := mail.NewSMTPClient()
+	server.Host = opts.Host
+	server.Port = opts.Port
+	server.Username = opts.Username
+	server.Password = opts.Password
+	switch opts.Encryption {
+	case "ssl/tls":
+		server.Encryption = mail.EncryptionSSLTLS
+	case "starttls":
+		server.Encryption = mail.EncryptionSTARTTLS
+	default:
+		server.Encryption = mail.EncryptionNone
+	}
+	// a stalled server must not hold a queue worker
+	server.ConnectTimeout = opts.ConnectTimeout
+	server.SendTimeout = opts.SendTimeout
+	// the connection is reset instead of closed after a message
+	server.KeepAlive = true
+
+	size := opts.PoolSize
+	if size < 1 {
+		size = 1
+	}
+	return &SmtpPool{server: server, size: size, idle: opts.IdleTimeout}
+}
+
+func (p *SmtpPool) Send(msg *Message) error {
+	email, err := Build(msg)
+	if err != nil {
+		return err
+	}
+	conn, reused, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = email.Send(conn.client)
+	if err != nil && reused {
+		// the server may have dropped the idle connection, try once on a new one
+		closeSmtpConn(conn)
+		if conn, err = p.connect(); err != nil {
+			return err
+		}
+		err = email.Send(conn.client)
+	}
+	if err != nil {
+		closeSmtpConn(conn)
+		return fmt.Errorf("send error: %w", err)
+	}
+	p.put(conn)
+	return nil
+}
+
+// Close quits the idle connections, the ones in use are closed when their send is done
+func (p *SmtpPool) Close() error {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns, p.closed = nil, true
+	p.mu.Unlock()
+	for _, conn := range conns {
+		closeSmtpConn(conn)
+	}
+	return nil
+}
+
+// get takes the most recently used idle connection, the expired ones are closed on the way. A QUIT can take up to
+// the send timeout, they are closed after the lock is released so the other senders do not wait for it.
+func (p *SmtpPool) get() (*smtpConn, bool, error) {
+	var conn *smtpConn
+	expired := make([]*smtpConn, 0)
+	p.mu.Lock()
+	for len(p.conns) > 0 {
+		last := p.conns[len(p.conns)-1]
+		p.conns = p.conns[:len(p.conns)-1]
+		if p.idle > 0 && time.Since(last.lastUsed) > p.idle {
+			expired = append(expired, last)
+			continue
+		}
+		conn = last
+		break
+	}
+	p.mu.Unlock()
+	for _, c := range expired {
+		closeSmtpConn(c)
+	}
+
+	if conn != nil {
+		return conn, true, nil
+	}
+	conn, err := p.connect()
+	return conn, false, err
+}
+
+func (p *SmtpPool) connect() (*smtpConn, error) {
+	client, err := p.server.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("can not connect smtp server error: %w", err)
+	}
+	return &smtpConn{client: client}, nil
+}
+
+func (p *SmtpPool) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+	p.mu.Lock()
+	if p.closed || len(p.conns) >= p.size {
+		p.mu.Unlock()
+		closeSmtpConn(conn)
+		return
+	}
+	p.conns = append(p.conns, conn)
+	p.mu.Unlock()
+}
+
+func closeSmtpConn(conn *smtpConn) {
+	_ = conn.client.Quit()
+	_ = conn.client.Close()
+}
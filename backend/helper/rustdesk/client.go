@@ -0,0 +1,89 @@
+package rustdesk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// ClientConfig is the server configuration of a RustDesk client, the fields of its config string
+type ClientConfig struct {
+	Host  string `json:"host"`
+	Relay string `json:"relay"`
+	Api   string `json:"api"`
+	Key   string `json:"key"`
+}
+
+// Code returns the config string the client imports (Settings > Network > Import server config, or
+// rustdesk --config): the json of the config in base64, reversed and without padding
+func (c *ClientConfig) Code() string {
+	b, _ := json.Marshal(c)
+	code := []rune(strings.TrimRight(base64.StdEncoding.EncodeToString(b), "="))
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
+
+// ParseClientConfig reads a config string made by Code
+func ParseClientConfig(code string) (*ClientConfig, error) {
+	r := []rune(strings.TrimSpace(code))
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	b, err := base64.RawStdEncoding.DecodeString(string(r))
+	if err != nil {
+		return nil, errors.New("invalid config string")
+	}
+	c := &ClientConfig{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, errors.New("invalid config string")
+	}
+	return c, nil
+}
+
+// QRCode renders "config=<config string>", which the mobile clients scan in their ID/Relay server settings, as a png
+func (c *ClientConfig) QRCode(size int) ([]byte, error) {
+	code, err := qr.Encode("config="+c.Code(), qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+	if code, err = barcode.Scale(code, size, size); err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err = png.Encode(buf, code); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// InstallerName names the windows installer so the client configures itself when it is installed:
+// rustdesk-host=<host>,key=<key>,api=<api>,relay=<relay>,.exe. The trailing comma keeps the " (1)" a browser adds
+// to a repeated download out of the last value. Values a windows file name can not hold are left out, skipped
+// names them.
+func (c *ClientConfig) InstallerName() (name string, skipped []string) {
+	parts := []string{}
+	for _, field := range []struct{ name, value string }{
+		{"host", c.Host},
+		{"key", c.Key},
+		{"api", c.Api},
+		{"relay", c.Relay},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if strings.ContainsAny(field.value, `<>:"/\|?*,`) {
+			skipped = append(skipped, field.name)
+			continue
+		}
+		parts = append(parts, field.name+"="+field.value)
+	}
+	return "rustdesk-" + strings.Join(parts, ",") + ",.exe", skipped
+}
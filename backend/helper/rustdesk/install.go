@@ -0,0 +1,497 @@
+package rustdesk
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"rustdesk-api-server-pro/helper/github"
+	"rustdesk-api-server-pro/util"
+	"strings"
+)
+
+const REPO = "rustdesk/rustdesk-server"
+
+// versionFile records the release installed by an upgrade
+const versionFile = "version"
+
+// AssetName is the name of the rustdesk-server zip of this system and the directory of the zip holding the
+// binaries, empty when rustdesk-server has no build for it
+func AssetName() (name, archDir string) {
+	if runtime.GOOS == "windows" {
+		return "rustdesk-server-windows-x86_64.zip", "x86_64"
+	}
+	archDir, ok := linuxArchDirs[runtime.GOARCH]
+	if runtime.GOOS != "linux" || !ok {
+		return "", ""
+	}
+	return "rustdesk-server-linux-" + archDir + ".zip", archDir
+}
+
+var linuxArchDirs = map[string]string{
+	"amd64": "amd64",
+	"arm64": "arm64v8",
+	"arm":   "armv7",
+	"386":   "i386",
+}
+
+// AssetFor picks the rustdesk-server zip of this system, archDir is the directory of the zip holding the binaries
+func AssetFor(assets []github.Asset) (asset github.Asset, archDir string) {
+	name, archDir := AssetName()
+	for _, a := range assets {
+		if a.Name == name {
+			return a, archDir
+		}
+	}
+	// the windows zip was renamed over time
+	for _, a := range assets {
+		if runtime.GOOS == "windows" && strings.Contains(a.Name, "windows") && strings.HasSuffix(a.Name, ".zip") {
+			return a, archDir
+		}
+	}
+	return github.Asset{}, ""
+}
+
+// Download saves url to file, unlike util.DownloadFile it fails on an http error
+func Download(url, file string) error {
+	client, err := util.HttpClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func Sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifySha256 compares the sha256 of file with expected, a hex digest that may start with sha256:
+func VerifySha256(file, expected string) error {
+	expected = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(expected), "sha256:"))
+	if expected == "" {
+		return errors.New("no checksum to verify " + filepath.Base(file))
+	}
+	actual, err := Sha256File(file)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", filepath.Base(file), expected, actual)
+	}
+	return nil
+}
+
+// ParseChecksums reads a checksum file by file name: sha256sum lines ("<hex>  <name>" or "<hex> *<name>"),
+// BSD lines ("SHA256 (<name>) = <hex>") or a single digest, stored under ""
+func ParseChecksums(content string) map[string]string {
+	sums := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "SHA256 ("); ok {
+			if name, sum, ok := strings.Cut(rest, ") = "); ok {
+				sums[filepath.Base(name)] = strings.ToLower(strings.TrimSpace(sum))
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			sums[""] = strings.ToLower(fields[0])
+		case 2:
+			sums[filepath.Base(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+		}
+	}
+	return sums
+}
+
+// ReadChecksums reads a checksum file from a path or an http(s) url, see ParseChecksums
+func ReadChecksums(source string) (map[string]string, error) {
+	var b []byte
+	var err error
+	if isUrl(source) {
+		b, err = fetch(source)
+	} else {
+		b, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sums := ParseChecksums(string(b))
+	if len(sums) == 0 {
+		return nil, errors.New("no checksum in " + source)
+	}
+	return sums, nil
+}
+
+func fetch(url string) ([]byte, error) {
+	client, err := util.HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// checksumOf returns the checksum of name, the single digest of the file applies to any name
+func checksumOf(sums map[string]string, name string) string {
+	if sum, ok := sums[name]; ok {
+		return sum
+	}
+	if len(sums) == 1 {
+		return sums[""]
+	}
+	return ""
+}
+
+func isUrl(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// binaries are the files taken from a rustdesk-server zip
+func binaries() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"hbbs.exe", "hbbr.exe", "rustdesk-utils.exe"}
+	}
+	return []string{"hbbs", "hbbr", "rustdesk-utils"}
+}
+
+// ExtractBinaries extracts hbbs, hbbr and rustdesk-utils from a rustdesk-server zip to dst, whatever
+// directory of the zip they are in. hbbs and hbbr are required.
+func ExtractBinaries(zipFile, dst string) error {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range binaries() {
+		wanted[name] = true
+	}
+	found := map[string]bool{}
+	for _, f := range r.File {
+		name := filepath.Base(f.Name)
+		if f.FileInfo().IsDir() || !wanted[name] || found[name] {
+			continue
+		}
+		if err = extractFile(f, filepath.Join(dst, name)); err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	for _, name := range binaries()[:2] {
+		if !found[name] {
+			return fmt.Errorf("%s is not in %s", name, filepath.Base(zipFile))
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, dst string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, src); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ReplaceBinaries moves the binaries of srcDir to binDir, the replaced ones are kept as .bak.
+// rollback puts the replaced binaries back.
+func ReplaceBinaries(srcDir, binDir string) (rollback func() error, err error) {
+	replaced := make([]string, 0)
+	added := make([]string, 0)
+	rollback = func() error {
+		var errs []error
+		for _, dst := range added {
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+		for _, dst := range replaced {
+			if err := os.Rename(dst+".bak", dst); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, name := range binaries() {
+		src := filepath.Join(srcDir, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(binDir, name)
+		if _, err := os.Stat(dst); err == nil {
+			_ = os.Remove(dst + ".bak")
+			if err = os.Rename(dst, dst+".bak"); err != nil {
+				return rollback, err
+			}
+			replaced = append(replaced, dst)
+		} else {
+			added = append(added, dst)
+		}
+		if err = os.Rename(src, dst); err != nil {
+			return rollback, err
+		}
+	}
+	return rollback, nil
+}
+
+// InstalledVersion returns the release installed by the last upgrade, empty when unknown
+func InstalledVersion() string {
+	b, err := os.ReadFile(filepath.Join(serverBinDir, versionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func SetInstalledVersion(version string) error {
+	return os.WriteFile(filepath.Join(serverBinDir, versionFile), []byte(version+"\n"), 0644)
+}
+
+type InstallOptions struct {
+	From         string // a zip, a directory holding the zip or the binaries, or a mirror url, empty uses the releases api
+	Version      string // the release to download, latest when empty, recorded as the installed version
+	Sha256       string // expected sha256 of the zip
+	ChecksumFile string // path or url of a sha256sum file, for the zip or for each binary of a directory
+}
+
+type InstallResult struct {
+	Source   string `json:"source"`
+	Version  string `json:"version"`
+	Sha256   string `json:"sha256"`
+	Verified bool   `json:"verified"`
+}
+
+// Install puts hbbs, hbbr and rustdesk-utils in the bin dir, from the releases api or, without network access,
+// from a local zip, a directory or a mirror. The zip is checked against Sha256, the checksum file or the digest
+// published with the release, an install nothing could be checked against is reported as not verified.
+func Install(opts InstallOptions) (*InstallResult, error) {
+	if err := os.MkdirAll(serverBinDir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(serverBinDir, ".install-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	sums := map[string]string{}
+	if opts.ChecksumFile != "" {
+		if sums, err = ReadChecksums(opts.ChecksumFile); err != nil {
+			return nil, err
+		}
+	}
+	result := &InstallResult{Source: opts.From, Version: opts.Version}
+	if result.Version == "latest" {
+		result.Version = ""
+	}
+	assetName, _ := AssetName()
+	zipFile, digest := "", ""
+	staging := filepath.Join(tmp, "bin")
+
+	switch {
+	case opts.From == "":
+		version := opts.Version
+		if version == "" {
+			version = "latest"
+		}
+		release, err := github.FetchRelease(REPO, version)
+		if err != nil {
+			return nil, err
+		}
+		asset, _ := AssetFor(release.Assets)
+		if asset.Name == "" {
+			return nil, fmt.Errorf("release %s has no rustdesk-server zip for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+		}
+		result.Source, result.Version, digest = asset.BrowserDownloadURL, release.TagName, asset.Digest
+		zipFile = filepath.Join(tmp, asset.Name)
+		if err = Download(asset.BrowserDownloadURL, zipFile); err != nil {
+			return nil, err
+		}
+	case isUrl(opts.From):
+		url := opts.From
+		if !strings.HasSuffix(strings.ToLower(url), ".zip") {
+			if assetName == "" {
+				return nil, fmt.Errorf("rustdesk-server has no zip for %s/%s", runtime.GOOS, runtime.GOARCH)
+			}
+			url = strings.TrimSuffix(url, "/") + "/" + assetName
+		}
+		result.Source = url
+		zipFile = filepath.Join(tmp, filepath.Base(url))
+		if err = Download(url, zipFile); err != nil {
+			return nil, err
+		}
+	default:
+		info, err := os.Stat(opts.From)
+		if err != nil {
+			return nil, err
+		}
+		zipFile = opts.From
+		if info.IsDir() {
+			zipFile = ""
+			if assetName != "" && util.FileExists(filepath.Join(opts.From, assetName)) {
+				zipFile = filepath.Join(opts.From, assetName)
+			}
+		}
+		if zipFile == "" {
+			if opts.Sha256 != "" {
+				return nil, errors.New("a directory of binaries is verified with a checksum file, not a single sha256")
+			}
+			if result.Verified, err = copyBinaries(opts.From, staging, sums); err != nil {
+				return nil, err
+			}
+			if !result.Verified && opts.ChecksumFile != "" {
+				return nil, fmt.Errorf("checksum file %s does not list hbbs and hbbr", opts.ChecksumFile)
+			}
+		}
+	}
+
+	if zipFile != "" {
+		expected := opts.Sha256
+		if expected == "" && opts.ChecksumFile != "" {
+			// a checksum file that was asked for must cover the zip
+			if expected = checksumOf(sums, filepath.Base(zipFile)); expected == "" {
+				return nil, fmt.Errorf("checksum file %s does not list %s", opts.ChecksumFile, filepath.Base(zipFile))
+			}
+		}
+		if expected == "" {
+			expected = digest
+		}
+		if result.Sha256, err = Sha256File(zipFile); err != nil {
+			return nil, err
+		}
+		if expected != "" {
+			if err = VerifySha256(zipFile, expected); err != nil {
+				return nil, err
+			}
+			result.Verified = true
+		}
+		if err = ExtractBinaries(zipFile, staging); err != nil {
+			return nil, err
+		}
+	}
+	if !result.Verified {
+		log.Warn("The rustdesk-server binaries were installed without a checksum to verify them", "source", result.Source)
+	}
+
+	rollback, err := ReplaceBinaries(staging, serverBinDir)
+	if err != nil {
+		// hbbs and hbbr must not be left from different releases
+		if rollbackErr := rollback(); rollbackErr != nil {
+			return nil, errors.Join(err, rollbackErr)
+		}
+		return nil, err
+	}
+	if result.Version != "" {
+		if err = SetInstalledVersion(result.Version); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// copyBinaries copies the binaries of dir, or of its directory named like the one of the zip, to dst. verified is
+// true when the checksum file lists hbbs and hbbr, any binary it lists must match.
+func copyBinaries(dir, dst string, sums map[string]string) (verified bool, err error) {
+	_, archDir := AssetName()
+	names := binaries()
+	if !util.FileExists(filepath.Join(dir, names[0])) && archDir != "" {
+		dir = filepath.Join(dir, archDir)
+	}
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return false, err
+	}
+	verified = true
+	for i, name := range names {
+		src := filepath.Join(dir, name)
+		if !util.FileExists(src) {
+			if i < 2 {
+				return false, fmt.Errorf("%s is not in %s", name, dir)
+			}
+			continue
+		}
+		if sum, ok := sums[name]; ok {
+			if err = VerifySha256(src, sum); err != nil {
+				return false, err
+			}
+		} else if i < 2 {
+			verified = false
+		}
+		if err = copyFile(src, filepath.Join(dst, name)); err != nil {
+			return false, err
+		}
+	}
+	return verified, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,82 @@
+package rustdesk
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	privateKeyFile = "id_ed25519"
+	publicKeyFile  = "id_ed25519.pub"
+)
+
+// GenerateKeyPair returns a keypair as hbbs writes it: base64 of the 32 byte public key and of the
+// 64 byte libsodium secret key (seed followed by the public key)
+func GenerateKeyPair() (public, private string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// ParsePrivateKey checks an imported private key, the 64 byte secret key of hbbs or a 32 byte seed, both in
+// base64, and returns the keypair in the hbbs format
+func ParsePrivateKey(key string) (public, private string, err error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return "", "", errors.New("the private key is not base64")
+	}
+	var priv ed25519.PrivateKey
+	switch len(b) {
+	case ed25519.SeedSize:
+		priv = ed25519.NewKeyFromSeed(b)
+	case ed25519.PrivateKeySize:
+		priv = ed25519.NewKeyFromSeed(b[:ed25519.SeedSize])
+		if !priv.Equal(ed25519.PrivateKey(b)) {
+			return "", "", errors.New("the public half of the private key does not match its seed")
+		}
+	default:
+		return "", "", fmt.Errorf("the private key has %d bytes, expected %d or %d", len(b), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// WriteKeys replaces the keypair of hbbs, the previous files are kept with a .<unix time>.bak suffix.
+// hbbs reads the keys when it starts.
+func WriteKeys(public, private string) error {
+	if err := os.MkdirAll(serverBinDir, 0755); err != nil {
+		return err
+	}
+	suffix := fmt.Sprintf(".%d.bak", time.Now().Unix())
+	for _, f := range []struct {
+		name, content string
+		perm          os.FileMode
+	}{
+		{privateKeyFile, private, 0600},
+		{publicKeyFile, public, 0644},
+	} {
+		path := filepath.Join(serverBinDir, f.name)
+		if _, err := os.Stat(path); err == nil {
+			if err = os.Rename(path, path+suffix); err != nil {
+				return err
+			}
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(f.content), f.perm); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,24 +8,46 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/shirou/gopsutil/v3/process"
 )
 
 var (
-	serverBinDir = GetRustdeskServerBinDir()
+	serverBinDir = defaultServerBinDir()
 
 	hbbrPidFile = filepath.Join(serverBinDir, "hbbr.pid")
 	hbbsPidFile = filepath.Join(serverBinDir, "hbbs.pid")
 )
 
-func GetRustdeskServerBinDir() string {
+func defaultServerBinDir() string {
 	pwd, _ := os.Getwd()
 	return path.Join(pwd, "rustdesk-server")
 }
 
+// SetServerBinDir changes the directory of hbbs and hbbr, rustdesk.binDir of server.yaml
+func SetServerBinDir(dir string) {
+	if dir == "" {
+		return
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	serverBinDir = dir
+	hbbrPidFile = filepath.Join(dir, "hbbr.pid")
+	hbbsPidFile = filepath.Join(dir, "hbbs.pid")
+}
+
+func GetRustdeskServerBinDir() string {
+	return serverBinDir
+}
+
 func GetRustdeskServerBin() (hbbr, hbbs string) {
-	dir := GetRustdeskServerBinDir()
+	return BinIn(GetRustdeskServerBinDir())
+}
+
+// BinIn returns the paths of hbbr and hbbs in dir, empty on systems rustdesk-server does not support
+func BinIn(dir string) (hbbr, hbbs string) {
 	switch runtime.GOOS {
 	case "windows":
 		hbbr = path.Join(dir, "hbbr.exe")
@@ -50,6 +72,8 @@ func StartServer() (bool, error) {
 		fmt.Println("hbbr start error:", err.Error())
 		return false, err
 	}
+	// reaps the process when it exits while the api server runs
+	go pHbbr.Wait()
 	err = os.WriteFile(hbbrPidFile, []byte(strconv.Itoa(pHbbr.Process.Pid)), os.ModePerm)
 	if err != nil {
 		fmt.Println("write hbbr pid file error:", err.Error())
@@ -63,6 +87,7 @@ func StartServer() (bool, error) {
 		fmt.Println("hbbs start error:", err.Error())
 		return false, err
 	}
+	go pHbbs.Wait()
 	err = os.WriteFile(hbbsPidFile, []byte(strconv.Itoa(pHbbs.Process.Pid)), os.ModePerm)
 	if err != nil {
 		fmt.Println("write hbbs pid file error:", err.Error())
@@ -94,15 +119,35 @@ func read_server_pid() (hbbrPid, hbbsPid int) {
 	return hbbrPid, hbbsPid
 }
 
+// serverProcess returns the process of a pid file, nil when it exited or the pid was reused by another program
+func serverProcess(pid int, name string) *process.Process {
+	if pid <= 0 {
+		return nil
+	}
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil
+	}
+	if running, _ := p.IsRunning(); !running {
+		return nil
+	}
+	processName, err := p.Name()
+	if err != nil || strings.TrimSuffix(processName, ".exe") != name {
+		return nil
+	}
+	return p
+}
+
 func StopServer() bool {
 
 	hbbrPid, hbbsPid := read_server_pid()
 
-	hbbr, _ := process.NewProcess(int32(hbbrPid))
-	hbbs, _ := process.NewProcess(int32(hbbsPid))
-
-	hbbr.Kill()
-	hbbs.Kill()
+	if hbbr := serverProcess(hbbrPid, "hbbr"); hbbr != nil {
+		hbbr.Kill()
+	}
+	if hbbs := serverProcess(hbbsPid, "hbbs"); hbbs != nil {
+		hbbs.Kill()
+	}
 
 	return true
 }
@@ -110,11 +155,8 @@ func StopServer() bool {
 func Status() (hbbrIsRunning, hbbsIsRunning bool) {
 	hbbrPid, hbbsPid := read_server_pid()
 
-	hbbr, _ := process.NewProcess(int32(hbbrPid))
-	hbbs, _ := process.NewProcess(int32(hbbsPid))
-
-	hbbrIsRunning, _ = hbbr.IsRunning()
-	hbbsIsRunning, _ = hbbs.IsRunning()
+	hbbrIsRunning = serverProcess(hbbrPid, "hbbr") != nil
+	hbbsIsRunning = serverProcess(hbbsPid, "hbbs") != nil
 
 	return hbbrIsRunning, hbbsIsRunning
 }
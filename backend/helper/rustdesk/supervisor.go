@@ -0,0 +1,257 @@
+package rustdesk
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"rustdesk-api-server-pro/helper/logger"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var log = logger.Module("rustdesk")
+
+// stableRun is how long a process must run before its restart delay goes back to one second
+const stableRun = time.Minute
+
+type ProcessOptions struct {
+	Name          string // hbbs hbbr
+	Bin           string
+	Dir           string
+	Args          []string
+	PidFile       string // written on every start, so the rustdesk status command sees the supervised process
+	LogFile       string // stdout and stderr, empty discards them
+	LogMaxSizeMB  int
+	LogMaxBackups int
+	MaxBackoff    time.Duration
+}
+
+type ProcessStatus struct {
+	Name       string   `json:"name"`
+	Running    bool     `json:"running"`
+	Pid        int      `json:"pid,omitempty"`
+	Args       []string `json:"args"`
+	StartedAt  string   `json:"startedAt,omitempty"`
+	Uptime     int64    `json:"uptime"` // seconds
+	Restarts   int      `json:"restarts"`
+	LastExit   string   `json:"lastExit,omitempty"` // exit status or start error
+	LastExitAt string   `json:"lastExitAt,omitempty"`
+	LogFile    string   `json:"logFile,omitempty"`
+}
+
+// Supervisor runs hbbs and hbbr as child processes and restarts them with a backoff when they exit
+type Supervisor struct {
+	processes []*supervisedProcess
+}
+
+type supervisedProcess struct {
+	opts      ProcessOptions
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	startedAt time.Time
+	restarts  int
+	lastExit  string
+	exitedAt  time.Time
+	active    bool // supervised, between start and terminate
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewSupervisor(processes ...ProcessOptions) *Supervisor {
+	s := &Supervisor{}
+	for _, opts := range processes {
+		if opts.MaxBackoff <= 0 {
+			opts.MaxBackoff = time.Minute
+		}
+		s.processes = append(s.processes, &supervisedProcess{opts: opts})
+	}
+	return s
+}
+
+// Start launches the processes that are not supervised yet, they are restarted until Stop
+func (s *Supervisor) Start() {
+	for _, p := range s.processes {
+		p.start()
+	}
+}
+
+// Stop asks the processes to exit and kills those still running after timeout, Start runs them again
+func (s *Supervisor) Stop(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, p := range s.processes {
+		wg.Add(1)
+		go func(p *supervisedProcess) {
+			defer wg.Done()
+			p.terminate(timeout)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (s *Supervisor) Status() []ProcessStatus {
+	status := make([]ProcessStatus, 0, len(s.processes))
+	for _, p := range s.processes {
+		status = append(status, p.status())
+	}
+	return status
+}
+
+// Restarts sums the restarts after a crash of every process
+func (s *Supervisor) Restarts() int {
+	restarts := 0
+	for _, p := range s.processes {
+		restarts += p.status().Restarts
+	}
+	return restarts
+}
+
+func (p *supervisedProcess) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active {
+		return
+	}
+	p.active = true
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go p.supervise(p.stop, p.done)
+}
+
+func (p *supervisedProcess) supervise(stop, done chan struct{}) {
+	defer close(done)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := p.run(stop)
+		if time.Since(started) >= stableRun {
+			backoff = time.Second
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.Error("Process exited, restarting", "process", p.opts.Name, "error", err, "in", backoff.String())
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+		if backoff *= 2; backoff > p.opts.MaxBackoff {
+			backoff = p.opts.MaxBackoff
+		}
+	}
+}
+
+// run starts the process and waits for it to exit
+func (p *supervisedProcess) run(stop chan struct{}) error {
+	cmd := exec.Command(p.opts.Bin, p.opts.Args...)
+	cmd.Dir = p.opts.Dir
+	if p.opts.LogFile != "" {
+		w, err := logger.NewRotateWriter(p.opts.LogFile, int64(p.opts.LogMaxSizeMB)*1024*1024, p.opts.LogMaxBackups)
+		if err != nil {
+			return p.exited(err)
+		}
+		defer w.Close()
+		cmd.Stdout, cmd.Stderr = w, w
+	}
+
+	p.mu.Lock()
+	select {
+	case <-stop:
+		p.mu.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return p.exited(err)
+	}
+	p.cmd, p.startedAt = cmd, time.Now()
+	p.mu.Unlock()
+
+	if p.opts.PidFile != "" {
+		if err := os.WriteFile(p.opts.PidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+			log.Warn("Write pid file error", "process", p.opts.Name, "error", err)
+		}
+	}
+	log.Info("Process started", "process", p.opts.Name, "pid", cmd.Process.Pid, "args", p.opts.Args)
+
+	err := cmd.Wait()
+	if err == nil {
+		err = errors.New("exit status 0")
+	}
+	return p.exited(err)
+}
+
+func (p *supervisedProcess) exited(err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cmd = nil
+	p.lastExit = err.Error()
+	p.exitedAt = time.Now()
+	return err
+}
+
+func (p *supervisedProcess) terminate(timeout time.Duration) {
+	p.mu.Lock()
+	if !p.active {
+		p.mu.Unlock()
+		return
+	}
+	p.active = false
+	close(p.stop)
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+
+	if cmd != nil {
+		// windows has no SIGTERM, the process is killed there
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			_ = cmd.Process.Kill()
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		p.mu.Lock()
+		if p.cmd != nil {
+			_ = p.cmd.Process.Kill()
+		}
+		p.mu.Unlock()
+		<-done
+	}
+	if p.opts.PidFile != "" {
+		_ = os.Remove(p.opts.PidFile)
+	}
+	log.Info("Process stopped", "process", p.opts.Name)
+}
+
+func (p *supervisedProcess) status() ProcessStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := ProcessStatus{
+		Name:     p.opts.Name,
+		Args:     p.opts.Args,
+		Restarts: p.restarts,
+		LastExit: p.lastExit,
+		LogFile:  p.opts.LogFile,
+	}
+	if status.Args == nil {
+		status.Args = []string{}
+	}
+	if p.cmd != nil {
+		status.Running = true
+		status.Pid = p.cmd.Process.Pid
+		status.StartedAt = p.startedAt.Format(time.DateTime)
+		status.Uptime = int64(time.Since(p.startedAt).Seconds())
+	}
+	if !p.exitedAt.IsZero() {
+		status.LastExitAt = p.exitedAt.Format(time.DateTime)
+	}
+	return status
+}
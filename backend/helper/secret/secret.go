@@ -0,0 +1,340 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Values are stored as enc:v1:<key id>:<wrapped data key>:<ciphertext>.
+// Every value gets its own random data key, the data key is encrypted with the master key,
+// so rotating the master key only needs the key id to find the right master key.
+const Prefix = "enc:v1:"
+
+// MasterKeyEnv holds one or more comma separated base64 master keys, the first one is used to encrypt
+const MasterKeyEnv = "RDAPI_MASTER_KEY"
+
+// MasterKeyFileEnv overrides the key file path from server.yaml
+const MasterKeyFileEnv = "RDAPI_MASTER_KEY_FILE"
+
+const keySize = 32
+
+var ErrNoKeyRing = errors.New("master key is not loaded")
+
+var ErrKeyNotSaved = errors.New("the new master key is not saved yet")
+
+type Key struct {
+	Id  string
+	Key []byte
+}
+
+type KeyRing struct {
+	// keys[0] is the primary key, the others are only used for decryption
+	keys []Key
+	// FromEnv is true when the keys come from the environment and can not be written back
+	FromEnv bool
+	File    string
+	// Generated is true when the key file did not exist, the new key encrypts nothing until Save wrote it
+	Generated bool
+}
+
+var (
+	defaultRing *KeyRing
+	mu          sync.RWMutex
+)
+
+func Default() *KeyRing {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultRing
+}
+
+func SetDefault(ring *KeyRing) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultRing = ring
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt encrypts value with the default key ring, empty values stay empty
+func Encrypt(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	ring := Default()
+	if ring == nil {
+		return "", ErrNoKeyRing
+	}
+	return ring.Encrypt(value)
+}
+
+// Decrypt decrypts value with the default key ring, plaintext values are returned unchanged
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	ring := Default()
+	if ring == nil {
+		return "", ErrNoKeyRing
+	}
+	return ring.Decrypt(value)
+}
+
+func NewKey() (Key, error) {
+	b := make([]byte, keySize)
+	if _, err := rand.Read(b); err != nil {
+		return Key{}, err
+	}
+	return newKey(b), nil
+}
+
+func newKey(b []byte) Key {
+	sum := sha256.Sum256(b)
+	return Key{
+		Id:  hex.EncodeToString(sum[:4]),
+		Key: b,
+	}
+}
+
+func ParseKey(s string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid master key: %s", err.Error())
+	}
+	if len(b) != keySize {
+		return Key{}, fmt.Errorf("invalid master key: must be %d bytes, got %d", keySize, len(b))
+	}
+	return newKey(b), nil
+}
+
+func NewKeyRing(keys ...Key) *KeyRing {
+	return &KeyRing{keys: keys}
+}
+
+// LoadKeyRing loads the master keys from RDAPI_MASTER_KEY, or from keyFile.
+// When neither exists the ring has a new random key and Generated set, it is written by Save once it is sure that
+// no stored value was encrypted with a lost key.
+func LoadKeyRing(keyFile string) (*KeyRing, error) {
+	if env := os.Getenv(MasterKeyEnv); env != "" {
+		keys := make([]Key, 0)
+		for _, s := range strings.Split(env, ",") {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			key, err := ParseKey(s)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return nil, errors.New(MasterKeyEnv + " is empty")
+		}
+		return &KeyRing{keys: keys, FromEnv: true}, nil
+	}
+
+	if f := os.Getenv(MasterKeyFileEnv); f != "" {
+		keyFile = f
+	}
+
+	content, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key, err := NewKey()
+		if err != nil {
+			return nil, err
+		}
+		return &KeyRing{keys: []Key{key}, File: keyFile, Generated: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", keyFile, err.Error())
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no master key found", keyFile)
+	}
+	return &KeyRing{keys: keys, File: keyFile}, nil
+}
+
+// Save writes the key ring to its key file, the primary key first
+func (r *KeyRing) Save() error {
+	if r.File == "" {
+		return errors.New("key ring has no key file")
+	}
+	var b strings.Builder
+	b.WriteString("# rustdesk-api-server-pro master keys, the first key encrypts new values. keep this file secret!\n")
+	for _, key := range r.keys {
+		b.WriteString(base64.StdEncoding.EncodeToString(key.Key))
+		b.WriteString("\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(r.File), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.File, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	r.Generated = false
+	return nil
+}
+
+// EnvValue returns the key ring in the RDAPI_MASTER_KEY format
+func (r *KeyRing) EnvValue() string {
+	values := make([]string, 0)
+	for _, key := range r.keys {
+		values = append(values, base64.StdEncoding.EncodeToString(key.Key))
+	}
+	return strings.Join(values, ",")
+}
+
+func (r *KeyRing) Primary() Key {
+	return r.keys[0]
+}
+
+func (r *KeyRing) Keys() []Key {
+	return r.keys
+}
+
+// Rotate returns a new key ring with a fresh primary key, the old keys are kept for decryption
+func (r *KeyRing) Rotate() (*KeyRing, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	keys := append([]Key{key}, r.keys...)
+	return &KeyRing{keys: keys, FromEnv: r.FromEnv, File: r.File}, nil
+}
+
+// WithoutOldKeys returns a key ring that only holds the primary key
+func (r *KeyRing) WithoutOldKeys() *KeyRing {
+	return &KeyRing{keys: []Key{r.keys[0]}, FromEnv: r.FromEnv, File: r.File}
+}
+
+func (r *KeyRing) find(id string) (Key, bool) {
+	for _, key := range r.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// KeyId returns the master key id a value was encrypted with, or "" for plaintext values
+func KeyId(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 3)
+	return parts[0]
+}
+
+func (r *KeyRing) Encrypt(value string) (string, error) {
+	if r.Generated {
+		return "", ErrKeyNotSaved
+	}
+	primary := r.Primary()
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(primary.Key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + primary.Id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (r *KeyRing) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, ok := r.find(parts[0])
+	if !ok {
+		return "", fmt.Errorf("master key %s not found", parts[0])
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(key.Key, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,58 @@
+package syslog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CEF formats an ArcSight Common Event Format message:
+// CEF:0|vendor|product|version|signature id|name|severity|extension
+type CEF struct {
+	Vendor      string
+	Product     string
+	Version     string
+	SignatureId string
+	Name        string
+	Severity    int // 0-10
+	Extension   map[string]string
+}
+
+func (c *CEF) Format() string {
+	header := []string{"CEF:0", c.Vendor, c.Product, c.Version, c.SignatureId, c.Name, strconv.Itoa(c.Severity)}
+	for i := 1; i < len(header); i++ {
+		header[i] = cefHeaderEscaper.Replace(header[i])
+	}
+
+	keys := make([]string, 0, len(c.Extension))
+	for key := range c.Extension {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ext := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ext = append(ext, key+"="+cefValueEscaper.Replace(c.Extension[key]))
+	}
+	return strings.Join(header, "|") + "|" + strings.Join(ext, " ")
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// CEFSeverity maps a syslog severity to the 0-10 CEF scale
+func CEFSeverity(severity int) int {
+	switch {
+	case severity <= SEVERITY_CRITICAL:
+		return 10
+	case severity == SEVERITY_ERROR:
+		return 8
+	case severity == SEVERITY_WARNING:
+		return 6
+	case severity == SEVERITY_NOTICE:
+		return 4
+	case severity == SEVERITY_INFO:
+		return 3
+	}
+	return 1
+}
@@ -0,0 +1,234 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"rustdesk-api-server-pro/helper/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SEVERITY_EMERGENCY = 0
+	SEVERITY_ALERT     = 1
+	SEVERITY_CRITICAL  = 2
+	SEVERITY_ERROR     = 3
+	SEVERITY_WARNING   = 4
+	SEVERITY_NOTICE    = 5
+	SEVERITY_INFO      = 6
+	SEVERITY_DEBUG     = 7
+)
+
+const (
+	NETWORK_UDP = "udp"
+	NETWORK_TCP = "tcp"
+	NETWORK_TLS = "tls"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "audit": 13, "alert": 14, "clock": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Facility returns the code of a facility name like local0 or auth
+func Facility(name string) (int, bool) {
+	code, ok := facilities[strings.ToLower(name)]
+	return code, ok
+}
+
+// Message is a RFC 5424 message
+type Message struct {
+	Facility       int
+	Severity       int
+	Time           time.Time
+	Hostname       string
+	AppName        string
+	MsgId          string
+	StructuredData string
+	Msg            string
+}
+
+// Format returns <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (m *Message) Format() string {
+	sd := m.StructuredData
+	if sd == "" {
+		sd = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s %s", m.Facility*8+m.Severity, m.Time.Format(time.RFC3339Nano),
+		headerField(m.Hostname, 255), headerField(m.AppName, 48), os.Getpid(), headerField(m.MsgId, 32), sd)
+	if m.Msg != "" {
+		line += " " + m.Msg
+	}
+	return line
+}
+
+// headerField replaces what is not allowed in a header field, empty fields are -
+func headerField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// StructuredData formats one SD-ELEMENT, the params are sorted by name
+func StructuredData(id string, params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("[" + id)
+	for _, name := range names {
+		b.WriteString(" " + headerField(strings.NewReplacer("=", "_", "]", "_", "\"", "_").Replace(name), 32) + "=\"")
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(params[name]))
+		b.WriteString("\"")
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var syslogLog = logger.Module("syslog")
+
+// Writer sends messages from a queue, so a slow or unreachable collector never blocks the caller.
+// Stream connections (tcp, tls) use octet counting framing (RFC 6587) and are reopened after an error.
+type Writer struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	queue     chan string
+	done      chan struct{}
+	mu        sync.RWMutex // guards queue against a send after Close
+	closed    bool
+	dropped   atomic.Int64
+	conn      net.Conn
+	failing   bool
+}
+
+func NewWriter(network, address string, tlsConfig *tls.Config, queueSize int) (*Writer, error) {
+	switch network {
+	case NETWORK_UDP, NETWORK_TCP, NETWORK_TLS:
+	default:
+		return nil, errors.New("unsupported syslog network " + network)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConfig.ServerName = host
+	}
+	w := &Writer{
+		network:   network,
+		address:   address,
+		tlsConfig: tlsConfig,
+		queue:     make(chan string, queueSize),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Send queues a formatted message, it is dropped when the queue is full or the writer is closed
+func (w *Writer) Send(msg string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return false
+	}
+	select {
+	case w.queue <- msg:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns the messages lost to a full queue or a failing collector
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Close sends what is queued (giving up after timeout) and closes the connection
+func (w *Writer) Close(timeout time.Duration) {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	for msg := range w.queue {
+		// one retry on a fresh connection, the collector may have closed an idle one
+		if err := w.write(msg); err != nil {
+			if err = w.write(msg); err != nil {
+				w.dropped.Add(1)
+				if !w.failing {
+					syslogLog.Error("Syslog collector error", "network", w.network, "address", w.address, "error", err)
+				}
+				w.failing = true
+				continue
+			}
+		}
+		w.failing = false
+	}
+	if w.conn != nil {
+		_ = w.conn.Close()
+	}
+}
+
+func (w *Writer) write(msg string) error {
+	if w.conn == nil {
+		conn, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	frame := msg
+	if w.network != NETWORK_UDP {
+		frame = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := w.conn.Write([]byte(frame)); err != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if w.network == NETWORK_TLS {
+		return tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	}
+	return dialer.Dial(w.network, w.address)
+}
@@ -0,0 +1,202 @@
+package test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"rustdesk-api-server-pro/app/controller/admin"
+	"rustdesk-api-server-pro/app/form/api"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/secret"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+	"xorm.io/xorm"
+)
+
+func TestParseAbImportData(t *testing.T) {
+	s := service.NewAddressBookService()
+
+	// GetAb / legacy AbForm shape: data is a json string, tag_colors is a json string too
+	legacy := []byte(`{"data":"{\"tags\":[\"office\"],\"peers\":[{\"id\":\"123456789\",\"alias\":\"pc\",\"tags\":[\"office\"],\"forceAlwaysRelay\":\"true\",\"rdpPort\":\"3389\"}],\"tag_colors\":\"{\\\"office\\\":4278190335}\"}"}`)
+	abData, tagColors, err := s.ParseImportData(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abData.Peers) != 1 || abData.Peers[0].Id != "123456789" || abData.Peers[0].RdpPort != "3389" {
+		t.Fatalf("unexpected peers: %+v", abData.Peers)
+	}
+	if tagColors["office"] != 4278190335 {
+		t.Fatalf("unexpected tag colors: %v", tagColors)
+	}
+
+	// AbData object with tag_colors as an object
+	plain := []byte(`{"tags":["home"],"peers":[{"id":"987654321","tags":["home"]}],"tag_colors":{"home":4294901760}}`)
+	abData, tagColors, err = s.ParseImportData(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abData.Tags) != 1 || abData.Tags[0] != "home" || tagColors["home"] != 4294901760 {
+		t.Fatalf("unexpected data: %+v %v", abData, tagColors)
+	}
+
+	if _, _, err = s.ParseImportData([]byte(`not json`)); err == nil {
+		t.Fatal("expected error for invalid json")
+	}
+}
+
+func newAbTestEngine(t *testing.T) *xorm.Engine {
+	engine := newTestEngine(t, new(model.AddressBook), new(model.AddressBookTag), new(model.Peer))
+	// the peer passwords are encrypted
+	key, err := secret.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := secret.Default()
+	secret.SetDefault(secret.NewKeyRing(key))
+	t.Cleanup(func() { secret.SetDefault(old) })
+	return engine
+}
+
+func TestAbImportStrategies(t *testing.T) {
+	engine := newAbTestEngine(t)
+	s := service.NewAddressBookService()
+
+	data := func() *api.AbData {
+		return &api.AbData{Peers: []api.AbPeer{
+			{Id: "111111111", Alias: "office", Password: "new"},
+			{Id: "222222222", Alias: "office"},
+			{Id: "222222222", Alias: "twice"},
+		}}
+	}
+	peers := func(abId int) []model.Peer {
+		list := make([]model.Peer, 0)
+		if err := engine.Where("ab_id = ?", abId).Asc("id").Find(&list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	for _, tc := range []struct {
+		strategy string
+		result   service.AbImportResult
+		aliases  []string
+		password string
+	}{
+		{service.AB_IMPORT_SKIP, service.AbImportResult{Imported: 1, Skipped: 2}, []string{"existing", "office"}, "old"},
+		{service.AB_IMPORT_OVERWRITE, service.AbImportResult{Imported: 1, Updated: 1, Skipped: 1}, []string{"office", "office"}, "new"},
+		{service.AB_IMPORT_RENAME, service.AbImportResult{Renamed: 1, Skipped: 2}, []string{"existing", "office (2)"}, "old"},
+	} {
+		ab := &model.AddressBook{Name: tc.strategy}
+		if _, err := engine.Insert(ab); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := engine.Insert(&model.Peer{AbId: ab.Id, RustdeskId: "111111111", Alias: "existing", Password: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.strategy == service.AB_IMPORT_RENAME {
+			// the alias of the new peer is taken by another device
+			if _, err := engine.Insert(&model.Peer{AbId: ab.Id, RustdeskId: "333333333", Alias: "office"}); err != nil {
+				t.Fatal(err)
+			}
+			tc.aliases = []string{"existing", "office", "office (2)"}
+		}
+
+		result, err := s.Import(ab, data(), nil, tc.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *result != tc.result {
+			t.Fatalf("%s: unexpected result %+v", tc.strategy, result)
+		}
+		list := peers(ab.Id)
+		if len(list) != len(tc.aliases) {
+			t.Fatalf("%s: expected %d peers, got %+v", tc.strategy, len(tc.aliases), list)
+		}
+		ids := map[string]bool{}
+		for i, peer := range list {
+			if ids[peer.RustdeskId] {
+				t.Fatalf("%s: rustdesk id %s imported twice", tc.strategy, peer.RustdeskId)
+			}
+			ids[peer.RustdeskId] = true
+			if peer.Alias != tc.aliases[i] {
+				t.Fatalf("%s: expected alias %q, got %q", tc.strategy, tc.aliases[i], peer.Alias)
+			}
+		}
+		if list[0].Password.String() != tc.password {
+			t.Fatalf("%s: expected password %q, got %q", tc.strategy, tc.password, list[0].Password)
+		}
+	}
+}
+
+func TestAbExportPermission(t *testing.T) {
+	engine := newAbTestEngine(t)
+	ab := &model.AddressBook{Name: "export"}
+	if _, err := engine.Insert(ab); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Insert(&model.Peer{AbId: ab.Id, RustdeskId: "111111111", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.User{}
+	app := iris.New()
+	app.RegisterDependency(engine)
+	party := app.Party("/admin")
+	party.Use(func(ctx iris.Context) {
+		ctx.Values().Set(config.AdminUserKey, user)
+		ctx.Next()
+	})
+	mvc.New(party).Handle(new(admin.AddressBooksController))
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	export := func(role int, query string) string {
+		user.Role = role
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/admin/address-books/%d/export%s", ab.Id, query), nil))
+		return rec.Body.String()
+	}
+
+	if body := export(model.ROLE_SUPPORT, ""); !strings.Contains(body, "Permission denied") {
+		t.Fatalf("expected support to be denied: %s", body)
+	}
+	if body := export(model.ROLE_SUPPORT_N2, ""); !strings.Contains(body, "111111111") || strings.Contains(body, "secret") {
+		t.Fatalf("expected an export without passwords: %s", body)
+	}
+	if body := export(model.ROLE_SUPPORT_N2, "?passwords=true"); !strings.Contains(body, "Permission denied") {
+		t.Fatalf("expected passwords to need a super admin: %s", body)
+	}
+	if body := export(model.ROLE_SUPER_ADMIN, "?passwords=true"); !strings.Contains(body, "secret") {
+		t.Fatalf("expected the passwords in the export: %s", body)
+	}
+}
+
+func TestAbImportSizeLimit(t *testing.T) {
+	engine := newAbTestEngine(t)
+	ab := &model.AddressBook{Name: "import"}
+	if _, err := engine.Insert(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	app := iris.New()
+	app.RegisterDependency(engine)
+	party := app.Party("/admin")
+	party.Use(func(ctx iris.Context) {
+		ctx.Values().Set(config.AdminUserKey, &model.User{Role: model.ROLE_SUPER_ADMIN})
+		ctx.Next()
+	})
+	mvc.New(party).Handle(new(admin.AddressBooksController))
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	body := `{"peers":[],"tags":[],"padding":"` + strings.Repeat("x", 11<<20) + `"}`
+	app.ServeHTTP(rec, httptest.NewRequest("POST", fmt.Sprintf("/admin/address-books/%d/import", ab.Id), strings.NewReader(body)))
+	if !strings.Contains(rec.Body.String(), "larger than 10 MB") {
+		t.Fatalf("expected the import to be refused: %.200s", rec.Body.String())
+	}
+}
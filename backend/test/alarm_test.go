@@ -0,0 +1,88 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"testing"
+	"time"
+)
+
+func TestParseAlarm(t *testing.T) {
+	alarm := service.ParseAlarm([]byte(`{"id":"1235182932","info":"{\"id\":\"182921366\",\"ip\":\"::ffff:192.168.100.170\",\"name\":\"mrkin\"}","typ":2,"uuid":"dXVpZA=="}`))
+	if alarm.RustdeskId != "1235182932" || alarm.Type != model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+	if alarm.IP != "192.168.100.170" || alarm.PeerId != "182921366" || alarm.PeerName != "mrkin" {
+		t.Fatalf("unexpected attribution: %+v", alarm)
+	}
+	if alarm.Severity != model.ALARM_SEVERITY_HIGH || alarm.Status != model.ALARM_STATUS_OPEN {
+		t.Fatalf("unexpected severity or status: %+v", alarm)
+	}
+
+	// info as an object and an unknown type
+	alarm = service.ParseAlarm([]byte(`{"id":"1","info":{"ip":"10.0.0.1"},"typ":9}`))
+	if alarm.IP != "10.0.0.1" || alarm.Severity != model.ALARM_SEVERITY_LOW {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+}
+
+func TestAlarmRecord(t *testing.T) {
+	engine := newTestEngine(t, new(model.Alarm), new(model.Peer), new(model.Device), new(model.User), new(model.SystemSettings),
+		new(model.MailLogs), new(model.MailAttachment), new(model.MailTemplate))
+	setTestConfig(t, config.GetDefaultServerConfig())
+	_, _ = engine.Insert(&model.User{Username: "admin", Email: "admin@example.com", Role: model.ROLE_SUPER_ADMIN, Status: 1})
+	if err := service.NewSettingsService().Save(map[string]string{service.SETTING_ALARM_NOTIFY_EMAILS: "security@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a limiter of its own, the window of the shared one may end in the middle of the test
+	s := service.NewAlarmService().SetLimiter(service.NewRateLimiter(time.Hour, 30))
+	record := func(rustdeskId string, typ int, ip string) (*model.Alarm, error) {
+		return s.Record([]byte(fmt.Sprintf(`{"id":%q,"info":"{\"ip\":\"10.0.0.1\"}","typ":%d}`, rustdeskId, typ)), ip)
+	}
+
+	// a high alarm mails the super admins and the notify emails
+	alarm, err := record("100", model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE, "192.0.2.1")
+	if err != nil || !alarm.Notified {
+		t.Fatalf("expected the alarm to be notified: %+v %v", alarm, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count, _ := engine.In("to", "admin@example.com", "security@example.com").Count(&model.MailLogs{})
+		if count == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two notification mails, got %d", count)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// the same alarm of the same device is not notified again within the cooldown, another device is
+	if alarm, err = record("100", model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE, "192.0.2.1"); err != nil || alarm.Notified {
+		t.Fatalf("expected the repeated alarm to be held back: %+v %v", alarm, err)
+	}
+	if alarm, err = record("200", model.ALARM_TYPE_SIX_ATTEMPTS_WITHIN_MINUTE, "192.0.2.1"); err != nil || !alarm.Notified {
+		t.Fatalf("expected the alarm of another device to be notified: %+v %v", alarm, err)
+	}
+	if alarm, err = record("100", model.ALARM_TYPE_IP_WHITELIST, "192.0.2.1"); err != nil || alarm.Notified {
+		t.Fatalf("expected a low alarm not to be notified: %+v %v", alarm, err)
+	}
+
+	// one address can only report a limited number of alarms a minute
+	limited := 0
+	for i := 0; i < 40; i++ {
+		if _, err = record(fmt.Sprintf("3%08d", i), model.ALARM_TYPE_IP_WHITELIST, "192.0.2.99"); errors.Is(err, service.ErrAlarmRateLimited) {
+			limited++
+		}
+	}
+	if limited != 10 {
+		t.Fatalf("expected 10 alarms to be rate limited, got %d", limited)
+	}
+	if _, err = record("400", model.ALARM_TYPE_IP_WHITELIST, "192.0.2.100"); err != nil {
+		t.Fatalf("expected another address to report alarms: %v", err)
+	}
+}
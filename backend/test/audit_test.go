@@ -0,0 +1,166 @@
+package test
+
+import (
+	"net/http/httptest"
+	"rustdesk-api-server-pro/app/controller/api"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+func TestParseFileTransferInfo(t *testing.T) {
+	info, err := service.ParseFileTransferInfo(`{"files":[["f1",170398208],["sub/f2",147870720]],"ip":"192.168.100.170","name":"mrkin","num":17778}`, "/Users/kali/Downloads/.nuget", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FileCount != 17778 || info.TotalSize != 170398208+147870720 || info.RemoteIp != "192.168.100.170" || info.RemoteName != "mrkin" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if info.Files[1].Name != "/Users/kali/Downloads/.nuget/sub/f2" {
+		t.Fatalf("unexpected name: %s", info.Files[1].Name)
+	}
+
+	info, err = service.ParseFileTransferInfo(`{"files":[["",89]],"ip":"10.0.0.1","name":"x","num":1}`, `C:\Users\x\report.xlsx`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Files) != 1 || info.Files[0].Name != `C:\Users\x\report.xlsx` || info.TotalSize != 89 {
+		t.Fatalf("unexpected single file: %+v", info)
+	}
+
+	if _, err = service.ParseFileTransferInfo(`not json`, "", false); err == nil {
+		t.Fatal("expected error for invalid info")
+	}
+}
+
+func TestRecordFileTransfer(t *testing.T) {
+	engine := newTestEngine(t, new(model.Audit), new(model.FileTransfer), new(model.FileTransferItem), new(model.AuthToken), new(model.Peer))
+	_, _ = engine.Insert(&model.Audit{UserId: 7, ConnId: 1, RustdeskId: "1235182932", Peer: `["182921366","kali"]`, PeerId: "182921366", Uuid: "dXVpZA=="})
+
+	body := []byte(`{"id":"1235182932","info":"{\"files\":[[\"a.txt\",10],[\"b.txt\",20]],\"ip\":\"192.168.100.170\",\"name\":\"mrkin\",\"num\":2}","is_file":false,"path":"/data","peer_id":"182921366","type":0,"uuid":"dXVpZA=="}`)
+	transfer, err := service.NewAuditService().RecordFileTransfer(body, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Uuid != "dXVpZA==" || transfer.AuditId == 0 || transfer.UserId != 7 || transfer.TotalSize != 30 {
+		t.Fatalf("unexpected transfer: %+v", transfer)
+	}
+	count, _ := engine.Where("file_transfer_id = ?", transfer.Id).Count(&model.FileTransferItem{})
+	if count != 2 {
+		t.Fatalf("expected 2 file rows, got %d", count)
+	}
+}
+
+func TestRecordConnLifecycle(t *testing.T) {
+	engine := newTestEngine(t, new(model.Audit), new(model.AuthToken), new(model.Peer), new(model.Device))
+	s := service.NewAuditService()
+	reports := []string{
+		`{"action":"new","conn_id":762,"id":"182921366","ip":"::ffff:10.0.0.2","session_id":0,"uuid":"xxx"}`,
+		// the same conn id on another device must not be touched
+		`{"action":"new","conn_id":762,"id":"999999999","ip":"10.0.0.3","session_id":0,"uuid":"yyy"}`,
+		`{"conn_id":762,"id":"182921366","peer":["1139987256","SYSTEM"],"session_id":17409556129324805845,"type":0,"uuid":"xxx"}`,
+		`{"action":"close","conn_id":762,"id":"182921366","session_id":17409556129324805845,"uuid":"xxx"}`,
+	}
+	for _, r := range reports {
+		if err := s.RecordConn([]byte(r), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var audit model.Audit
+	_, _ = engine.Where("rustdesk_id = ?", "182921366").Get(&audit)
+	if audit.PeerId != "1139987256" || audit.PeerName != "SYSTEM" || audit.SessionId != "17409556129324805845" || audit.IP != "10.0.0.2" {
+		t.Fatalf("unexpected session: %+v", audit)
+	}
+	if audit.ClosedAt.IsZero() || audit.CloseReason != model.AUDIT_CLOSE_REASON_CLOSED {
+		t.Fatalf("expected closed session: %+v", audit)
+	}
+	var other model.Audit
+	_, _ = engine.Where("rustdesk_id = ?", "999999999").Get(&other)
+	if !other.ClosedAt.IsZero() {
+		t.Fatalf("session of another device was closed: %+v", other)
+	}
+
+	// a note is only written to the session of the reporting device
+	if _, err := engine.ID(other.Id).Cols("session_id").Update(&model.Audit{SessionId: audit.SessionId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordConn([]byte(`{"id":"182921366","session_id":17409556129324805845,"note":"printer"}`), ""); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := engine.Where("id = ? AND note = ''", other.Id).Exist(&model.Audit{}); !has {
+		t.Fatal("note written to another device")
+	}
+	if has, _ := engine.Where("id = ? AND note = ?", audit.Id, "printer").Exist(&model.Audit{}); !has {
+		t.Fatal("note was not written")
+	}
+	// a peer report of another session does not overwrite the open one
+	if err := s.RecordConn([]byte(`{"conn_id":762,"id":"999999999","peer":["123","x"],"session_id":42,"type":0}`), ""); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := engine.Where("id = ? AND peer_id = ''", other.Id).Exist(&model.Audit{}); !has {
+		t.Fatal("peer of another session was written")
+	}
+
+	// a reused conn id supersedes the session that was never closed
+	if err := s.RecordConn([]byte(`{"action":"new","conn_id":762,"id":"999999999","ip":"10.0.0.3","session_id":0,"uuid":"yyy"}`), ""); err != nil {
+		t.Fatal(err)
+	}
+	superseded := &model.Audit{}
+	_, _ = engine.ID(other.Id).Get(superseded)
+	if superseded.CloseReason != model.AUDIT_CLOSE_REASON_SUPERSEDED {
+		t.Fatalf("expected superseded session: %+v", superseded)
+	}
+	// the server closes what is still open when it stops
+	count, err := s.CloseAll(model.AUDIT_CLOSE_REASON_SHUTDOWN)
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 closed session, got %d %v", count, err)
+	}
+	if open, _ := engine.Where("close_reason = ''").Count(&model.Audit{}); open != 0 {
+		t.Fatalf("%d sessions are still open", open)
+	}
+}
+
+func TestHeartbeatReconcileConns(t *testing.T) {
+	engine := newTestEngine(t, new(model.Audit), new(model.Device))
+	session := &model.Audit{RustdeskId: "182921366", ConnId: 762}
+	if _, err := engine.Insert(session); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = engine.Exec("UPDATE audit SET created_at = ?", time.Now().Add(-5*time.Minute).Format(config.TimeFormat))
+
+	app := iris.New()
+	app.RegisterDependency(engine)
+	mvc.New(app.Party("/api")).Handle(new(api.SystemController))
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	heartbeat := func(body string) *model.Audit {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest("POST", "/api/heartbeat", strings.NewReader(body)))
+		if strings.Contains(rec.Body.String(), "error") {
+			t.Fatalf("heartbeat error: %s", rec.Body.String())
+		}
+		audit := &model.Audit{}
+		_, _ = engine.ID(session.Id).Get(audit)
+		return audit
+	}
+
+	// an older client does not send its connections, its sessions stay open
+	if audit := heartbeat(`{"id":"182921366","uuid":"xxx","ver":1}`); !audit.ClosedAt.IsZero() {
+		t.Fatalf("expected the session to stay open without conns: %+v", audit)
+	}
+	if audit := heartbeat(`{"id":"182921366","uuid":"xxx","conns":[762]}`); !audit.ClosedAt.IsZero() {
+		t.Fatalf("expected the listed session to stay open: %+v", audit)
+	}
+	if audit := heartbeat(`{"id":"182921366","uuid":"xxx","conns":[]}`); audit.CloseReason != model.AUDIT_CLOSE_REASON_LOST {
+		t.Fatalf("expected the session to be lost once the client lists no connection: %+v", audit)
+	}
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"rustdesk-api-server-pro/app/middleware"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+)
+
+func TestClientConfig(t *testing.T) {
+	cfg := &rustdesk.ClientConfig{Host: "rd.example.com", Relay: "relay.example.com", Api: "https://rd.example.com", Key: "abc+def="}
+
+	code := cfg.Code()
+	parsed, err := rustdesk.ParseClientConfig(code)
+	if err != nil || *parsed != *cfg {
+		t.Fatalf("unexpected round trip of %q: %+v %v", code, parsed, err)
+	}
+	if code[len(code)-1] == '=' || code[0] == '=' {
+		t.Fatalf("expected the config string without padding: %q", code)
+	}
+
+	name, skipped := cfg.InstallerName()
+	if name != "rustdesk-host=rd.example.com,key=abc+def=,relay=relay.example.com,.exe" || len(skipped) != 1 || skipped[0] != "api" {
+		t.Fatalf("unexpected installer name %q, skipped %v", name, skipped)
+	}
+	cfg.Key = "ab/cd="
+	if _, skipped = cfg.InstallerName(); len(skipped) != 2 || skipped[0] != "key" {
+		t.Fatalf("expected a key with a slash to be left out: %v", skipped)
+	}
+
+	b, err := cfg.QRCode(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil || img.Bounds().Dx() != 128 {
+		t.Fatalf("unexpected QR code: %v", err)
+	}
+}
+
+func TestRequestUrlTrustedProxies(t *testing.T) {
+	cfg := config.GetDefaultServerConfig()
+	setTestConfig(t, cfg)
+
+	app := iris.New()
+	app.Get("/url", func(ctx iris.Context) {
+		_, _ = ctx.WriteString(middleware.RequestUrl(ctx))
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	requestUrl := func(remoteAddr string) string {
+		req := httptest.NewRequest("GET", "http://rd.example.com/url", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "evil.example.com, proxy.local")
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	if u := requestUrl("203.0.113.7:4000"); u != "http://rd.example.com" {
+		t.Fatalf("expected the forwarded headers to be ignored without trusted proxies, got %s", u)
+	}
+	cfg.HttpConfig.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+	if u := requestUrl("203.0.113.7:4000"); u != "http://rd.example.com" {
+		t.Fatalf("expected the forwarded headers of an untrusted client to be ignored, got %s", u)
+	}
+	if u := requestUrl("10.1.2.3:4000"); u != "https://evil.example.com" {
+		t.Fatalf("expected the forwarded headers of a trusted proxy, got %s", u)
+	}
+	if u := requestUrl("[::1]:4000"); u != "https://evil.example.com" {
+		t.Fatalf("expected the forwarded headers of a trusted proxy, got %s", u)
+	}
+
+	cfg.HttpConfig.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "httpConfig.trustedProxies[0]") {
+		t.Fatalf("expected an invalid trusted proxy to be refused, got %v", err)
+	}
+}
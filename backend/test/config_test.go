@@ -0,0 +1,122 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/config"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestServerConfigEnvAndValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "server.yaml")
+	err := os.WriteFile(file, []byte("signKey: \"0123456789abcdef\"\ndb:\n  driver: sqlite\n  dsn: ./server.db\n  timeZone: UTC\nhttpConfig:\n  port: \":8080\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFile := config.SetConfigFile(file)
+	t.Cleanup(func() { config.SetConfigFile(oldFile) })
+	t.Setenv("RDAPI_MASTER_KEY_FILE", filepath.Join(dir, "master.key"))
+
+	t.Setenv("RDAPI_HTTP_PORT", ":9090")
+	t.Setenv("RDAPI_DEBUG_MODE", "true")
+	passwordFile := filepath.Join(dir, "smtp_password")
+	_ = os.WriteFile(passwordFile, []byte("s3cret\n"), 0600)
+	t.Setenv("RDAPI_SMTP_PASSWORD_FILE", passwordFile)
+
+	cfg, err := config.LoadServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HttpConfig.Port != ":9090" || !cfg.DebugMode || cfg.SmtpConfig.Password != "s3cret" {
+		t.Fatalf("env overrides not applied: %+v %+v", cfg.HttpConfig, cfg.SmtpConfig)
+	}
+
+	t.Setenv("RDAPI_DB_DRIVER", "postgres")
+	t.Setenv("RDAPI_SIGN_KEY", "short")
+	t.Setenv("RDAPI_MAIL_TRANSPORT", "http") // without mailConfig.http.url
+	_, err = config.LoadServerConfig()
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Errors) != 3 {
+		t.Fatalf("expected 3 validation errors, got %v", err)
+	}
+
+	_ = os.WriteFile(file, []byte("signKey: \"0123456789abcdef\"\nunknownKey: 1\n"), 0600)
+	if _, err = config.ReadServerConfig(); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestServerConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "server.yaml")
+	write := func(signKey, port string, smtpPort int) {
+		content := fmt.Sprintf("signKey: %q\ndb:\n  driver: sqlite\n  dsn: ./server.db\n  timeZone: UTC\nhttpConfig:\n  port: %q\nsmtpConfig:\n  host: localhost\n  port: %d\n", signKey, port, smtpPort)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("0123456789abcdef", ":8080", 25)
+	oldFile := config.SetConfigFile(file)
+	t.Setenv("RDAPI_MASTER_KEY_FILE", filepath.Join(dir, "master.key"))
+	oldConfig := config.SetServerConfig(nil)
+	config.GetServerConfig()
+
+	smtpChanged := false
+	remove := config.OnReload(func(old, cfg *config.ServerConfig) {
+		smtpChanged = config.Changed(old, cfg, "smtpConfig")
+	})
+	t.Cleanup(func() {
+		remove()
+		// unloaded first, so the restored config is the startup config again
+		config.SetServerConfig(nil)
+		config.SetServerConfig(oldConfig)
+		config.SetConfigFile(oldFile)
+	})
+
+	write("0123456789abcdef", ":9090", 587)
+	result, err := config.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !smtpChanged || config.GetServerConfig().SmtpConfig.Port != 587 {
+		t.Fatalf("smtp change not applied: %+v", result)
+	}
+	if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "httpConfig.port" {
+		t.Fatalf("expected httpConfig.port to need a restart, got %v", result.RestartRequired)
+	}
+
+	write("short", ":9090", 587)
+	if _, err = config.Reload(); err == nil {
+		t.Fatal("expected invalid config to be rejected")
+	}
+	if config.GetServerConfig().SignKey != "0123456789abcdef" {
+		t.Fatal("rejected config replaced the current one")
+	}
+}
+
+func TestServerConfigRedacted(t *testing.T) {
+	cfg := config.GetDefaultServerConfig()
+	cfg.SignKey = "0123456789abcdef"
+	cfg.SmtpConfig.Password = "smtp-secret"
+	cfg.Db.Dsn = "rustdesk:db-secret@tcp(127.0.0.1:3306)/rustdesk"
+	cfg.MailConfig.Http.Headers = map[string]string{"Authorization": "Bearer api-secret", "X-Api-Key": "key-secret"}
+
+	b, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"0123456789abcdef", "smtp-secret", "db-secret", "api-secret", "key-secret"} {
+		if strings.Contains(string(b), s) {
+			t.Fatalf("%s is not redacted: %s", s, b)
+		}
+	}
+	if cfg.MailConfig.Http.Headers["Authorization"] != "Bearer api-secret" {
+		t.Fatal("Redacted changed the config it copies")
+	}
+}
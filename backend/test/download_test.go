@@ -0,0 +1,81 @@
+package test
+
+import (
+	"net/url"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedDownloadLinks(t *testing.T) {
+	cfg := config.GetDefaultServerConfig()
+	cfg.SignKey = "download-link-test-key"
+	setTestConfig(t, cfg)
+
+	verify := func(link string) (bool, error) {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return service.VerifyDownload(u.Path, u.Query())
+	}
+
+	link := service.SignDownloadUrl("https://rd.example.com", "/api/download/windows", url.Values{"arch": {"arm64"}}, time.Now().Add(time.Hour))
+	if signed, err := verify(link); err != nil || !signed {
+		t.Fatalf("expected the link to be valid: %s %v", link, err)
+	}
+	if _, err := verify(strings.Replace(link, "arm64", "x86_64", 1)); err != service.ErrDownloadLinkInvalid {
+		t.Fatalf("expected a changed parameter to be refused, got %v", err)
+	}
+	if _, err := verify(strings.Replace(link, "/windows", "/linux", 1)); err != service.ErrDownloadLinkInvalid {
+		t.Fatalf("expected another path to be refused, got %v", err)
+	}
+	// the file name is escaped in the url and verified decoded, as the router sees it
+	named := service.SignDownloadUrl("https://rd.example.com", "/api/download/RustDesk setup #2?.exe", nil, time.Now().Add(time.Hour))
+	if !strings.Contains(named, "/api/download/RustDesk%20setup%20%232%3F.exe?") {
+		t.Fatalf("expected the file name to be escaped: %s", named)
+	}
+	if signed, err := verify(named); err != nil || !signed {
+		t.Fatalf("expected the link of an escaped name to be valid: %s %v", named, err)
+	}
+	expired := service.SignDownloadUrl("", "/api/download/windows", nil, time.Now().Add(-time.Minute))
+	if _, err := verify(expired); err != service.ErrDownloadLinkExpired {
+		t.Fatalf("expected the link to be expired, got %v", err)
+	}
+
+	if signed, err := verify("/api/download/windows"); err != nil || signed {
+		t.Fatalf("expected unsigned downloads to be allowed: %v", err)
+	}
+	cfg.HttpConfig.RequireSignedDownloads = true
+	if _, err := verify("/api/download/windows"); err != service.ErrDownloadLinkMissing {
+		t.Fatalf("expected a signed link to be required, got %v", err)
+	}
+}
+
+func TestDownloadStats(t *testing.T) {
+	engine := newTestEngine(t, new(model.DownloadEvent))
+	s := service.NewDownloadService()
+	for _, e := range []model.DownloadEvent{
+		{File: "a.exe", Platform: "windows", Version: "1.3.2", Signed: true},
+		{File: "a.exe", Platform: "windows", Version: "1.3.2"},
+		{File: "b.exe", Platform: "windows", Version: "1.3.1"},
+		{File: "c.dmg", Platform: "macos", Version: "1.3.2"},
+	} {
+		s.Record(&e)
+	}
+	_, _ = engine.Exec("UPDATE download_event SET created_at = ? WHERE file = 'c.dmg'", time.Now().AddDate(0, 0, -40).Format(config.TimeFormat))
+
+	stats, err := s.Stats(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 3 || stats.Signed != 1 || len(stats.ByPlatform) != 1 || stats.ByPlatform[0].Count != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(stats.ByVersion) != 2 || stats.ByVersion[0].Version != "1.3.2" || stats.ByVersion[0].Count != 2 {
+		t.Fatalf("unexpected versions: %+v", stats.ByVersion)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/helper/export"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportWriters(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := export.NewWriter(export.FORMAT_CSV, &buf, "sessions", []string{"ID", "Name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteRow(1, "a,b")
+	_ = w.Close()
+	if buf.String() != "\xEF\xBB\xBFID,Name\n1,\"a,b\"\n" {
+		t.Fatalf("unexpected csv: %q", buf.String())
+	}
+
+	// text a client sent must not become a formula, numbers keep their sign
+	buf.Reset()
+	w, _ = export.NewWriter(export.FORMAT_CSV, &buf, "sessions", []string{"ID", "Name"})
+	for _, name := range []string{"=HYPERLINK(\"http://evil\")", "+1", "-2", "@SUM(A1)", "\tx", "\rx"} {
+		_ = w.WriteRow(-1, name)
+	}
+	_ = w.Close()
+	want := "\xEF\xBB\xBFID,Name\n-1,\"'=HYPERLINK(\"\"http://evil\"\")\"\n-1,'+1\n-1,'-2\n-1,'@SUM(A1)\n-1,'\tx\n-1,\"'\rx\"\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv: %q", buf.String())
+	}
+
+	buf.Reset()
+	w, err = export.NewWriter(export.FORMAT_XLSX, &buf, "sessions", []string{"ID", "Name", "Time"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.WriteRow(1, "<tag> & \x01", time.Now())
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range z.File {
+		r, _ := f.Open()
+		content, _ := io.ReadAll(r)
+		// every part must be well formed xml
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err = decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" && !strings.Contains(string(content), "&lt;tag&gt; &amp;") {
+			t.Fatalf("unexpected sheet: %s", content)
+		}
+	}
+}
+
+func TestAuditReportPdf(t *testing.T) {
+	engine := newTestEngine(t, new(model.Audit), new(model.User), new(model.Device), new(model.FileTransfer), new(model.Alarm))
+	_, _ = engine.Insert(&model.User{Username: "alice"})
+	_, _ = engine.Insert(&model.Audit{UserId: 1, RustdeskId: "123", Duration: 60, CloseReason: model.AUDIT_CLOSE_REASON_CLOSED})
+	_, _ = engine.Insert(&model.Audit{UserId: 1, RustdeskId: "123", Duration: 120, CloseReason: model.AUDIT_CLOSE_REASON_CLOSED})
+	_, _ = engine.Insert(&model.Audit{RustdeskId: "456"})
+	_, _ = engine.Insert(&model.FileTransfer{RustdeskId: "123", Path: "/data/ünïcode.txt", TotalSize: 2048})
+
+	report, err := service.NewReportService().AuditReport(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sessions != 3 || report.AvgDuration != 90 || len(report.ByUser) != 2 || report.ByUser[0].Name != "alice" || report.ByUser[0].Sessions != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.TopTransfers) != 1 {
+		t.Fatalf("expected 1 transfer, got %d", len(report.TopTransfers))
+	}
+
+	data, err := report.RenderPDF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Fatal("not a pdf")
+	}
+}
@@ -0,0 +1,40 @@
+package test
+
+import (
+	"context"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"testing"
+)
+
+func TestHealthReady(t *testing.T) {
+	engine := newTestEngine(t)
+	service.SetSchedulerProbe(nil)
+
+	status := func(checks []service.HealthCheck) map[string]string {
+		m := make(map[string]string)
+		for _, c := range checks {
+			m[c.Name] = c.Status
+		}
+		return m
+	}
+
+	ready, checks := service.NewHealthService().Ready(context.Background(), false)
+	s := status(checks)
+	if ready || s["database"] != service.HEALTH_OK || s["migrations"] != service.HEALTH_FAIL || s["scheduler"] != service.HEALTH_FAIL {
+		t.Fatalf("expected missing tables and scheduler to fail: %+v", checks)
+	}
+
+	if err := engine.Sync2(model.Tables()...); err != nil {
+		t.Fatal(err)
+	}
+	service.SetSchedulerProbe(func() error { return nil })
+	defer service.SetSchedulerProbe(nil)
+	ready, checks = service.NewHealthService().Ready(context.Background(), false)
+	if !ready {
+		t.Fatalf("expected ready: %+v", checks)
+	}
+	if _, ok := status(checks)["smtp"]; ok {
+		t.Fatal("smtp is only checked on request")
+	}
+}
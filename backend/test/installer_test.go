@@ -0,0 +1,97 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.3.10", "1.3.9", 1},
+		{"v1.3.2", "1.3.2", 0},
+		{"1.3", "1.3.0", 0},
+		{"1.3.2-beta1", "1.3.2", -1},
+		{"1.3.2-beta2", "1.3.2-beta1", 1},
+		{"1.2.9", "1.3.0-rc1", -1},
+	} {
+		if got := service.CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestInstallerCatalog(t *testing.T) {
+	newTestEngine(t, new(model.Installer))
+	cfg := config.GetDefaultServerConfig()
+	cfg.HttpConfig.InstallersDir = t.TempDir()
+	setTestConfig(t, cfg)
+
+	s := service.NewInstallerService()
+	add := func(arch, version, channel string) *model.Installer {
+		content := arch + version
+		installer, err := s.Add(strings.NewReader(content), "rustdesk-"+version+".exe", service.InstallerUpload{
+			Platform: "windows", Arch: arch, Version: version, Channel: channel,
+		}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		if installer.Sha256 != hex.EncodeToString(sum[:]) || installer.Size != int64(len(content)) {
+			t.Fatalf("unexpected checksum or size: %+v", installer)
+		}
+		return installer
+	}
+	add("amd64", "1.2.9", "stable")
+	newest := add("x86_64", "1.3.10", "stable")
+	add("x86_64", "1.3.2", "stable")
+	beta := add("x86_64", "1.4.0-beta1", "beta")
+	arm := add("aarch64", "1.3.1", "stable")
+	universal := add("universal", "1.3.10", "stable")
+
+	if _, err := os.Stat(s.FilePath(newest)); err != nil {
+		t.Fatal("expected the file in the catalog directory", err)
+	}
+	if _, err := s.Add(strings.NewReader("x"), "rustdesk-1.3.10.exe", service.InstallerUpload{Platform: "windows", Arch: "x64", Version: "1.3.10"}, 1); err == nil {
+		t.Fatal("expected a duplicate to be refused")
+	}
+	_, err := s.Add(strings.NewReader("x"), "a.exe", service.InstallerUpload{Platform: "windows", Arch: "x64", Version: "1.5.0", Sha256: strings.Repeat("0", 64)}, 1)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	latest := func(arch, channel string) int {
+		installer, err := s.Latest("windows", arch, channel)
+		if err != nil || installer == nil {
+			t.Fatalf("no installer for %s %s: %v", arch, channel, err)
+		}
+		return installer.Id
+	}
+	if id := latest("x86_64", "stable"); id != newest.Id {
+		t.Fatalf("expected the arch build of 1.3.10, got %d", id)
+	}
+	if id := latest("x86_64", "beta"); id != beta.Id {
+		t.Fatalf("expected the beta, got %d", id)
+	}
+	if id := latest("arm64", "stable"); id != universal.Id {
+		t.Fatalf("expected the newer universal build before the arm64 1.3.1 (%d), got %d", arm.Id, id)
+	}
+	if installer, _ := s.Latest("macos", "", "stable"); installer != nil {
+		t.Fatal("expected no macos build")
+	}
+
+	if count, err := s.Delete([]int{newest.Id}); err != nil || count != 1 {
+		t.Fatalf("unexpected delete: %d %v", count, err)
+	}
+	if _, err = os.Stat(s.FilePath(newest)); !os.IsNotExist(err) {
+		t.Fatal("expected the file to be removed")
+	}
+}
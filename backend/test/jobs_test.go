@@ -0,0 +1,117 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"testing"
+	"time"
+)
+
+func TestJobLeader(t *testing.T) {
+	engine := newTestEngine(t, new(model.JobLease))
+
+	ctx := context.Background()
+	a := service.NewJobLeader("a", time.Minute)
+	b := service.NewJobLeader("b", time.Minute)
+	a.Start()
+	b.Start()
+	if a.IsLeader(ctx) != nil || b.IsLeader(ctx) == nil {
+		t.Fatal("expected a to hold the lease and b to wait")
+	}
+
+	// b takes over as soon as a releases the lease
+	a.Stop()
+	b.Renew()
+	if a.IsLeader(ctx) == nil || b.IsLeader(ctx) != nil {
+		t.Fatal("expected b to take over the released lease")
+	}
+	if lease, err := service.NewJobService().Leader(); err != nil || lease == nil || lease.Holder != "b" {
+		t.Fatalf("expected b to be the running leader: %+v %v", lease, err)
+	}
+
+	// a lease that expired is taken by the next instance
+	b.Stop()
+	if lease, err := service.NewJobService().Leader(); err != nil || lease != nil {
+		t.Fatalf("expected no running instance after the last one stopped: %+v %v", lease, err)
+	}
+	_, _ = engine.Exec("UPDATE job_lease SET holder = 'gone', expires_at = ?", time.Now().Add(-time.Second).Unix())
+	c := service.NewJobLeader("c", time.Minute)
+	c.Start()
+	defer c.Stop()
+	if c.IsLeader(ctx) != nil {
+		t.Fatal("expected c to take the expired lease")
+	}
+}
+
+func TestJobRun(t *testing.T) {
+	engine := newTestEngine(t, new(model.JobLease), new(model.JobRun))
+	cfg := config.GetDefaultServerConfig()
+	cfg.JobsConfig.InstanceId = "test"
+	setTestConfig(t, cfg)
+	t.Cleanup(func() {
+		for _, name := range []string{"test_ok", "test_fail", "test_quiet"} {
+			service.UnregisterJob(name)
+		}
+	})
+
+	release := make(chan struct{})
+	service.RegisterJob("test_ok", "", func() (string, error) { <-release; return "done", nil })
+	service.RegisterJob("test_fail", "", func() (string, error) { return "", errors.New("boom") })
+
+	s := service.NewJobService()
+	run, err := s.Run("test_fail", model.JOB_TRIGGER_SCHEDULE)
+	if err != nil || run.Status != model.JOB_STATUS_FAILED || run.Error != "boom" || run.Instance != "test" {
+		t.Fatalf("unexpected failed run: %+v %v", run, err)
+	}
+
+	started, err := s.Start("test_ok", model.JOB_TRIGGER_MANUAL)
+	if err != nil || started.Status != model.JOB_STATUS_RUNNING {
+		t.Fatalf("unexpected started run: %+v %v", started, err)
+	}
+	if _, err = s.Run("test_ok", model.JOB_TRIGGER_SCHEDULE); !errors.Is(err, service.ErrJobRunning) {
+		t.Fatalf("expected the running job to be skipped, got %v", err)
+	}
+	close(release)
+	if !service.WaitStarted(5 * time.Second) {
+		t.Fatal("the manual run did not finish")
+	}
+
+	status, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := map[string]*model.JobRun{}
+	for _, job := range status.Jobs {
+		last[job.Name] = job.LastRun
+	}
+	if last["test_ok"] == nil || last["test_ok"].Status != model.JOB_STATUS_SUCCESS || last["test_ok"].Result != "done" ||
+		last["test_ok"].Trigger != model.JOB_TRIGGER_MANUAL {
+		t.Fatalf("unexpected last run: %+v", last["test_ok"])
+	}
+
+	// a successful scheduled run is recorded once, the next ones only when slow or an hour later
+	service.RegisterJob("test_quiet", "", func() (string, error) { return "", nil })
+	for i := 0; i < 3; i++ {
+		if run, err := s.Run("test_quiet", model.JOB_TRIGGER_SCHEDULE); err != nil || run.Status != model.JOB_STATUS_SUCCESS {
+			t.Fatalf("unexpected scheduled run: %+v %v", run, err)
+		}
+	}
+	if count, _ := engine.Where("job = ?", "test_quiet").Count(&model.JobRun{}); count != 1 {
+		t.Fatalf("expected one recorded scheduled run, got %d", count)
+	}
+
+	for i := 0; i < 105; i++ {
+		_, _ = s.Run("test_fail", model.JOB_TRIGGER_SCHEDULE)
+	}
+	if count, _ := engine.Where("job = ?", "test_fail").Count(&model.JobRun{}); count != 100 {
+		t.Fatalf("expected the history to keep 100 runs, got %d", count)
+	}
+
+	service.UnregisterJob("test_fail")
+	if _, err = s.Run("test_fail", model.JOB_TRIGGER_MANUAL); err == nil {
+		t.Fatal("expected an unregistered job not to run")
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/app/middleware"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/logger"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+)
+
+func TestLoggerModulesAndRedaction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs", "server.log")
+	err := logger.Configure(logger.Options{Level: "warn", Format: logger.FORMAT_JSON, Modules: map[string]string{"http": "debug"}, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Configure(logger.Options{})
+
+	ctx := logger.WithRequestId(context.Background(), "req-1")
+	logger.Module("http").DebugContext(ctx, "request", "password", "hunter2", "path", "/api/login")
+	logger.Module("jobs").Info("dropped below warn")
+	logger.Module("jobs").With("token", "abc").Error("failed")
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid json record %q", scanner.Text())
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+	if records[0]["module"] != "http" || records[0][logger.REQUEST_ID_KEY] != "req-1" || records[0]["password"] != logger.REDACTED {
+		t.Fatalf("unexpected http record %v", records[0])
+	}
+	if records[1]["module"] != "jobs" || records[1]["token"] != logger.REDACTED {
+		t.Fatalf("unexpected jobs record %v", records[1])
+	}
+
+	body := logger.RedactBody([]byte(`{"username":"admin","password":"pw","deviceInfo":{"tfaCode":"123456"}}`), 0)
+	if strings.Contains(body, "pw") || strings.Contains(body, "123456") || !strings.Contains(body, `"username":"admin"`) {
+		t.Fatalf("body not redacted: %s", body)
+	}
+	headers := logger.RedactHeaders(http.Header{"Authorization": {"secret-token"}, "Content-Type": {"application/json"}})
+	if headers["Authorization"] != logger.REDACTED || headers["Content-Type"] != "application/json" {
+		t.Fatalf("headers not redacted: %v", headers)
+	}
+
+	if err = logger.Configure(logger.Options{Level: "verbose"}); err == nil {
+		t.Fatal("expected unknown level to be rejected")
+	}
+}
+
+func TestLoggerRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.log")
+	err := logger.Configure(logger.Options{File: file, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Configure(logger.Options{})
+
+	log := logger.Module("app")
+	line := strings.Repeat("x", 64*1024)
+	for i := 0; i < 40; i++ {
+		log.Info("filler", "data", line)
+	}
+	for _, name := range []string{file, file + ".1", file + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024*1024 {
+			t.Fatalf("%s is larger than the limit: %d", name, info.Size())
+		}
+	}
+	if _, err = os.Stat(file + ".3"); err == nil {
+		t.Fatal("expected at most 2 backups")
+	}
+}
+
+func TestRequestLoggerBody(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.log")
+	if err := logger.Configure(logger.Options{Level: "info", Format: logger.FORMAT_JSON, Modules: map[string]string{"http": "debug"}, File: file}); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Configure(logger.Options{})
+	cfg := config.GetDefaultServerConfig()
+	cfg.HttpConfig.PrintRequestLog = true
+	setTestConfig(t, cfg)
+
+	app := iris.New()
+	app.Use(middleware.RequestLogger())
+	app.Post("/echo", func(ctx iris.Context) {
+		body, _ := io.ReadAll(ctx.Request().Body)
+		ctx.WriteString(strconv.Itoa(len(body)))
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	post := func(contentType, body string) string {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/echo", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		// the handler still reads the whole body
+		if rec.Body.String() != strconv.Itoa(len(body)) {
+			t.Fatalf("expected the handler to read %d bytes, got %s", len(body), rec.Body.String())
+		}
+		content, _ := os.ReadFile(file)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			record := map[string]interface{}{}
+			if json.Unmarshal([]byte(lines[i]), &record) == nil && record["msg"] == "request" {
+				return record["body"].(string)
+			}
+		}
+		t.Fatal("request not logged")
+		return ""
+	}
+
+	if body := post("application/json", `{"username":"admin","password":"pw"}`); strings.Contains(body, "pw") || !strings.Contains(body, "admin") {
+		t.Fatalf("unexpected logged body: %s", body)
+	}
+	if body := post("application/json", `{"data":"`+strings.Repeat("x", 5000)+`"}`); strings.Contains(body, "xxx") {
+		t.Fatalf("expected a long body not to be logged: %s", body)
+	}
+	if body := post("multipart/form-data; boundary=x", "--x\r\n"+strings.Repeat("y", 100)+"\r\n--x--\r\n"); strings.Contains(body, "yyy") {
+		t.Fatalf("expected an upload not to be logged: %s", body)
+	}
+}
@@ -0,0 +1,236 @@
+package test
+
+import (
+	"bufio"
+	"net"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// smtpStub is an in-process smtp server that keeps the messages it receives, it refuses the connections while
+// down is set
+type smtpStub struct {
+	listener    net.Listener
+	down        atomic.Bool
+	connections atomic.Int32
+	mu          sync.Mutex
+	messages    []string
+}
+
+func newSmtpStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (stub *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	stub.connections.Add(1)
+	if stub.down.Load() {
+		_, _ = conn.Write([]byte("421 service not available\r\n"))
+		return
+	}
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 stub")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				line, err = r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			stub.mu.Lock()
+			stub.messages = append(stub.messages, data.String())
+			stub.mu.Unlock()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (stub *smtpStub) Messages() []string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return append([]string(nil), stub.messages...)
+}
+
+func TestMailQueue(t *testing.T) {
+	engine := newTestEngine(t, new(model.MailLogs), new(model.MailAttachment), new(model.MailTemplate))
+
+	stub := newSmtpStub(t)
+	stub.down.Store(true)
+	addr := stub.listener.Addr().(*net.TCPAddr)
+	cfg := config.GetDefaultServerConfig()
+	cfg.SmtpConfig.Host = addr.IP.String()
+	cfg.SmtpConfig.Port = addr.Port
+	cfg.SmtpConfig.From = "rustdesk@example.com"
+	cfg.JobsConfig.MailQueueJob.MaxAttempts = 2
+	cfg.JobsConfig.MailQueueJob.BackoffSeconds = 60
+	setTestConfig(t, cfg)
+
+	content := &service.MailContent{Subject: "Queued report", Html: "<p>report</p>"}
+	err := service.NewMailService().SendContent(0, 0, "admin@example.com", "mail-queue-test", content,
+		&mail.File{Name: "report.pdf", MimeType: "application/pdf", Data: []byte("%PDF-1.4")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() *model.MailLogs {
+		log := &model.MailLogs{}
+		if _, err := engine.Where("uuid = ?", "mail-queue-test").Get(log); err != nil {
+			t.Fatal(err)
+		}
+		return log
+	}
+	if log := get(); log.Status != model.MAIL_SEND_QUEUED || log.Subject != "Queued report" {
+		t.Fatalf("expected the mail to be queued: %+v", log)
+	}
+
+	q := service.NewMailQueueService()
+	drain := func(sent, retry, dead int) {
+		t.Helper()
+		result, err := q.Drain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Sent != sent || result.Retry != retry || result.Dead != dead {
+			t.Fatalf("expected %d sent, %d to retry, %d dead, got %s", sent, retry, dead, result)
+		}
+	}
+
+	// the server is down, the mail waits for the backoff
+	drain(0, 1, 0)
+	log := get()
+	if log.Status != model.MAIL_SEND_ERR || log.Attempts != 1 || !log.NextAttemptAt.After(time.Now().Add(30*time.Second)) {
+		t.Fatalf("expected a retry after the backoff: %+v", log)
+	}
+	drain(0, 0, 0)
+
+	// the second attempt is the last one
+	_, _ = engine.Exec("UPDATE mail_logs SET next_attempt_at = ?", time.Now().Add(-time.Second).Format(config.TimeFormat))
+	drain(0, 0, 1)
+	if log = get(); log.Status != model.MAIL_SEND_DEAD || log.Attempts != 2 || strings.Count(log.Logs, "\n") != 2 {
+		t.Fatalf("expected the mail to be dead after 2 attempts: %+v", log)
+	}
+	drain(0, 0, 0)
+
+	stub.down.Store(false)
+	if n, err := q.Resend([]int{log.Id}); err != nil || n != 1 {
+		t.Fatalf("expected the mail to be queued again: %d %v", n, err)
+	}
+	drain(1, 0, 0)
+	if log = get(); log.Status != model.MAIL_SEND_OK || log.Attempts != 1 || log.SentAt.IsZero() {
+		t.Fatalf("expected the mail to be sent: %+v", log)
+	}
+	messages := stub.Messages()
+	if len(messages) != 1 || !strings.Contains(messages[0], "Subject: Queued report") || !strings.Contains(messages[0], "report.pdf") ||
+		!strings.Contains(messages[0], "text/plain") || !strings.Contains(messages[0], "text/html") {
+		t.Fatalf("unexpected messages: %q", messages)
+	}
+}
+
+func TestMailQueueExpiry(t *testing.T) {
+	engine := newTestEngine(t, new(model.MailLogs), new(model.MailAttachment), new(model.MailTemplate), new(model.User))
+
+	stub := newSmtpStub(t)
+	stub.down.Store(true)
+	addr := stub.listener.Addr().(*net.TCPAddr)
+	cfg := config.GetDefaultServerConfig()
+	cfg.SmtpConfig.Host = addr.IP.String()
+	cfg.SmtpConfig.Port = addr.Port
+	cfg.JobsConfig.MailQueueJob.BackoffSeconds = 60
+	setTestConfig(t, cfg)
+
+	// a failure of the versions before the queue is not waiting for a retry
+	legacy := &model.MailLogs{To: "old@example.com", Status: model.MAIL_SEND_ERR}
+	retry := &model.MailLogs{To: "retry@example.com", Status: model.MAIL_SEND_ERR, NextAttemptAt: time.Now().Add(time.Hour)}
+	if _, err := engine.Insert(legacy, retry); err != nil {
+		t.Fatal(err)
+	}
+	q := service.NewMailQueueService()
+	if n, err := q.MarkLegacyFailed(); err != nil || n != 1 {
+		t.Fatalf("expected the legacy failure to be migrated: %d %v", n, err)
+	}
+	status := func(id int) int {
+		log := &model.MailLogs{}
+		_, _ = engine.ID(id).Get(log)
+		return log.Status
+	}
+	if status(legacy.Id) != model.MAIL_SEND_DEAD || status(retry.Id) != model.MAIL_SEND_ERR {
+		t.Fatalf("unexpected statuses %d %d", status(legacy.Id), status(retry.Id))
+	}
+	_, _ = engine.ID(retry.Id).Delete(&model.MailLogs{})
+
+	// a verification code is not retried past its expiry
+	err := service.NewMailService().SendExpiring(0, model.MAIL_TPL_TYPE_LOGIN_VERIFY, "user@example.com", "code-mail",
+		map[string]string{"code": "X7K2QD", "expired": "10"}, time.Now().Add(90*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := &model.MailLogs{}
+	if _, err = engine.Where("uuid = ?", "code-mail").Get(code); err != nil || code.ExpiresAt.IsZero() {
+		t.Fatalf("expected the mail to expire: %+v %v", code, err)
+	}
+	if result, _ := q.Drain(); result.Retry != 1 {
+		t.Fatalf("expected a retry before the expiry, got %s", result)
+	}
+	_, _ = engine.Exec("UPDATE mail_logs SET next_attempt_at = ?", time.Now().Add(-time.Second).Format(config.TimeFormat))
+	if result, _ := q.Drain(); result.Dead != 1 || status(code.Id) != model.MAIL_SEND_DEAD {
+		t.Fatalf("expected the mail to be dead when the next attempt is after its expiry, got %s", result)
+	}
+
+	// a mail that expired while waiting is not sent at all
+	stub.down.Store(false)
+	if _, err = q.Resend([]int{code.Id}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = engine.Exec("UPDATE mail_logs SET expires_at = ?", time.Now().Add(-time.Second).Format(config.TimeFormat))
+	connections := stub.connections.Load()
+	if result, _ := q.Drain(); result.Dead != 1 || stub.connections.Load() != connections {
+		t.Fatalf("expected the expired mail to be dropped without sending, got %s", result)
+	}
+	code = &model.MailLogs{}
+	_, _ = engine.Where("uuid = ?", "code-mail").Get(code)
+	if code.Status != model.MAIL_SEND_DEAD || !strings.Contains(code.Logs, "expired") {
+		t.Fatalf("unexpected mail: %+v", code)
+	}
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"strings"
+	"testing"
+)
+
+func TestRenderMailTemplate(t *testing.T) {
+	// a template written before html/template
+	legacy := &model.MailTemplate{
+		Type:     model.MAIL_TPL_TYPE_LOGIN_VERIFY,
+		Subject:  "Code for {$username}",
+		Contents: `<p>Hello {$username}, your code is <b>{$code}</b>{{if .expired}}, valid {{.expired}} minutes{{end}}.</p>`,
+	}
+	content, err := service.RenderMailTemplate(legacy, map[string]string{"username": "<Bob>", "code": "AB12", "expired": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Subject != "Code for <Bob>" {
+		t.Fatalf("unexpected subject %q", content.Subject)
+	}
+	if content.Html != "<p>Hello &lt;Bob&gt;, your code is <b>AB12</b>, valid 10 minutes.</p>" {
+		t.Fatalf("expected the variables to be escaped, got %q", content.Html)
+	}
+	if text := service.HtmlToText(content.Html); text != "Hello <Bob>, your code is AB12, valid 10 minutes." {
+		t.Fatalf("unexpected text part %q", text)
+	}
+
+	if err = service.ValidateMailTemplate(legacy); err != nil {
+		t.Fatalf("expected the template to be valid: %v", err)
+	}
+	legacy.Contents += "{$link}"
+	if err = service.ValidateMailTemplate(legacy); err == nil || !strings.Contains(err.Error(), "link") {
+		t.Fatalf("expected a variable of another type to be refused, got %v", err)
+	}
+	legacy.Contents = "{{if .code}}"
+	if err = service.ValidateMailTemplate(legacy); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+
+	// a new line in a value must not add a header
+	content, err = service.RenderMailTemplate(&model.MailTemplate{Subject: "Hi {{.username}}", Contents: "-"},
+		map[string]string{"username": "x\r\nBcc: evil@example.com"})
+	if err != nil || strings.ContainsAny(content.Subject, "\r\n") {
+		t.Fatalf("unexpected subject %q %v", content.Subject, err)
+	}
+}
+
+func TestMailTemplateLanguage(t *testing.T) {
+	engine := newTestEngine(t, new(model.MailTemplate))
+	s := service.NewMailService()
+
+	tpl, err := s.Template(model.MAIL_TPL_TYPE_ALARM, "de")
+	if err != nil || tpl.Id != 0 || !strings.Contains(tpl.Subject, "{{.type}}") {
+		t.Fatalf("expected the built-in alarm template: %+v %v", tpl, err)
+	}
+	if _, err = s.Template(model.MAIL_TPL_TYPE_OTHER, ""); err == nil {
+		t.Fatal("expected no template of the other type")
+	}
+
+	for _, tpl := range []*model.MailTemplate{
+		{Name: "default", Type: model.MAIL_TPL_TYPE_ALARM, Subject: "Alarm", Contents: "alarm"},
+		{Name: "chinese", Type: model.MAIL_TPL_TYPE_ALARM, Language: "zh", Subject: "警报", Contents: "警报"},
+		{Name: "traditional", Type: model.MAIL_TPL_TYPE_ALARM, Language: "zh-tw", Subject: "警報", Contents: "警報"},
+	} {
+		if _, err = engine.Insert(tpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for language, name := range map[string]string{"zh_TW": "traditional", "zh-CN": "chinese", "fr": "default", "": "default"} {
+		if tpl, err = s.Template(model.MAIL_TPL_TYPE_ALARM, language); err != nil || tpl.Name != name {
+			t.Fatalf("expected the %s template for %q, got %+v %v", name, language, tpl, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/mailer"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() *mailer.Message {
+	return &mailer.Message{
+		From:        "rustdesk@example.com",
+		To:          "alice@example.com",
+		Subject:     "Transport test",
+		Html:        "<p>Hello</p>",
+		Text:        "Hello",
+		Attachments: []mailer.Attachment{{Name: "a.txt", MimeType: "text/plain", Data: []byte("attached")}},
+	}
+}
+
+func TestSmtpPool(t *testing.T) {
+	stub := newSmtpStub(t)
+	addr := stub.listener.Addr().(*net.TCPAddr)
+	pool := mailer.NewSmtpPool(mailer.SmtpOptions{Host: addr.IP.String(), Port: addr.Port, PoolSize: 1, IdleTimeout: time.Minute,
+		ConnectTimeout: time.Second, SendTimeout: time.Second})
+	defer pool.Close()
+	for i := 0; i < 3; i++ {
+		if err := pool.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(stub.Messages()); n != 3 {
+		t.Fatalf("expected 3 messages, got %d", n)
+	}
+	if n := stub.connections.Load(); n != 1 {
+		t.Fatalf("expected the connection to be kept open, got %d connections", n)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	transport := &mailer.FileTransport{Dir: dir}
+	if err := transport.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, want := range []string{"Subject: Transport test", "To: <alice@example.com>", "multipart/alternative", "text/plain", "text/html", "a.txt"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in the message:\n%s", want, data)
+		}
+	}
+}
+
+func TestHttpTransport(t *testing.T) {
+	var got map[string]interface{}
+	var auth string
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"error": "quota exceeded"}`))
+	}))
+	defer server.Close()
+
+	transport, err := mailer.NewHttpTransport(mailer.HttpOptions{Url: server.URL, Headers: map[string]string{"Authorization": "Bearer k"}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testMessage()
+	msg.Subject = `Quote " and \ backslash`
+	if err = transport.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer k" || got["subject"] != msg.Subject || got["to"].([]interface{})[0] != "alice@example.com" {
+		t.Fatalf("unexpected request %s %v", auth, got)
+	}
+	attachment := got["attachments"].([]interface{})[0].(map[string]interface{})
+	if data, _ := base64.StdEncoding.DecodeString(attachment["content"].(string)); string(data) != "attached" {
+		t.Fatalf("unexpected attachment %v", attachment)
+	}
+
+	status = http.StatusTooManyRequests
+	if err = transport.Send(msg); err == nil || !strings.Contains(err.Error(), "429") || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("expected the status and the response in the error, got %v", err)
+	}
+
+	custom, err := mailer.NewHttpTransport(mailer.HttpOptions{Url: server.URL, Body: `{"personalizations": [{"to": [{"email": {{json .To}}}]}]}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusOK
+	if err = custom.Send(msg); err != nil || got["personalizations"] == nil {
+		t.Fatalf("expected the custom body: %v %v", got, err)
+	}
+	if _, err = mailer.NewHttpTransport(mailer.HttpOptions{Body: "{{.Missing"}); err == nil {
+		t.Fatal("expected a template error")
+	}
+}
+
+func TestSendmailTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.eml")
+	script := filepath.Join(dir, "sendmail")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = \"-t\" ] || exit 2\ncat > "+out+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&mailer.Sendmail{Path: script, Args: []string{"-t", "-i"}, Timeout: 5 * time.Second}).Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	if !strings.Contains(string(data), "To: <alice@example.com>\n") || strings.Contains(string(data), "\r\n") {
+		t.Fatalf("expected the message with unix line endings:\n%q", data)
+	}
+	if err := (&mailer.Sendmail{Path: script}).Send(testMessage()); err == nil || !strings.Contains(err.Error(), "sendmail error") {
+		t.Fatalf("expected the exit status of sendmail, got %v", err)
+	}
+}
+
+func TestMailServiceTransport(t *testing.T) {
+	newTestEngine(t, new(model.MailLogs), new(model.MailAttachment), new(model.MailTemplate))
+	dirs := []string{filepath.Join(t.TempDir(), "a"), filepath.Join(t.TempDir(), "b")}
+	cfg := config.GetDefaultServerConfig()
+	cfg.MailConfig.Transport = mailer.TRANSPORT_FILE
+	cfg.MailConfig.File.Dir = dirs[0]
+	setTestConfig(t, cfg)
+	if service.NewMailService() != service.NewMailService() {
+		t.Fatal("expected the mail service to be cached")
+	}
+
+	send := func() {
+		t.Helper()
+		content := &service.MailContent{Subject: "File transport", Html: "<p>file</p>"}
+		if err := service.NewMailService().SendContent(0, 0, "alice@example.com", "", content); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := service.NewMailQueueService().Drain(); err != nil || result.Sent != 1 {
+			t.Fatalf("expected the mail to be sent: %v %v", result, err)
+		}
+	}
+	send()
+
+	// a new config with another directory builds a new transport
+	next := config.GetDefaultServerConfig()
+	next.MailConfig.Transport = mailer.TRANSPORT_FILE
+	next.MailConfig.File.Dir = dirs[1]
+	config.SetServerConfig(next)
+	send()
+
+	for _, dir := range dirs {
+		if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 1 {
+			t.Fatalf("expected one .eml file in %s, got %v", dir, files)
+		}
+	}
+}
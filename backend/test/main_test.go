@@ -0,0 +1,45 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/db"
+	"testing"
+
+	"xorm.io/xorm"
+)
+
+func TestMain(m *testing.M) {
+	// a config is always set, loading one would create server.yaml and master.key in the test directory
+	cfg := config.GetDefaultServerConfig()
+	cfg.SignKey = "0123456789abcdef"
+	config.SetServerConfig(cfg)
+	os.Exit(m.Run())
+}
+
+// newTestEngine opens a sqlite database in a temp dir with the given tables. db.NewEngine replaces the global
+// db.DbEngine, the previous one is restored and the database closed when the test ends.
+func newTestEngine(t *testing.T, beans ...any) *xorm.Engine {
+	t.Helper()
+	old := db.DbEngine
+	engine, err := db.NewEngine(&config.DbConfig{Driver: "sqlite", Dsn: filepath.Join(t.TempDir(), "test.db"), TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.DbEngine = old
+		_ = engine.Close()
+	})
+	if err = engine.Sync2(beans...); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+// setTestConfig sets the server config until the test ends
+func setTestConfig(t *testing.T, cfg *config.ServerConfig) {
+	t.Helper()
+	old := config.SetServerConfig(cfg)
+	t.Cleanup(func() { config.SetServerConfig(old) })
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"testing"
+	"time"
+)
+
+func TestRetentionPrune(t *testing.T) {
+	dir := t.TempDir()
+	engine := newTestEngine(t, new(model.Audit), new(model.FileTransfer), new(model.FileTransferItem), new(model.AuthToken), new(model.VerifyCode))
+	cfg := config.GetDefaultServerConfig()
+	cfg.SignKey = "0123456789abcdef"
+	cfg.Retention.ArchiveDir = filepath.Join(dir, "archive")
+	cfg.Retention.BatchSize = 2
+	cfg.Retention.FileTransfer = &config.RetentionPolicy{Days: 30, Archive: true}
+	cfg.Retention.Audit = &config.RetentionPolicy{Days: 30}
+	setTestConfig(t, cfg)
+
+	old := time.Now().AddDate(0, 0, -40)
+	for i := 0; i < 5; i++ {
+		transfer := &model.FileTransfer{RustdeskId: "1"}
+		_, _ = engine.Insert(transfer)
+		_, _ = engine.Insert(&model.FileTransferItem{FileTransferId: transfer.Id, Name: "a"})
+		if i < 3 {
+			_, _ = engine.Exec("UPDATE file_transfer SET created_at = ? WHERE id = ?", old.Format(config.TimeFormat), transfer.Id)
+		}
+	}
+	// a pruned session is no longer referenced by the transfers that are kept
+	session := &model.Audit{RustdeskId: "1", ClosedAt: old, CloseReason: model.AUDIT_CLOSE_REASON_CLOSED}
+	_, _ = engine.Insert(session)
+	_, _ = engine.Exec("UPDATE audit SET created_at = ?", old.Format(config.TimeFormat))
+	_, _ = engine.Exec("UPDATE file_transfer SET audit_id = ?", session.Id)
+	_, _ = engine.Insert(&model.AuthToken{Token: "expired", Expired: old})
+	_, _ = engine.Insert(&model.AuthToken{Token: "valid", Expired: time.Now().Add(time.Hour)})
+
+	s := service.NewRetentionService()
+	report, err := s.Prune(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 4 || report[0].Table != "audit" || report[0].Rows != 1 ||
+		report[1].Table != "file_transfer" || report[1].Rows != 3 || report[1].ChildRows != 3 {
+		t.Fatalf("unexpected dry run: %+v", report)
+	}
+	if count, _ := engine.Count(&model.FileTransfer{}); count != 5 {
+		t.Fatalf("dry run deleted rows, %d left", count)
+	}
+
+	results, err := s.Prune(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("%s: %s", r.Table, r.Error)
+		}
+	}
+	if count, _ := engine.Count(&model.FileTransfer{}); count != 2 {
+		t.Fatalf("expected 2 transfers left, got %d", count)
+	}
+	if count, _ := engine.Count(&model.FileTransferItem{}); count != 2 {
+		t.Fatalf("expected 2 files left, got %d", count)
+	}
+	if count, _ := engine.Where("audit_id <> 0").Count(&model.FileTransfer{}); count != 0 {
+		t.Fatalf("expected the transfers to drop the pruned session, %d still reference it", count)
+	}
+	if count, _ := engine.Count(&model.AuthToken{}); count != 1 {
+		t.Fatalf("expected the valid token to be kept, got %d tokens", count)
+	}
+
+	if len(results[1].ArchiveFiles) != 2 {
+		t.Fatalf("expected transfer and file archives, got %v", results[1].ArchiveFiles)
+	}
+	f, err := os.Open(results[1].ArchiveFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(gz); scanner.Scan(); {
+		lines++
+	}
+	if lines != 3 {
+		t.Fatalf("expected 3 archived rows, got %d", lines)
+	}
+}
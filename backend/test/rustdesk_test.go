@@ -0,0 +1,316 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/github"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeStub writes a shell script standing in for hbbs or hbbr
+func writeStub(t *testing.T, dir, name, script string) string {
+	bin := filepath.Join(dir, name)
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestRustdeskProcesses(t *testing.T) {
+	cfg := config.GetDefaultServerConfig().Rustdesk
+	cfg.RelayServers = []string{"relay1:21117", "relay2:21117"}
+	cfg.Key = "_"
+	cfg.HbbsPort = 31116
+	cfg.HbbrArgs = []string{"--max-bandwidth", "100"}
+
+	processes := service.RustdeskProcesses(cfg)
+	args := map[string]string{}
+	for _, p := range processes {
+		args[p.Name] = strings.Join(p.Args, " ")
+	}
+	if args["hbbs"] != "-p 31116 -r relay1:21117,relay2:21117 -k _" || args["hbbr"] != "-k _ --max-bandwidth 100" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+}
+
+func TestRustdeskSupervisor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stubs are shell scripts")
+	}
+	dir := t.TempDir()
+	crash := writeStub(t, dir, "hbbs", `echo "started $@"; exit 1`)
+	serve := writeStub(t, dir, "hbbr", `echo "relay $@"; exec sleep 60`)
+
+	s := rustdesk.NewSupervisor(
+		rustdesk.ProcessOptions{Name: "hbbs", Bin: crash, Dir: dir, Args: []string{"-k", "_"}, LogFile: filepath.Join(dir, "hbbs.log"), MaxBackoff: time.Second},
+		rustdesk.ProcessOptions{Name: "hbbr", Bin: serve, Dir: dir, PidFile: filepath.Join(dir, "hbbr.pid"), LogFile: filepath.Join(dir, "hbbr.log")},
+	)
+	s.Start()
+
+	status := func() map[string]rustdesk.ProcessStatus {
+		m := map[string]rustdesk.ProcessStatus{}
+		for _, p := range s.Status() {
+			m[p.Name] = p
+		}
+		return m
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status()["hbbs"].Restarts < 2 || !status()["hbbr"].Running {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected hbbs to be restarted and hbbr to run: %+v", status())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if hbbs := status()["hbbs"]; hbbs.LastExit != "exit status 1" {
+		t.Fatalf("unexpected last exit: %+v", hbbs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hbbr.pid")); err != nil {
+		t.Fatal("expected the hbbr pid file", err)
+	}
+
+	s.Stop(5 * time.Second)
+	if hbbr := status()["hbbr"]; hbbr.Running {
+		t.Fatalf("expected hbbr to be stopped: %+v", hbbr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hbbr.pid")); !os.IsNotExist(err) {
+		t.Fatal("expected the pid file to be removed")
+	}
+
+	log, _ := os.ReadFile(filepath.Join(dir, "hbbs.log"))
+	if strings.Count(string(log), "started -k _") < 3 {
+		t.Fatalf("expected the output of every hbbs run in the log: %q", log)
+	}
+}
+
+// stubZip builds a rustdesk-server zip whose hbbs and hbbr run script
+func stubZip(t *testing.T, script string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"hbbs", "hbbr"} {
+		header := &zip.FileHeader{Name: "amd64/" + name, Method: zip.Deflate}
+		header.SetMode(0755)
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write([]byte("#!/bin/sh\n" + script + "\n"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRustdeskUpgrade(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skip("rustdesk-server releases have no zip for this system")
+	}
+	dir := t.TempDir()
+	writeStub(t, dir, "hbbs", "# old\nexec sleep 60")
+	writeStub(t, dir, "hbbr", "# old\nexec sleep 60")
+	defer rustdesk.SetServerBinDir(rustdesk.GetRustdeskServerBinDir())
+	rustdesk.SetServerBinDir(dir)
+
+	cfg := config.GetDefaultServerConfig()
+	cfg.Rustdesk.BinDir = dir
+	cfg.Rustdesk.LogDir = ""
+	cfg.Shutdown.Timeout = 5
+	setTestConfig(t, cfg)
+
+	supervisor := rustdesk.NewSupervisor(service.RustdeskProcesses(cfg.Rustdesk)...)
+	supervisor.Start()
+	service.SetRustdeskSupervisor(supervisor)
+	defer func() {
+		supervisor.Stop(5 * time.Second)
+		service.SetRustdeskSupervisor(nil)
+	}()
+	service.SetUpgradeCheckDelay(1500 * time.Millisecond)
+
+	zips := map[string][]byte{
+		"good":  stubZip(t, "# new\nexec sleep 60"),
+		"crash": stubZip(t, "exit 1"),
+	}
+	digest := func(name string) string {
+		sum := sha256.Sum256(zips[name])
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	releases := map[string]struct{ zip, digest string }{
+		"1.2.0": {"good", digest("good")},
+		"1.2.1": {"crash", digest("crash")},
+		"1.2.2": {"good", digest("crash")},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := strings.CutPrefix(r.URL.Path, "/download/"); ok {
+			_, _ = w.Write(zips[name])
+			return
+		}
+		tag := strings.TrimPrefix(r.URL.Path, "/repos/rustdesk/rustdesk-server/releases/tags/")
+		release, ok := releases[tag]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		assets := make([]github.Asset, 0)
+		for _, name := range []string{"rustdesk-server-linux-amd64.zip", "rustdesk-server-linux-arm64v8.zip"} {
+			assets = append(assets, github.Asset{Name: name, BrowserDownloadURL: "http://" + r.Host + "/download/" + release.zip, Digest: release.digest})
+		}
+		_ = json.NewEncoder(w).Encode(github.Release{TagName: tag, Assets: assets})
+	}))
+	defer server.Close()
+	defer func(base string) { github.ApiBaseUrl = base }(github.ApiBaseUrl)
+	github.ApiBaseUrl = server.URL
+
+	hbbs := func() string {
+		b, _ := os.ReadFile(filepath.Join(dir, "hbbs"))
+		return string(b)
+	}
+
+	s := service.NewRustdeskService()
+	if _, err := s.Upgrade("1.2.2", ""); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := s.Upgrade("1.2.1", ""); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected the crashing release to be rolled back, got %v", err)
+	}
+	if !strings.Contains(hbbs(), "# old") {
+		t.Fatalf("expected the old hbbs after the rollback: %q", hbbs())
+	}
+
+	result, err := s.Upgrade("1.2.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Verified || result.To != "1.2.0" || !strings.Contains(hbbs(), "# new") || rustdesk.InstalledVersion() != "1.2.0" {
+		t.Fatalf("unexpected upgrade: %+v %q", result, hbbs())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hbbs.bak")); err != nil {
+		t.Fatal("expected the previous hbbs to be kept", err)
+	}
+}
+
+func TestReleasesCache(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		_ = json.NewEncoder(w).Encode([]github.Release{{TagName: "1.2.0-rc1", Prerelease: true}, {TagName: "1.1.14"}})
+	}))
+	defer func(base string) { github.ApiBaseUrl = base }(github.ApiBaseUrl)
+	github.ApiBaseUrl = server.URL
+	cache := t.TempDir()
+	github.SetReleasesCache(cache, time.Hour)
+	defer github.SetReleasesCache("", 0)
+
+	for i := 0; i < 2; i++ {
+		if releases, err := github.FetchReleases(rustdesk.REPO); err != nil || len(releases) != 2 {
+			t.Fatalf("unexpected releases: %+v %v", releases, err)
+		}
+	}
+	if latest, err := github.FetchRelease(rustdesk.REPO, "latest"); err != nil || latest.TagName != "1.1.14" {
+		t.Fatalf("expected the latest stable release from the cache: %+v %v", latest, err)
+	}
+	if hits != 1 {
+		t.Fatalf("expected one request, got %d", hits)
+	}
+
+	// an expired list is used when the api is down
+	server.Close()
+	expired := time.Now().Add(-2 * time.Hour)
+	files, _ := filepath.Glob(filepath.Join(cache, "*.json"))
+	for _, f := range files {
+		_ = os.Chtimes(f, expired, expired)
+	}
+	if releases, err := github.FetchReleases(rustdesk.REPO); err != nil || len(releases) != 2 {
+		t.Fatalf("expected the expired list: %+v %v", releases, err)
+	}
+	github.SetReleasesCache(t.TempDir(), time.Hour)
+	if _, err := github.FetchReleases(rustdesk.REPO); err == nil {
+		t.Fatal("expected an error without a cache")
+	}
+}
+
+func TestRustdeskInstall(t *testing.T) {
+	assetName, archDir := rustdesk.AssetName()
+	if assetName == "" {
+		t.Skip("rustdesk-server releases have no zip for this system")
+	}
+	defer rustdesk.SetServerBinDir(rustdesk.GetRustdeskServerBinDir())
+
+	artifacts := t.TempDir()
+	zipData := stubZip(t, "# mirrored")
+	sum := sha256.Sum256(zipData)
+	digest := hex.EncodeToString(sum[:])
+	zipFile := filepath.Join(artifacts, assetName)
+	_ = os.WriteFile(zipFile, zipData, 0644)
+	_ = os.WriteFile(filepath.Join(artifacts, "SHA256SUMS"), []byte(digest+"  "+assetName+"\n"), 0644)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(artifacts)))
+	defer server.Close()
+
+	hbbs := func(dir string) string {
+		b, _ := os.ReadFile(filepath.Join(dir, "hbbs"))
+		return string(b)
+	}
+	install := func(opts rustdesk.InstallOptions) (string, *rustdesk.InstallResult, error) {
+		dir := t.TempDir()
+		rustdesk.SetServerBinDir(dir)
+		result, err := rustdesk.Install(opts)
+		return dir, result, err
+	}
+
+	// a mirror url with its checksum file
+	dir, result, err := install(rustdesk.InstallOptions{From: server.URL + "/", ChecksumFile: server.URL + "/SHA256SUMS", Version: "1.1.14"})
+	if err != nil || !result.Verified || !strings.Contains(hbbs(dir), "# mirrored") || rustdesk.InstalledVersion() != "1.1.14" {
+		t.Fatalf("unexpected mirror install: %+v %v", result, err)
+	}
+
+	// a local zip with a wrong checksum is refused
+	if dir, _, err = install(rustdesk.InstallOptions{From: zipFile, Sha256: strings.Repeat("0", 64)}); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "hbbs")); !os.IsNotExist(err) {
+		t.Fatal("expected nothing installed after a checksum mismatch")
+	}
+
+	// a checksum file that does not list the zip is refused, not skipped
+	other := filepath.Join(t.TempDir(), "SHA256SUMS")
+	_ = os.WriteFile(other, []byte(digest+"  other.zip\n"+digest+"  another.zip\n"), 0644)
+	if dir, _, err = install(rustdesk.InstallOptions{From: zipFile, ChecksumFile: other}); err == nil || !strings.Contains(err.Error(), "does not list") {
+		t.Fatalf("expected the unlisted zip to be refused, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "hbbs")); !os.IsNotExist(err) {
+		t.Fatal("expected nothing installed without a checksum")
+	}
+
+	// a directory holding the zip, verified by the checksum file next to it
+	if dir, result, err = install(rustdesk.InstallOptions{From: artifacts, ChecksumFile: filepath.Join(artifacts, "SHA256SUMS")}); err != nil || !result.Verified || hbbs(dir) == "" {
+		t.Fatalf("unexpected directory install: %+v %v", result, err)
+	}
+
+	// a directory of extracted binaries, one of them tampered with
+	extracted := filepath.Join(t.TempDir(), archDir)
+	_ = os.MkdirAll(extracted, 0755)
+	writeStub(t, extracted, "hbbs", "# extracted")
+	writeStub(t, extracted, "hbbr", "# extracted")
+	hbbsSum, _ := rustdesk.Sha256File(filepath.Join(extracted, "hbbs"))
+	sums := filepath.Join(t.TempDir(), "sums")
+	_ = os.WriteFile(sums, []byte(hbbsSum+"  hbbs\n"+strings.Repeat("0", 64)+"  hbbr\n"), 0644)
+	if _, _, err = install(rustdesk.InstallOptions{From: filepath.Dir(extracted), ChecksumFile: sums}); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected the tampered hbbr to be refused, got %v", err)
+	}
+	if dir, result, err = install(rustdesk.InstallOptions{From: extracted}); err != nil || result.Verified || !strings.Contains(hbbs(dir), "# extracted") {
+		t.Fatalf("expected an unverified install of the binaries: %+v %v", result, err)
+	}
+}
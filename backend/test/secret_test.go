@@ -0,0 +1,110 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/helper/secret"
+	"strings"
+	"testing"
+)
+
+func TestSecretKeyRing(t *testing.T) {
+	t.Setenv(secret.MasterKeyEnv, "")
+	t.Setenv(secret.MasterKeyFileEnv, "")
+
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	ring, err := secret.LoadKeyRing(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a generated key encrypts nothing until it is saved
+	if _, err = ring.Encrypt("peer-password"); err != secret.ErrKeyNotSaved {
+		t.Fatalf("expected the unsaved key to be refused, got %v", err)
+	}
+	if _, err = os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Fatal("expected the key file to be written by Save only")
+	}
+	if err = ring.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// loading the key file again must give the same key
+	again, err := secret.LoadKeyRing(keyFile)
+	if err != nil || again.Primary().Id != ring.Primary().Id {
+		t.Fatalf("key file not reused: %v", err)
+	}
+
+	encrypted, err := ring.Encrypt("peer-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secret.IsEncrypted(encrypted) || secret.KeyId(encrypted) != ring.Primary().Id {
+		t.Fatalf("unexpected value: %s", encrypted)
+	}
+
+	rotated, err := ring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := rotated.Decrypt(encrypted)
+	if err != nil || plaintext != "peer-password" {
+		t.Fatalf("old value not readable after rotate: %s %v", plaintext, err)
+	}
+	if _, err = rotated.WithoutOldKeys().Decrypt(encrypted); err == nil {
+		t.Fatal("pruned key ring must not decrypt values of the old key")
+	}
+
+	defer secret.SetDefault(secret.Default())
+	secret.SetDefault(rotated)
+
+	s := model.EncryptedString("JBSWY3DPEHPK3PXP")
+	stored, err := s.ToDB()
+	if err != nil || secret.KeyId(string(stored)) != rotated.Primary().Id {
+		t.Fatalf("unexpected stored value: %s %v", stored, err)
+	}
+	var loaded model.EncryptedString
+	if err = loaded.FromDB(stored); err != nil || loaded != s {
+		t.Fatalf("unexpected loaded value: %s %v", loaded, err)
+	}
+	// plaintext written by older versions is still readable
+	if err = loaded.FromDB([]byte("legacy")); err != nil || loaded != "legacy" {
+		t.Fatalf("unexpected legacy value: %s %v", loaded, err)
+	}
+}
+
+func TestSaveGeneratedKey(t *testing.T) {
+	t.Setenv(secret.MasterKeyEnv, "")
+	t.Setenv(secret.MasterKeyFileEnv, "")
+	dir := t.TempDir()
+	engine := newTestEngine(t, new(model.Peer))
+	defer secret.SetDefault(secret.Default())
+	load := func() *secret.KeyRing {
+		ring, err := secret.LoadKeyRing(filepath.Join(dir, "master.key"))
+		if err != nil || !ring.Generated {
+			t.Fatalf("expected a generated key: %v", err)
+		}
+		secret.SetDefault(ring)
+		return ring
+	}
+
+	// values encrypted with a key file that got lost: the new key is not saved
+	_, _ = engine.Exec("INSERT INTO peer (rustdesk_id, password) VALUES ('1', 'enc:v1:lost:abc:def')")
+	load()
+	if err := service.NewSecretService().SaveGeneratedKey(); err == nil || !strings.Contains(err.Error(), "1 stored secrets") {
+		t.Fatalf("expected the missing key file to stop the start, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "master.key")); !os.IsNotExist(err) {
+		t.Fatal("expected no key file to be written")
+	}
+
+	// nothing encrypted yet: the first start writes the key
+	_, _ = engine.Exec("DELETE FROM peer")
+	ring := load()
+	if err := service.NewSecretService().SaveGeneratedKey(); err != nil || ring.Generated {
+		t.Fatalf("expected the key to be saved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "master.key")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/helper/rustdesk"
+	"rustdesk-api-server-pro/helper/secret"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	public, private, err := rustdesk.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := base64.StdEncoding.DecodeString(private)
+	seed := base64.StdEncoding.EncodeToString(priv[:ed25519.SeedSize])
+	for _, key := range []string{private, seed} {
+		pub, full, err := rustdesk.ParsePrivateKey(key)
+		if err != nil || pub != public || full != private {
+			t.Fatalf("unexpected keypair for %q: %s %s %v", key, pub, full, err)
+		}
+	}
+
+	priv[40] ^= 1 // the public half no longer matches the seed
+	if _, _, err = rustdesk.ParsePrivateKey(base64.StdEncoding.EncodeToString(priv)); err == nil {
+		t.Fatal("expected a mismatching secret key to be rejected")
+	}
+	if _, _, err = rustdesk.ParsePrivateKey("c2hvcnQ="); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+}
+
+func TestServerKeyRotation(t *testing.T) {
+	engine := newTestEngine(t, new(model.ServerKey), new(model.Device))
+	key, err := secret.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secret.SetDefault(secret.Default())
+	secret.SetDefault(secret.NewKeyRing(key))
+	defer rustdesk.SetServerBinDir(rustdesk.GetRustdeskServerBinDir())
+	rustdesk.SetServerBinDir(t.TempDir())
+
+	// the keypair hbbs generated on its first start is served right away and recorded by the sync
+	public, private, _ := rustdesk.GenerateKeyPair()
+	if err = rustdesk.WriteKeys(public, private); err != nil {
+		t.Fatal(err)
+	}
+	s := service.NewServerKeyService()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if active, err := s.Active(); err != nil || active == nil || active.PublicKey != public {
+				t.Errorf("expected the hbbs key: %+v %v", active, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if count, _ := engine.Count(&model.ServerKey{}); count != 0 {
+		t.Fatalf("expected Active to only read, %d keys recorded", count)
+	}
+	for i := 0; i < 2; i++ {
+		active, err := s.SyncFiles()
+		if err != nil || active == nil || active.PublicKey != public || active.Source != model.SERVER_KEY_SOURCE_HBBS {
+			t.Fatalf("expected the hbbs key to be recorded: %+v %v", active, err)
+		}
+	}
+	if count, _ := engine.Where("status = ?", model.SERVER_KEY_ACTIVE).Count(&model.ServerKey{}); count != 1 {
+		t.Fatalf("expected one active key, got %d", count)
+	}
+
+	_, _ = engine.Insert(&model.Device{RustdeskId: "100", Uuid: "u1"}, &model.Device{RustdeskId: "200", Uuid: "u2"})
+	if err = s.ReportKey("100", "u1", public); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := s.Rotate("", time.Hour, 1)
+	if err != nil || pending.Status != model.SERVER_KEY_PENDING {
+		t.Fatalf("expected a pending key: %+v %v", pending, err)
+	}
+	if due, _ := s.ActivateDue(); due != nil {
+		t.Fatal("expected the key to wait for its grace period")
+	}
+	if current, _ := rustdesk.Keys(); strings.TrimSpace(current) != public {
+		t.Fatal("expected hbbs to keep its key during the grace period")
+	}
+
+	_, _ = engine.Exec("UPDATE server_key SET activate_at = ? WHERE id = ?", time.Now().Add(-time.Minute), pending.Id)
+	due, err := s.ActivateDue()
+	if err != nil || due == nil {
+		t.Fatalf("expected the pending key to be activated: %v", err)
+	}
+	if current, _ := rustdesk.Keys(); strings.TrimSpace(current) != pending.PublicKey {
+		t.Fatalf("expected the new key in place, got %q", current)
+	}
+	if active, _ := s.Active(); active.PublicKey != pending.PublicKey {
+		t.Fatalf("unexpected active key: %+v", active)
+	}
+	old := &model.ServerKey{}
+	if has, _ := engine.Where("public_key = ?", public).Get(old); !has || old.Status != model.SERVER_KEY_RETIRED {
+		t.Fatalf("expected the previous key to be retired: %+v", old)
+	}
+
+	// the private key is stored encrypted
+	var stored string
+	_, _ = engine.SQL("SELECT private_key FROM server_key WHERE id = ?", pending.Id).Get(&stored)
+	if !secret.IsEncrypted(stored) {
+		t.Fatal("expected the private key to be encrypted")
+	}
+
+	outdated, err := s.OutdatedDevices()
+	if err != nil || len(outdated) != 1 || outdated[0].RustdeskId != "100" {
+		t.Fatalf("expected device 100 to be outdated: %+v %v", outdated, err)
+	}
+	// device 200 never reported a key, the list can not tell whether it is affected
+	if unreported, err := s.UnreportedDevices(); err != nil || unreported != 1 {
+		t.Fatalf("expected 1 device without a reported key, got %d %v", unreported, err)
+	}
+}
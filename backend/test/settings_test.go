@@ -0,0 +1,47 @@
+package test
+
+import (
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"testing"
+)
+
+func TestSettingsPrecedence(t *testing.T) {
+	newTestEngine(t, new(model.SystemSettings))
+	cfg := config.GetDefaultServerConfig()
+	cfg.SignKey = "0123456789abcdef"
+	setTestConfig(t, cfg)
+
+	s := service.NewSettingsService()
+	key := service.SETTING_AUDIT_ORPHAN_TIMEOUT_MINS
+	if v := s.Get(key); v.Value != "10" || v.Source != service.SETTING_SOURCE_DEFAULT {
+		t.Fatalf("expected default, got %+v", v)
+	}
+
+	cfg.Settings = map[string]string{key: "60"}
+	if v := s.Get(key); v.Value != "60" || v.Source != service.SETTING_SOURCE_YAML {
+		t.Fatalf("expected yaml value, got %+v", v)
+	}
+
+	if err := s.Save(map[string]string{key: "30"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetInt(key) != 30 || s.Get(key).Source != service.SETTING_SOURCE_DB {
+		t.Fatalf("expected db value, got %+v", s.Get(key))
+	}
+
+	if err := s.Save(map[string]string{key: "0"}); err == nil {
+		t.Fatal("expected out of range value to be rejected")
+	}
+	if err := s.Save(map[string]string{"unknown.key": "1"}); err == nil {
+		t.Fatal("expected unknown key to be rejected")
+	}
+
+	if err := s.Reset(key); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetInt(key) != 60 {
+		t.Fatalf("expected yaml value after reset, got %+v", s.Get(key))
+	}
+}
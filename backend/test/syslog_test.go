@@ -0,0 +1,125 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"rustdesk-api-server-pro/app/model"
+	"rustdesk-api-server-pro/app/service"
+	"rustdesk-api-server-pro/config"
+	"rustdesk-api-server-pro/helper/syslog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogForwarding(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "server.yaml")
+	content := fmt.Sprintf(`signKey: "0123456789abcdef"
+db:
+  driver: sqlite
+  dsn: ./server.db
+  timeZone: UTC
+syslog:
+  hostname: "rdapi-test"
+  targets:
+    - network: udp
+      address: %q
+      format: rfc5424
+      facility: auth
+      events: ["login.*"]
+    - network: tcp
+      address: %q
+      format: cef
+`, udp.LocalAddr().String(), tcp.Addr().String())
+	if err = os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldFile := config.SetConfigFile(file)
+	t.Setenv("RDAPI_MASTER_KEY_FILE", filepath.Join(dir, "master.key"))
+	oldConfig := config.SetServerConfig(nil)
+	service.NewEventService()
+	t.Cleanup(func() {
+		service.StopEventService()
+		config.SetServerConfig(nil)
+		config.SetServerConfig(oldConfig)
+		config.SetConfigFile(oldFile)
+	})
+
+	service.EmitEvent(service.EVENT_CONN_START, syslog.SEVERITY_INFO, "", map[string]string{"rustdesk_id": "123456789"})
+	// the username of a user event is looked up when a target accepts it
+	engine := newTestEngine(t, new(model.User))
+	user := &model.User{Username: "bob"}
+	if _, err = engine.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	service.EmitUserEvent(service.EVENT_CONN_END, syslog.SEVERITY_INFO, "", user.Id, map[string]string{"rustdesk_id": "123456789"})
+	service.EmitEvent(service.EVENT_LOGIN_FAILURE, syslog.SEVERITY_WARNING, "", map[string]string{
+		"username": "alice",
+		"ip":       "10.0.0.1",
+		"reason":   `wrong "password"`,
+	})
+
+	// the udp target only forwards login events
+	_ = udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<36>1 ") || !strings.Contains(msg, " rdapi-test rustdesk-api ") ||
+		!strings.Contains(msg, ` login.failure [rdapi@32473 ip="10.0.0.1" reason="wrong \"password\"" username="alice"] Client login failed`) {
+		t.Fatalf("unexpected rfc5424 message: %s", msg)
+	}
+
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	frames := make([]string, 0, 3)
+	for len(frames) < 3 {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			t.Fatalf("invalid octet count %q", length)
+		}
+		frame := make([]byte, size)
+		if _, err = io.ReadFull(reader, frame); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(frame))
+	}
+	if !strings.Contains(frames[0], "CEF:0|RustDesk|rustdesk-api-server-pro|1|conn.start|Connection started|3|dvchost=123456789 rt=") {
+		t.Fatalf("unexpected cef message: %s", frames[0])
+	}
+	if !strings.Contains(frames[1], "|conn.end|Connection ended|3|") || !strings.HasSuffix(frames[1], "suser=bob") {
+		t.Fatalf("unexpected cef message: %s", frames[1])
+	}
+	if !strings.Contains(frames[2], `|login.failure|Client login failed|6|reason=wrong "password" rt=`) ||
+		!strings.HasSuffix(frames[2], "src=10.0.0.1 suser=alice") {
+		t.Fatalf("unexpected cef message: %s", frames[2])
+	}
+}
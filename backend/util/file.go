@@ -85,3 +85,17 @@ func MoveFiles(src, dst string) error {
 	}
 	return nil
 }
+
+// FormatBytes formats a size with binary units, e.g. 1.5 MiB
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}